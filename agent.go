@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Settings of the systemd unit running the daemon as a permanent benchmark agent
+type AgentUnit struct {
+	Name           string
+	User           string
+	Executable     string
+	Args           []string
+	ReadWritePaths []string
+}
+
+var installAgentFlags = []string{"--schedule", "--config", "--output-dir", "--keep", "--listen", "--name", "--user", "--read-write", "--install"}
+
+var agentUnitTemplate = template.Must(template.New("unit").Funcs(template.FuncMap{"quote": systemdQuote}).Parse(`[Unit]
+Description=statexec benchmark agent ({{.Name}})
+Documentation=https://github.com/blackswifthosting/statexec
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=simple
+{{- if .User}}
+User={{.User}}
+{{- end}}
+ExecStart={{quote .Executable}}{{range .Args}} {{quote .}}{{end}}
+Restart=on-failure
+RestartSec=10s
+
+# Sandboxing: the agent only writes its runs outputs, host metrics are read from /proc and /sys
+NoNewPrivileges=yes
+PrivateTmp=yes
+ProtectSystem=strict
+ProtectHome=read-only
+ProtectKernelTunables=yes
+ProtectKernelModules=yes
+ProtectControlGroups=yes
+RestrictSUIDSGID=yes
+RestrictRealtime=yes
+LockPersonality=yes
+StateDirectory=statexec
+{{- range .ReadWritePaths}}
+ReadWritePaths={{quote .}}
+{{- end}}
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+// Quote a word of a systemd command line or path setting when it needs it
+func systemdQuote(word string) string {
+	if word != "" && !strings.ContainsAny(word, " \t\"'\\%$;") {
+		return word
+	}
+	word = strings.ReplaceAll(word, `\`, `\\`)
+	word = strings.ReplaceAll(word, `"`, `\"`)
+	word = strings.ReplaceAll(word, "%", "%%")
+	word = strings.ReplaceAll(word, "$", "$$")
+	return `"` + word + `"`
+}
+
+func renderAgentUnit(unit AgentUnit) string {
+	var rendered strings.Builder
+	if err := agentUnitTemplate.Execute(&rendered, unit); err != nil {
+		fatal("Cannot render systemd unit", "error", err)
+	}
+	return rendered.String()
+}
+
+func absolutePath(path string) string {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		fatalWith(ExitConfig, "Cannot resolve path", "path", path, "error", err)
+	}
+	return absolute
+}
+
+func installAgentSubcommand(args []string) {
+	unit := AgentUnit{Name: "statexec-agent"}
+	scheduleExpression := ""
+	configFile := ""
+	outputDir := "/var/lib/statexec/runs"
+	keep := ""
+	listen := ""
+	install := false
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--schedule":
+			scheduleExpression = flagValue(args, i)
+			i++
+		case "--config":
+			configFile = flagValue(args, i)
+			i++
+		case "--output-dir":
+			outputDir = flagValue(args, i)
+			i++
+		case "--keep":
+			keep = flagValue(args, i)
+			if _, err := strconv.Atoi(keep); err != nil {
+				fatalWith(ExitConfig, "Cannot parse number of runs to keep", "value", keep)
+			}
+			i++
+		case "--listen":
+			listen = flagValue(args, i)
+			i++
+		case "--name":
+			unit.Name = flagValue(args, i)
+			i++
+		case "--user":
+			unit.User = flagValue(args, i)
+			i++
+		case "--read-write":
+			unit.ReadWritePaths = append(unit.ReadWritePaths, absolutePath(flagValue(args, i)))
+			i++
+		case "--install":
+			install = true
+		case "-h", "--help":
+			fmt.Printf("Usage: %s install-agent --schedule <cron> --config <bench.yaml> [daemon flags] [--name <name>] [--user <user>] [--read-write <path>]... [--install]\n", os.Args[0])
+			fmt.Println("  Print a systemd unit running statexec daemon as a permanent benchmark agent, or install and start it")
+			fmt.Println("  --schedule, --config, --output-dir, --keep, --listen   Flags of the daemon (output dir default: /var/lib/statexec/runs)")
+			fmt.Println("  --name <name>         Unit name (default: statexec-agent)")
+			fmt.Println("  --user <user>         User running the agent (default: root)")
+			fmt.Println("  --read-write <path>   Path the benchmarked command writes to, besides the output dir, can be repeated")
+			fmt.Println("  --install             Write the unit into /etc/systemd/system, then enable and start it")
+			os.Exit(0)
+		default:
+			fatalWith(ExitConfig, "Unknown install-agent argument", "argument", args[i])
+		}
+	}
+	setupLogger()
+
+	// Fail now rather than in a restart loop of the service
+	if scheduleExpression == "" || configFile == "" {
+		fatalWith(ExitConfig, "Agent needs a schedule (--schedule) and a run configuration (--config)")
+	}
+	if _, err := parseSchedule(scheduleExpression); err != nil {
+		fatalWith(ExitConfig, "Cannot parse schedule", "schedule", scheduleExpression, "error", err)
+	}
+	if _, err := loadRunSpec(configFile); err != nil {
+		fatalWith(ExitConfig, "Cannot load run configuration", "file", configFile, "error", err)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		fatal("Cannot locate statexec binary", "error", err)
+	}
+	unit.Executable, _ = filepath.EvalSymlinks(executable)
+	outputDir = absolutePath(outputDir)
+	unit.Args = []string{"daemon", "--schedule", scheduleExpression, "--config", absolutePath(configFile), "--output-dir", outputDir}
+	if keep != "" {
+		unit.Args = append(unit.Args, "--keep", keep)
+	}
+	if listen != "" {
+		unit.Args = append(unit.Args, "--listen", listen)
+	}
+	unit.ReadWritePaths = append([]string{outputDir}, unit.ReadWritePaths...)
+
+	rendered := renderAgentUnit(unit)
+	if !install {
+		fmt.Print(rendered)
+		return
+	}
+
+	// ReadWritePaths must exist when the service starts
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		fatalWith(ExitOutput, "Cannot create output directory", "dir", outputDir, "error", err)
+	}
+	unitFile := filepath.Join("/etc/systemd/system", unit.Name+".service")
+	if err := os.WriteFile(unitFile, []byte(rendered), 0644); err != nil {
+		fatalWith(ExitOutput, "Cannot write systemd unit", "file", unitFile, "error", err)
+	}
+	for _, systemctlArgs := range [][]string{{"daemon-reload"}, {"enable", "--now", unit.Name + ".service"}} {
+		cmd := exec.Command("systemctl", systemctlArgs...)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			fatal("Cannot run systemctl", "args", strings.Join(systemctlArgs, " "), "error", err)
+		}
+	}
+	fmt.Printf("Agent installed as %s and started, see: systemctl status %s\n", unitFile, unit.Name)
+}
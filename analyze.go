@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/blackswifthosting/statexec/promfile"
+)
+
+// Suspicious period of a run
+type Finding struct {
+	Check   string `json:"check"`
+	Start   int64  `json:"start"`
+	End     int64  `json:"end"`
+	Message string `json:"message"`
+}
+
+var analyzeFlags = []string{"--format", "--steal-threshold", "--annotate"}
+
+// Sum the values of a metric per timestamp (over cpus, modes...), optionally filtered by labels
+func sumPerTimestamp(file *promfile.File, name string, labels map[string]string) map[int64]float64 {
+	sums := make(map[int64]float64)
+	for _, sample := range file.Samples {
+		if sample.Name != name {
+			continue
+		}
+		match := true
+		for key, value := range labels {
+			if sample.Labels[key] != value {
+				match = false
+			}
+		}
+		if match {
+			sums[sample.Timestamp] += sample.Value
+		}
+	}
+	return sums
+}
+
+func sortedTimestamps(values map[int64]float64) []int64 {
+	timestamps := make([]int64, 0, len(values))
+	for timestamp := range values {
+		timestamps = append(timestamps, timestamp)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps
+}
+
+// Merge consecutive flagged intervals into findings
+func flagIntervals(check string, timestamps []int64, flagged func(index int) bool, message func(start int, end int) string) []Finding {
+	var findings []Finding
+	start := -1
+	for index := 1; index <= len(timestamps); index++ {
+		if index < len(timestamps) && flagged(index) {
+			if start == -1 {
+				start = index
+			}
+			continue
+		}
+		if start != -1 {
+			findings = append(findings, Finding{
+				Check:   check,
+				Start:   timestamps[start-1],
+				End:     timestamps[index-1],
+				Message: message(start, index-1),
+			})
+			start = -1
+		}
+	}
+	return findings
+}
+
+// Flag intervals where a counter increased
+func analyzeCounter(file *promfile.File, check string, name string, description string) []Finding {
+	values := sumPerTimestamp(file, MetricPrefix+name, nil)
+	timestamps := sortedTimestamps(values)
+	return flagIntervals(check, timestamps, func(index int) bool {
+		return values[timestamps[index]] > values[timestamps[index-1]]
+	}, func(start int, end int) string {
+		return fmt.Sprintf("%s: %s", description, strconv.FormatFloat(values[timestamps[end]]-values[timestamps[start-1]], 'f', -1, 64))
+	})
+}
+
+// Run all checks on a result file
+func analyzeFile(file *promfile.File, stealThreshold float64) []Finding {
+	var findings []Finding
+
+	// CPU steal, in percent of the total CPU time of each interval
+	cpuTotal := sumPerTimestamp(file, MetricPrefix+"cpu_seconds_total", nil)
+	cpuSteal := sumPerTimestamp(file, MetricPrefix+"cpu_seconds_total", map[string]string{"mode": "steal"})
+	cpuTimestamps := sortedTimestamps(cpuTotal)
+	stealPercent := func(index int) float64 {
+		// Extra samples taken at command start/stop are too close to be meaningful
+		if cpuTimestamps[index]-cpuTimestamps[index-1] < 500 {
+			return 0
+		}
+		total := cpuTotal[cpuTimestamps[index]] - cpuTotal[cpuTimestamps[index-1]]
+		if total <= 0 {
+			return 0
+		}
+		return (cpuSteal[cpuTimestamps[index]] - cpuSteal[cpuTimestamps[index-1]]) / total * 100
+	}
+	findings = append(findings, flagIntervals("cpu_steal", cpuTimestamps, func(index int) bool {
+		return stealPercent(index) > stealThreshold
+	}, func(start int, end int) string {
+		max := 0.0
+		for index := start; index <= end; index++ {
+			if stealPercent(index) > max {
+				max = stealPercent(index)
+			}
+		}
+		return fmt.Sprintf("CPU steal above %.1f%% (max %.1f%%), the hypervisor took CPU time from the host", stealThreshold, max)
+	})...)
+
+	// Swap activity
+	findings = append(findings, analyzeCounter(file, "swap_in", "memory_swap_in_bytes_total", "Swap in activity, bytes swapped in")...)
+	findings = append(findings, analyzeCounter(file, "swap_out", "memory_swap_out_bytes_total", "Swap out activity, bytes swapped out")...)
+
+	// Thermal throttling
+	findings = append(findings, analyzeCounter(file, "thermal_throttling", "cpu_thermal_throttle_events_total", "CPU thermal throttling, events")...)
+
+	// Collection overruns : collection slower than the interval, or missing samples
+	collectDurations := sumPerTimestamp(file, MetricPrefix+"metric_collect_duration_seconds", nil)
+	if len(collectDurations) == 0 {
+		// Results written with --legacy-names or by older versions, in milliseconds
+		collectDurations = sumPerTimestamp(file, MetricPrefix+"metric_collect_duration_ms", nil)
+		for timestamp, duration := range collectDurations {
+			collectDurations[timestamp] = duration / 1000
+		}
+	}
+	collectTimestamps := sortedTimestamps(collectDurations)
+	overrun := func(index int) bool {
+		return collectDurations[collectTimestamps[index]] > 1 || collectTimestamps[index]-collectTimestamps[index-1] > 1500
+	}
+	findings = append(findings, flagIntervals("collection_overrun", collectTimestamps, overrun, func(start int, end int) string {
+		return fmt.Sprintf("Metrics collection overrun on %d interval(s), collection slower than the 1s interval or samples missing", end-start+1)
+	})...)
+
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Start < findings[j].Start })
+	return findings
+}
+
+func analyzeSubcommand(args []string) {
+	format := "text"
+	stealThreshold := defaultStealThreshold
+	annotate := false
+
+	files := []string{}
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--format":
+			format = flagValue(args, i)
+			if format != "text" && format != "json" {
+				fatalWith(ExitConfig, "Format must be text or json", "format", format)
+			}
+			i++
+		case "--steal-threshold":
+			value, err := strconv.ParseFloat(flagValue(args, i), 64)
+			if err != nil {
+				fatalWith(ExitConfig, "Cannot parse steal threshold", "value", args[i+1], "error", err)
+			}
+			stealThreshold = value
+			i++
+		case "--annotate":
+			annotate = true
+		case "-h", "--help":
+			fmt.Printf("Usage: %s analyze [OPTIONS] <file.prom>\n", os.Args[0])
+			fmt.Println("  --format <text|json>          Output format (default: text)")
+			fmt.Println("  --steal-threshold <percent>   CPU steal threshold in percent of CPU time (default: 5)")
+			fmt.Println("  --annotate                    Add findings as Grafana annotations to the result file")
+			os.Exit(0)
+		default:
+			files = append(files, args[i])
+		}
+	}
+	if len(files) != 1 {
+		fatalWith(ExitConfig, "Analyze needs exactly one result file")
+	}
+
+	file, err := parseResultFile(files[0])
+	if err != nil {
+		fatal("Cannot parse result file", "file", files[0], "error", err)
+	}
+	findings := analyzeFile(file, stealThreshold)
+
+	if annotate && len(findings) > 0 {
+		if resultFileEncrypted(files[0]) {
+			fatalWith(ExitConfig, "Cannot annotate an encrypted result file", "file", files[0])
+		}
+		resultFile, err := os.OpenFile(files[0], os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			fatalWith(ExitOutput, "Cannot open result file", "file", files[0], "error", err)
+		}
+		for _, finding := range findings {
+			annotationJson, err := json.Marshal(GrafanaAnnotation{
+				Time:    finding.Start,
+				TimeEnd: finding.End,
+				Text:    finding.Message,
+				Tags:    []string{"statexec", "anomaly", "check=" + finding.Check, "instance=" + file.Label("instance"), "job=" + file.Label("job"), "role=" + file.Label("role")},
+			})
+			if err != nil {
+				fatal("Cannot marshal annotation", "error", err)
+			}
+			if _, err := resultFile.WriteString(promfile.AnnotationPrefix + string(annotationJson) + "\n"); err != nil {
+				fatalWith(ExitOutput, "Cannot write to result file", "file", files[0], "error", err)
+			}
+		}
+		resultFile.Close()
+	}
+
+	if format == "json" {
+		if findings == nil {
+			findings = []Finding{}
+		}
+		printJson(findings)
+		return
+	}
+
+	if len(findings) == 0 {
+		fmt.Println("No anomaly found, the run looks trustworthy")
+		return
+	}
+	for _, finding := range findings {
+		fmt.Printf("WARNING [%s] %s -> %s (%.1fs): %s\n", finding.Check, formatTimestamp(finding.Start), formatTimestamp(finding.End), float64(finding.End-finding.Start)/1000.0, finding.Message)
+	}
+}
+
+// Format a timestamp in milliseconds as a local time
+func formatTimestamp(timestamp int64) string {
+	return time.UnixMilli(timestamp).Format("2006-01-02 15:04:05.000")
+}
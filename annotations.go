@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/blackswifthosting/statexec/promfile"
+)
+
+var annotationsFile string = "" // annotations written as a JSON array in the schema of the Grafana annotations API (--annotations-file)
+
+// Write the annotations of the run, each one can be posted as is to /api/annotations
+type annotationsExporter struct {
+	path string
+}
+
+func (exporter annotationsExporter) Name() string {
+	return "annotations"
+}
+
+func (exporter annotationsExporter) Export(result RunResult) error {
+	annotations := append([]GrafanaAnnotation{}, result.annotations...)
+	annotationsJson, err := json.MarshalIndent(annotations, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot marshal annotations: %w", err)
+	}
+	if err := writeFileAtomic(exporter.path, append(annotationsJson, '\n')); err != nil {
+		return fmt.Errorf("cannot write annotations file %s: %w", exporter.path, err)
+	}
+	logger.Debug("Annotations written", "file", exporter.path, "annotations", len(annotations))
+	return nil
+}
+
+func isAnnotationsFile(path string) bool {
+	return strings.HasSuffix(path, ".json")
+}
+
+// Import an annotations file into Grafana, shifted as the result files
+func importAnnotationsFile(path string, options ImportOptions) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	file := &promfile.File{}
+	if err := json.Unmarshal(data, &file.Annotations); err != nil {
+		return fmt.Errorf("invalid annotations file: %w", err)
+	}
+	if options.shift != 0 {
+		file.Shift(options.shift)
+	}
+	return postAnnotations(file.Annotations, options)
+}
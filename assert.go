@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/blackswifthosting/statexec/promfile"
+)
+
+// Assertion on a summary value, e.g. cpu_mean_seconds{mode="user"}<2 or exit_code==0
+type Assertion struct {
+	Expression string
+	Key        string
+	Operator   string
+	Threshold  float64
+}
+
+type AssertionResult struct {
+	Assertion Assertion
+	Value     float64
+	Found     bool
+	Ok        bool
+}
+
+var (
+	assertions       []Assertion
+	assertionResults []AssertionResult
+	assertionRegexp  = regexp.MustCompile(`^(.+?)\s*(<=|>=|==|!=|<|>)\s*([-+0-9.eE]+)$`)
+)
+
+func parseAssertion(expression string) Assertion {
+	match := assertionRegexp.FindStringSubmatch(strings.TrimSpace(expression))
+	if match == nil {
+		fatalWith(ExitConfig, "Cannot parse assertion, expected <summary value><operator><number>", "assertion", expression)
+	}
+	threshold, err := strconv.ParseFloat(match[3], 64)
+	if err != nil {
+		fatalWith(ExitConfig, "Cannot parse assertion threshold", "assertion", expression, "error", err)
+	}
+	return Assertion{Expression: expression, Key: match[1], Operator: match[2], Threshold: threshold}
+}
+
+func (assertion Assertion) check(value float64) bool {
+	switch assertion.Operator {
+	case "<":
+		return value < assertion.Threshold
+	case "<=":
+		return value <= assertion.Threshold
+	case ">":
+		return value > assertion.Threshold
+	case ">=":
+		return value >= assertion.Threshold
+	case "==":
+		return value == assertion.Threshold
+	case "!=":
+		return value != assertion.Threshold
+	}
+	return false
+}
+
+// Summary values of the current run, keyed as in the report subcommand
+func currentSummaryValues() map[string]float64 {
+	summary := runSummary()
+	file, err := promfile.Parse(strings.NewReader(renderSummary(summary)))
+	if err != nil {
+		fatal("Cannot parse summary", "error", err)
+	}
+	values := summaryValues(file)
+	values["duration_seconds"] = summary.DurationSeconds
+	values["exit_code"] = float64(summary.ExitCode)
+	return values
+}
+
+// Evaluate assertions against the summary of the run, a missing value fails the assertion
+func evaluateAssertions(values map[string]float64) []AssertionResult {
+	var results []AssertionResult
+	for _, assertion := range assertions {
+		value, found := values[assertion.Key]
+		result := AssertionResult{
+			Assertion: assertion,
+			Value:     value,
+			Found:     found,
+			Ok:        found && assertion.check(value),
+		}
+		if !result.Ok {
+			logger.Error("Assertion failed", "assertion", assertion.Expression, "value", value, "found", found)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func (result AssertionResult) String() string {
+	if !result.Found {
+		return fmt.Sprintf("%s: no such summary value", result.Assertion.Expression)
+	}
+	return fmt.Sprintf("%s: value %s", result.Assertion.Expression, strconv.FormatFloat(result.Value, 'f', -1, 64))
+}
+
+func assertionsFailed() bool {
+	for _, result := range assertionResults {
+		if !result.Ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Output files being written, discarded when the run aborts on a fatal error
+var (
+	pendingFiles      = make(map[*atomicFile]struct{})
+	pendingFilesMutex sync.Mutex
+)
+
+// An output file written under a hidden temporary name in the directory of its path, then renamed over it once complete.
+// A reader only ever finds a complete file under the final name: a crash mid-write leaves the temporary file behind,
+// never a truncated result that an import would half-ingest.
+type atomicFile struct {
+	*bufio.Writer
+	file *os.File
+	path string
+}
+
+// Create a buffered file for the path, to be committed once written. Writers defer Discard right away, so an early
+// return never leaves the temporary file behind.
+func createAtomicFile(path string) (*atomicFile, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), ".statexec-"+filepath.Base(path)+"-*")
+	if err != nil {
+		return nil, err
+	}
+	output := &atomicFile{Writer: bufio.NewWriterSize(file, 1024*1024), file: file, path: path}
+	pendingFilesMutex.Lock()
+	pendingFiles[output] = struct{}{}
+	pendingFilesMutex.Unlock()
+	return output, nil
+}
+
+// Flush the buffer, sync the file to disk and rename it to its path
+func (output *atomicFile) Commit() error {
+	if err := output.Flush(); err != nil {
+		return err
+	}
+	// Temporary files are created 0600, results are readable as they were before
+	if err := output.file.Chmod(0644); err != nil {
+		return err
+	}
+	if err := output.file.Sync(); err != nil {
+		return err
+	}
+	if err := output.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(output.file.Name(), output.path); err != nil {
+		return err
+	}
+	pendingFilesMutex.Lock()
+	delete(pendingFiles, output)
+	pendingFilesMutex.Unlock()
+	return nil
+}
+
+// Remove the temporary file unless committed
+func (output *atomicFile) Discard() {
+	pendingFilesMutex.Lock()
+	_, pending := pendingFiles[output]
+	delete(pendingFiles, output)
+	pendingFilesMutex.Unlock()
+	if !pending {
+		return
+	}
+	_ = output.file.Close()
+	_ = os.Remove(output.file.Name())
+}
+
+// Discard the files being written, before exiting on a fatal error which skips the deferred calls
+func discardPendingFiles() {
+	pendingFilesMutex.Lock()
+	files := make([]*atomicFile, 0, len(pendingFiles))
+	for file := range pendingFiles {
+		files = append(files, file)
+	}
+	pendingFilesMutex.Unlock()
+	for _, file := range files {
+		file.Discard()
+	}
+}
+
+// Write a whole file atomically
+func writeFileAtomic(path string, data []byte) error {
+	file, err := createAtomicFile(path)
+	if err != nil {
+		return err
+	}
+	defer file.Discard()
+	if _, err := file.Write(data); err != nil {
+		return err
+	}
+	return file.Commit()
+}
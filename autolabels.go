@@ -0,0 +1,149 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"strings"
+)
+
+// Sources of labels taken from the environment of orchestrators
+var autoLabelSources = []string{"ci", "k8s", "nomad"}
+
+var autoLabels []string // sources of labels joining the run back to the pipeline or workload which ran it (--auto-labels)
+
+// Label taken from the first env var set
+type autoLabel struct {
+	name    string
+	envVars []string
+}
+
+// CI providers, detected by an env var they always set
+var ciProviders = []struct {
+	name   string
+	marker string
+	labels []autoLabel
+}{
+	{"github", "GITHUB_ACTIONS", []autoLabel{
+		{"ci_pipeline_id", []string{"GITHUB_RUN_ID"}},
+		{"ci_job_id", []string{"GITHUB_JOB"}},
+		{"ci_project", []string{"GITHUB_REPOSITORY"}},
+		{"ci_commit", []string{"GITHUB_SHA"}},
+		{"ci_ref", []string{"GITHUB_REF_NAME"}},
+	}},
+	{"gitlab", "GITLAB_CI", []autoLabel{
+		{"ci_pipeline_id", []string{"CI_PIPELINE_ID"}},
+		{"ci_job_id", []string{"CI_JOB_ID"}},
+		{"ci_project", []string{"CI_PROJECT_PATH"}},
+		{"ci_commit", []string{"CI_COMMIT_SHA"}},
+		{"ci_ref", []string{"CI_COMMIT_REF_NAME"}},
+	}},
+	{"buildkite", "BUILDKITE", []autoLabel{
+		{"ci_pipeline_id", []string{"BUILDKITE_BUILD_ID"}},
+		{"ci_job_id", []string{"BUILDKITE_JOB_ID"}},
+		{"ci_project", []string{"BUILDKITE_PIPELINE_SLUG"}},
+		{"ci_commit", []string{"BUILDKITE_COMMIT"}},
+		{"ci_ref", []string{"BUILDKITE_BRANCH"}},
+	}},
+	{"circleci", "CIRCLECI", []autoLabel{
+		{"ci_pipeline_id", []string{"CIRCLE_WORKFLOW_ID"}},
+		{"ci_job_id", []string{"CIRCLE_BUILD_NUM"}},
+		{"ci_project", []string{"CIRCLE_PROJECT_REPONAME"}},
+		{"ci_commit", []string{"CIRCLE_SHA1"}},
+		{"ci_ref", []string{"CIRCLE_BRANCH"}},
+	}},
+	{"jenkins", "JENKINS_URL", []autoLabel{
+		{"ci_pipeline_id", []string{"BUILD_NUMBER"}},
+		{"ci_job_id", []string{"BUILD_TAG"}},
+		{"ci_project", []string{"JOB_NAME"}},
+		{"ci_commit", []string{"GIT_COMMIT"}},
+		{"ci_ref", []string{"GIT_BRANCH"}},
+	}},
+}
+
+// Pod metadata exposed by the downward API, under the names of the Kubernetes documentation
+var k8sLabels = []autoLabel{
+	{"k8s_namespace", []string{"POD_NAMESPACE", "MY_POD_NAMESPACE", "K8S_NAMESPACE"}},
+	{"k8s_pod", []string{"POD_NAME", "MY_POD_NAME", "K8S_POD_NAME"}},
+	{"k8s_node", []string{"NODE_NAME", "MY_NODE_NAME", "K8S_NODE_NAME"}},
+}
+
+// File of the namespace of the pod, mounted with its service account
+const k8sNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+var nomadLabels = []autoLabel{
+	{"nomad_alloc_id", []string{"NOMAD_ALLOC_ID"}},
+	{"nomad_job", []string{"NOMAD_JOB_NAME"}},
+	{"nomad_task", []string{"NOMAD_TASK_NAME"}},
+	{"nomad_namespace", []string{"NOMAD_NAMESPACE"}},
+	{"nomad_dc", []string{"NOMAD_DC"}},
+}
+
+func parseAutoLabels(value string) {
+	autoLabels = nil
+	for _, source := range strings.Split(value, ",") {
+		source = strings.TrimSpace(source)
+		if !slices.Contains(autoLabelSources, source) {
+			fatalWith(ExitConfig, "Unknown auto labels source", "source", source, "available", strings.Join(autoLabelSources, ","))
+		}
+		autoLabels = append(autoLabels, source)
+	}
+}
+
+// Label from the first env var set, labels given with --label win
+func addAutoLabel(label autoLabel) {
+	if _, exists := extraLabels[label.name]; exists {
+		return
+	}
+	for _, envVar := range label.envVars {
+		if value := os.Getenv(envVar); value != "" {
+			extraLabels[label.name] = value
+			return
+		}
+	}
+}
+
+// Add the labels of the orchestrators found in the environment, nothing when statexec does not run under them
+func applyAutoLabels() {
+	for _, source := range autoLabels {
+		switch source {
+		case "ci":
+			for _, provider := range ciProviders {
+				if os.Getenv(provider.marker) == "" {
+					continue
+				}
+				if _, exists := extraLabels["ci_provider"]; !exists {
+					extraLabels["ci_provider"] = provider.name
+				}
+				for _, label := range provider.labels {
+					addAutoLabel(label)
+				}
+				break
+			}
+		case "k8s":
+			if os.Getenv("KUBERNETES_SERVICE_HOST") == "" {
+				continue
+			}
+			for _, label := range k8sLabels {
+				addAutoLabel(label)
+			}
+			if _, exists := extraLabels["k8s_namespace"]; !exists {
+				if namespace, err := os.ReadFile(k8sNamespaceFile); err == nil {
+					extraLabels["k8s_namespace"] = strings.TrimSpace(string(namespace))
+				}
+			}
+			// The hostname of a pod is its name
+			if _, exists := extraLabels["k8s_pod"]; !exists {
+				if podName, err := os.Hostname(); err == nil {
+					extraLabels["k8s_pod"] = podName
+				}
+			}
+		case "nomad":
+			if os.Getenv("NOMAD_ALLOC_ID") == "" {
+				continue
+			}
+			for _, label := range nomadLabels {
+				addAutoLabel(label)
+			}
+		}
+	}
+}
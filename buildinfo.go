@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+)
+
+// Build of the running binary, to slice the results of heterogeneous fleets by build
+type BuildInfo struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+	Os        string `json:"os"`
+	Arch      string `json:"arch"`
+	ArchLevel string `json:"arch_level,omitempty"` // GOAMD64, GOARM, GOARM64... the binary was built for
+	Revision  string `json:"revision,omitempty"`
+	Time      string `json:"time,omitempty"`
+	Modified  bool   `json:"modified"` // built from a tree with uncommitted changes
+	Static    bool   `json:"static"`   // built without cgo, runs without the libc of the host
+}
+
+var buildInfo = readBuildInfo()
+
+// Build settings embedded by the go toolchain, the VCS ones are missing when built outside of a git checkout
+func readBuildInfo() BuildInfo {
+	info := BuildInfo{
+		Version:   version,
+		GoVersion: runtime.Version(),
+		Os:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Revision = setting.Value
+		case "vcs.time":
+			info.Time = setting.Value
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		case "CGO_ENABLED":
+			info.Static = setting.Value == "0"
+		case archLevelSettings[runtime.GOARCH]:
+			info.ArchLevel = setting.Value
+		}
+	}
+	return info
+}
+
+// Build setting of the architecture level by GOARCH, e.g. GOAMD64=v3
+var archLevelSettings = map[string]string{
+	"amd64": "GOAMD64", "386": "GO386", "arm": "GOARM", "arm64": "GOARM64", "mips": "GOMIPS", "mipsle": "GOMIPS",
+	"mips64": "GOMIPS64", "mips64le": "GOMIPS64", "ppc64": "GOPPC64", "ppc64le": "GOPPC64", "riscv64": "GORISCV64", "wasm": "GOWASM",
+}
+
+// Platform of the binary, e.g. linux/amd64/v3
+func (info BuildInfo) Platform() string {
+	platform := info.Os + "/" + info.Arch
+	if info.ArchLevel != "" {
+		platform += "/" + info.ArchLevel
+	}
+	return platform
+}
+
+// Short revision, with a suffix if the tree was modified
+func (info BuildInfo) ShortRevision() string {
+	revision := info.Revision
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	if revision != "" && info.Modified {
+		revision += "-dirty"
+	}
+	return revision
+}
+
+// Output of --version
+func printVersion() {
+	fmt.Println(buildInfo.Version)
+	fmt.Printf("  platform: %s\n", buildInfo.Platform())
+	fmt.Printf("  go:       %s\n", buildInfo.GoVersion)
+	fmt.Printf("  static:   %t\n", buildInfo.Static)
+	if revision := buildInfo.ShortRevision(); revision != "" {
+		fmt.Printf("  revision: %s\n", revision)
+	}
+	if buildInfo.Time != "" {
+		fmt.Printf("  built:    %s\n", buildInfo.Time)
+	}
+}
+
+// One-time statexec_build_info series with the build as labels
+func addBuildInfoMetric(timestamp int64) {
+	addStaticMetric("build_info", map[string]string{
+		"version":   buildInfo.Version,
+		"goversion": buildInfo.GoVersion,
+		"goos":      buildInfo.Os,
+		"goarch":    buildInfo.Arch,
+		"revision":  buildInfo.ShortRevision(),
+		"static":    strconv.FormatBool(buildInfo.Static),
+	}, 1, timestamp)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/blackswifthosting/statexec/collectors"
+)
+
+// Series that gopsutil always reports as zero on a platform, as a name and the label pairs they have
+var platformUnavailableMetrics = map[string][][]string{
+	"darwin": {
+		{"cpu_seconds_total", "mode", "iowait"},
+		{"cpu_seconds_total", "mode", "irq"},
+		{"cpu_seconds_total", "mode", "softirq"},
+		{"cpu_seconds_total", "mode", "steal"},
+		{"cpu_seconds_total", "mode", "guest"},
+		{"cpu_seconds_total", "mode", "guestNice"},
+		{"memory_buffers_bytes"},
+		{"memory_cached_bytes"},
+		{"memory_hugepages_total"},
+		{"memory_hugepages_free"},
+		{"memory_hugepages_reserved"},
+		{"memory_hugepages_surplus"},
+		{"memory_hugepage_size_bytes"},
+	},
+	"windows": {
+		{"cpu_seconds_total", "mode", "nice"},
+		{"cpu_seconds_total", "mode", "iowait"},
+		{"cpu_seconds_total", "mode", "softirq"},
+		{"cpu_seconds_total", "mode", "steal"},
+		{"cpu_seconds_total", "mode", "guest"},
+		{"cpu_seconds_total", "mode", "guestNice"},
+		{"memory_buffers_bytes"},
+		{"memory_cached_bytes"},
+		{"memory_swap_in_bytes_total"},
+		{"memory_swap_out_bytes_total"},
+		{"memory_hugepages_total"},
+		{"memory_hugepages_free"},
+		{"memory_hugepages_reserved"},
+		{"memory_hugepages_surplus"},
+		{"memory_hugepage_size_bytes"},
+	},
+}
+
+var (
+	// Series left out of the result file, by name, each with the label pairs it matches
+	unavailableMetrics = make(map[string][][]string)
+	// Collectors that had nothing to report when probed
+	emptyCollectors []string
+)
+
+// Probe the enabled collectors once before the run, to find the metrics this platform cannot provide
+func probeCapabilities() {
+	// Synthetic collectors report everything, and must not be sampled ahead
+	if fakeCollectorsSeed >= 0 {
+		return
+	}
+	var probeSamples []collectors.Sample
+	for _, collector := range sampleCollectors() {
+		// Metrics of the command cannot be probed before it starts
+		if collector.name == "target_go" || collector.name == "jvm" || collector.name == "children" {
+			continue
+		}
+		samples, err := collector.collect()
+		if err != nil {
+			logger.Warn("Collector failed when probed", "collector", collector.name, "error", err)
+			continue
+		}
+		probeSamples = append(probeSamples, samples...)
+
+		// Memory samples are emitted even without values, a collector with only zeros has nothing to report
+		if !slices.ContainsFunc(samples, func(sample collectors.Sample) bool { return sample.Value != 0 }) {
+			emptyCollectors = append(emptyCollectors, collector.name)
+		}
+	}
+
+	// Only series always at zero are left out, some platforms (e.g. FreeBSD) do report them
+	for _, sample := range probeSamples {
+		if sample.Value != 0 {
+			continue
+		}
+		for _, unavailable := range append(platformUnavailableMetrics[runtime.GOOS], backendUnavailableMetrics()...) {
+			if unavailable[0] == sample.Name && matchLabels(sample.Labels, unavailable[1:]) {
+				if !containsLabels(unavailableMetrics[sample.Name], unavailable[1:]) {
+					unavailableMetrics[sample.Name] = append(unavailableMetrics[sample.Name], unavailable[1:])
+				}
+			}
+		}
+	}
+
+	if len(unavailableMetrics) > 0 {
+		logger.Info("Metrics unavailable on this platform are not emitted", "os", runtime.GOOS, "backend", collectors.ActiveBackend().Name, "metrics", strings.Join(unavailableMetricNames(), ","))
+	}
+	if len(emptyCollectors) > 0 {
+		logger.Info("Collectors with nothing to report", "collectors", strings.Join(emptyCollectors, ","))
+	}
+}
+
+// Whether a series is known to be unavailable, and is not worth emitting
+func isUnavailable(name string, labels []string) bool {
+	for _, unavailable := range unavailableMetrics[name] {
+		if matchLabels(labels, unavailable) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether label pairs contain all the wanted label pairs
+func matchLabels(labels []string, wanted []string) bool {
+	for i := 0; i+1 < len(wanted); i += 2 {
+		found := false
+		for j := 0; j+1 < len(labels); j += 2 {
+			if labels[j] == wanted[i] && labels[j+1] == wanted[i+1] {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func containsLabels(list [][]string, labels []string) bool {
+	for _, existing := range list {
+		if strings.Join(existing, "\xff") == strings.Join(labels, "\xff") {
+			return true
+		}
+	}
+	return false
+}
+
+// Names of unavailable series, e.g. cpu_seconds_total{mode="iowait"}
+func unavailableMetricNames() []string {
+	var names []string
+	for name, labelSets := range unavailableMetrics {
+		for _, labels := range labelSets {
+			rendered := name
+			if len(labels) > 0 {
+				var pairs []string
+				for i := 0; i+1 < len(labels); i += 2 {
+					pairs = append(pairs, labels[i]+"=\""+labels[i+1]+"\"")
+				}
+				rendered += "{" + strings.Join(pairs, ",") + "}"
+			}
+			names = append(names, rendered)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Comment lines recording unavailable metrics in the result file
+func capabilityComment() string {
+	comment := ""
+	if len(unavailableMetrics) > 0 {
+		comment += "# Unavailable metrics: " + strings.Join(unavailableMetricNames(), " ") + "\n"
+	}
+	if len(emptyCollectors) > 0 {
+		comment += "# Empty collectors: " + strings.Join(emptyCollectors, " ") + "\n"
+	}
+	return comment
+}
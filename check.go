@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Maximum increase in percent of a summary value compared to the baseline
+type Tolerance struct {
+	Name    string
+	Key     string
+	Percent float64
+}
+
+type ToleranceResult struct {
+	Tolerance    Tolerance
+	Baseline     float64
+	Value        float64
+	DeltaPercent float64
+	Regression   bool
+}
+
+var (
+	checkEnabled     bool = false
+	tolerances       []Tolerance
+	toleranceResults []ToleranceResult
+
+	// Shortcuts to the main summary values
+	toleranceAliases = map[string]string{
+		"cpu":      "cpu_busy_seconds",
+		"duration": "duration_seconds",
+		"memory":   "memory_used_bytes",
+		"disk":     "disk_bytes_per_second",
+		"network":  "network_bytes_per_second",
+	}
+)
+
+// Parse tolerances : "cpu=10%,duration=5%", keys are aliases or summary values names
+func parseTolerances(value string) []Tolerance {
+	var parsed []Tolerance
+	for _, item := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(parts) != 2 {
+			fatalWith(ExitConfig, "Cannot parse tolerance, expected <metric>=<percent>%", "tolerance", item)
+		}
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(parts[1], "%"), 64)
+		if err != nil || percent < 0 {
+			fatalWith(ExitConfig, "Cannot parse tolerance percent", "tolerance", item)
+		}
+		key := parts[0]
+		if alias, ok := toleranceAliases[key]; ok {
+			key = alias
+		}
+		parsed = append(parsed, Tolerance{Name: parts[0], Key: key, Percent: percent})
+	}
+	return parsed
+}
+
+// Add values aggregated over labels to summary values, so they can be compared as a whole
+func addAggregatedValues(values map[string]float64) map[string]float64 {
+	for key, value := range values {
+		if strings.HasPrefix(key, "cpu_mean_seconds{") && !strings.Contains(key, `"idle"`) && !strings.Contains(key, `"iowait"`) {
+			values["cpu_busy_seconds"] += value
+		}
+	}
+	values["disk_bytes_per_second"] = values["disk_mean_read_bytes_per_second"] + values["disk_mean_write_bytes_per_second"]
+	values["network_bytes_per_second"] = values["network_mean_sent_bytes_per_second"] + values["network_mean_received_bytes_per_second"]
+	return values
+}
+
+// Compare the run to the baseline, an increase above the tolerance is a regression
+func checkBaseline() []ToleranceResult {
+	baseline := buildFileReport(baselineFile)
+	baseline.Summary["duration_seconds"] = baseline.DurationSeconds
+	baselineValues := addAggregatedValues(baseline.Summary)
+	runValues := addAggregatedValues(currentSummaryValues())
+
+	var results []ToleranceResult
+	for _, tolerance := range tolerances {
+		baselineValue, okBaseline := baselineValues[tolerance.Key]
+		runValue, okRun := runValues[tolerance.Key]
+		if !okBaseline || !okRun {
+			fatalWith(ExitConfig, "No such summary value to check", "metric", tolerance.Name, "baseline", okBaseline, "run", okRun)
+		}
+
+		result := ToleranceResult{Tolerance: tolerance, Baseline: baselineValue, Value: runValue}
+		if baselineValue != 0 {
+			result.DeltaPercent = (runValue - baselineValue) / baselineValue * 100
+			result.Regression = result.DeltaPercent > tolerance.Percent
+		} else {
+			result.Regression = runValue > 0 && tolerance.Percent == 0
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// Print the diff report on stderr, stdout being reserved to the command
+func printCheckReport(results []ToleranceResult) {
+	fmt.Fprintf(os.Stderr, "Baseline check against %s\n", baselineFile)
+	fmt.Fprintf(os.Stderr, "%-30s %18s %18s %10s %10s  %s\n", "Metric", "Baseline", "Run", "Delta", "Tolerance", "Status")
+	for _, result := range results {
+		status := "ok"
+		if result.Regression {
+			status = "REGRESSION"
+		}
+		fmt.Fprintf(os.Stderr, "%-30s %18f %18f %+9.1f%% %9.1f%%  %s\n", result.Tolerance.Name, result.Baseline, result.Value, result.DeltaPercent, result.Tolerance.Percent, status)
+	}
+}
+
+func checkFailed() bool {
+	for _, result := range toleranceResults {
+		if result.Regression {
+			return true
+		}
+	}
+	return false
+}
+
+// Run a command like the run subcommand, then fail if it regressed compared to the baseline
+func checkSubcommand(args []string) {
+	runArgs := []string{}
+	toleranceValue := "cpu=10%,memory=10%,duration=10%"
+	if value := os.Getenv(EnvVarPrefix + "TOLERANCE"); value != "" {
+		toleranceValue = value
+	}
+
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--" {
+			runArgs = append(runArgs, args[i:]...)
+			break
+		}
+		switch args[i] {
+		case "--tolerance":
+			toleranceValue = flagValue(args, i)
+			i++
+		case "-h", "--help":
+			fmt.Printf("Usage: %s check --baseline <ref.prom> [--tolerance <metric>=<percent>%%,...] [OPTIONS] -- <command> [command args]\n", os.Args[0])
+			fmt.Printf("  --baseline <file.prom>     %sBASELINE    Reference result file\n", EnvVarPrefix)
+			fmt.Printf("  --tolerance <list>         %sTOLERANCE   Maximum increase per metric (default: cpu=10%%,memory=10%%,duration=10%%)\n", EnvVarPrefix)
+			fmt.Println("  Metrics are cpu, memory, duration, disk, network or summary values names as in the report subcommand")
+			fmt.Println("  Other options are the ones of the run subcommand")
+			os.Exit(0)
+		default:
+			runArgs = append(runArgs, args[i])
+		}
+	}
+
+	checkEnabled = true
+	tolerances = parseTolerances(toleranceValue)
+	runSubcommand(runArgs)
+}
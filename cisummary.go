@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	ciSummaryTarget string = ""
+	baselineFile    string = ""
+)
+
+// Peak CPU usage in percent (all modes but idle and iowait) between two consecutive samples of the command window
+func peakCpuPercent(metrics MetricsSnapshot, first int, last int) float64 {
+	cpuSeriesPerMode := make(map[string][]Series)
+	for _, series := range metrics.find("cpu_seconds_total") {
+		cpuSeriesPerMode[series.labels["mode"]] = append(cpuSeriesPerMode[series.labels["mode"]], series)
+	}
+
+	peak := 0.0
+	for i := first + 1; i <= last; i++ {
+		before := metrics.samples[i-1].timestamp
+		after := metrics.samples[i].timestamp
+		// Extra samples taken at command start/stop are too close to be meaningful
+		if after-before < 500 {
+			continue
+		}
+		var busyDelta, totalDelta float64
+		for mode, modeSeries := range cpuSeriesPerMode {
+			delta := increaseBetween(modeSeries, before, after)
+			totalDelta += delta
+			if mode != "idle" && mode != "iowait" {
+				busyDelta += delta
+			}
+		}
+		if totalDelta > 0 && busyDelta/totalDelta*100 > peak {
+			peak = busyDelta / totalDelta * 100
+		}
+	}
+	return peak
+}
+
+func peakMemoryUsedBytes(metrics MetricsSnapshot, first int, last int) uint64 {
+	memoryUsedSeries := metrics.find("memory_used_bytes")
+	var peak float64
+	for i := first; i <= last; i++ {
+		if used := sumAt(memoryUsedSeries, metrics.samples[i].timestamp); used > peak {
+			peak = used
+		}
+	}
+	return uint64(peak)
+}
+
+// Format bytes with a binary unit
+func formatBytes(bytes float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	unit := 0
+	for bytes >= 1024 && unit < len(units)-1 {
+		bytes /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %s", bytes, units[unit])
+}
+
+// Render the run summary as Markdown, compared to a baseline result file if any
+func renderCiSummary() string {
+	metrics := store.Metrics()
+	first, last := commandWindow(metrics)
+	summary := computeSummary(metrics, first, last)
+
+	markdown := fmt.Sprintf("### statexec: %s (%s)\n\n", instance, jobName)
+	markdown += "| | |\n|---|---|\n"
+	markdown += fmt.Sprintf("| Command | `%s` |\n", strings.ReplaceAll(strings.Join(command, " "), "|", "\\|"))
+	markdown += fmt.Sprintf("| Exit code | %d |\n", summary.ExitCode)
+	markdown += fmt.Sprintf("| Duration | %.3fs |\n", summary.DurationSeconds)
+	if enabledCollectors["cpu"] {
+		markdown += fmt.Sprintf("| Peak CPU | %.1f%% of %d cores |\n", peakCpuPercent(metrics, first, last), summary.CpuCores)
+	}
+	if enabledCollectors["memory"] {
+		markdown += fmt.Sprintf("| Peak memory used | %s of %s |\n", formatBytes(float64(peakMemoryUsedBytes(metrics, first, last))), formatBytes(float64(summary.MemoryTotalBytes)))
+	}
+	markdown += fmt.Sprintf("| Network sent / received | %s/s / %s/s |\n", formatBytes(summary.NetworkMeanSentBytesPerSecond), formatBytes(summary.NetworkMeanReceivedBytesPerSecond))
+	markdown += fmt.Sprintf("| Disk read / write | %s/s / %s/s |\n", formatBytes(summary.DiskMeanReadBytesPerSecond), formatBytes(summary.DiskMeanWriteBytesPerSecond))
+
+	if len(assertionResults) > 0 {
+		markdown += "\n#### Assertions\n\n"
+		for _, result := range assertionResults {
+			status := ":white_check_mark:"
+			if !result.Ok {
+				status = ":x:"
+			}
+			markdown += fmt.Sprintf("- %s `%s`\n", status, result.String())
+		}
+	}
+
+	if baselineFile != "" {
+		baseline := buildFileReport(baselineFile)
+		baseline.Summary["duration_seconds"] = baseline.DurationSeconds
+		markdown += fmt.Sprintf("\n#### Compared to baseline `%s`\n\n", baselineFile)
+		markdown += "| Metric | Baseline | Run | Delta |\n|---|---:|---:|---:|\n"
+		for _, value := range compareSummaries(baseline.Summary, currentSummaryValues()) {
+			if value.A == nil || value.B == nil {
+				continue
+			}
+			markdown += fmt.Sprintf("| `%s` | %s | %s | %s |\n", value.Key, formatOptional(value.A, "%.3f"), formatOptional(value.B, "%.3f"), formatOptional(value.DeltaPercent, "%+.1f%%"))
+		}
+	}
+	return markdown + "\n"
+}
+
+// Append the Markdown summary to a file, "auto" being the GitHub Actions job summary
+func writeCiSummary(target string) {
+	if target == "auto" {
+		target = os.Getenv("GITHUB_STEP_SUMMARY")
+		if target == "" {
+			logger.Warn("GITHUB_STEP_SUMMARY is not set, CI summary skipped")
+			return
+		}
+	}
+
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		fatalWith(ExitOutput, "Cannot open CI summary file", "file", target, "error", err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(renderCiSummary()); err != nil {
+		fatalWith(ExitOutput, "Cannot write CI summary", "file", target, "error", err)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blackswifthosting/statexec/collectors"
+)
+
+// A collector gathers its metrics, and returns them as samples or why it could not read them
+type sampleCollector struct {
+	name    string
+	collect func() ([]collectors.Sample, error)
+}
+
+// Samples a collector emitted for a sample
+type collectorSamples struct {
+	collector string
+	samples   []collectors.Sample
+}
+
+type collectorResult struct {
+	name     string
+	duration time.Duration
+	samples  []collectors.Sample
+	err      error
+}
+
+var collectorTimeout int64 = 800 // in milliseconds
+
+// Collectors still running, a collector blocked past its timeout (e.g. on a stalled NFS mount) is not started again until it
+// returns, rather than piling up one blocked goroutine per sample
+var runningCollectors = struct {
+	sync.Mutex
+	names map[string]bool
+}{names: make(map[string]bool)}
+
+// Mark a collector as running, false if it still is from a previous sample
+func startCollector(name string) bool {
+	runningCollectors.Lock()
+	defer runningCollectors.Unlock()
+	if runningCollectors.names[name] {
+		return false
+	}
+	runningCollectors.names[name] = true
+	return true
+}
+
+func finishCollector(name string) {
+	runningCollectors.Lock()
+	defer runningCollectors.Unlock()
+	delete(runningCollectors.names, name)
+}
+
+// Phases of the run: before the command (delay, sync, triggers), while it runs, and after it
+var commandPhases = []string{"pre", "run", "post"}
+
+var (
+	collectPhases    = map[string]bool{"pre": true, "run": true, "post": true} // phases sampled (--collect-phases)
+	postPhaseSampled bool                                                      // only accessed by the collect loop
+	openingSample    *InstantMetric                                            // last sample before the command when pre is not sampled
+)
+
+var phaseOfStatus = map[int]string{CommandStatusPending: "pre", CommandStatusRunning: "run", CommandStatusDone: "post"}
+
+func parseCollectPhases(value string) {
+	collectPhases = make(map[string]bool)
+	for _, phase := range strings.Split(value, ",") {
+		phase = strings.TrimSpace(phase)
+		if !slices.Contains(commandPhases, phase) {
+			fatalWith(ExitConfig, "Unknown collect phase", "phase", phase, "available", strings.Join(commandPhases, ","))
+		}
+		collectPhases[phase] = true
+	}
+	if !collectPhases["run"] {
+		fatalWith(ExitConfig, "Collect phases must include run, the command window of the summary", "phases", value)
+	}
+}
+
+func enabledCollectPhases() []string {
+	var phases []string
+	for _, phase := range commandPhases {
+		if collectPhases[phase] {
+			phases = append(phases, phase)
+		}
+	}
+	return phases
+}
+
+// Whether to sample in the current phase, the samples bracketing the command are always kept as they delimit its window
+func samplePhase(status int) bool {
+	phase := phaseOfStatus[status]
+	if phase == "pre" {
+		return true
+	}
+	if phase == "post" && !postPhaseSampled {
+		postPhaseSampled = true
+		return true
+	}
+	return collectPhases[phase]
+}
+
+// Collectors to run for each sample, their samples are stored in this order
+func sampleCollectors() []sampleCollector {
+	if fakeCollectorsSeed >= 0 {
+		return fakeSampleCollectors()
+	}
+	all := []sampleCollector{
+		{"cpu", func() ([]collectors.Sample, error) {
+			cpu, err := collectors.CollectCpuMetrics()
+			return collectors.CpuSamples(cpu), err
+		}},
+		{"memory", func() ([]collectors.Sample, error) {
+			memory, err := collectors.CollectMemoryMetrics()
+			return collectors.MemorySamples(memory), err
+		}},
+		{"network", func() ([]collectors.Sample, error) {
+			network, err := collectors.CollectNetworkMetrics()
+			return collectors.NetworkSamples(network), err
+		}},
+		{"disk", func() ([]collectors.Sample, error) {
+			disk, err := collectors.CollectDiskMetrics()
+			return collectors.DiskSamples(disk), err
+		}},
+		{"nfs", func() ([]collectors.Sample, error) {
+			return collectors.NfsSamples(collectors.CollectNfsMetrics()), nil
+		}},
+		{"conntrack", func() ([]collectors.Sample, error) {
+			return collectors.ConntrackSamples(collectors.CollectConntrackMetrics()), nil
+		}},
+		{"netstat", func() ([]collectors.Sample, error) {
+			return collectors.NetstatSamples(collectors.CollectNetstatMetrics()), nil
+		}},
+		{"numa", func() ([]collectors.Sample, error) {
+			return collectors.NumaSamples(collectors.CollectNumaMetrics()), nil
+		}},
+		{"kernel", func() ([]collectors.Sample, error) {
+			return collectors.KernelSamples(collectors.CollectKernelMetrics()), nil
+		}},
+		{"clock", func() ([]collectors.Sample, error) {
+			return collectors.ClockSamples(collectors.CollectClockMetrics()), nil
+		}},
+		{"interrupts", func() ([]collectors.Sample, error) {
+			return collectors.InterruptsSamples(collectors.CollectInterruptsMetrics()), nil
+		}},
+	}
+
+	var enabled []sampleCollector
+	for _, collector := range all {
+		if enabledCollectors[collector.name] {
+			enabled = append(enabled, collector)
+		}
+	}
+	if targetPprofUrl != "" {
+		enabled = append(enabled, sampleCollector{"target_go", func() ([]collectors.Sample, error) {
+			return collectors.GoTargetSamples(collectors.CollectGoTargetMetrics(targetPprofUrl)), nil
+		}})
+	}
+	if len(ethtoolInterfaces) > 0 {
+		enabled = append(enabled, sampleCollector{"ethtool", func() ([]collectors.Sample, error) {
+			return collectors.EthtoolSamples(collectors.CollectEthtoolMetrics(ethtoolInterfaces)), nil
+		}})
+	}
+	if traceChildren {
+		enabled = append(enabled, sampleCollector{"children", func() ([]collectors.Sample, error) {
+			return collectors.ChildrenSamples(collectors.CollectChildrenMetrics()), nil
+		}})
+	}
+	if jmxTarget != "" {
+		enabled = append(enabled, sampleCollector{"jvm", func() ([]collectors.Sample, error) {
+			return collectors.JvmSamples(collectors.CollectJvmMetrics(jmxTarget)), nil
+		}})
+	}
+	return enabled
+}
+
+// Store the samples of a collector in the sample
+func (metric *InstantMetric) storeCollector(name string, samples []collectors.Sample) {
+	metric.collected = append(metric.collected, collectorSamples{collector: name, samples: samples})
+}
+
+// Samples of a collector in the sample, if it reported
+func (metric InstantMetric) collectedBy(name string) ([]collectors.Sample, bool) {
+	for _, collected := range metric.collected {
+		if collected.collector == name {
+			return collected.samples, true
+		}
+	}
+	return nil, false
+}
+
+// Gather metrics, collectors run concurrently and the ones failing or slower than the timeout are left out of the sample
+func collectInstantMetrics(msSinceStart int64) {
+	status := store.CommandStatus()
+	if !samplePhase(status) {
+		return
+	}
+	timeBeforeGathering := time.Now()
+	currentTimestamp := metricsStartTime + msSinceStart
+
+	instantMetric := InstantMetric{
+		cmdStatus:          status,
+		msSinceStart:       msSinceStart,
+		timestamp:          currentTimestamp,
+		collectorDurations: make(map[string]int64),
+		collectorFailures:  make(map[string]bool),
+	}
+
+	runCollectors(sampleCollectors(), &instantMetric)
+	instantMetric.collectDuration = time.Since(timeBeforeGathering).Milliseconds()
+	// Synthetic samples do not depend on the speed of the host
+	if fakeCollectorsSeed >= 0 {
+		instantMetric.collectDuration = 0
+		for name := range instantMetric.collectorDurations {
+			instantMetric.collectorDurations[name] = 0
+		}
+	}
+
+	// Only the last sample before the command is kept when pre is not sampled
+	if status == CommandStatusPending && !collectPhases["pre"] {
+		openingSample = &instantMetric
+		return
+	}
+	if openingSample != nil {
+		store.AddMetric(*openingSample)
+		streamSample(*openingSample)
+		openingSample = nil
+	}
+
+	// Add metric to store
+	store.AddMetric(instantMetric)
+	streamSample(instantMetric)
+}
+
+// Run the collectors concurrently into the sample, the ones failing or slower than the timeout are left out of it.
+// Samples are stored in the order of the collectors, whichever returns first.
+func runCollectors(enabled []sampleCollector, metric *InstantMetric) {
+	// Buffered so that late collectors never block
+	results := make(chan collectorResult, len(enabled))
+	pending := make(map[string]bool)
+	reported := make(map[string][]collectors.Sample)
+	for _, collector := range enabled {
+		if !startCollector(collector.name) {
+			logger.Warn("Collector still blocked since a previous sample, left out of the sample", "collector", collector.name)
+			metric.collectorDurations[collector.name] = collectorTimeout
+			metric.collectorFailures[collector.name] = true
+			continue
+		}
+		pending[collector.name] = true
+		go func(collector sampleCollector) {
+			defer finishCollector(collector.name)
+			start := time.Now()
+			samples, err := collector.collect()
+			results <- collectorResult{name: collector.name, duration: time.Since(start), samples: samples, err: err}
+		}(collector)
+	}
+
+	timeout := time.NewTimer(time.Duration(collectorTimeout) * time.Millisecond)
+	defer timeout.Stop()
+	for len(pending) > 0 {
+		select {
+		case result := <-results:
+			if result.err != nil {
+				logger.Warn("Collector failed, left out of the sample", "collector", result.name, "error", result.err)
+				metric.collectorFailures[result.name] = true
+			} else {
+				reported[result.name] = result.samples
+			}
+			metric.collectorDurations[result.name] = result.duration.Milliseconds()
+			delete(pending, result.name)
+		case <-timeout.C:
+			for name := range pending {
+				logger.Warn("Collector timed out, left out of the sample", "collector", name, "timeout_ms", collectorTimeout)
+				metric.collectorDurations[name] = collectorTimeout
+				metric.collectorFailures[name] = true
+			}
+			pending = nil
+		}
+	}
+	for _, collector := range enabled {
+		if samples, ok := reported[collector.name]; ok {
+			metric.storeCollector(collector.name, samples)
+		}
+	}
+}
+
+// Collectors which failed or timed out in every sample of the run, the result has none of their metrics
+func failedCollectors(metrics MetricsSnapshot) []string {
+	var failed []string
+	for _, series := range metrics.find("collector_success") {
+		if len(series.values) > 0 && !slices.Contains(series.values, 1) {
+			failed = append(failed, series.labels["collector"])
+		}
+	}
+	slices.Sort(failed)
+	return failed
+}
+
+// Fail when a collector could not read its counters for the whole run, results are written anyway
+func exitIfCollectorsFailed() {
+	if failed := failedCollectors(store.Metrics()); len(failed) > 0 {
+		logger.Error("Collectors failed for the whole run, their metrics are missing", "collectors", strings.Join(failed, ","))
+		os.Exit(ExitCollector)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/blackswifthosting/statexec/collectors"
+)
+
+// Sample of the cpu and memory collectors, the memory one timing out when asked
+func testSample(timestamp int64, memoryTimedOut bool, memoryUsed uint64) InstantMetric {
+	metric := InstantMetric{
+		cmdStatus:          CommandStatusRunning,
+		timestamp:          timestamp,
+		collectorDurations: map[string]int64{"cpu": 1, "memory": 1},
+		collectorFailures:  make(map[string]bool),
+	}
+	metric.storeCollector("cpu", collectors.CpuSamples([]collectors.CpuMetrics{{Cpu: "cpu0", CpuTimePerMode: map[string]float64{"user": float64(timestamp) / 1000}}}))
+	if memoryTimedOut {
+		metric.collectorDurations["memory"] = collectorTimeout
+		metric.collectorFailures["memory"] = true
+	} else {
+		metric.storeCollector("memory", collectors.MemorySamples(collectors.MemoryMetrics{Total: 4096, Used: memoryUsed}))
+	}
+	return metric
+}
+
+func pointNames(metric InstantMetric) map[string]bool {
+	names := make(map[string]bool)
+	for _, point := range metric.points() {
+		names[point.Name] = true
+	}
+	return names
+}
+
+func TestPointsLeaveOutTimedOutCollector(t *testing.T) {
+	names := pointNames(testSample(1000, true, 0))
+	if names["memory_used_bytes"] {
+		t.Error("memory_used_bytes emitted for a memory collector that timed out")
+	}
+	if !names["cpu_seconds_total"] {
+		t.Error("cpu_seconds_total missing for a cpu collector that reported")
+	}
+	if !names["collector_success"] {
+		t.Error("collector_success missing for a collector that timed out, the gap must stay explained")
+	}
+
+	names = pointNames(testSample(1000, false, 1024))
+	if !names["memory_used_bytes"] {
+		t.Error("memory_used_bytes missing for a memory collector that reported")
+	}
+}
+
+func TestPointsLeaveOutDisabledCollector(t *testing.T) {
+	metric := InstantMetric{cmdStatus: CommandStatusRunning}
+	names := pointNames(metric)
+	for _, name := range []string{"memory_used_bytes", "memory_total_bytes", "memory_swap_used_bytes"} {
+		if names[name] {
+			t.Errorf("%s emitted without the memory collector", name)
+		}
+	}
+	if !names["command_status"] {
+		t.Error("command_status missing")
+	}
+}
+
+func TestFailedCollectors(t *testing.T) {
+	testStore := newTestStore()
+	testStore.AddMetric(testSample(1000, true, 0))
+	testStore.AddMetric(testSample(2000, true, 0))
+	if failed := failedCollectors(testStore.Metrics()); len(failed) != 1 || failed[0] != "memory" {
+		t.Errorf("failed collectors = %v, want [memory]", failed)
+	}
+
+	// A single sample is enough for the collector not to fail the run
+	testStore.AddMetric(testSample(3000, false, 1024))
+	if failed := failedCollectors(testStore.Metrics()); len(failed) != 0 {
+		t.Errorf("failed collectors = %v, want none", failed)
+	}
+}
+
+// A collector failing to read its counters is left out of the sample instead of stopping the run
+func TestRunCollectorsLeavesOutFailedCollector(t *testing.T) {
+	metric := InstantMetric{collectorDurations: make(map[string]int64), collectorFailures: make(map[string]bool)}
+	runCollectors([]sampleCollector{
+		{"cpu", func() ([]collectors.Sample, error) {
+			return collectors.CpuSamples([]collectors.CpuMetrics{{Cpu: "cpu0"}}), nil
+		}},
+		{"memory", func() ([]collectors.Sample, error) {
+			return nil, errors.New("cannot read /proc/meminfo")
+		}},
+	}, &metric)
+
+	if _, ok := metric.collectedBy("cpu"); !ok || metric.collectorFailures["cpu"] {
+		t.Error("cpu collector not stored")
+	}
+	if _, ok := metric.collectedBy("memory"); ok || !metric.collectorFailures["memory"] {
+		t.Error("failed memory collector stored in the sample")
+	}
+	if _, ok := metric.collectorDurations["memory"]; !ok {
+		t.Error("no duration for the failed memory collector")
+	}
+}
+
+// A collector blocked past its timeout is not started again, samples do not pile up blocked goroutines
+func TestRunCollectorsSkipsBlockedCollector(t *testing.T) {
+	defer func(timeout int64) { collectorTimeout = timeout }(collectorTimeout)
+	collectorTimeout = 10
+
+	unblock := make(chan struct{})
+	var calls atomic.Int32
+	blocking := []sampleCollector{{"nfs", func() ([]collectors.Sample, error) {
+		calls.Add(1)
+		<-unblock
+		return nil, nil
+	}}}
+	sample := func() InstantMetric {
+		metric := InstantMetric{collectorDurations: make(map[string]int64), collectorFailures: make(map[string]bool)}
+		runCollectors(blocking, &metric)
+		return metric
+	}
+
+	for i := 0; i < 3; i++ {
+		if metric := sample(); !metric.collectorFailures["nfs"] {
+			t.Fatalf("sample %d has the blocked collector", i)
+		}
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("blocked collector started %d times, want once", got)
+	}
+
+	// Once it returns, it runs again in the next sample
+	close(unblock)
+	for deadline := time.Now().Add(time.Second); !startCollector("nfs"); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("blocked collector still running once unblocked")
+		}
+	}
+	finishCollector("nfs")
+	if metric := sample(); metric.collectorFailures["nfs"] || calls.Load() != 2 {
+		t.Errorf("collector not run again once unblocked, %d calls", calls.Load())
+	}
+}
+
+func TestSummaryAveragesOnlyCollectedMemory(t *testing.T) {
+	testStore := &Store{seriesIndex: make(map[string]*Series)}
+	testStore.AddMetric(testSample(1000, false, 1000))
+	testStore.AddMetric(testSample(2000, true, 0))
+	testStore.AddMetric(testSample(3000, false, 3000))
+
+	summary := computeSummary(testStore.Metrics(), 0, 2)
+	if summary.MemoryUsedBytes != 2000 {
+		t.Errorf("memory used = %d, want 2000, the sample without memory left out of the mean", summary.MemoryUsedBytes)
+	}
+	if summary.MemoryTotalBytes != 4096 {
+		t.Errorf("memory total = %d, want 4096", summary.MemoryTotalBytes)
+	}
+}
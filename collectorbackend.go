@@ -0,0 +1,103 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/blackswifthosting/statexec/collectors"
+)
+
+var collectorBackend string = "auto" // source of the CPU and memory counters, auto falling back from /proc to syscalls (--collector-backend)
+
+// Backend the counters were read from, and the features it has, for the manifest
+type CollectorBackendStatus struct {
+	Name     string          `json:"name"`
+	Features map[string]bool `json:"features"`
+}
+
+var collectorBackendStatus *CollectorBackendStatus
+
+// Series left at zero by a backend lacking a feature, as a name and the label pairs they have
+var backendFeatureMetrics = map[string][][]string{
+	"memory_buffers": {{"memory_buffers_bytes"}},
+	"memory_cache":   {{"memory_cached_bytes"}},
+	"swap":           {{"memory_swap_total_bytes"}, {"memory_swap_used_bytes"}},
+	"swap_io":        {{"memory_swap_in_bytes_total"}, {"memory_swap_out_bytes_total"}},
+	"hugepages": {
+		{"memory_hugepages_total"},
+		{"memory_hugepages_free"},
+		{"memory_hugepages_reserved"},
+		{"memory_hugepages_surplus"},
+		{"memory_hugepage_size_bytes"},
+	},
+}
+
+// Select the backend of the collectors, before they are probed
+func selectCollectorBackend() {
+	if fakeCollectorsSeed >= 0 {
+		return
+	}
+	backend, err := collectors.SelectBackend(collectorBackend)
+	if err != nil {
+		// A backend of the platform which cannot read this host is a collector failure, an unknown one a mistake
+		code := ExitCollector
+		if collectorBackend != "auto" && !slices.Contains(collectors.BackendNames(), collectorBackend) {
+			code = ExitConfig
+		}
+		fatalWith(code, "Cannot select collector backend", "backend", collectorBackend, "error", err)
+	}
+	collectorBackendStatus = &CollectorBackendStatus{Name: backend.Name, Features: backend.Features}
+
+	if missing := missingBackendFeatures(); len(missing) > 0 {
+		logger.Info("Collector backend without some features, their series are not emitted", "backend", backend.Name, "missing", strings.Join(missing, ","))
+	} else {
+		logger.Debug("Collector backend selected", "backend", backend.Name)
+	}
+}
+
+func missingBackendFeatures() []string {
+	var missing []string
+	for _, feature := range collectors.BackendFeatures {
+		if !collectorBackendStatus.Features[feature] {
+			missing = append(missing, feature)
+		}
+	}
+	return missing
+}
+
+// Series the selected backend cannot provide, in the form of the platform ones
+func backendUnavailableMetrics() [][]string {
+	if collectorBackendStatus == nil {
+		return nil
+	}
+	var unavailable [][]string
+	for _, feature := range missingBackendFeatures() {
+		unavailable = append(unavailable, backendFeatureMetrics[feature]...)
+	}
+	return unavailable
+}
+
+// Backend and its features as info metrics, to tell a missing feature from a zero counter
+func addCollectorBackendMetrics(timestamp int64) {
+	if collectorBackendStatus == nil {
+		return
+	}
+	addStaticMetric("collector_backend_info", map[string]string{"backend": collectorBackendStatus.Name}, 1, timestamp)
+
+	features := make([]string, 0, len(collectorBackendStatus.Features))
+	for feature := range collectorBackendStatus.Features {
+		features = append(features, feature)
+	}
+	sort.Strings(features)
+	for _, feature := range features {
+		available := 0.0
+		if collectorBackendStatus.Features[feature] {
+			available = 1
+		}
+		addStaticMetric("collector_backend_feature", map[string]string{
+			"backend": collectorBackendStatus.Name,
+			"feature": feature,
+		}, available, timestamp)
+	}
+}
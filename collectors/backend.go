@@ -0,0 +1,73 @@
+package collectors
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Features a collector backend may lack, a missing one leaving its series at zero
+var BackendFeatures = []string{"cpu_times", "cpu_per_core", "memory", "memory_buffers", "memory_cache", "swap", "swap_io", "hugepages"}
+
+// Source of the CPU times and memory usage: /proc through gopsutil where mounted, else syscalls for minimal containers and jails
+type Backend struct {
+	Name      string
+	Features  map[string]bool
+	available func() bool
+	cpu       func() ([]CpuMetrics, error)
+	memory    func() (MemoryMetrics, error)
+}
+
+var activeBackend *Backend
+
+// Names of the backends of the platform, in the order auto tries them
+func BackendNames() []string {
+	var names []string
+	for _, backend := range platformBackends() {
+		names = append(names, backend.Name)
+	}
+	return names
+}
+
+// Select the backend of the collectors, auto being the first available one of the platform
+func SelectBackend(name string) (Backend, error) {
+	for _, backend := range platformBackends() {
+		if name != "auto" && name != backend.Name {
+			continue
+		}
+		if !backend.available() {
+			if name == "auto" {
+				continue
+			}
+			return Backend{}, fmt.Errorf("collector backend %s not available on this host", name)
+		}
+		activeBackend = &backend
+		return backend, nil
+	}
+	if name == "auto" {
+		return Backend{}, fmt.Errorf("no collector backend available on this host")
+	}
+	return Backend{}, fmt.Errorf("unknown collector backend %s, available: auto,%s", name, strings.Join(BackendNames(), ","))
+}
+
+// Backend in use, the first available one if none was selected
+func ActiveBackend() Backend {
+	if activeBackend == nil {
+		if _, err := SelectBackend("auto"); err != nil {
+			backend := platformBackends()[0]
+			activeBackend = &backend
+		}
+	}
+	return *activeBackend
+}
+
+// Features of a backend from the ones it has, the others set to false
+func backendFeatures(features ...string) map[string]bool {
+	result := make(map[string]bool, len(BackendFeatures))
+	for _, feature := range BackendFeatures {
+		result[feature] = false
+	}
+	for _, feature := range features {
+		result[feature] = true
+	}
+	return result
+}
@@ -0,0 +1,60 @@
+//go:build linux
+
+package collectors
+
+import (
+	"os"
+	"syscall"
+)
+
+// procfs through gopsutil, then the sysinfo syscall which only needs the kernel
+func platformBackends() []Backend {
+	return []Backend{
+		{
+			Name:      "procfs",
+			Features:  backendFeatures(BackendFeatures...),
+			available: procMounted,
+			cpu:       gopsutilCpuMetrics,
+			memory:    gopsutilMemoryMetrics,
+		},
+		{
+			Name:      "sysinfo",
+			Features:  backendFeatures("memory", "memory_buffers", "swap"),
+			available: func() bool { return true },
+			cpu:       func() ([]CpuMetrics, error) { return nil, nil },
+			memory:    sysinfoMemoryMetrics,
+		},
+	}
+}
+
+func procMounted() bool {
+	_, err := os.Stat("/proc/stat")
+	return err == nil
+}
+
+// Memory and swap usage from sysinfo(2), without the page cache which only /proc/meminfo reports: available memory is
+// underestimated by the cache size
+func sysinfoMemoryMetrics() (MemoryMetrics, error) {
+	var info syscall.Sysinfo_t
+	if err := syscall.Sysinfo(&info); err != nil {
+		return MemoryMetrics{}, err
+	}
+	unit := uint64(info.Unit)
+	if unit == 0 {
+		unit = 1
+	}
+
+	memoryMetrics := MemoryMetrics{
+		Total:     uint64(info.Totalram) * unit,
+		Free:      uint64(info.Freeram) * unit,
+		Buffers:   uint64(info.Bufferram) * unit,
+		SwapTotal: uint64(info.Totalswap) * unit,
+		SwapUsed:  uint64(info.Totalswap-info.Freeswap) * unit,
+	}
+	memoryMetrics.Available = memoryMetrics.Free + memoryMetrics.Buffers
+	memoryMetrics.Used = memoryMetrics.Total - memoryMetrics.Available
+	if memoryMetrics.Total > 0 {
+		memoryMetrics.UsedPercent = float64(memoryMetrics.Used) / float64(memoryMetrics.Total) * 100
+	}
+	return memoryMetrics, nil
+}
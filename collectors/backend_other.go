@@ -0,0 +1,25 @@
+//go:build !linux
+
+package collectors
+
+import (
+	"runtime"
+)
+
+// gopsutil reads the kernel through sysctl on macOS and the BSDs, through the native APIs elsewhere
+func platformBackends() []Backend {
+	name := "native"
+	switch runtime.GOOS {
+	case "darwin", "freebsd", "openbsd", "netbsd", "dragonfly":
+		name = "sysctl"
+	}
+	return []Backend{
+		{
+			Name:      name,
+			Features:  backendFeatures("cpu_times", "cpu_per_core", "memory", "swap", "swap_io"),
+			available: func() bool { return true },
+			cpu:       gopsutilCpuMetrics,
+			memory:    gopsutilMemoryMetrics,
+		},
+	}
+}
@@ -0,0 +1,27 @@
+package collectors
+
+// Processes of the command tree as reported by the kernel on each fork, exec and exit, short-lived ones included
+type ChildrenMetrics struct {
+	Available        bool
+	Spawned          uint64 // processes forked in the tree, threads excluded
+	Execs            uint64
+	Exited           uint64
+	Running          uint64 // processes of the tree alive at the time of the sample
+	CpuUserSeconds   float64
+	CpuSystemSeconds float64 // of the whole tree, exited processes included once reaped by their parent
+}
+
+// Samples of the processes of the command tree, none when they cannot be traced
+func ChildrenSamples(children ChildrenMetrics) []Sample {
+	if !children.Available {
+		return nil
+	}
+	return []Sample{
+		intCounter("command_processes_spawned_total", children.Spawned),
+		intCounter("command_execs_total", children.Execs),
+		intCounter("command_processes_exited_total", children.Exited),
+		intGauge("command_processes", children.Running),
+		counter("command_cpu_seconds_total", children.CpuUserSeconds, "mode", "user"),
+		counter("command_cpu_seconds_total", children.CpuSystemSeconds, "mode", "system"),
+	}
+}
@@ -0,0 +1,198 @@
+//go:build linux
+
+package collectors
+
+import (
+	"encoding/binary"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const (
+	netlinkConnector   = 11 // NETLINK_CONNECTOR
+	connectorProcIdx   = 1  // CN_IDX_PROC
+	connectorProcVal   = 1  // CN_VAL_PROC
+	procMcastListen    = 1  // PROC_CN_MCAST_LISTEN
+	procEventFork      = 0x00000001
+	procEventExec      = 0x00000002
+	procEventExit      = 0x80000000
+	netlinkHeaderLen   = 16 // struct nlmsghdr
+	connectorHeaderLen = 20 // struct cn_msg
+	procEventHeaderLen = 16 // what, cpu, timestamp_ns of struct proc_event
+	clockTicksPerSec   = 100
+)
+
+// Command tree built from the events of the proc connector
+type childrenTracker struct {
+	sync.Mutex
+	fd      int
+	root    chan int // pid of the command, events wait for it
+	tree    map[int]bool
+	metrics ChildrenMetrics
+	cpuMax  float64 // CPU time never decreases, even when an orphan of the tree exits
+	final   bool
+}
+
+var tracker *childrenTracker
+
+// Subscribe to the process events before the command starts, so none of its children are missed (needs CAP_NET_ADMIN)
+func StartChildrenTracker() error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM, netlinkConnector)
+	if err != nil {
+		return err
+	}
+	// Builds fork thousands of processes per second, give the events room while the tracker waits for the command pid
+	_ = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, 4*1024*1024)
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: connectorProcIdx}); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+
+	listen := make([]byte, netlinkHeaderLen+connectorHeaderLen+4)
+	binary.NativeEndian.PutUint32(listen[0:], uint32(len(listen)))
+	binary.NativeEndian.PutUint16(listen[4:], syscall.NLMSG_DONE)
+	binary.NativeEndian.PutUint32(listen[12:], uint32(os.Getpid()))
+	binary.NativeEndian.PutUint32(listen[16:], connectorProcIdx)
+	binary.NativeEndian.PutUint32(listen[20:], connectorProcVal)
+	binary.NativeEndian.PutUint16(listen[32:], 4)
+	binary.NativeEndian.PutUint32(listen[36:], procMcastListen)
+	if err := syscall.Sendto(fd, listen, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+
+	tracker = &childrenTracker{fd: fd, root: make(chan int, 1), tree: make(map[int]bool)}
+	go tracker.run()
+	return nil
+}
+
+// Root the tree at the started command
+func TrackChildren(pid int) {
+	if tracker != nil {
+		tracker.root <- pid
+	}
+}
+
+// Once the command is reaped its CPU time, and the one of the descendants it reaped, is only known from wait4
+func FinishChildren(userSeconds float64, systemSeconds float64) {
+	if tracker == nil {
+		return
+	}
+	tracker.Lock()
+	defer tracker.Unlock()
+	tracker.final = true
+	if userSeconds+systemSeconds >= tracker.cpuMax {
+		tracker.metrics.CpuUserSeconds = userSeconds
+		tracker.metrics.CpuSystemSeconds = systemSeconds
+	}
+}
+
+func CollectChildrenMetrics() ChildrenMetrics {
+	if tracker == nil {
+		return ChildrenMetrics{}
+	}
+	tracker.Lock()
+	defer tracker.Unlock()
+
+	tracker.metrics.Running = uint64(len(tracker.tree))
+	if !tracker.final {
+		// Each process accounts for its own CPU time and the one of the children it reaped
+		var user, system float64
+		for pid := range tracker.tree {
+			processUser, processSystem, ok := processTreeCpu(pid)
+			if ok {
+				user += processUser
+				system += processSystem
+			}
+		}
+		if user+system >= tracker.cpuMax {
+			tracker.cpuMax = user + system
+			tracker.metrics.CpuUserSeconds = user
+			tracker.metrics.CpuSystemSeconds = system
+		}
+	}
+	return tracker.metrics
+}
+
+func (tracker *childrenTracker) run() {
+	root := <-tracker.root
+	tracker.Lock()
+	tracker.tree[root] = true
+	tracker.metrics.Available = true
+	tracker.Unlock()
+
+	buffer := make([]byte, 64*1024)
+	for {
+		n, _, err := syscall.Recvfrom(tracker.fd, buffer, 0)
+		if err == syscall.ENOBUFS {
+			// Events were lost, the counts are a lower bound
+			continue
+		}
+		if err != nil {
+			return
+		}
+		messages, err := syscall.ParseNetlinkMessage(buffer[:n])
+		if err != nil {
+			continue
+		}
+		tracker.Lock()
+		for _, message := range messages {
+			tracker.handle(message.Data)
+		}
+		tracker.Unlock()
+	}
+}
+
+func (tracker *childrenTracker) handle(data []byte) {
+	if len(data) < connectorHeaderLen+procEventHeaderLen+8 {
+		return
+	}
+	event := data[connectorHeaderLen:]
+	field := func(index int) int {
+		offset := procEventHeaderLen + index*4
+		if offset+4 > len(event) {
+			return 0
+		}
+		return int(binary.NativeEndian.Uint32(event[offset:]))
+	}
+
+	switch binary.NativeEndian.Uint32(event[0:]) {
+	case procEventFork:
+		// parent_pid, parent_tgid, child_pid, child_tgid, threads share the tgid of their parent
+		parentTgid, childPid, childTgid := field(1), field(2), field(3)
+		if tracker.tree[parentTgid] && childPid == childTgid && childTgid != parentTgid {
+			tracker.tree[childTgid] = true
+			tracker.metrics.Spawned++
+		}
+	case procEventExec:
+		if tracker.tree[field(1)] {
+			tracker.metrics.Execs++
+		}
+	case procEventExit:
+		pid, tgid := field(0), field(1)
+		if pid == tgid && tracker.tree[tgid] {
+			delete(tracker.tree, tgid)
+			tracker.metrics.Exited++
+		}
+	}
+}
+
+// utime, stime, cutime and cstime of /proc/<pid>/stat, in seconds
+func processTreeCpu(pid int) (float64, float64, bool) {
+	content, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return 0, 0, false
+	}
+	// The command name can contain spaces and parentheses, fields start after the last one
+	stat := string(content)
+	fields := strings.Fields(stat[strings.LastIndexByte(stat, ')')+1:])
+	if len(fields) < 15 {
+		return 0, 0, false
+	}
+	user := float64(parseUint(fields[11])+parseUint(fields[13])) / clockTicksPerSec
+	system := float64(parseUint(fields[12])+parseUint(fields[14])) / clockTicksPerSec
+	return user, system, true
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package collectors
+
+import "errors"
+
+// The proc connector is Linux only
+func StartChildrenTracker() error {
+	return errors.New("process events are only available on Linux")
+}
+
+func TrackChildren(pid int) {}
+
+func FinishChildren(userSeconds float64, systemSeconds float64) {}
+
+func CollectChildrenMetrics() ChildrenMetrics {
+	return ChildrenMetrics{}
+}
@@ -0,0 +1,26 @@
+package collectors
+
+type ClockMetrics struct {
+	Available    bool
+	Synchronized bool
+	OffsetMs     float64 // Estimated offset to the NTP reference
+	MaxErrorMs   float64
+	EstErrorMs   float64
+}
+
+// Samples of the kernel clock synchronization, none without adjtimex
+func ClockSamples(clock ClockMetrics) []Sample {
+	if !clock.Available {
+		return nil
+	}
+	synchronized := uint64(0)
+	if clock.Synchronized {
+		synchronized = 1
+	}
+	return []Sample{
+		intGauge("clock_synchronized", synchronized),
+		gauge("clock_offset_seconds", clock.OffsetMs/1000.0),
+		gauge("clock_max_error_seconds", clock.MaxErrorMs/1000.0),
+		gauge("clock_estimated_error_seconds", clock.EstErrorMs/1000.0),
+	}
+}
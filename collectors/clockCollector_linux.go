@@ -0,0 +1,34 @@
+//go:build linux
+
+package collectors
+
+import (
+	"syscall"
+)
+
+const (
+	adjtimexStatusUnsync = 0x0040 // STA_UNSYNC
+	adjtimexStatusNano   = 0x2000 // STA_NANO
+)
+
+// Collect the kernel clock discipline state, as maintained by chrony/ntpd (read-only adjtimex)
+func CollectClockMetrics() ClockMetrics {
+	var timex syscall.Timex
+	if _, err := syscall.Adjtimex(&timex); err != nil {
+		return ClockMetrics{}
+	}
+
+	// Offset is in microseconds, or nanoseconds when STA_NANO is set
+	offsetMs := float64(timex.Offset) / 1000.0
+	if timex.Status&adjtimexStatusNano != 0 {
+		offsetMs = float64(timex.Offset) / 1000000.0
+	}
+
+	return ClockMetrics{
+		Available:    true,
+		Synchronized: timex.Status&adjtimexStatusUnsync == 0,
+		OffsetMs:     offsetMs,
+		MaxErrorMs:   float64(timex.Maxerror) / 1000.0,
+		EstErrorMs:   float64(timex.Esterror) / 1000.0,
+	}
+}
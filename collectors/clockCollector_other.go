@@ -0,0 +1,8 @@
+//go:build !linux
+
+package collectors
+
+// Clock discipline state is only available on Linux
+func CollectClockMetrics() ClockMetrics {
+	return ClockMetrics{}
+}
@@ -0,0 +1,41 @@
+package collectors
+
+const (
+	conntrackCountPath = "/proc/sys/net/netfilter/nf_conntrack_count"
+	conntrackMaxPath   = "/proc/sys/net/netfilter/nf_conntrack_max"
+)
+
+type ConntrackMetrics struct {
+	Available bool
+	Entries   uint64
+	Limit     uint64
+}
+
+// Collect netfilter connection tracking table usage (Linux only, nf_conntrack module loaded)
+func CollectConntrackMetrics() ConntrackMetrics {
+	entries, err := readUintFile(conntrackCountPath)
+	if err != nil {
+		return ConntrackMetrics{}
+	}
+	limit, err := readUintFile(conntrackMaxPath)
+	if err != nil {
+		return ConntrackMetrics{}
+	}
+
+	return ConntrackMetrics{
+		Available: true,
+		Entries:   entries,
+		Limit:     limit,
+	}
+}
+
+// Samples of the connection tracking table, none without conntrack
+func ConntrackSamples(conntrack ConntrackMetrics) []Sample {
+	if !conntrack.Available {
+		return nil
+	}
+	return []Sample{
+		intGauge("conntrack_entries", conntrack.Entries),
+		intGauge("conntrack_entries_limit", conntrack.Limit),
+	}
+}
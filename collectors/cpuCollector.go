@@ -2,6 +2,8 @@ package collectors
 
 import (
 	"fmt"
+	"path/filepath"
+	"sort"
 
 	"github.com/shirou/gopsutil/v3/cpu"
 )
@@ -39,12 +41,20 @@ func getCpuTimeByMode(cpuTimeStat *cpu.TimesStat, mode string) float64 {
 	}
 }
 
-func CollectCpuMetrics() []CpuMetrics {
+func CollectCpuMetrics() ([]CpuMetrics, error) {
+	cpuMetrics, err := ActiveBackend().cpu()
+	if err != nil {
+		return nil, fmt.Errorf("cannot retrieve CPU times from the %s backend: %w", ActiveBackend().Name, err)
+	}
+	return cpuMetrics, nil
+}
+
+// CPU times as gopsutil reads them, from /proc on Linux and sysctl on the BSDs
+func gopsutilCpuMetrics() ([]CpuMetrics, error) {
 	var cpuMetrics []CpuMetrics
 	cpuTimeStat, err := cpu.Times(true)
 	if err != nil {
-		fmt.Println("Error retrieving CPU Times:", err)
-		panic(err)
+		return nil, err
 	}
 
 	// CpuFreqStat, _ := cpu.Info()
@@ -59,5 +69,61 @@ func CollectCpuMetrics() []CpuMetrics {
 
 		cpuMetrics = append(cpuMetrics, CpuMetrics{Cpu: cpuTime.CPU, CpuTimePerMode: cpuTimePerMode})
 	}
-	return cpuMetrics
+	return cpuMetrics, nil
+}
+
+// Frequency scaling and SMT state of the CPUs, which explain most differences between runs on the same machine (sysfs, Linux only)
+type CpuTuningInfo struct {
+	Driver    string         // cpufreq scaling driver, empty without cpufreq (e.g. in most VMs)
+	Governors map[string]int // number of CPUs per scaling governor
+	Turbo     string         // on or off, empty when there is no turbo/boost control
+	Smt       string         // SMT control: on, off, forceoff, notsupported or notimplemented, empty on older kernels
+	SmtActive bool
+}
+
+func CollectCpuTuningInfo() CpuTuningInfo {
+	info := CpuTuningInfo{Governors: make(map[string]int)}
+
+	governorPaths, _ := filepath.Glob("/sys/devices/system/cpu/cpu[0-9]*/cpufreq/scaling_governor")
+	for _, governorPath := range governorPaths {
+		if governor := readStringFile(governorPath, ""); governor != "" {
+			info.Governors[governor]++
+		}
+		if info.Driver == "" {
+			info.Driver = readStringFile(filepath.Join(filepath.Dir(governorPath), "scaling_driver"), "")
+		}
+	}
+
+	// intel_pstate inverts the flag, the other drivers expose the generic boost switch
+	if noTurbo := readStringFile("/sys/devices/system/cpu/intel_pstate/no_turbo", ""); noTurbo != "" {
+		info.Turbo = "on"
+		if noTurbo == "1" {
+			info.Turbo = "off"
+		}
+	} else if boost := readStringFile("/sys/devices/system/cpu/cpufreq/boost", ""); boost != "" {
+		info.Turbo = "off"
+		if boost == "1" {
+			info.Turbo = "on"
+		}
+	}
+
+	info.Smt = readStringFile("/sys/devices/system/cpu/smt/control", "")
+	info.SmtActive = readStringFile("/sys/devices/system/cpu/smt/active", "0") == "1"
+	return info
+}
+
+// Samples of the CPU times, per CPU and mode
+func CpuSamples(cpuMetrics []CpuMetrics) []Sample {
+	var samples []Sample
+	for _, cpuMetric := range cpuMetrics {
+		modes := make([]string, 0, len(cpuMetric.CpuTimePerMode))
+		for mode := range cpuMetric.CpuTimePerMode {
+			modes = append(modes, mode)
+		}
+		sort.Strings(modes)
+		for _, mode := range modes {
+			samples = append(samples, counter("cpu_seconds_total", cpuMetric.CpuTimePerMode[mode], "cpu", cpuMetric.Cpu, "mode", mode))
+		}
+	}
+	return samples
 }
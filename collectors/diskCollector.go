@@ -2,6 +2,8 @@ package collectors
 
 import (
 	"fmt"
+	"path/filepath"
+	"strings"
 
 	"github.com/shirou/gopsutil/v3/disk"
 )
@@ -12,17 +14,79 @@ type DiskMetrics struct {
 	WriteBytesTotal uint64
 }
 
-func CollectDiskMetrics() []DiskMetrics {
+type DiskQueueInfo struct {
+	Device      string
+	Scheduler   string // active IO scheduler, e.g. mq-deadline, none
+	Requests    int64  // queue depth (nr_requests), -1 if unknown
+	Rotational  bool
+	ReadAheadKb int64 // -1 if unknown
+}
+
+func CollectDiskMetrics() ([]DiskMetrics, error) {
 	var diskMetrics []DiskMetrics
 	diskStat, err := disk.IOCounters()
 	if err != nil {
-		fmt.Println("Error retrieving Disk IO Counters:", err)
-		panic(err)
+		return nil, fmt.Errorf("cannot retrieve disk IO counters: %w", err)
 	}
 
 	for device, diskIO := range diskStat {
 		diskMetrics = append(diskMetrics, DiskMetrics{Device: device, ReadBytesTotal: diskIO.ReadBytes, WriteBytesTotal: diskIO.WriteBytes})
 	}
 
-	return diskMetrics
+	return diskMetrics, nil
+}
+
+// Collect IO scheduler, queue depth, rotational flag and read-ahead of each block device (sysfs, Linux only)
+func CollectDiskQueueInfo() []DiskQueueInfo {
+	var disksQueueInfo []DiskQueueInfo
+
+	queuePaths, _ := filepath.Glob("/sys/block/*/queue")
+	for _, queuePath := range queuePaths {
+		device := filepath.Base(filepath.Dir(queuePath))
+		// Loop and ram devices are not storage a benchmark runs on
+		if strings.HasPrefix(device, "loop") || strings.HasPrefix(device, "ram") {
+			continue
+		}
+		queueInfo := DiskQueueInfo{
+			Device:      device,
+			Scheduler:   activeScheduler(readStringFile(filepath.Join(queuePath, "scheduler"), "")),
+			Requests:    -1,
+			Rotational:  readStringFile(filepath.Join(queuePath, "rotational"), "0") == "1",
+			ReadAheadKb: -1,
+		}
+		if requests, err := readUintFile(filepath.Join(queuePath, "nr_requests")); err == nil {
+			queueInfo.Requests = int64(requests)
+		}
+		if readAheadKb, err := readUintFile(filepath.Join(queuePath, "read_ahead_kb")); err == nil {
+			queueInfo.ReadAheadKb = int64(readAheadKb)
+		}
+		disksQueueInfo = append(disksQueueInfo, queueInfo)
+	}
+
+	return disksQueueInfo
+}
+
+// Active scheduler of a "none [mq-deadline] kyber bfq" list, devices without scheduler show "none"
+func activeScheduler(schedulers string) string {
+	if _, active, ok := strings.Cut(schedulers, "["); ok {
+		active, _, _ = strings.Cut(active, "]")
+		return active
+	}
+	if schedulers == "" {
+		return "unknown"
+	}
+	return schedulers
+}
+
+// Samples of the disk counters, per device
+func DiskSamples(diskMetrics []DiskMetrics) []Sample {
+	var samples []Sample
+	for _, diskMetric := range diskMetrics {
+		labels := []string{"disk", diskMetric.Device}
+		samples = append(samples,
+			intCounter("disk_read_bytes_total", diskMetric.ReadBytesTotal, labels...),
+			intCounter("disk_write_bytes_total", diskMetric.WriteBytesTotal, labels...),
+		)
+	}
+	return samples
 }
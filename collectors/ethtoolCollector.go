@@ -0,0 +1,107 @@
+package collectors
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type EthtoolMetrics struct {
+	Interface string
+	Queues    []EthtoolQueueMetrics
+	Stats     []EthtoolStat // driver statistics that are not per queue, e.g. rx_missed_errors
+}
+
+type EthtoolStat struct {
+	Name  string
+	Value uint64
+}
+
+type EthtoolQueueMetrics struct {
+	Queue     string
+	Direction string // rx, tx
+	Packets   uint64
+	Bytes     uint64
+	Drops     uint64
+}
+
+// Per queue statistics as named by the common drivers: rx_queue_0_packets (virtio, ixgbe), rx0_bytes (mlx5), tx-1.packets (i40e), queue_2_rx_drops (ena)
+var ethtoolQueueStatPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^(?P<direction>rx|tx)[_-]?(?:queue[_-]?)?(?P<queue>\d+)[_.-](?P<stat>packets|bytes|drops|dropped)$`),
+	regexp.MustCompile(`^queue[_-](?P<queue>\d+)[_-](?P<direction>rx|tx)[_-](?P<stat>cnt|packets|bytes|drops|dropped)$`),
+}
+
+// Split the statistics of an interface into its queues and the interface wide ones
+func newEthtoolMetrics(iface string, stats map[string]uint64) EthtoolMetrics {
+	ethtoolMetrics := EthtoolMetrics{Interface: iface}
+	queues := make(map[string]*EthtoolQueueMetrics)
+
+	for name, value := range stats {
+		direction, queue, stat, ok := parseEthtoolQueueStat(name)
+		if !ok {
+			ethtoolMetrics.Stats = append(ethtoolMetrics.Stats, EthtoolStat{Name: name, Value: value})
+			continue
+		}
+		key := direction + queue
+		queueMetrics, exists := queues[key]
+		if !exists {
+			queueMetrics = &EthtoolQueueMetrics{Queue: queue, Direction: direction}
+			queues[key] = queueMetrics
+		}
+		switch stat {
+		case "packets", "cnt":
+			queueMetrics.Packets = value
+		case "bytes":
+			queueMetrics.Bytes = value
+		case "drops", "dropped":
+			queueMetrics.Drops = value
+		}
+	}
+	sort.Slice(ethtoolMetrics.Stats, func(i, j int) bool { return ethtoolMetrics.Stats[i].Name < ethtoolMetrics.Stats[j].Name })
+	for _, queueMetrics := range queues {
+		ethtoolMetrics.Queues = append(ethtoolMetrics.Queues, *queueMetrics)
+	}
+	sort.Slice(ethtoolMetrics.Queues, func(i, j int) bool {
+		a, b := ethtoolMetrics.Queues[i], ethtoolMetrics.Queues[j]
+		if a.Queue != b.Queue {
+			queueA, _ := strconv.Atoi(a.Queue)
+			queueB, _ := strconv.Atoi(b.Queue)
+			return queueA < queueB
+		}
+		return a.Direction < b.Direction
+	})
+	return ethtoolMetrics
+}
+
+func parseEthtoolQueueStat(name string) (direction string, queue string, stat string, ok bool) {
+	name = strings.ToLower(name)
+	for _, pattern := range ethtoolQueueStatPatterns {
+		match := pattern.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+		return match[pattern.SubexpIndex("direction")], match[pattern.SubexpIndex("queue")], match[pattern.SubexpIndex("stat")], true
+	}
+	return "", "", "", false
+}
+
+// Samples of the driver statistics, per interface and queue
+func EthtoolSamples(ethtoolMetrics []EthtoolMetrics) []Sample {
+	var samples []Sample
+	for _, ethtoolMetric := range ethtoolMetrics {
+		for _, queueMetric := range ethtoolMetric.Queues {
+			labels := []string{"interface", ethtoolMetric.Interface, "queue", queueMetric.Queue, "direction", queueMetric.Direction}
+			samples = append(samples,
+				intCounter("ethtool_queue_packets_total", queueMetric.Packets, labels...),
+				intCounter("ethtool_queue_bytes_total", queueMetric.Bytes, labels...),
+				intCounter("ethtool_queue_drops_total", queueMetric.Drops, labels...),
+			)
+		}
+		// Driver statistics mix counters and gauges, none is handled as a counter
+		for _, stat := range ethtoolMetric.Stats {
+			samples = append(samples, intGauge("ethtool_stat", stat.Value, "interface", ethtoolMetric.Interface, "stat", stat.Name))
+		}
+	}
+	return samples
+}
@@ -0,0 +1,120 @@
+//go:build linux
+
+package collectors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log/slog"
+	"runtime"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	siocEthtool        = 0x8946 // SIOCETHTOOL
+	ethtoolGetStrings  = 0x1b   // ETHTOOL_GSTRINGS
+	ethtoolGetStats    = 0x1d   // ETHTOOL_GSTATS
+	ethtoolGetSsetInfo = 0x37   // ETHTOOL_GSSET_INFO
+	ethtoolStatsSet    = 1      // ETH_SS_STATS
+	ethtoolStringLen   = 32     // ETH_GSTRING_LEN
+	interfaceNameLen   = 16     // IFNAMSIZ
+)
+
+// struct ifreq with the ifr_data member of the union
+type ethtoolRequest struct {
+	name [interfaceNameLen]byte
+	data unsafe.Pointer
+	_    [16]byte
+}
+
+var (
+	// Statistics names by interface, they only change when the driver is reloaded
+	ethtoolStatNames   = make(map[string][]string)
+	ethtoolStatNamesMu sync.Mutex
+	ethtoolWarned      = make(map[string]bool)
+)
+
+// Collect the driver statistics of the interfaces with the ethtool ioctl, as ethtool -S does (Linux only)
+func CollectEthtoolMetrics(interfaces []string) []EthtoolMetrics {
+	var ethtoolMetrics []EthtoolMetrics
+
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		return nil
+	}
+	defer syscall.Close(fd)
+
+	ethtoolStatNamesMu.Lock()
+	defer ethtoolStatNamesMu.Unlock()
+	for _, iface := range interfaces {
+		stats, err := readEthtoolStats(fd, iface)
+		if err != nil {
+			if !ethtoolWarned[iface] {
+				slog.Warn("Cannot read driver statistics of interface", "interface", iface, "error", err)
+				ethtoolWarned[iface] = true
+			}
+			continue
+		}
+		ethtoolMetrics = append(ethtoolMetrics, newEthtoolMetrics(iface, stats))
+	}
+	return ethtoolMetrics
+}
+
+func readEthtoolStats(fd int, iface string) (map[string]uint64, error) {
+	// ethtool_sset_info: cmd, reserved, sset_mask, then the size of each set in the mask
+	setInfo := make([]byte, 20)
+	binary.NativeEndian.PutUint32(setInfo[0:], ethtoolGetSsetInfo)
+	binary.NativeEndian.PutUint64(setInfo[8:], 1<<ethtoolStatsSet)
+	if err := ethtoolIoctl(fd, iface, setInfo); err != nil {
+		return nil, err
+	}
+	count := int(binary.NativeEndian.Uint32(setInfo[16:]))
+	if binary.NativeEndian.Uint64(setInfo[8:]) == 0 || count == 0 {
+		return nil, syscall.EOPNOTSUPP
+	}
+
+	names := ethtoolStatNames[iface]
+	if len(names) != count {
+		// ethtool_gstrings: cmd, string_set, len, then the names
+		stringsRequest := make([]byte, 12+count*ethtoolStringLen)
+		binary.NativeEndian.PutUint32(stringsRequest[0:], ethtoolGetStrings)
+		binary.NativeEndian.PutUint32(stringsRequest[4:], ethtoolStatsSet)
+		binary.NativeEndian.PutUint32(stringsRequest[8:], uint32(count))
+		if err := ethtoolIoctl(fd, iface, stringsRequest); err != nil {
+			return nil, err
+		}
+		names = make([]string, count)
+		for i := range names {
+			name := stringsRequest[12+i*ethtoolStringLen : 12+(i+1)*ethtoolStringLen]
+			names[i] = string(bytes.TrimRight(name, "\x00"))
+		}
+		ethtoolStatNames[iface] = names
+	}
+
+	// ethtool_stats: cmd, n_stats, then the values in the order of the names
+	statsRequest := make([]byte, 8+count*8)
+	binary.NativeEndian.PutUint32(statsRequest[0:], ethtoolGetStats)
+	binary.NativeEndian.PutUint32(statsRequest[4:], uint32(count))
+	if err := ethtoolIoctl(fd, iface, statsRequest); err != nil {
+		return nil, err
+	}
+	stats := make(map[string]uint64, count)
+	for i, name := range names {
+		stats[name] = binary.NativeEndian.Uint64(statsRequest[8+i*8:])
+	}
+	return stats, nil
+}
+
+func ethtoolIoctl(fd int, iface string, data []byte) error {
+	var request ethtoolRequest
+	copy(request.name[:interfaceNameLen-1], iface)
+	request.data = unsafe.Pointer(&data[0])
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), siocEthtool, uintptr(unsafe.Pointer(&request)))
+	runtime.KeepAlive(data)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package collectors
+
+// Driver statistics are read with the ethtool ioctl, only available on Linux
+func CollectEthtoolMetrics(interfaces []string) []EthtoolMetrics {
+	return nil
+}
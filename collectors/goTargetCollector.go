@@ -0,0 +1,90 @@
+package collectors
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type GoTargetMetrics struct {
+	Available        bool
+	Goroutines       uint64
+	Threads          uint64
+	HeapInuseBytes   uint64
+	GcCycles         uint64
+	GcPauseTotalSecs float64
+}
+
+// Subset of runtime.MemStats exposed by expvar on /debug/vars
+type expvarMemStats struct {
+	Memstats struct {
+		HeapInuse    uint64 `json:"HeapInuse"`
+		NumGC        uint64 `json:"NumGC"`
+		PauseTotalNs uint64 `json:"PauseTotalNs"`
+	} `json:"memstats"`
+}
+
+var goTargetClient = &http.Client{Timeout: 500 * time.Millisecond}
+
+// Collect runtime metrics of a Go process exposing expvar and net/http/pprof (e.g. http://localhost:6060)
+func CollectGoTargetMetrics(baseUrl string) GoTargetMetrics {
+	var goTargetMetrics GoTargetMetrics
+	baseUrl = strings.TrimSuffix(baseUrl, "/")
+
+	// The target may not be listening yet (or anymore), samples are then skipped
+	resp, err := goTargetClient.Get(baseUrl + "/debug/vars")
+	if err != nil {
+		return goTargetMetrics
+	}
+	defer resp.Body.Close()
+
+	var vars expvarMemStats
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&vars) != nil {
+		return goTargetMetrics
+	}
+	goTargetMetrics.Available = true
+	goTargetMetrics.HeapInuseBytes = vars.Memstats.HeapInuse
+	goTargetMetrics.GcCycles = vars.Memstats.NumGC
+	goTargetMetrics.GcPauseTotalSecs = float64(vars.Memstats.PauseTotalNs) / 1e9
+
+	goTargetMetrics.Goroutines, _ = readPprofTotal(baseUrl+"/debug/pprof/goroutine?debug=1", "goroutine")
+	goTargetMetrics.Threads, _ = readPprofTotal(baseUrl+"/debug/pprof/threadcreate?debug=1", "threadcreate")
+
+	return goTargetMetrics
+}
+
+// Read the total from the first line of a pprof text profile ("goroutine profile: total 12")
+func readPprofTotal(url string, profile string) (uint64, error) {
+	resp, err := goTargetClient.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	if !scanner.Scan() {
+		return 0, fmt.Errorf("empty %s profile", profile)
+	}
+	var total uint64
+	if _, err := fmt.Sscanf(scanner.Text(), profile+" profile: total %d", &total); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// Samples of the Go runtime of the command, none when its expvar endpoint cannot be read
+func GoTargetSamples(goTarget GoTargetMetrics) []Sample {
+	if !goTarget.Available {
+		return nil
+	}
+	return []Sample{
+		intGauge("target_go_goroutines", goTarget.Goroutines),
+		intGauge("target_go_threads", goTarget.Threads),
+		intGauge("target_go_heap_inuse_bytes", goTarget.HeapInuseBytes),
+		intCounter("target_go_gc_cycles_total", goTarget.GcCycles),
+		counter("target_go_gc_pause_seconds_total", goTarget.GcPauseTotalSecs),
+	}
+}
@@ -0,0 +1,88 @@
+package collectors
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/host"
+)
+
+type HostInfo struct {
+	Hostname        string
+	Os              string
+	Platform        string
+	PlatformVersion string
+	KernelVersion   string
+	KernelArch      string
+	Cpus            int
+	MemoryBytes     uint64
+}
+
+type DiskUsageMetrics struct {
+	Mountpoint string
+	Device     string
+	Fstype     string
+	TotalBytes uint64
+	UsedBytes  uint64
+	FreeBytes  uint64
+}
+
+func CollectHostInfo() (HostInfo, error) {
+	hostInfo, err := host.Info()
+	if err != nil {
+		return HostInfo{}, fmt.Errorf("cannot retrieve host info: %w", err)
+	}
+
+	// The CPUs the Go runtime sees when /proc/cpuinfo cannot be read
+	cpus, err := cpu.Counts(true)
+	if err != nil || cpus == 0 {
+		cpus = runtime.NumCPU()
+	}
+
+	memoryMetrics, err := ActiveBackend().memory()
+	if err != nil {
+		return HostInfo{}, fmt.Errorf("cannot retrieve memory usage from the %s backend: %w", ActiveBackend().Name, err)
+	}
+
+	return HostInfo{
+		Hostname:        hostInfo.Hostname,
+		Os:              hostInfo.OS,
+		Platform:        hostInfo.Platform,
+		PlatformVersion: hostInfo.PlatformVersion,
+		KernelVersion:   hostInfo.KernelVersion,
+		KernelArch:      hostInfo.KernelArch,
+		Cpus:            cpus,
+		MemoryBytes:     memoryMetrics.Total,
+	}, nil
+}
+
+// Disk space of physical partitions, slow-moving so only collected before and after the run
+func CollectDiskUsageMetrics() ([]DiskUsageMetrics, error) {
+	var diskUsageMetrics []DiskUsageMetrics
+	partitions, err := disk.Partitions(false)
+	if err != nil {
+		return nil, fmt.Errorf("cannot retrieve disk partitions: %w", err)
+	}
+
+	for _, partition := range partitions {
+		usage, err := disk.Usage(partition.Mountpoint)
+		if err != nil {
+			// Unreadable mountpoints (permissions, stale mounts) are skipped
+			slog.Debug("Cannot retrieve disk usage", "mountpoint", partition.Mountpoint, "error", err)
+			continue
+		}
+		diskUsageMetrics = append(diskUsageMetrics, DiskUsageMetrics{
+			Mountpoint: partition.Mountpoint,
+			Device:     partition.Device,
+			Fstype:     partition.Fstype,
+			TotalBytes: usage.Total,
+			UsedBytes:  usage.Used,
+			FreeBytes:  usage.Free,
+		})
+	}
+
+	return diskUsageMetrics, nil
+}
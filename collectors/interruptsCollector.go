@@ -0,0 +1,116 @@
+package collectors
+
+import (
+	"bufio"
+	"os"
+	"slices"
+	"strings"
+)
+
+const (
+	softirqsPath   = "/proc/softirqs"
+	interruptsPath = "/proc/interrupts"
+)
+
+type InterruptsMetrics struct {
+	Cpus       []string // columns of the per CPU counts, e.g. cpu0, as the cpu collector labels them
+	Softirqs   []SoftirqMetrics
+	Interrupts []InterruptMetrics
+}
+
+// Softirqs of a class (NET_RX, NET_TX, BLOCK, TIMER...) handled by each CPU
+type SoftirqMetrics struct {
+	Type   string
+	PerCpu []uint64
+}
+
+// Interrupts of an IRQ handled by each CPU, showing its affinity
+type InterruptMetrics struct {
+	Irq    string
+	Device string // name of the device raising it, e.g. eth0-TxRx-0, empty for architecture specific ones
+	PerCpu []uint64
+}
+
+// Collect softirqs and hardware interrupts per CPU, IRQs never raised are left out (Linux only)
+func CollectInterruptsMetrics() InterruptsMetrics {
+	var interruptsMetrics InterruptsMetrics
+
+	interruptsMetrics.Cpus = readCpuTable(softirqsPath, func(name string, perCpu []uint64, _ []string) {
+		interruptsMetrics.Softirqs = append(interruptsMetrics.Softirqs, SoftirqMetrics{Type: name, PerCpu: perCpu})
+	})
+
+	cpus := readCpuTable(interruptsPath, func(name string, perCpu []uint64, description []string) {
+		raised := false
+		for _, count := range perCpu {
+			raised = raised || count > 0
+		}
+		if !raised {
+			return
+		}
+		device := ""
+		// Numbered IRQs end with their chip, hwirq and trigger, then the device names, e.g. "IR-PCI-MSI 524288-edge eth0-TxRx-0"
+		if len(description) > 0 && strings.Trim(name, "0123456789") == "" {
+			device = description[len(description)-1]
+		}
+		interruptsMetrics.Interrupts = append(interruptsMetrics.Interrupts, InterruptMetrics{Irq: name, Device: device, PerCpu: perCpu})
+	})
+	// Both tables have the same columns, unless a CPU went offline in between
+	if !slices.Equal(cpus, interruptsMetrics.Cpus) {
+		interruptsMetrics.Interrupts = nil
+	}
+
+	return interruptsMetrics
+}
+
+// Parse a table with a "CPU0 CPU1..." header and "NAME: count count... [description]" rows, offline CPUs have no column
+func readCpuTable(path string, row func(name string, perCpu []uint64, description []string)) []string {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return nil
+	}
+	var cpus []string
+	for _, column := range strings.Fields(scanner.Text()) {
+		cpus = append(cpus, strings.ToLower(column))
+	}
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || !strings.HasSuffix(fields[0], ":") {
+			continue
+		}
+		name := strings.TrimSuffix(fields[0], ":")
+		counts := fields[1:]
+		// Rows like ERR and MIS have a single total instead of a count per CPU
+		if len(counts) < len(cpus) {
+			continue
+		}
+		perCpu := make([]uint64, len(cpus))
+		for i := range cpus {
+			perCpu[i] = parseUint(counts[i])
+		}
+		row(name, perCpu, counts[len(cpus):])
+	}
+	return cpus
+}
+
+// Samples of the softirqs and interrupts, per CPU
+func InterruptsSamples(interrupts InterruptsMetrics) []Sample {
+	var samples []Sample
+	for _, softirqMetric := range interrupts.Softirqs {
+		for i, count := range softirqMetric.PerCpu {
+			samples = append(samples, intCounter("softirqs_total", count, "cpu", interrupts.Cpus[i], "type", softirqMetric.Type))
+		}
+	}
+	for _, interruptMetric := range interrupts.Interrupts {
+		for i, count := range interruptMetric.PerCpu {
+			samples = append(samples, intCounter("interrupts_total", count, "cpu", interrupts.Cpus[i], "irq", interruptMetric.Irq, "device", interruptMetric.Device))
+		}
+	}
+	return samples
+}
@@ -0,0 +1,132 @@
+package collectors
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+type JvmGcMetrics struct {
+	Name        string
+	Collections uint64
+	TimeSeconds float64
+}
+
+type JvmMetrics struct {
+	Available          bool
+	HeapUsedBytes      uint64
+	HeapCommittedBytes uint64
+	HeapMaxBytes       int64
+	Threads            uint64
+	Gc                 []JvmGcMetrics
+}
+
+// Bulk read of the java.lang MBeans, see https://jolokia.org/reference/html/protocol.html
+const jolokiaRequest = `[
+{"type":"read","mbean":"java.lang:type=Memory","attribute":"HeapMemoryUsage"},
+{"type":"read","mbean":"java.lang:type=Threading","attribute":"ThreadCount"},
+{"type":"read","mbean":"java.lang:type=GarbageCollector,name=*","attribute":["CollectionCount","CollectionTime"]}
+]`
+
+type jolokiaResponse struct {
+	Status int             `json:"status"`
+	Value  json.RawMessage `json:"value"`
+}
+
+const jolokiaDefaultPath = "/jolokia/"
+
+var (
+	jvmClient    = &http.Client{Timeout: 500 * time.Millisecond}
+	gcNameRegexp = regexp.MustCompile(`name=([^,]+)`)
+)
+
+// Build the Jolokia url from a host:port or a full url
+func JolokiaUrl(target string) string {
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		return target
+	}
+	return "http://" + target + jolokiaDefaultPath
+}
+
+// Collect heap, threads and GC metrics of a JVM through a Jolokia agent (JMX over HTTP)
+func CollectJvmMetrics(target string) JvmMetrics {
+	var jvmMetrics JvmMetrics
+
+	// The JVM may not be listening yet (or anymore), samples are then skipped
+	resp, err := jvmClient.Post(JolokiaUrl(target), "application/json", bytes.NewBufferString(jolokiaRequest))
+	if err != nil {
+		return jvmMetrics
+	}
+	defer resp.Body.Close()
+
+	var responses []jolokiaResponse
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&responses) != nil || len(responses) != 3 {
+		return jvmMetrics
+	}
+
+	// Heap memory usage
+	var heap struct {
+		Used      uint64 `json:"used"`
+		Committed uint64 `json:"committed"`
+		Max       int64  `json:"max"`
+	}
+	if responses[0].Status != http.StatusOK || json.Unmarshal(responses[0].Value, &heap) != nil {
+		return jvmMetrics
+	}
+	jvmMetrics.Available = true
+	jvmMetrics.HeapUsedBytes = heap.Used
+	jvmMetrics.HeapCommittedBytes = heap.Committed
+	jvmMetrics.HeapMaxBytes = heap.Max
+
+	// Live threads
+	if responses[1].Status == http.StatusOK {
+		_ = json.Unmarshal(responses[1].Value, &jvmMetrics.Threads)
+	}
+
+	// Garbage collectors, keyed by MBean name
+	var collectors map[string]struct {
+		CollectionCount uint64 `json:"CollectionCount"`
+		CollectionTime  uint64 `json:"CollectionTime"`
+	}
+	if responses[2].Status == http.StatusOK && json.Unmarshal(responses[2].Value, &collectors) == nil {
+		for mbean, gc := range collectors {
+			name := mbean
+			if match := gcNameRegexp.FindStringSubmatch(mbean); match != nil {
+				name = match[1]
+			}
+			jvmMetrics.Gc = append(jvmMetrics.Gc, JvmGcMetrics{
+				Name:        name,
+				Collections: gc.CollectionCount,
+				TimeSeconds: float64(gc.CollectionTime) / 1000.0,
+			})
+		}
+		sort.Slice(jvmMetrics.Gc, func(i, j int) bool { return jvmMetrics.Gc[i].Name < jvmMetrics.Gc[j].Name })
+	}
+
+	return jvmMetrics
+}
+
+// Samples of the JVM of the command, none when its Jolokia endpoint cannot be read
+func JvmSamples(jvm JvmMetrics) []Sample {
+	if !jvm.Available {
+		return nil
+	}
+	samples := []Sample{
+		intGauge("target_jvm_heap_used_bytes", jvm.HeapUsedBytes),
+		intGauge("target_jvm_heap_committed_bytes", jvm.HeapCommittedBytes),
+		NewSample("target_jvm_heap_max_bytes", Gauge, float64(jvm.HeapMaxBytes), true),
+		intGauge("target_jvm_threads", jvm.Threads),
+	}
+	for _, gcMetric := range jvm.Gc {
+		labels := []string{"gc", gcMetric.Name}
+		samples = append(samples,
+			intCounter("target_jvm_gc_collections_total", gcMetric.Collections, labels...),
+			counter("target_jvm_gc_seconds_total", gcMetric.TimeSeconds, labels...),
+		)
+	}
+	return samples
+}
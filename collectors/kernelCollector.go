@@ -0,0 +1,70 @@
+package collectors
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+type KernelMetrics struct {
+	Available            bool
+	FileHandlesAllocated uint64
+	FileHandlesMax       uint64
+	InodesAllocated      uint64
+	InodesFree           uint64
+	EntropyAvailableBits uint64
+
+	ThermalThrottleAvailable bool
+	ThermalThrottleEvents    uint64 // Summed over CPUs, core and package
+}
+
+// Collect kernel resources usage from /proc/sys (Linux only)
+func CollectKernelMetrics() KernelMetrics {
+	var kernelMetrics KernelMetrics
+
+	// allocated, allocated but unused (always 0 since 2.6), max
+	fileNr := strings.Fields(readStringFile("/proc/sys/fs/file-nr", ""))
+	if len(fileNr) < 3 {
+		return kernelMetrics
+	}
+	kernelMetrics.Available = true
+	kernelMetrics.FileHandlesAllocated = parseUint(fileNr[0])
+	kernelMetrics.FileHandlesMax = parseUint(fileNr[2])
+
+	// nr_inodes, nr_free_inodes
+	inodeNr := strings.Fields(readStringFile("/proc/sys/fs/inode-nr", ""))
+	if len(inodeNr) >= 2 {
+		kernelMetrics.InodesAllocated = parseUint(inodeNr[0])
+		kernelMetrics.InodesFree = parseUint(inodeNr[1])
+	}
+
+	kernelMetrics.EntropyAvailableBits, _ = readUintFile("/proc/sys/kernel/random/entropy_avail")
+
+	// Thermal throttling events, x86 only
+	throttleFiles, _ := filepath.Glob("/sys/devices/system/cpu/cpu*/thermal_throttle/*_throttle_count")
+	for _, throttleFile := range throttleFiles {
+		if count, err := readUintFile(throttleFile); err == nil {
+			kernelMetrics.ThermalThrottleAvailable = true
+			kernelMetrics.ThermalThrottleEvents += count
+		}
+	}
+
+	return kernelMetrics
+}
+
+// Samples of the kernel resources, the thermal throttling only where the CPUs report it
+func KernelSamples(kernel KernelMetrics) []Sample {
+	if !kernel.Available {
+		return nil
+	}
+	samples := []Sample{
+		intGauge("kernel_file_handles_allocated", kernel.FileHandlesAllocated),
+		intGauge("kernel_file_handles_max", kernel.FileHandlesMax),
+		intGauge("kernel_inodes_allocated", kernel.InodesAllocated),
+		intGauge("kernel_inodes_free", kernel.InodesFree),
+		intGauge("kernel_entropy_available_bits", kernel.EntropyAvailableBits),
+	}
+	if kernel.ThermalThrottleAvailable {
+		samples = append(samples, intCounter("cpu_thermal_throttle_events_total", kernel.ThermalThrottleEvents))
+	}
+	return samples
+}
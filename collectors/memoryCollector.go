@@ -14,13 +14,37 @@ type MemoryMetrics struct {
 	Buffers     uint64
 	Cached      uint64
 	UsedPercent float64
+
+	HugePagesTotal    uint64
+	HugePagesFree     uint64
+	HugePagesReserved uint64
+	HugePagesSurplus  uint64
+	HugePageSizeBytes uint64
+
+	SwapTotal    uint64
+	SwapUsed     uint64
+	SwapInBytes  uint64
+	SwapOutBytes uint64
 }
 
-func CollectMemoryMetrics() MemoryMetrics {
+func CollectMemoryMetrics() (MemoryMetrics, error) {
+	memoryMetrics, err := ActiveBackend().memory()
+	if err != nil {
+		return MemoryMetrics{}, fmt.Errorf("cannot retrieve memory usage from the %s backend: %w", ActiveBackend().Name, err)
+	}
+	return memoryMetrics, nil
+}
+
+// Memory and swap usage as gopsutil reads them, from /proc on Linux and sysctl on the BSDs
+func gopsutilMemoryMetrics() (MemoryMetrics, error) {
 	vmStat, err := mem.VirtualMemory()
 	if err != nil {
-		fmt.Println("Error retrieving Virtual Memory Usage:", err)
-		panic(err)
+		return MemoryMetrics{}, err
+	}
+
+	swapStat, err := mem.SwapMemory()
+	if err != nil {
+		return MemoryMetrics{}, err
 	}
 
 	return MemoryMetrics{
@@ -31,5 +55,38 @@ func CollectMemoryMetrics() MemoryMetrics {
 		Buffers:     vmStat.Buffers,
 		Cached:      vmStat.Cached,
 		UsedPercent: vmStat.UsedPercent,
+
+		HugePagesTotal:    vmStat.HugePagesTotal,
+		HugePagesFree:     vmStat.HugePagesFree,
+		HugePagesReserved: vmStat.HugePagesRsvd,
+		HugePagesSurplus:  vmStat.HugePagesSurp,
+		HugePageSizeBytes: vmStat.HugePageSize,
+
+		SwapTotal:    swapStat.Total,
+		SwapUsed:     swapStat.Used,
+		SwapInBytes:  swapStat.Sin,
+		SwapOutBytes: swapStat.Sout,
+	}, nil
+}
+
+// Samples of the memory usage, emitted even when the platform reports no values
+func MemorySamples(memory MemoryMetrics) []Sample {
+	return []Sample{
+		intGauge("memory_total_bytes", memory.Total),
+		intGauge("memory_available_bytes", memory.Available),
+		intGauge("memory_used_bytes", memory.Used),
+		intGauge("memory_free_bytes", memory.Free),
+		intGauge("memory_buffers_bytes", memory.Buffers),
+		intGauge("memory_cached_bytes", memory.Cached),
+		gauge("memory_used_percent", memory.UsedPercent),
+		intGauge("memory_swap_total_bytes", memory.SwapTotal),
+		intGauge("memory_swap_used_bytes", memory.SwapUsed),
+		intCounter("memory_swap_in_bytes_total", memory.SwapInBytes),
+		intCounter("memory_swap_out_bytes_total", memory.SwapOutBytes),
+		intGauge("memory_hugepages_total", memory.HugePagesTotal),
+		intGauge("memory_hugepages_free", memory.HugePagesFree),
+		intGauge("memory_hugepages_reserved", memory.HugePagesReserved),
+		intGauge("memory_hugepages_surplus", memory.HugePagesSurplus),
+		intGauge("memory_hugepage_size_bytes", memory.HugePageSizeBytes),
 	}
 }
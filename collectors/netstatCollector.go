@@ -0,0 +1,187 @@
+package collectors
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+type UdpMetrics struct {
+	Protocol     string
+	InDatagrams  uint64
+	OutDatagrams uint64
+	NoPorts      uint64
+	InErrors     uint64
+	RcvbufErrors uint64
+	SndbufErrors uint64
+	SocketDrops  uint64
+}
+
+type TcpMetrics struct {
+	Available   bool
+	InSegs      uint64
+	OutSegs     uint64
+	RetransSegs uint64
+	InErrs      uint64
+}
+
+type NetstatMetrics struct {
+	Udp []UdpMetrics
+	Tcp TcpMetrics
+}
+
+// Collect per-protocol counters from /proc/net/snmp, /proc/net/snmp6 and /proc/net/udp* (Linux only)
+func CollectNetstatMetrics() NetstatMetrics {
+	var netstatMetrics NetstatMetrics
+
+	snmp := readSnmpFile("/proc/net/snmp")
+	if udp, ok := snmp["Udp"]; ok {
+		netstatMetrics.Udp = append(netstatMetrics.Udp, UdpMetrics{
+			Protocol:     "udp",
+			InDatagrams:  udp["InDatagrams"],
+			OutDatagrams: udp["OutDatagrams"],
+			NoPorts:      udp["NoPorts"],
+			InErrors:     udp["InErrors"],
+			RcvbufErrors: udp["RcvbufErrors"],
+			SndbufErrors: udp["SndbufErrors"],
+			SocketDrops:  sumSocketDrops("/proc/net/udp"),
+		})
+	}
+	if tcp, ok := snmp["Tcp"]; ok {
+		netstatMetrics.Tcp = TcpMetrics{
+			Available:   true,
+			InSegs:      tcp["InSegs"],
+			OutSegs:     tcp["OutSegs"],
+			RetransSegs: tcp["RetransSegs"],
+			InErrs:      tcp["InErrs"],
+		}
+	}
+
+	snmp6 := readSnmp6File("/proc/net/snmp6")
+	if udp6, ok := snmp6["Udp6"]; ok {
+		netstatMetrics.Udp = append(netstatMetrics.Udp, UdpMetrics{
+			Protocol:     "udp6",
+			InDatagrams:  udp6["InDatagrams"],
+			OutDatagrams: udp6["OutDatagrams"],
+			NoPorts:      udp6["NoPorts"],
+			InErrors:     udp6["InErrors"],
+			RcvbufErrors: udp6["RcvbufErrors"],
+			SndbufErrors: udp6["SndbufErrors"],
+			SocketDrops:  sumSocketDrops("/proc/net/udp6"),
+		})
+	}
+
+	return netstatMetrics
+}
+
+// Parse /proc/net/snmp, made of pairs of lines "Proto: Name1 Name2" / "Proto: Value1 Value2"
+func readSnmpFile(path string) map[string]map[string]uint64 {
+	result := make(map[string]map[string]uint64)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return result
+	}
+	defer file.Close()
+
+	var header []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		if header == nil || header[0] != fields[0] {
+			header = fields
+			continue
+		}
+
+		protocol := strings.TrimSuffix(fields[0], ":")
+		counters := make(map[string]uint64)
+		for i := 1; i < len(fields) && i < len(header); i++ {
+			counters[header[i]] = parseUint(fields[i])
+		}
+		result[protocol] = counters
+		header = nil
+	}
+	return result
+}
+
+// Parse /proc/net/snmp6, made of "Udp6InErrors 0" lines
+func readSnmp6File(path string) map[string]map[string]uint64 {
+	result := make(map[string]map[string]uint64)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return result
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		for _, protocol := range []string{"Udp6", "Tcp6", "Ip6", "Icmp6"} {
+			if strings.HasPrefix(fields[0], protocol) {
+				if _, ok := result[protocol]; !ok {
+					result[protocol] = make(map[string]uint64)
+				}
+				result[protocol][strings.TrimPrefix(fields[0], protocol)] = parseUint(fields[1])
+				break
+			}
+		}
+	}
+	return result
+}
+
+// Sum the drops column (last one) of all sockets listed in /proc/net/udp or /proc/net/udp6
+func sumSocketDrops(path string) uint64 {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	var drops uint64
+	scanner := bufio.NewScanner(file)
+	header := true
+	for scanner.Scan() {
+		if header {
+			header = false
+			continue
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 {
+			drops += parseUint(fields[len(fields)-1])
+		}
+	}
+	return drops
+}
+
+// Samples of the UDP and TCP protocol counters
+func NetstatSamples(netstat NetstatMetrics) []Sample {
+	var samples []Sample
+	for _, udpMetric := range netstat.Udp {
+		labels := []string{"protocol", udpMetric.Protocol}
+		samples = append(samples,
+			intCounter("udp_in_datagrams_total", udpMetric.InDatagrams, labels...),
+			intCounter("udp_out_datagrams_total", udpMetric.OutDatagrams, labels...),
+			intCounter("udp_no_ports_total", udpMetric.NoPorts, labels...),
+			intCounter("udp_in_errors_total", udpMetric.InErrors, labels...),
+			intCounter("udp_receive_buffer_errors_total", udpMetric.RcvbufErrors, labels...),
+			intCounter("udp_send_buffer_errors_total", udpMetric.SndbufErrors, labels...),
+			intCounter("udp_socket_drops_total", udpMetric.SocketDrops, labels...),
+		)
+	}
+	if netstat.Tcp.Available {
+		samples = append(samples,
+			intCounter("tcp_in_segments_total", netstat.Tcp.InSegs),
+			intCounter("tcp_out_segments_total", netstat.Tcp.OutSegs),
+			intCounter("tcp_retransmitted_segments_total", netstat.Tcp.RetransSegs),
+			intCounter("tcp_in_errors_total", netstat.Tcp.InErrs),
+		)
+	}
+	return samples
+}
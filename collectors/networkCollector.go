@@ -2,8 +2,10 @@ package collectors
 
 import (
 	"fmt"
+	"net"
+	"strconv"
 
-	"github.com/shirou/gopsutil/v3/net"
+	psnet "github.com/shirou/gopsutil/v3/net"
 )
 
 type NetworkMetrics struct {
@@ -12,17 +14,64 @@ type NetworkMetrics struct {
 	RecvTotalBytes uint64
 }
 
-func CollectNetworkMetrics() []NetworkMetrics {
+func CollectNetworkMetrics() ([]NetworkMetrics, error) {
 	var networkMetrics []NetworkMetrics
-	netStat, err := net.IOCounters(true)
+	netStat, err := psnet.IOCounters(true)
 	if err != nil {
-		fmt.Println("Error retrieving Network IO Counters:", err)
-		panic(err)
+		return nil, fmt.Errorf("cannot retrieve network IO counters: %w", err)
 	}
 
 	for _, netIO := range netStat {
 		networkMetrics = append(networkMetrics, NetworkMetrics{Interface: netIO.Name, SentTotalBytes: netIO.BytesSent, RecvTotalBytes: netIO.BytesRecv})
 	}
 
-	return networkMetrics
+	return networkMetrics, nil
+}
+
+type NetworkInterfaceInfo struct {
+	Interface string
+	Operstate string
+	Duplex    string
+	SpeedMbps int64
+	Mtu       int
+}
+
+// Collect negotiated speed, duplex and link state of each interface (speed/duplex/operstate from sysfs, Linux only)
+func CollectNetworkInterfaceInfo() ([]NetworkInterfaceInfo, error) {
+	var interfacesInfo []NetworkInterfaceInfo
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("cannot retrieve network interfaces: %w", err)
+	}
+
+	for _, networkInterface := range interfaces {
+		sysfsPath := "/sys/class/net/" + networkInterface.Name + "/"
+		interfaceInfo := NetworkInterfaceInfo{
+			Interface: networkInterface.Name,
+			Operstate: readStringFile(sysfsPath+"operstate", "unknown"),
+			Duplex:    readStringFile(sysfsPath+"duplex", "unknown"),
+			SpeedMbps: -1,
+			Mtu:       networkInterface.MTU,
+		}
+		// Reading speed fails (EINVAL) on links without carrier or virtual interfaces
+		if speed, err := strconv.ParseInt(readStringFile(sysfsPath+"speed", ""), 10, 64); err == nil {
+			interfaceInfo.SpeedMbps = speed
+		}
+		interfacesInfo = append(interfacesInfo, interfaceInfo)
+	}
+
+	return interfacesInfo, nil
+}
+
+// Samples of the network counters, per interface
+func NetworkSamples(networkMetrics []NetworkMetrics) []Sample {
+	var samples []Sample
+	for _, networkMetric := range networkMetrics {
+		labels := []string{"interface", networkMetric.Interface}
+		samples = append(samples,
+			intCounter("network_sent_bytes_total", networkMetric.SentTotalBytes, labels...),
+			intCounter("network_received_bytes_total", networkMetric.RecvTotalBytes, labels...),
+		)
+	}
+	return samples
 }
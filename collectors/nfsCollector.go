@@ -0,0 +1,125 @@
+package collectors
+
+import (
+	"bufio"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+const mountStatsPath = "/proc/self/mountstats"
+
+type NfsOpMetrics struct {
+	Op            string
+	Ops           uint64
+	Transmissions uint64
+	MajorTimeouts uint64
+	BytesSent     uint64
+	BytesRecv     uint64
+	RttMs         uint64
+	ExecuteMs     uint64
+}
+
+type NfsMetrics struct {
+	Mountpoint string
+	Export     string
+	ReadBytes  uint64
+	WriteBytes uint64
+	Ops        []NfsOpMetrics
+}
+
+// Collect NFS client counters of each NFS mount, from /proc/self/mountstats (Linux only)
+func CollectNfsMetrics() []NfsMetrics {
+	var nfsMetrics []NfsMetrics
+
+	file, err := os.Open(mountStatsPath)
+	if err != nil {
+		// Not available on this platform
+		return nfsMetrics
+	}
+	defer file.Close()
+
+	var current *NfsMetrics
+	inPerOpStats := false
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		// device server:/export mounted on /mnt with fstype nfs4 statvers=1.1
+		if fields[0] == "device" {
+			if current != nil {
+				nfsMetrics = append(nfsMetrics, *current)
+				current = nil
+			}
+			inPerOpStats = false
+			if len(fields) >= 8 && strings.HasPrefix(fields[7], "nfs") {
+				current = &NfsMetrics{Export: fields[1], Mountpoint: fields[4]}
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		switch {
+		case fields[0] == "bytes:" && len(fields) >= 7:
+			// normalread normalwrite directread directwrite serverread serverwrite ...
+			current.ReadBytes = parseUint(fields[5])
+			current.WriteBytes = parseUint(fields[6])
+		case strings.TrimSpace(line) == "per-op statistics":
+			inPerOpStats = true
+		case inPerOpStats && strings.HasSuffix(fields[0], ":") && len(fields) >= 9:
+			// op: ops trans major_timeouts bytes_sent bytes_recv queue_ms rtt_ms execute_ms [errors]
+			current.Ops = append(current.Ops, NfsOpMetrics{
+				Op:            strings.TrimSuffix(fields[0], ":"),
+				Ops:           parseUint(fields[1]),
+				Transmissions: parseUint(fields[2]),
+				MajorTimeouts: parseUint(fields[3]),
+				BytesSent:     parseUint(fields[4]),
+				BytesRecv:     parseUint(fields[5]),
+				RttMs:         parseUint(fields[7]),
+				ExecuteMs:     parseUint(fields[8]),
+			})
+		}
+	}
+	if current != nil {
+		nfsMetrics = append(nfsMetrics, *current)
+	}
+	if err := scanner.Err(); err != nil {
+		slog.Warn("Cannot read NFS mount stats", "error", err)
+	}
+
+	return nfsMetrics
+}
+
+// Samples of the NFS client counters, per mount and per operation that was used
+func NfsSamples(nfsMetrics []NfsMetrics) []Sample {
+	var samples []Sample
+	for _, nfsMetric := range nfsMetrics {
+		labels := []string{"mountpoint", nfsMetric.Mountpoint, "export", nfsMetric.Export}
+		samples = append(samples,
+			intCounter("nfs_read_bytes_total", nfsMetric.ReadBytes, labels...),
+			intCounter("nfs_write_bytes_total", nfsMetric.WriteBytes, labels...),
+		)
+
+		for _, opMetric := range nfsMetric.Ops {
+			if opMetric.Ops == 0 {
+				continue
+			}
+			opLabels := []string{"mountpoint", nfsMetric.Mountpoint, "export", nfsMetric.Export, "op", opMetric.Op}
+			samples = append(samples,
+				intCounter("nfs_ops_total", opMetric.Ops, opLabels...),
+				intCounter("nfs_retransmissions_total", opMetric.Transmissions-opMetric.Ops, opLabels...),
+				intCounter("nfs_major_timeouts_total", opMetric.MajorTimeouts, opLabels...),
+				counter("nfs_rtt_seconds_total", float64(opMetric.RttMs)/1000.0, opLabels...),
+				counter("nfs_execute_seconds_total", float64(opMetric.ExecuteMs)/1000.0, opLabels...),
+			)
+		}
+	}
+	return samples
+}
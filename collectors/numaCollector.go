@@ -0,0 +1,82 @@
+package collectors
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const numaNodesPath = "/sys/devices/system/node"
+
+type NumaNodeMetrics struct {
+	Node           string
+	MemTotalBytes  uint64
+	MemFreeBytes   uint64
+	MemUsedBytes   uint64
+	HugePagesTotal uint64
+	HugePagesFree  uint64
+}
+
+// Collect memory usage of each NUMA node from sysfs (Linux only)
+func CollectNumaMetrics() []NumaNodeMetrics {
+	var numaMetrics []NumaNodeMetrics
+
+	nodePaths, _ := filepath.Glob(filepath.Join(numaNodesPath, "node[0-9]*"))
+	for _, nodePath := range nodePaths {
+		meminfo := readNodeMeminfo(filepath.Join(nodePath, "meminfo"))
+		if meminfo == nil {
+			continue
+		}
+		numaMetrics = append(numaMetrics, NumaNodeMetrics{
+			Node:           strings.TrimPrefix(filepath.Base(nodePath), "node"),
+			MemTotalBytes:  meminfo["MemTotal"],
+			MemFreeBytes:   meminfo["MemFree"],
+			MemUsedBytes:   meminfo["MemUsed"],
+			HugePagesTotal: meminfo["HugePages_Total"],
+			HugePagesFree:  meminfo["HugePages_Free"],
+		})
+	}
+
+	return numaMetrics
+}
+
+// Parse a node meminfo file made of "Node 0 MemTotal: 4423416 kB" lines, values in kB are converted to bytes
+func readNodeMeminfo(path string) map[string]uint64 {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	meminfo := make(map[string]uint64)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		value := parseUint(fields[3])
+		if len(fields) >= 5 && fields[4] == "kB" {
+			value *= 1024
+		}
+		meminfo[strings.TrimSuffix(fields[2], ":")] = value
+	}
+	return meminfo
+}
+
+// Samples of the memory per NUMA node
+func NumaSamples(numaMetrics []NumaNodeMetrics) []Sample {
+	var samples []Sample
+	for _, numaMetric := range numaMetrics {
+		labels := []string{"node", numaMetric.Node}
+		samples = append(samples,
+			intGauge("numa_memory_total_bytes", numaMetric.MemTotalBytes, labels...),
+			intGauge("numa_memory_free_bytes", numaMetric.MemFreeBytes, labels...),
+			intGauge("numa_memory_used_bytes", numaMetric.MemUsedBytes, labels...),
+			intGauge("numa_hugepages_total", numaMetric.HugePagesTotal, labels...),
+			intGauge("numa_hugepages_free", numaMetric.HugePagesFree, labels...),
+		)
+	}
+	return samples
+}
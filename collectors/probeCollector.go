@@ -0,0 +1,165 @@
+package collectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+type Probe struct {
+	Target   string // As given on the command line, e.g. http://svc/health
+	Type     string // icmp, tcp, http, dns
+	Host     string
+	Url      string
+	Resolver string // dns only, system resolver if empty
+}
+
+type ProbeResult struct {
+	Success         bool
+	DurationSeconds float64
+	NotFound        bool // dns only, NXDOMAIN
+}
+
+var pingTimeRegexp = regexp.MustCompile(`time[=<]([0-9.]+) ms`)
+
+// Parse a probe target : icmp://host, tcp://host:port, http(s)://host/path, dns://name[@resolver[:port]]
+func ParseProbe(target string) (Probe, error) {
+	parsedUrl, err := url.Parse(target)
+	if err != nil {
+		return Probe{}, err
+	}
+
+	probe := Probe{Target: target, Type: parsedUrl.Scheme, Host: parsedUrl.Host}
+	switch parsedUrl.Scheme {
+	case "icmp":
+	case "tcp":
+		if parsedUrl.Port() == "" {
+			return Probe{}, fmt.Errorf("tcp probe needs a port: %s", target)
+		}
+	case "http", "https":
+		probe.Type = "http"
+		probe.Url = target
+	case "dns":
+		if parsedUrl.User != nil {
+			probe.Host = parsedUrl.User.Username()
+			probe.Resolver = parsedUrl.Host
+			if parsedUrl.Port() == "" {
+				probe.Resolver = net.JoinHostPort(parsedUrl.Hostname(), "53")
+			}
+		}
+	default:
+		return Probe{}, fmt.Errorf("unsupported probe type %q, expected icmp, tcp, http, https or dns", parsedUrl.Scheme)
+	}
+	if probe.Host == "" {
+		return Probe{}, fmt.Errorf("probe needs a host: %s", target)
+	}
+	return probe, nil
+}
+
+// Run a probe once, it fails if it does not succeed within the timeout
+func (probe Probe) Run(timeout time.Duration) ProbeResult {
+	switch probe.Type {
+	case "icmp":
+		return probe.runIcmp(timeout)
+	case "tcp":
+		return probe.runTcp(timeout)
+	case "http":
+		return probe.runHttp(timeout)
+	case "dns":
+		return probe.runDns(timeout)
+	}
+	return ProbeResult{}
+}
+
+// ICMP echo through the ping binary, as raw sockets need privileges
+func (probe Probe) runIcmp(timeout time.Duration) ProbeResult {
+	timeoutSeconds := int(timeout.Seconds())
+	if timeoutSeconds < 1 {
+		timeoutSeconds = 1
+	}
+	output, err := exec.Command("ping", "-n", "-c", "1", "-W", strconv.Itoa(timeoutSeconds), probe.Host).Output()
+	if err != nil {
+		return ProbeResult{}
+	}
+	match := pingTimeRegexp.FindSubmatch(output)
+	if match == nil {
+		return ProbeResult{}
+	}
+	rttMs, _ := strconv.ParseFloat(string(match[1]), 64)
+	return ProbeResult{Success: true, DurationSeconds: rttMs / 1000.0}
+}
+
+func (probe Probe) runTcp(timeout time.Duration) ProbeResult {
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", probe.Host, timeout)
+	duration := time.Since(start).Seconds()
+	if err != nil {
+		return ProbeResult{DurationSeconds: duration}
+	}
+	conn.Close()
+	return ProbeResult{Success: true, DurationSeconds: duration}
+}
+
+// HTTP GET, successful on 2xx and 3xx status codes
+func (probe Probe) runHttp(timeout time.Duration) ProbeResult {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, probe.Url, nil)
+	if err != nil {
+		return ProbeResult{}
+	}
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	start := time.Now()
+	resp, err := client.Do(request)
+	duration := time.Since(start).Seconds()
+	if err != nil {
+		return ProbeResult{DurationSeconds: duration}
+	}
+	resp.Body.Close()
+	return ProbeResult{
+		Success:         resp.StatusCode >= 200 && resp.StatusCode < 400,
+		DurationSeconds: duration,
+	}
+}
+
+// DNS lookup of the name, through the given resolver if any
+func (probe Probe) runDns(timeout time.Duration) ProbeResult {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	resolver := net.DefaultResolver
+	if probe.Resolver != "" {
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+				dialer := net.Dialer{}
+				return dialer.DialContext(ctx, network, probe.Resolver)
+			},
+		}
+	}
+
+	start := time.Now()
+	_, err := resolver.LookupHost(ctx, probe.Host)
+	duration := time.Since(start).Seconds()
+	if err != nil {
+		var dnsError *net.DNSError
+		return ProbeResult{
+			DurationSeconds: duration,
+			NotFound:        errors.As(err, &dnsError) && dnsError.IsNotFound,
+		}
+	}
+	return ProbeResult{Success: true, DurationSeconds: duration}
+}
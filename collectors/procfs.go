@@ -0,0 +1,30 @@
+package collectors
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+func parseUint(value string) uint64 {
+	parsed, _ := strconv.ParseUint(value, 10, 64)
+	return parsed
+}
+
+// Read a file containing a single unsigned integer (/proc/sys, /sys)
+func readUintFile(path string) (uint64, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+}
+
+// Read a file containing a single string value, or return a default value
+func readStringFile(path string, defaultValue string) string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return defaultValue
+	}
+	return strings.TrimSpace(string(content))
+}
@@ -0,0 +1,79 @@
+package collectors
+
+import (
+	"net"
+)
+
+// Path toward a peer as chosen by the kernel when the run starts: source address, outgoing interface, next hop and MTU
+type PeerRoute struct {
+	Peer          string
+	SourceAddress string
+	Interface     string
+	Gateway       string // empty when the peer is on link
+	InterfaceMtu  int
+	PathMtu       int // MTU of the route, lowered by path MTU discovery, the interface MTU if unknown
+}
+
+// Resolve the route toward a peer, connecting an UDP socket sends nothing but selects the route
+func CollectPeerRoute(peer string) (PeerRoute, error) {
+	route := PeerRoute{Peer: peer}
+	ips, err := net.LookupIP(peer)
+	if err != nil {
+		return route, err
+	}
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: ips[0], Port: 9})
+	if err != nil {
+		return route, err
+	}
+	defer conn.Close()
+
+	source := conn.LocalAddr().(*net.UDPAddr).IP
+	route.SourceAddress = source.String()
+	networkInterface, ok := interfaceOfAddress(source)
+	if _, local := interfaceOfAddress(ips[0]); local || ips[0].IsLoopback() {
+		// Local peers are reached through the loopback, whatever the address they listen on
+		networkInterface, ok = loopbackInterface()
+	}
+	if ok {
+		route.Interface = networkInterface.Name
+		route.InterfaceMtu = networkInterface.MTU
+	}
+	route.PathMtu = route.InterfaceMtu
+	if mtu, err := pathMtu(conn); err == nil && mtu > 0 {
+		route.PathMtu = mtu
+	}
+	route.Gateway = routeGateway(ips[0], route.Interface)
+	return route, nil
+}
+
+func interfaceOfAddress(ip net.IP) (net.Interface, bool) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return net.Interface{}, false
+	}
+	for _, networkInterface := range interfaces {
+		addresses, err := networkInterface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, address := range addresses {
+			if ipNet, ok := address.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return networkInterface, true
+			}
+		}
+	}
+	return net.Interface{}, false
+}
+
+func loopbackInterface() (net.Interface, bool) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return net.Interface{}, false
+	}
+	for _, networkInterface := range interfaces {
+		if networkInterface.Flags&net.FlagLoopback != 0 {
+			return networkInterface, true
+		}
+	}
+	return net.Interface{}, false
+}
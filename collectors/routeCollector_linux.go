@@ -0,0 +1,112 @@
+//go:build linux
+
+package collectors
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// MTU of the route of a connected socket (IP_MTU, IPV6_MTU), including what path MTU discovery learnt
+func pathMtu(conn *net.UDPConn) (int, error) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	level, option := syscall.IPPROTO_IP, syscall.IP_MTU
+	if conn.LocalAddr().(*net.UDPAddr).IP.To4() == nil {
+		level, option = syscall.IPPROTO_IPV6, syscall.IPV6_MTU
+	}
+	var mtu int
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		mtu, sockErr = syscall.GetsockoptInt(int(fd), level, option)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return mtu, sockErr
+}
+
+// Next hop of the most specific route of the main table toward a peer through an interface, empty if on link
+func routeGateway(peer net.IP, interfaceName string) string {
+	if peer.To4() != nil {
+		return routeGateway4(peer.To4(), interfaceName)
+	}
+	return routeGateway6(peer, interfaceName)
+}
+
+// /proc/net/route: Iface Destination Gateway Flags RefCnt Use Metric Mask ..., addresses in hex of host byte order
+func routeGateway4(peer net.IP, interfaceName string) string {
+	content, err := os.ReadFile("/proc/net/route")
+	if err != nil {
+		return ""
+	}
+	gateway, bestPrefix, bestMetric := "", -1, uint64(0)
+	for _, line := range strings.Split(string(content), "\n")[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 8 || fields[0] != interfaceName {
+			continue
+		}
+		destination, gatewayIp, mask := procRouteIp(fields[1]), procRouteIp(fields[2]), procRouteIp(fields[7])
+		if destination == nil || gatewayIp == nil || mask == nil {
+			continue
+		}
+		prefix, _ := net.IPMask(mask).Size()
+		metric, _ := strconv.ParseUint(fields[6], 10, 64)
+		if !destination.Equal(peer.Mask(net.IPMask(mask))) || prefix < bestPrefix || (prefix == bestPrefix && metric >= bestMetric) {
+			continue
+		}
+		gateway, bestPrefix, bestMetric = "", prefix, metric
+		if !gatewayIp.IsUnspecified() {
+			gateway = gatewayIp.String()
+		}
+	}
+	return gateway
+}
+
+func procRouteIp(value string) net.IP {
+	raw, err := strconv.ParseUint(value, 16, 32)
+	if err != nil {
+		return nil
+	}
+	ip := make(net.IP, 4)
+	binary.NativeEndian.PutUint32(ip, uint32(raw))
+	return ip
+}
+
+// /proc/net/ipv6_route: destination, prefix length, source, source prefix length, next hop, metric, ..., interface
+func routeGateway6(peer net.IP, interfaceName string) string {
+	content, err := os.ReadFile("/proc/net/ipv6_route")
+	if err != nil {
+		return ""
+	}
+	gateway, bestPrefix, bestMetric := "", -1, uint64(0)
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 10 || fields[9] != interfaceName {
+			continue
+		}
+		destination, err1 := hex.DecodeString(fields[0])
+		prefix, err2 := strconv.ParseInt(fields[1], 16, 32)
+		nextHop, err3 := hex.DecodeString(fields[4])
+		metric, err4 := strconv.ParseUint(fields[5], 16, 32)
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil || len(destination) != net.IPv6len || len(nextHop) != net.IPv6len {
+			continue
+		}
+		mask := net.CIDRMask(int(prefix), 128)
+		if !net.IP(destination).Equal(peer.Mask(mask)) || int(prefix) < bestPrefix || (int(prefix) == bestPrefix && metric >= bestMetric) {
+			continue
+		}
+		gateway, bestPrefix, bestMetric = "", int(prefix), metric
+		if !net.IP(nextHop).IsUnspecified() {
+			gateway = net.IP(nextHop).String()
+		}
+	}
+	return gateway
+}
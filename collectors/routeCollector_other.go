@@ -0,0 +1,17 @@
+//go:build !linux
+
+package collectors
+
+import (
+	"errors"
+	"net"
+)
+
+// The route MTU and the routing table are read from the kernel, only available on Linux
+func pathMtu(conn *net.UDPConn) (int, error) {
+	return 0, errors.New("path MTU only available on Linux")
+}
+
+func routeGateway(peer net.IP, interfaceName string) string {
+	return ""
+}
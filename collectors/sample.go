@@ -0,0 +1,39 @@
+package collectors
+
+// Type of a sampled metric, a counter only increases until it is reset
+type MetricType int
+
+const (
+	Gauge MetricType = iota
+	Counter
+)
+
+// A point emitted by a collector for a sample: a metric, its labels as key/value pairs, its value and its type. Integer
+// values are written without decimals.
+type Sample struct {
+	Name    string
+	Labels  []string
+	Value   float64
+	Type    MetricType
+	Integer bool
+}
+
+func NewSample(name string, metricType MetricType, value float64, integer bool, labels ...string) Sample {
+	return Sample{Name: name, Labels: labels, Value: value, Type: metricType, Integer: integer}
+}
+
+func gauge(name string, value float64, labels ...string) Sample {
+	return NewSample(name, Gauge, value, false, labels...)
+}
+
+func intGauge(name string, value uint64, labels ...string) Sample {
+	return NewSample(name, Gauge, float64(value), true, labels...)
+}
+
+func counter(name string, value float64, labels ...string) Sample {
+	return NewSample(name, Counter, value, false, labels...)
+}
+
+func intCounter(name string, value uint64, labels ...string) Sample {
+	return NewSample(name, Counter, float64(value), true, labels...)
+}
@@ -0,0 +1,111 @@
+package collectors
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os/exec"
+)
+
+type SmartDeviceMetrics struct {
+	Device             string
+	Model              string
+	Serial             string
+	Protocol           string
+	Passed             bool
+	TemperatureCelsius int64
+	PowerOnHours       uint64
+	MediaErrors        uint64 // NVMe media errors, ATA reallocated sectors
+	PercentageUsed     int64  // NVMe only, -1 if unknown
+}
+
+// Subset of the smartctl --json output
+type smartctlOutput struct {
+	Device struct {
+		Name     string `json:"name"`
+		Protocol string `json:"protocol"`
+	} `json:"device"`
+	ModelName    string `json:"model_name"`
+	SerialNumber string `json:"serial_number"`
+	SmartStatus  struct {
+		Passed bool `json:"passed"`
+	} `json:"smart_status"`
+	Temperature struct {
+		Current int64 `json:"current"`
+	} `json:"temperature"`
+	PowerOnTime struct {
+		Hours uint64 `json:"hours"`
+	} `json:"power_on_time"`
+	NvmeHealth *struct {
+		MediaErrors    uint64 `json:"media_errors"`
+		PercentageUsed int64  `json:"percentage_used"`
+	} `json:"nvme_smart_health_information_log"`
+	AtaAttributes struct {
+		Table []struct {
+			Id  int `json:"id"`
+			Raw struct {
+				Value uint64 `json:"value"`
+			} `json:"raw"`
+		} `json:"table"`
+	} `json:"ata_smart_attributes"`
+}
+
+const ataReallocatedSectorsId = 5
+
+// Collect SMART/NVMe health of all devices with smartctl (smartmontools >= 7.0, needs root)
+func CollectSmartMetrics() []SmartDeviceMetrics {
+	var smartMetrics []SmartDeviceMetrics
+
+	smartctl, err := exec.LookPath("smartctl")
+	if err != nil {
+		slog.Warn("Cannot find smartctl, SMART snapshot skipped", "error", err)
+		return smartMetrics
+	}
+
+	scanOutput, err := exec.Command(smartctl, "--scan-open", "--json").Output()
+	if err != nil {
+		slog.Warn("Cannot scan devices with smartctl", "error", err)
+		return smartMetrics
+	}
+	var scan struct {
+		Devices []struct {
+			Name string `json:"name"`
+			Type string `json:"type"`
+		} `json:"devices"`
+	}
+	if err := json.Unmarshal(scanOutput, &scan); err != nil {
+		slog.Warn("Cannot parse smartctl scan", "error", err)
+		return smartMetrics
+	}
+
+	for _, device := range scan.Devices {
+		// smartctl exit code is a bitmask also reporting device health, rely on the json output only
+		deviceOutput, _ := exec.Command(smartctl, "--json", "--all", "--device", device.Type, device.Name).Output()
+		var smart smartctlOutput
+		if err := json.Unmarshal(deviceOutput, &smart); err != nil {
+			slog.Warn("Cannot parse smartctl output", "device", device.Name, "error", err)
+			continue
+		}
+
+		deviceMetrics := SmartDeviceMetrics{
+			Device:             device.Name,
+			Model:              smart.ModelName,
+			Serial:             smart.SerialNumber,
+			Protocol:           smart.Device.Protocol,
+			Passed:             smart.SmartStatus.Passed,
+			TemperatureCelsius: smart.Temperature.Current,
+			PowerOnHours:       smart.PowerOnTime.Hours,
+			PercentageUsed:     -1,
+		}
+		if smart.NvmeHealth != nil {
+			deviceMetrics.MediaErrors = smart.NvmeHealth.MediaErrors
+			deviceMetrics.PercentageUsed = smart.NvmeHealth.PercentageUsed
+		}
+		for _, attribute := range smart.AtaAttributes.Table {
+			if attribute.Id == ataReallocatedSectorsId {
+				deviceMetrics.MediaErrors = attribute.Raw.Value
+			}
+		}
+		smartMetrics = append(smartMetrics, deviceMetrics)
+	}
+	return smartMetrics
+}
@@ -0,0 +1,106 @@
+package collectors
+
+import (
+	"os"
+	"strings"
+
+	"github.com/shirou/gopsutil/v3/host"
+)
+
+// Virtualization of the host, "none" when not detected
+type VirtualizationInfo struct {
+	Hypervisor string // kvm, xen, vmware, hyperv, vbox, ...
+	Container  string // docker, podman, lxc, ...
+	Cloud      string // aws, gcp, azure, ...
+}
+
+// Cloud providers by a fragment of their DMI sys_vendor, product_name, bios_vendor or chassis_asset_tag
+var cloudProviders = []struct {
+	fragment string
+	cloud    string
+}{
+	{"amazon ec2", "aws"},
+	{"google", "gcp"},
+	{"7783-7084-3265-9085-8269-3286-77", "azure"}, // asset tag of Azure VMs, Hyper-V alone has none
+	{"digitalocean", "digitalocean"},
+	{"hetzner", "hetzner"},
+	{"scaleway", "scaleway"},
+	{"ovh", "ovh"},
+	{"alibaba", "alibaba"},
+	{"oraclecloud", "oracle"},
+	{"linode", "linode"},
+	{"vultr", "vultr"},
+	{"exoscale", "exoscale"},
+	{"openstack", "openstack"},
+}
+
+// Hypervisors by a fragment of their DMI sys_vendor or product_name
+var hypervisorVendors = []struct {
+	fragment   string
+	hypervisor string
+}{
+	{"qemu", "kvm"},
+	{"kvm", "kvm"},
+	{"amazon ec2", "kvm"}, // Nitro
+	{"google", "kvm"},
+	{"vmware", "vmware"},
+	{"virtualbox", "vbox"},
+	{"innotek", "vbox"},
+	{"xen", "xen"},
+	{"microsoft corporation", "hyperv"},
+	{"parallels", "parallels"},
+	{"bhyve", "bhyve"},
+}
+
+// Detect the hypervisor from DMI and the hypervisor CPU flag, the container from its marker files and cgroups (Linux only)
+func CollectVirtualizationInfo() VirtualizationInfo {
+	info := VirtualizationInfo{Hypervisor: "none", Container: "none", Cloud: "none"}
+
+	dmi := strings.ToLower(strings.Join([]string{
+		readStringFile("/sys/class/dmi/id/sys_vendor", ""),
+		readStringFile("/sys/class/dmi/id/product_name", ""),
+		readStringFile("/sys/class/dmi/id/bios_vendor", ""),
+		readStringFile("/sys/class/dmi/id/chassis_asset_tag", ""),
+	}, " "))
+	for _, provider := range cloudProviders {
+		if strings.Contains(dmi, provider.fragment) {
+			info.Cloud = provider.cloud
+			break
+		}
+	}
+
+	// Bare metal has no hypervisor flag, even with the DMI of a vendor which also makes hypervisors
+	cpuinfo, _ := os.ReadFile("/proc/cpuinfo")
+	guest := strings.Contains(string(cpuinfo), " hypervisor")
+	if hypervisorType := readStringFile("/sys/hypervisor/type", ""); hypervisorType != "" {
+		info.Hypervisor = hypervisorType
+	} else if guest {
+		info.Hypervisor = "unknown"
+		for _, vendor := range hypervisorVendors {
+			if strings.Contains(dmi, vendor.fragment) {
+				info.Hypervisor = vendor.hypervisor
+				break
+			}
+		}
+	}
+
+	switch {
+	case fileExists("/.dockerenv"):
+		info.Container = "docker"
+	case fileExists("/run/.containerenv"):
+		info.Container = "podman"
+	default:
+		if system, role, err := host.Virtualization(); err == nil && role == "guest" {
+			switch system {
+			case "docker", "lxc", "rkt", "openvz", "linux-vserver", "podman":
+				info.Container = system
+			}
+		}
+	}
+	return info
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
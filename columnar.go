@@ -0,0 +1,189 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/blackswifthosting/statexec/collectors"
+)
+
+// Timestamp and command status of a sample, its values are stored in the series
+type SampleInfo struct {
+	cmdStatus int
+	timestamp int64
+}
+
+// A series stores its points column-wise, a value and a timestamp for each sample it appears in
+type Series struct {
+	name       string
+	labels     map[string]string
+	rendered   string
+	integer    bool
+	counter    bool
+	timestamps []int64
+	values     []float64
+}
+
+// Snapshot of the samples and series collected so far
+type MetricsSnapshot struct {
+	samples []SampleInfo
+	series  []Series
+	events  []CommandEvent
+}
+
+// Insert a point, keeping points ordered by timestamp as concurrent samples may be stored out of order
+func (series *Series) add(value float64, timestamp int64) {
+	series.timestamps = append(series.timestamps, timestamp)
+	series.values = append(series.values, value)
+	for i := len(series.timestamps) - 1; i > 0 && series.timestamps[i-1] > series.timestamps[i]; i-- {
+		series.timestamps[i-1], series.timestamps[i] = series.timestamps[i], series.timestamps[i-1]
+		series.values[i-1], series.values[i] = series.values[i], series.values[i-1]
+	}
+}
+
+// Counters only increase, unless they are reset (interface down/up, device re-enumeration)
+func (series Series) isCounter() bool {
+	return series.counter
+}
+
+// A counter falling below half of its previous value was reset, smaller decreases are jitter of the kernel accounting, e.g. CPU times
+func isCounterReset(previous float64, value float64) bool {
+	return value < previous/2
+}
+
+// Value of the series at a timestamp, if it has a point there
+func (series Series) valueAt(timestamp int64) (float64, bool) {
+	i := sort.Search(len(series.timestamps), func(i int) bool { return series.timestamps[i] >= timestamp })
+	if i < len(series.timestamps) && series.timestamps[i] == timestamp {
+		return series.values[i], true
+	}
+	return 0, false
+}
+
+// Render a point in prometheus format
+func (series Series) appendPoint(buffer []byte, index int) []byte {
+	buffer = append(buffer, MetricPrefix...)
+	buffer = append(buffer, series.name...)
+	buffer = append(buffer, '{')
+	buffer = append(buffer, series.rendered...)
+	buffer = append(buffer, "} "...)
+	if series.integer {
+		buffer = strconv.AppendInt(buffer, int64(series.values[index]), 10)
+	} else {
+		buffer = strconv.AppendFloat(buffer, series.values[index], 'f', 6, 64)
+	}
+	buffer = append(buffer, ' ')
+	buffer = strconv.AppendInt(buffer, series.timestamps[index], 10)
+	return append(buffer, '\n')
+}
+
+// Series with a name, and the given labels if any
+func (snapshot MetricsSnapshot) find(name string, labels ...string) []Series {
+	var found []Series
+	for _, series := range snapshot.series {
+		if series.name != name {
+			continue
+		}
+		matching := true
+		for i := 0; i+1 < len(labels); i += 2 {
+			if series.labels[labels[i]] != labels[i+1] {
+				matching = false
+			}
+		}
+		if matching {
+			found = append(found, series)
+		}
+	}
+	return found
+}
+
+// Sum of the values of series at a timestamp
+func sumAt(series []Series, timestamp int64) float64 {
+	sum := 0.0
+	for _, oneSeries := range series {
+		if value, ok := oneSeries.valueAt(timestamp); ok {
+			sum += value
+		}
+	}
+	return sum
+}
+
+// Whether one of the series has a point at a timestamp
+func hasPointAt(series []Series, timestamp int64) bool {
+	for _, oneSeries := range series {
+		if _, ok := oneSeries.valueAt(timestamp); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Increase of counters between two timestamps, a decreasing value is a reset and counts from zero like rate() does
+func increaseBetween(series []Series, from int64, to int64) float64 {
+	increase := 0.0
+	for _, oneSeries := range series {
+		start := sort.Search(len(oneSeries.timestamps), func(i int) bool { return oneSeries.timestamps[i] >= from })
+		for i := start + 1; i < len(oneSeries.timestamps) && oneSeries.timestamps[i] <= to; i++ {
+			if delta := oneSeries.values[i] - oneSeries.values[i-1]; delta >= 0 {
+				increase += delta
+			} else if isCounterReset(oneSeries.values[i-1], oneSeries.values[i]) {
+				increase += oneSeries.values[i]
+			}
+		}
+	}
+	return increase
+}
+
+// Distinct values of a label among series having a point at a timestamp
+func labelValuesAt(series []Series, label string, timestamp int64) []string {
+	var values []string
+	seen := make(map[string]bool)
+	for _, oneSeries := range series {
+		value := oneSeries.labels[label]
+		if _, ok := oneSeries.valueAt(timestamp); ok && !seen[value] {
+			seen[value] = true
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func seriesKey(name string, labels []string) string {
+	return name + "\xff" + strings.Join(labels, "\xff")
+}
+
+// Points of a sample under the names selected by --legacy-names: the command status, the samples of the collectors which
+// reported, a collector disabled or failed having no points rather than zeros, then the self monitoring of the collection
+func (metric InstantMetric) points() []collectors.Sample {
+	points := []collectors.Sample{collectors.NewSample("command_status", collectors.Gauge, float64(metric.cmdStatus), true)}
+	for _, collected := range metric.collected {
+		points = append(points, collected.samples...)
+	}
+
+	// Self monitoring
+	points = append(points,
+		collectors.NewSample("time_since_start_seconds", collectors.Gauge, float64(metric.msSinceStart)/1000.0, false),
+		collectors.NewSample("metric_collect_duration_seconds", collectors.Gauge, float64(metric.collectDuration)/1000.0, false),
+	)
+	collectorNames := make([]string, 0, len(metric.collectorDurations))
+	for collector := range metric.collectorDurations {
+		collectorNames = append(collectorNames, collector)
+	}
+	sort.Strings(collectorNames)
+	for _, collector := range collectorNames {
+		success := 1
+		if metric.collectorFailures[collector] {
+			success = 0
+		}
+		points = append(points,
+			collectors.NewSample("collector_duration_seconds", collectors.Gauge, float64(metric.collectorDurations[collector])/1000.0, false, "collector", collector),
+			collectors.NewSample("collector_success", collectors.Gauge, float64(success), true, "collector", collector),
+		)
+	}
+
+	for i := range points {
+		points[i] = namedSample(points[i])
+	}
+	return points
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+)
+
+// Sample of the synthetic collectors, as many series as a small host
+func benchmarkSample(timestamp int64) InstantMetric {
+	metric := InstantMetric{
+		cmdStatus:          CommandStatusRunning,
+		timestamp:          timestamp,
+		collectorDurations: make(map[string]int64),
+		collectorFailures:  make(map[string]bool),
+	}
+	for _, collector := range fakeSampleCollectors() {
+		samples, _ := collector.collect()
+		metric.storeCollector(collector.name, samples)
+		metric.collectorDurations[collector.name] = 0
+	}
+	return metric
+}
+
+func setupBenchmarkCollectors() {
+	fakeCollectorsSeed = 42
+	fakeCollectorStates = make(map[string]*fakeCollector)
+	enabledCollectors = map[string]bool{"cpu": true, "memory": true, "network": true, "disk": true}
+}
+
+func BenchmarkSeriesAdd(b *testing.B) {
+	series := Series{name: "cpu_seconds_total"}
+	for i := 0; i < b.N; i++ {
+		series.add(float64(i), int64(i)*1000)
+	}
+}
+
+func BenchmarkSamplePoints(b *testing.B) {
+	setupBenchmarkCollectors()
+	metric := benchmarkSample(1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		metric.points()
+	}
+}
+
+// Columnar append of a sample, its points appended to the series of the store
+func BenchmarkStoreAddMetric(b *testing.B) {
+	setupBenchmarkCollectors()
+	samples := make([]InstantMetric, 100)
+	for i := range samples {
+		samples[i] = benchmarkSample(int64(i) * 1000)
+	}
+	testStore := newTestStore()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sample := samples[i%len(samples)]
+		sample.timestamp = int64(i) * 1000
+		testStore.AddMetric(sample)
+	}
+}
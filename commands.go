@@ -0,0 +1,252 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//go:embed explorer/config/grafana-dashboards/statexec-dashboard.json
+var dashboardJson []byte
+
+type Subcommand struct {
+	Name        string
+	Description string
+	Flags       []string
+	Run         func(args []string)
+}
+
+// List of available subcommands, "run" is the default one
+func subcommands() []Subcommand {
+	return []Subcommand{
+		{Name: "run", Description: "Execute a command and collect metrics (default)", Flags: runFlags, Run: runSubcommand},
+		{Name: "check", Description: "Execute a command and fail if it regressed compared to a baseline run", Flags: append([]string{"--tolerance"}, runFlags...), Run: checkSubcommand},
+		{Name: "daemon", Description: "Execute a command on a schedule, keeping the outputs of each run", Flags: daemonFlags, Run: daemonSubcommand},
+		{Name: "follow", Description: "Execute the command distributed by a sync server, synchronized with it", Flags: followFlags, Run: followSubcommand},
+		{Name: "install-agent", Description: "Print or install a systemd unit running the daemon", Flags: installAgentFlags, Run: installAgentSubcommand},
+		{Name: "import", Description: "Import result files into VictoriaMetrics and Grafana", Flags: importFlags, Run: importSubcommand},
+		{Name: "report", Description: "Print the summary of a result file", Flags: reportFlags, Run: reportSubcommand},
+		{Name: "compare", Description: "Compare the summaries of two result files", Flags: compareFlags, Run: compareSubcommand},
+		{Name: "analyze", Description: "Flag suspicious patterns of a result file", Flags: analyzeFlags, Run: analyzeSubcommand},
+		{Name: "validate", Description: "Check the schema, series and annotations of result files", Flags: validateFlags, Run: validateSubcommand},
+		{Name: "merge", Description: "Merge result files into a single one", Flags: mergeFlags, Run: mergeSubcommand},
+		{Name: "resample", Description: "Downsample or crop a result file", Flags: resampleFlags, Run: resampleSubcommand},
+		{Name: "replay", Description: "Replay a result file into a live sink with shifted timestamps", Flags: replayFlags, Run: replaySubcommand},
+		{Name: "explore", Description: "Start the explorer stack and import result files", Flags: exploreFlags, Run: exploreSubcommand},
+		{Name: "generate-sample", Description: "Write synthetic result files of a multi-node run, to demo the explorer or test dashboards", Flags: generateSampleFlags, Run: generateSampleSubcommand},
+		{Name: "dashboard", Description: "Print or upload the Grafana dashboard", Flags: dashboardFlags, Run: dashboardSubcommand},
+		{Name: "self-update", Description: "Replace the binary by the latest release", Flags: selfUpdateFlags, Run: selfUpdateSubcommand},
+		{Name: "completion", Description: "Generate shell completion script (bash, zsh, fish)", Run: completionSubcommand},
+	}
+}
+
+func findSubcommand(name string) (Subcommand, bool) {
+	for _, subcommand := range subcommands() {
+		if subcommand.Name == name {
+			return subcommand, true
+		}
+	}
+	return Subcommand{}, false
+}
+
+// Return the value following a flag, exit if missing
+func flagValue(args []string, i int) string {
+	if i+1 >= len(args) {
+		fatalWith(ExitConfig, "Missing value for flag", "flag", args[i])
+	}
+	return args[i+1]
+}
+
+//===============================================================================
+// Explore
+//===============================================================================
+
+var exploreFlags = []string{"--explorer-dir", "--tsdb"}
+
+func exploreSubcommand(args []string) {
+	explorerDir := "explorer"
+	if value := os.Getenv(EnvVarPrefix + "EXPLORER_DIR"); value != "" {
+		explorerDir = value
+	}
+
+	importArgs := []string{}
+	tsdb := ""
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--explorer-dir":
+			explorerDir = flagValue(args, i)
+			i++
+		case "--tsdb":
+			tsdb = flagValue(args, i)
+			i++
+		case "-h", "--help":
+			fmt.Printf("Usage: %s explore [--explorer-dir <dir>] [--tsdb <dir> | import dir]\n", os.Args[0])
+			fmt.Printf("  --explorer-dir <dir>   %sEXPLORER_DIR   Directory of the explorer stack (default: explorer)\n", EnvVarPrefix)
+			fmt.Println("  --tsdb <dir>                             Serve a local TSDB written by run --tsdb with Prometheus instead of importing result files")
+			os.Exit(0)
+		default:
+			importArgs = append(importArgs, args[i])
+		}
+	}
+
+	script := filepath.Join(explorerDir, "explorer.sh")
+	if _, err := os.Stat(script); err != nil {
+		fatalWith(ExitConfig, "Explorer script not found, use --explorer-dir to locate it", "error", err)
+	}
+
+	action := append([]string{"explore"}, importArgs...)
+	if tsdb != "" {
+		from, to, err := tsdbTimeRange(tsdb)
+		if err != nil {
+			fatalWith(ExitConfig, "Cannot read tsdb", "dir", tsdb, "error", err)
+		}
+		action = []string{"explore-tsdb", tsdb, strconv.FormatInt(from, 10), strconv.FormatInt(to, 10)}
+	}
+
+	cmd := exec.Command(script, action...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		fatal("Cannot run explorer", "error", err)
+	}
+}
+
+//===============================================================================
+// Dashboard
+//===============================================================================
+
+var dashboardFlags = []string{"--grafana-url"}
+
+func dashboardSubcommand(args []string) {
+	grafanaUrl := ""
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--grafana-url":
+			grafanaUrl = flagValue(args, i)
+			i++
+		case "-h", "--help":
+			fmt.Printf("Usage: %s dashboard [--grafana-url <url>]\n", os.Args[0])
+			fmt.Println("  Print the statexec Grafana dashboard, or upload it when --grafana-url is set")
+			os.Exit(0)
+		default:
+			fatalWith(ExitConfig, "Unknown argument", "argument", args[i])
+		}
+	}
+
+	if grafanaUrl == "" {
+		fmt.Println(string(dashboardJson))
+		return
+	}
+
+	body := `{"overwrite":true,"dashboard":` + string(dashboardJson) + `}`
+	if err := postJson(strings.TrimSuffix(grafanaUrl, "/")+"/api/dashboards/db", []byte(body)); err != nil {
+		fatal("Cannot upload dashboard", "grafana", grafanaUrl, "error", err)
+	}
+}
+
+//===============================================================================
+// Completion
+//===============================================================================
+
+func completionSubcommand(args []string) {
+	if len(args) != 1 {
+		fmt.Printf("Usage: %s completion <bash|zsh|fish>\n", os.Args[0])
+		os.Exit(ExitConfig)
+	}
+
+	switch args[0] {
+	case "bash":
+		fmt.Print(bashCompletion())
+	case "zsh":
+		fmt.Print(zshCompletion())
+	case "fish":
+		fmt.Print(fishCompletion())
+	default:
+		fatalWith(ExitConfig, "Unsupported shell", "shell", args[0])
+	}
+}
+
+func subcommandNames() string {
+	names := []string{}
+	for _, subcommand := range subcommands() {
+		names = append(names, subcommand.Name)
+	}
+	return strings.Join(names, " ")
+}
+
+func bashCompletion() string {
+	script := "# bash completion for statexec\n"
+	script += "_statexec() {\n"
+	script += "    local cur=\"${COMP_WORDS[COMP_CWORD]}\"\n"
+	script += "    if [ \"$COMP_CWORD\" -eq 1 ]; then\n"
+	script += fmt.Sprintf("        COMPREPLY=( $(compgen -W \"%s %s\" -- \"$cur\") )\n", subcommandNames(), strings.Join(runFlags, " "))
+	script += "        return\n"
+	script += "    fi\n"
+	script += "    case \"${COMP_WORDS[1]}\" in\n"
+	for _, subcommand := range subcommands() {
+		words := strings.Join(subcommand.Flags, " ")
+		if subcommand.Name == "completion" {
+			words = "bash zsh fish"
+		}
+		script += fmt.Sprintf("        %s) COMPREPLY=( $(compgen -W \"%s\" -- \"$cur\") ) ;;\n", subcommand.Name, words)
+	}
+	script += fmt.Sprintf("        *) COMPREPLY=( $(compgen -W \"%s\" -- \"$cur\") ) ;;\n", strings.Join(runFlags, " "))
+	script += "    esac\n"
+	script += "}\n"
+	script += "complete -o default -F _statexec statexec\n"
+	return script
+}
+
+func zshCompletion() string {
+	script := "#compdef statexec\n"
+	script += "_statexec() {\n"
+	script += "    if (( CURRENT == 2 )); then\n"
+	script += fmt.Sprintf("        compadd -- %s %s\n", subcommandNames(), strings.Join(runFlags, " "))
+	script += "        _files\n"
+	script += "        return\n"
+	script += "    fi\n"
+	script += "    case $words[2] in\n"
+	for _, subcommand := range subcommands() {
+		words := strings.Join(subcommand.Flags, " ")
+		if subcommand.Name == "completion" {
+			words = "bash zsh fish"
+		}
+		script += fmt.Sprintf("        %s) compadd -- %s; _files ;;\n", subcommand.Name, words)
+	}
+	script += fmt.Sprintf("        *) compadd -- %s; _files ;;\n", strings.Join(runFlags, " "))
+	script += "    esac\n"
+	script += "}\n"
+	script += "compdef _statexec statexec\n"
+	return script
+}
+
+func fishCompletion() string {
+	script := "# fish completion for statexec\n"
+	for _, subcommand := range subcommands() {
+		script += fmt.Sprintf("complete -c statexec -n __fish_use_subcommand -a %s -d '%s'\n", subcommand.Name, subcommand.Description)
+	}
+	script += "complete -c statexec -n '__fish_seen_subcommand_from completion' -f -a 'bash zsh fish'\n"
+	for _, subcommand := range subcommands() {
+		for _, flag := range subcommand.Flags {
+			script += fmt.Sprintf("complete -c statexec -n '__fish_seen_subcommand_from %s' %s\n", subcommand.Name, fishFlag(flag))
+		}
+	}
+	return script
+}
+
+// Convert a flag to fish syntax : --file => -l file, -f => -s f, -mst => -o mst
+func fishFlag(flag string) string {
+	if strings.HasPrefix(flag, "--") {
+		return "-l " + strings.TrimPrefix(flag, "--")
+	}
+	name := strings.TrimPrefix(flag, "-")
+	if len(name) == 1 {
+		return "-s " + name
+	}
+	return "-o " + name
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule of daemon runs, a cron expression or a fixed interval
+type Schedule struct {
+	Expression string
+	every      time.Duration
+	fields     [5]map[int]bool // minute, hour, day of month, month, day of week
+	anyDay     bool            // day of month is *
+	anyWeekday bool            // day of week is *
+}
+
+var cronAliases = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+}
+
+// Parse a schedule: a 5 fields cron expression ("0 2 * * *"), an alias (@daily) or an interval (@every 1h)
+func parseSchedule(expression string) (Schedule, error) {
+	schedule := Schedule{Expression: expression}
+	expression = strings.TrimSpace(expression)
+
+	if strings.HasPrefix(expression, "@every ") {
+		every, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(expression, "@every ")))
+		if err != nil || every < time.Second {
+			return schedule, fmt.Errorf("invalid interval, expected a duration of at least 1s like @every 1h")
+		}
+		schedule.every = every
+		return schedule, nil
+	}
+	if alias, ok := cronAliases[expression]; ok {
+		expression = alias
+	}
+
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return schedule, fmt.Errorf("expected 5 fields (minute hour day-of-month month day-of-week), got %d", len(fields))
+	}
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	for i, field := range fields {
+		values, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return schedule, fmt.Errorf("field %q: %w", field, err)
+		}
+		schedule.fields[i] = values
+	}
+	// Sunday is both 0 and 7
+	if schedule.fields[4][7] {
+		schedule.fields[4][0] = true
+	}
+	schedule.anyDay = fields[2] == "*"
+	schedule.anyWeekday = fields[4] == "*"
+	return schedule, nil
+}
+
+// Parse a cron field made of comma separated *, n, a-b and */step or a-b/step items
+func parseCronField(field string, min int, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, item := range strings.Split(field, ",") {
+		step := 1
+		if base, stepValue, found := strings.Cut(item, "/"); found {
+			var err error
+			step, err = strconv.Atoi(stepValue)
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step %q", stepValue)
+			}
+			item = base
+		}
+
+		start, end := min, max
+		if item != "*" {
+			first, last, isRange := strings.Cut(item, "-")
+			var err error
+			if start, err = strconv.Atoi(first); err != nil {
+				return nil, fmt.Errorf("invalid value %q", first)
+			}
+			end = start
+			if isRange {
+				if end, err = strconv.Atoi(last); err != nil {
+					return nil, fmt.Errorf("invalid value %q", last)
+				}
+			}
+		}
+		if start < min || end > max || start > end {
+			return nil, fmt.Errorf("out of range %d-%d", min, max)
+		}
+		for value := start; value <= end; value += step {
+			values[value] = true
+		}
+	}
+	return values, nil
+}
+
+// Next time the schedule fires strictly after the given time
+func (schedule Schedule) Next(after time.Time) time.Time {
+	if schedule.every > 0 {
+		return after.Add(schedule.every)
+	}
+
+	next := after.Truncate(time.Minute).Add(time.Minute)
+	// Every minute of a few years is enough to find any valid expression (e.g. February 29th)
+	for i := 0; i < 5*366*24*60; i++ {
+		if schedule.matches(next) {
+			return next
+		}
+		next = next.Add(time.Minute)
+	}
+	return time.Time{}
+}
+
+func (schedule Schedule) matches(t time.Time) bool {
+	if !schedule.fields[0][t.Minute()] || !schedule.fields[1][t.Hour()] || !schedule.fields[3][int(t.Month())] {
+		return false
+	}
+	dayMatches := schedule.fields[2][t.Day()]
+	weekdayMatches := schedule.fields[4][int(t.Weekday())]
+	// As in cron, when both days are restricted either one matching is enough
+	switch {
+	case schedule.anyDay && schedule.anyWeekday:
+		return true
+	case schedule.anyDay:
+		return weekdayMatches
+	case schedule.anyWeekday:
+		return dayMatches
+	default:
+		return dayMatches || weekdayMatches
+	}
+}
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Specification of a run, read from the daemon configuration file or posted to the API
+type RunSpec struct {
+	Command  []string          `json:"command"`
+	Instance string            `json:"instance,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Args     []string          `json:"args,omitempty"`     // Extra run flags, e.g. ["--collectors", "cpu,memory"]
+	Duration string            `json:"duration,omitempty"` // The command is terminated after it, e.g. "10m"
+	Outputs  []string          `json:"outputs,omitempty"`  // Extra outputs: junit, ci_summary
+}
+
+// Extra outputs of a run, written into its directory
+var runOutputs = map[string][]string{
+	"junit":      {"--junit", "junit.xml"},
+	"ci_summary": {"--ci-summary", "ci-summary.md"},
+}
+
+// Status of a run started by the daemon, also written as run.json in its directory
+type DaemonRun struct {
+	Id          string          `json:"id"`
+	Status      string          `json:"status"`  // running, done, failed
+	Trigger     string          `json:"trigger"` // schedule, api
+	ScheduledAt string          `json:"scheduled_at"`
+	StartedAt   string          `json:"started_at"`
+	FinishedAt  string          `json:"finished_at,omitempty"`
+	ExitCode    *int            `json:"exit_code,omitempty"`
+	Dir         string          `json:"dir"`
+	Spec        RunSpec         `json:"spec"`
+	Artifacts   []string        `json:"artifacts"`
+	Summary     json.RawMessage `json:"summary,omitempty"`
+}
+
+type Daemon struct {
+	mutex              sync.Mutex
+	spec               RunSpec
+	schedule           Schedule
+	scheduleExpression string
+	outputDir          string
+	keep               int
+	apiToken           string
+	runs               []*DaemonRun
+}
+
+var daemonFlags = []string{"--schedule", "--config", "--output-dir", "--keep", "--listen", "--api-token"}
+
+var errRunInProgress = errors.New("a run is already in progress")
+
+// Load a run specification from a YAML or JSON file
+func loadRunSpec(path string) (RunSpec, error) {
+	var spec RunSpec
+	if err := loadSpecFile(path, &spec); err != nil {
+		return spec, err
+	}
+	if err := spec.validate(); err != nil {
+		return spec, err
+	}
+	return spec, nil
+}
+
+// Decode a YAML or JSON file into a spec, commands given as a single string are split on spaces
+func loadSpecFile(path string, spec interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	generic, err := parseYaml(data)
+	if err != nil {
+		return err
+	}
+	splitCommandLines(generic)
+	jsonSpec, err := json.Marshal(generic)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(jsonSpec, spec)
+}
+
+func splitCommandLines(value interface{}) {
+	values, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+	if commandLine, ok := values["command"].(string); ok {
+		fields := []interface{}{}
+		for _, field := range strings.Fields(commandLine) {
+			fields = append(fields, field)
+		}
+		values["command"] = fields
+	}
+	// Per-node specs of a follower scenario
+	if nodes, ok := values["nodes"].(map[string]interface{}); ok {
+		for _, node := range nodes {
+			splitCommandLines(node)
+		}
+	}
+}
+
+func (spec RunSpec) validate() error {
+	if len(spec.Command) == 0 {
+		return fmt.Errorf("no command to execute")
+	}
+	if spec.Duration != "" {
+		if duration, err := time.ParseDuration(spec.Duration); err != nil || duration <= 0 {
+			return fmt.Errorf("invalid duration %q", spec.Duration)
+		}
+	}
+	for _, output := range spec.Outputs {
+		if _, ok := runOutputs[output]; !ok {
+			return fmt.Errorf("unknown output %q, expected junit or ci_summary", output)
+		}
+	}
+	return nil
+}
+
+// Arguments of the statexec process executing a run, its outputs written into a directory
+func (spec RunSpec) runArgs(dir string) []string {
+	args := []string{
+		"--file", filepath.Join(dir, "metrics.prom"),
+		"--summary-json", filepath.Join(dir, "summary.json"),
+		"--manifest", filepath.Join(dir, "manifest.json"),
+	}
+	for _, output := range spec.Outputs {
+		args = append(args, runOutputs[output][0], filepath.Join(dir, runOutputs[output][1]))
+	}
+	if spec.Instance != "" {
+		args = append(args, "--instance", spec.Instance)
+	}
+	if spec.Duration != "" {
+		args = append(args, "--duration", spec.Duration)
+	}
+	keys := make([]string, 0, len(spec.Labels))
+	for key := range spec.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, "--label", key+"="+spec.Labels[key])
+	}
+	args = append(args, spec.Args...)
+	args = append(args, "--")
+	return append(args, spec.Command...)
+}
+
+// Load the runs of previous daemon executions from the output directory
+func (daemon *Daemon) loadRuns() {
+	entries, err := os.ReadDir(daemon.outputDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(daemon.outputDir, entry.Name(), "run.json"))
+		if err != nil {
+			continue
+		}
+		var run DaemonRun
+		if err := json.Unmarshal(data, &run); err != nil {
+			logger.Warn("Cannot read past run", "dir", entry.Name(), "error", err)
+			continue
+		}
+		// Interrupted by a restart of the daemon
+		if run.Status == "running" {
+			run.Status = "failed"
+		}
+		daemon.runs = append(daemon.runs, &run)
+	}
+	sort.Slice(daemon.runs, func(i, j int) bool { return daemon.runs[i].Id < daemon.runs[j].Id })
+}
+
+func (daemon *Daemon) saveRun(run *DaemonRun) {
+	runJson, err := json.MarshalIndent(run, "", "  ")
+	if err == nil {
+		err = os.WriteFile(filepath.Join(run.Dir, "run.json"), append(runJson, '\n'), 0644)
+	}
+	if err != nil {
+		logger.Error("Cannot write run status", "run", run.Id, "error", err)
+	}
+}
+
+func (daemon *Daemon) running() bool {
+	for _, run := range daemon.runs {
+		if run.Status == "running" {
+			return true
+		}
+	}
+	return false
+}
+
+func (daemon *Daemon) findRun(id string) *DaemonRun {
+	for _, run := range daemon.runs {
+		if run.Id == id {
+			return run
+		}
+	}
+	return nil
+}
+
+// Register a new run, unless one is still running: benchmarks must not disturb each other
+func (daemon *Daemon) newRun(spec RunSpec, trigger string, scheduledAt time.Time) (*DaemonRun, error) {
+	daemon.mutex.Lock()
+	defer daemon.mutex.Unlock()
+	if daemon.running() {
+		return nil, errRunInProgress
+	}
+	startedAt := time.Now().UTC()
+	id := startedAt.Format("20060102T150405Z")
+	for n := 2; daemon.findRun(id) != nil; n++ {
+		id = fmt.Sprintf("%s-%d", startedAt.Format("20060102T150405Z"), n)
+	}
+	run := &DaemonRun{
+		Id:          id,
+		Status:      "running",
+		Trigger:     trigger,
+		ScheduledAt: scheduledAt.UTC().Format(time.RFC3339),
+		StartedAt:   startedAt.Format(time.RFC3339),
+		Dir:         filepath.Join(daemon.outputDir, id),
+		Spec:        spec,
+	}
+	if err := os.MkdirAll(run.Dir, 0755); err != nil {
+		return nil, err
+	}
+	daemon.runs = append(daemon.runs, run)
+	daemon.saveRun(run)
+	return run, nil
+}
+
+// Run the child statexec process of a run, returning its exit code
+func runProcess(run *DaemonRun) int {
+	executable, err := os.Executable()
+	if err != nil {
+		fatal("Cannot find statexec executable", "error", err)
+	}
+	output, err := os.Create(filepath.Join(run.Dir, "output.log"))
+	if err != nil {
+		logger.Error("Cannot create run output", "dir", run.Dir, "error", err)
+		return -1
+	}
+	defer output.Close()
+
+	logger.Info("Run started", "run", run.Id, "dir", run.Dir)
+	cmd := exec.Command(executable, run.Spec.runArgs(run.Dir)...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	if err := cmd.Start(); err != nil {
+		logger.Error("Cannot start run", "run", run.Id, "error", err)
+		return -1
+	}
+	_ = cmd.Wait()
+	return cmd.ProcessState.ExitCode()
+}
+
+// Execute a run, then record its status and artifacts
+func (daemon *Daemon) execute(run *DaemonRun) {
+	exitCode := runProcess(run)
+
+	daemon.mutex.Lock()
+	run.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+	run.ExitCode = &exitCode
+	run.Status = "done"
+	if exitCode != 0 {
+		run.Status = "failed"
+	}
+	run.Artifacts = nil
+	if entries, err := os.ReadDir(run.Dir); err == nil {
+		for _, entry := range entries {
+			if entry.Name() != "run.json" {
+				run.Artifacts = append(run.Artifacts, entry.Name())
+			}
+		}
+	}
+	if summary, err := os.ReadFile(filepath.Join(run.Dir, "summary.json")); err == nil && json.Valid(summary) {
+		run.Summary = summary
+	}
+	daemon.saveRun(run)
+	daemon.rotate()
+	daemon.mutex.Unlock()
+	logger.Info("Run finished", "run", run.Id, "exit_code", exitCode)
+}
+
+// Remove the oldest runs beyond the number of runs to keep
+func (daemon *Daemon) rotate() {
+	if daemon.keep <= 0 {
+		return
+	}
+	for len(daemon.runs) > daemon.keep && daemon.runs[0].Status != "running" {
+		oldest := daemon.runs[0]
+		if err := os.RemoveAll(oldest.Dir); err != nil {
+			logger.Warn("Cannot remove old run", "run", oldest.Id, "error", err)
+		}
+		daemon.runs = daemon.runs[1:]
+	}
+}
+
+// Whether a listen address only accepts connections from the host itself
+func loopbackAddress(listen string) bool {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func writeApiError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// Routes of the web page and the HTTP API, all behind the API token when there is one
+func (daemon *Daemon) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/runs", daemon.handleRuns)
+	mux.HandleFunc("/runs/", daemon.handleRuns)
+	mux.HandleFunc("/", daemon.handlePage)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !daemon.authorized(r) {
+			// Browsers ask for it as the password of a basic authentication, the user name is ignored
+			w.Header().Set("WWW-Authenticate", `Basic realm="statexec", charset="UTF-8"`)
+			writeApiError(w, http.StatusUnauthorized, "invalid API token")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// The run outputs and the command output may be sensitive: with an API token, every request must give it, as a bearer
+// token or as the password of a basic authentication
+func (daemon *Daemon) authorized(r *http.Request) bool {
+	if daemon.apiToken == "" {
+		return true
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		_, token, _ = r.BasicAuth()
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(daemon.apiToken)) == 1
+}
+
+// Runs as JSON, most recent first, a run by id, or one of its artifacts. POST launches a run.
+func (daemon *Daemon) handleRuns(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/runs"), "/")
+	if r.Method == http.MethodPost && path == "" {
+		daemon.handleLaunch(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeApiError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	// Runs are updated by the executions, the response is prepared under the lock and written once it is released
+	daemon.mutex.Lock()
+	response := daemon.runsResponse(path)
+	daemon.mutex.Unlock()
+
+	switch {
+	case response.status != http.StatusOK:
+		writeApiError(w, response.status, response.message)
+	case response.artifact != "":
+		http.ServeFile(w, r, response.artifact)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(response.body)
+	}
+}
+
+// Response of GET /runs: JSON of the runs or of a run, path of an artifact, or an error
+type runsResponse struct {
+	status   int
+	message  string
+	body     []byte
+	artifact string
+}
+
+// Response to a path under /runs, called with the lock held
+func (daemon *Daemon) runsResponse(path string) runsResponse {
+	id, artifact, _ := strings.Cut(path, "/")
+	if id == "" {
+		runs := []*DaemonRun{}
+		for i := len(daemon.runs) - 1; i >= 0; i-- {
+			runs = append(runs, daemon.runs[i])
+		}
+		return jsonRunsResponse(runs)
+	}
+	run := daemon.findRun(id)
+	if run == nil {
+		return runsResponse{status: http.StatusNotFound, message: "run not found"}
+	}
+	if artifact == "" {
+		return jsonRunsResponse(run)
+	}
+	// Only listed artifacts are served, the path never leaves the run directory
+	for _, name := range run.Artifacts {
+		if name == artifact {
+			return runsResponse{status: http.StatusOK, artifact: filepath.Join(run.Dir, name)}
+		}
+	}
+	return runsResponse{status: http.StatusNotFound, message: "artifact not found"}
+}
+
+func jsonRunsResponse(value any) runsResponse {
+	body, err := json.Marshal(value)
+	if err != nil {
+		return runsResponse{status: http.StatusInternalServerError, message: err.Error()}
+	}
+	return runsResponse{status: http.StatusOK, body: append(body, '\n')}
+}
+
+// Launch a run from a posted spec, it executes arbitrary commands so it needs the API token, checked for every route
+func (daemon *Daemon) handleLaunch(w http.ResponseWriter, r *http.Request) {
+	if daemon.apiToken == "" {
+		writeApiError(w, http.StatusForbidden, "launching runs is disabled, start the daemon with an API token")
+		return
+	}
+
+	var spec RunSpec
+	decoder := json.NewDecoder(io.LimitReader(r.Body, 1024*1024))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&spec); err != nil {
+		writeApiError(w, http.StatusBadRequest, "invalid run spec: "+err.Error())
+		return
+	}
+	if err := spec.validate(); err != nil {
+		writeApiError(w, http.StatusBadRequest, "invalid run spec: "+err.Error())
+		return
+	}
+
+	run, err := daemon.newRun(spec, "api", time.Now())
+	if errors.Is(err, errRunInProgress) {
+		writeApiError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		writeApiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	go daemon.execute(run)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/runs/"+run.Id)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"id": run.Id, "status": run.Status})
+}
+
+func daemonSubcommand(args []string) {
+	scheduleExpression := os.Getenv(EnvVarPrefix + "SCHEDULE")
+	configFile := os.Getenv(EnvVarPrefix + "CONFIG")
+	outputDir := "runs"
+	if value := os.Getenv(EnvVarPrefix + "OUTPUT_DIR"); value != "" {
+		outputDir = value
+	}
+	keep := 30
+	if value := os.Getenv(EnvVarPrefix + "KEEP"); value != "" {
+		var err error
+		if keep, err = strconv.Atoi(value); err != nil {
+			fatalWith(ExitConfig, "Cannot parse env var, must be an int", "env", EnvVarPrefix+"KEEP", "value", value)
+		}
+	}
+	listen := "127.0.0.1:8090"
+	if value := os.Getenv(EnvVarPrefix + "LISTEN"); value != "" {
+		listen = value
+	}
+	apiToken := os.Getenv(EnvVarPrefix + "API_TOKEN")
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--schedule":
+			scheduleExpression = flagValue(args, i)
+			i++
+		case "--config":
+			configFile = flagValue(args, i)
+			i++
+		case "--output-dir":
+			outputDir = flagValue(args, i)
+			i++
+		case "--keep":
+			var err error
+			if keep, err = strconv.Atoi(flagValue(args, i)); err != nil {
+				fatalWith(ExitConfig, "Cannot parse number of runs to keep", "value", args[i+1])
+			}
+			i++
+		case "--listen":
+			listen = flagValue(args, i)
+			i++
+		case "--api-token":
+			apiToken = flagValue(args, i)
+			i++
+		case "-h", "--help":
+			fmt.Printf("Usage: %s daemon [--schedule <cron> --config <bench.yaml>] [--output-dir <dir>] [--keep <n>] [--listen <addr>] [--api-token <token>]\n", os.Args[0])
+			fmt.Printf("  --schedule <cron>      %sSCHEDULE     Cron expression ('0 2 * * *'), @hourly/@daily/@weekly/@monthly or @every <duration> (no default)\n", EnvVarPrefix)
+			fmt.Printf("  --config <file>        %sCONFIG       Run specification, YAML or JSON: command, instance, labels, args (no default)\n", EnvVarPrefix)
+			fmt.Printf("  --output-dir <dir>     %sOUTPUT_DIR   Directory of the runs outputs, one sub-directory per run (default: runs)\n", EnvVarPrefix)
+			fmt.Printf("  --keep <n>             %sKEEP         Number of runs to keep, 0 keeps all (default: 30)\n", EnvVarPrefix)
+			fmt.Printf("  --listen <addr>        %sLISTEN       Address of the web page and HTTP API listing runs, empty to disable (default: 127.0.0.1:8090)\n", EnvVarPrefix)
+			fmt.Printf("  --api-token <token>    %sAPI_TOKEN    Token required by every request, as bearer or basic auth password, and to launch runs with POST /runs (no default)\n", EnvVarPrefix)
+			os.Exit(0)
+		default:
+			fatalWith(ExitConfig, "Unknown daemon argument", "argument", args[i])
+		}
+	}
+	setupLogger()
+
+	// Without a schedule, the daemon only executes the runs launched through the API
+	scheduled := scheduleExpression != "" || configFile != ""
+	if scheduled && (scheduleExpression == "" || configFile == "") {
+		fatalWith(ExitConfig, "Daemon needs both a schedule (--schedule) and a run configuration (--config)")
+	}
+	if !scheduled && (listen == "" || apiToken == "") {
+		fatalWith(ExitConfig, "Daemon needs a schedule (--schedule) and a run configuration (--config), or an API to launch runs (--listen and --api-token)")
+	}
+
+	daemon := &Daemon{scheduleExpression: scheduleExpression, outputDir: outputDir, keep: keep, apiToken: apiToken}
+	if scheduled {
+		var err error
+		if daemon.schedule, err = parseSchedule(scheduleExpression); err != nil {
+			fatalWith(ExitConfig, "Cannot parse schedule", "schedule", scheduleExpression, "error", err)
+		}
+		if daemon.spec, err = loadRunSpec(configFile); err != nil {
+			fatalWith(ExitConfig, "Cannot load run configuration", "file", configFile, "error", err)
+		}
+	}
+	daemon.loadRuns()
+
+	if listen != "" {
+		if apiToken == "" && !loopbackAddress(listen) {
+			logger.Warn("Daemon listening beyond localhost without an API token, runs and command output are readable by anyone reaching it", "listen", listen)
+		}
+		go func() {
+			if err := http.ListenAndServe(listen, daemon.handler()); err != nil {
+				fatal("Cannot start the daemon HTTP API", "listen", listen, "error", err)
+			}
+		}()
+	}
+
+	if !scheduled {
+		logger.Info("Waiting for runs launched through the API", "listen", listen)
+		select {}
+	}
+	for {
+		next := daemon.schedule.Next(time.Now())
+		if next.IsZero() {
+			fatalWith(ExitConfig, "Schedule never fires", "schedule", scheduleExpression)
+		}
+		logger.Info("Next run scheduled", "at", next.Format(time.RFC3339), "command", strings.Join(daemon.spec.Command, " "))
+		time.Sleep(time.Until(next))
+		go func(scheduledAt time.Time) {
+			run, err := daemon.newRun(daemon.spec, "schedule", scheduledAt)
+			if err != nil {
+				logger.Warn("Scheduled run skipped", "scheduled_at", scheduledAt.Format(time.RFC3339), "error", err)
+				return
+			}
+			daemon.execute(run)
+		}(next)
+	}
+}
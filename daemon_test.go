@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Daemon with one done run and its output, served with the given token
+func testDaemon(t *testing.T, token string) *httptest.Server {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "output.log"), []byte("secret output\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	daemon := &Daemon{apiToken: token, runs: []*DaemonRun{{Id: "20240101-120000", Status: "done", Dir: dir, Artifacts: []string{"output.log"}}}}
+	server := httptest.NewServer(daemon.handler())
+	t.Cleanup(server.Close)
+	return server
+}
+
+func daemonGet(t *testing.T, url string, authorize func(*http.Request)) int {
+	t.Helper()
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authorize != nil {
+		authorize(request)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	return response.StatusCode
+}
+
+// With a token, the page, the runs and their artifacts are not served without it
+func TestDaemonRequiresTokenOnEveryRoute(t *testing.T) {
+	server := testDaemon(t, "secret")
+	bearer := func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") }
+	basic := func(r *http.Request) { r.SetBasicAuth("", "secret") }
+	wrong := func(r *http.Request) { r.Header.Set("Authorization", "Bearer guess") }
+
+	for _, path := range []string{"/", "/runs", "/runs/20240101-120000", "/runs/20240101-120000/output.log"} {
+		if status := daemonGet(t, server.URL+path, nil); status != http.StatusUnauthorized {
+			t.Errorf("GET %s without token = %d, want 401", path, status)
+		}
+		if status := daemonGet(t, server.URL+path, wrong); status != http.StatusUnauthorized {
+			t.Errorf("GET %s with a wrong token = %d, want 401", path, status)
+		}
+		if status := daemonGet(t, server.URL+path, bearer); status != http.StatusOK {
+			t.Errorf("GET %s with the bearer token = %d, want 200", path, status)
+		}
+		if status := daemonGet(t, server.URL+path, basic); status != http.StatusOK {
+			t.Errorf("GET %s with the token as basic password = %d, want 200", path, status)
+		}
+	}
+}
+
+func TestDaemonServesRuns(t *testing.T) {
+	server := testDaemon(t, "")
+	response, err := http.Get(server.URL + "/runs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	var runs []DaemonRun
+	if err := json.NewDecoder(response.Body).Decode(&runs); err != nil || len(runs) != 1 || runs[0].Id != "20240101-120000" {
+		t.Errorf("runs = %+v, %v", runs, err)
+	}
+	for path, want := range map[string]int{
+		"/runs/20240101-120000/output.log": http.StatusOK,
+		"/runs/20240101-120000/run.json":   http.StatusNotFound,
+		"/runs/unknown":                    http.StatusNotFound,
+	} {
+		if status := daemonGet(t, server.URL+path, nil); status != want {
+			t.Errorf("GET %s = %d, want %d", path, status, want)
+		}
+	}
+}
+
+func TestLoopbackAddress(t *testing.T) {
+	for listen, want := range map[string]bool{
+		"127.0.0.1:8090": true,
+		"[::1]:8090":     true,
+		"localhost:8090": true,
+		":8090":          false,
+		"0.0.0.0:8090":   false,
+		"10.0.0.1:8090":  false,
+	} {
+		if got := loopbackAddress(listen); got != want {
+			t.Errorf("loopbackAddress(%s) = %v, want %v", listen, got, want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Row of the runs page, with the summary metrics decoded
+type RunRow struct {
+	*DaemonRun
+	Summary *RunSummary
+}
+
+var daemonPageTemplate = template.Must(template.New("runs").Funcs(template.FuncMap{
+	"join":        strings.Join,
+	"pathEscape":  url.PathEscape,
+	"bytes":       func(value uint64) string { return formatBytes(float64(value)) },
+	"bytesPerSec": func(value float64) string { return formatBytes(value) + "/s" },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+{{if .Running}}<meta http-equiv="refresh" content="10">
+{{end}}<title>statexec agent</title>
+<style>
+body { font-family: sans-serif; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+td.value { text-align: right; font-family: monospace; }
+.running { color: #1f6feb; }
+.done { color: #1a7f37; }
+.failed { color: #cf222e; }
+</style>
+</head>
+<body>
+<h2>statexec agent</h2>
+{{if .Schedule}}<p>Schedule: <code>{{.Schedule}}</code>, command: <code>{{join .Command " "}}</code></p>
+{{end}}{{if not .Rows}}<p>No runs yet.</p>
+{{else}}<table>
+<tr><th>Run</th><th>Trigger</th><th>Status</th><th>Started</th><th>Finished</th><th>Command</th><th>Duration</th><th>Memory used</th><th>Network sent / received</th><th>Disk read / written</th><th>Artifacts</th></tr>
+{{range .Rows}}<tr>
+<td><a href="/runs/{{pathEscape .Id}}">{{.Id}}</a></td>
+<td>{{.Trigger}}</td>
+<td class="{{.Status}}">{{.Status}}{{if .ExitCode}} ({{.ExitCode}}){{end}}</td>
+<td>{{.StartedAt}}</td>
+<td>{{.FinishedAt}}</td>
+<td><code>{{join .Spec.Command " "}}</code></td>
+{{if .Summary}}<td class="value">{{printf "%.1f" .Summary.DurationSeconds}}s</td>
+<td class="value">{{bytes .Summary.MemoryUsedBytes}}</td>
+<td class="value">{{bytesPerSec .Summary.NetworkMeanSentBytesPerSecond}} / {{bytesPerSec .Summary.NetworkMeanReceivedBytesPerSecond}}</td>
+<td class="value">{{bytesPerSec .Summary.DiskMeanReadBytesPerSecond}} / {{bytesPerSec .Summary.DiskMeanWriteBytesPerSecond}}</td>
+{{else}}<td></td><td></td><td></td><td></td>
+{{end}}<td>{{$id := .Id}}{{range .Artifacts}}<a href="/runs/{{pathEscape $id}}/{{pathEscape .}}">{{.}}</a><br>
+{{end}}</td>
+</tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
+
+// Page listing the runs, most recent first, to operate the agent from a browser
+func (daemon *Daemon) handlePage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	daemon.mutex.Lock()
+	page := struct {
+		Schedule string
+		Command  []string
+		Running  bool
+		Rows     []RunRow
+	}{daemon.scheduleExpression, daemon.spec.Command, daemon.running(), nil}
+	for i := len(daemon.runs) - 1; i >= 0; i-- {
+		row := RunRow{DaemonRun: daemon.runs[i]}
+		if len(row.DaemonRun.Summary) > 0 {
+			var summary RunSummary
+			if err := json.Unmarshal(row.DaemonRun.Summary, &summary); err == nil {
+				row.Summary = &summary
+			}
+		}
+		page.Rows = append(page.Rows, row)
+	}
+	var html strings.Builder
+	err := daemonPageTemplate.Execute(&html, page)
+	daemon.mutex.Unlock()
+	if err != nil {
+		logger.Error("Cannot render runs page", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(html.String()))
+}
@@ -0,0 +1,513 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Prefix of the result file comment holding the effective configuration as JSON
+const ConfigCommentPrefix string = "# Config: "
+
+// EffectiveConfig is the fully resolved configuration (flags + env vars) of a run
+type EffectiveConfig struct {
+	Command            []string          `json:"command"`
+	Instance           string            `json:"instance"`
+	Hostname           string            `json:"hostname"`
+	Job                string            `json:"job"`
+	MetricsFile        string            `json:"metrics_file"`
+	MetricsStartTime   string            `json:"metrics_start_time"`
+	DelayBeforeCommand string            `json:"delay_before_command"`
+	DelayAfterCommand  string            `json:"delay_after_command"`
+	StartAt            string            `json:"start_at,omitempty"`
+	Triggers           []string          `json:"triggers,omitempty"`
+	StopWhen           []string          `json:"stop_when,omitempty"`
+	Duration           string            `json:"duration,omitempty"`
+	Retries            int               `json:"retries,omitempty"`
+	RetryBackoff       string            `json:"retry_backoff,omitempty"`
+	Hooks              []string          `json:"hooks,omitempty"`
+	Encrypt            string            `json:"encrypt,omitempty"`
+	Labels             map[string]string `json:"labels"`
+	Collectors         []string          `json:"collectors"`
+	CollectPhases      []string          `json:"collect_phases"`
+	CollectorBackend   string            `json:"collector_backend"`
+	CollectorTimeout   int64             `json:"collector_timeout"`
+	TargetPprof        string            `json:"target_pprof,omitempty"`
+	Jmx                string            `json:"jmx,omitempty"`
+	Ethtool            []string          `json:"ethtool,omitempty"`
+	Smart              bool              `json:"smart"`
+	TextfileDir        string            `json:"textfile_dir,omitempty"`
+	TraceChildren      bool              `json:"trace_children"`
+	Normalize          []string          `json:"normalize,omitempty"`
+	Realtime           bool              `json:"realtime"`
+	FakeCollectors     string            `json:"fake_collectors,omitempty"`
+	Perf               string            `json:"perf,omitempty"`
+	Probes             []string          `json:"probes"`
+	ProbeInterval      int64             `json:"probe_interval"`
+	ProbeBuckets       []float64         `json:"probe_buckets,omitempty"`
+	LegacyNames        bool              `json:"legacy_names"`
+	RedactLabels       []string          `json:"redact_labels,omitempty"`
+	Anonymize          bool              `json:"anonymize"`
+	RequireConfirm     bool              `json:"require_confirm"`
+	DenyPatterns       []string          `json:"deny_patterns,omitempty"`
+	User               string            `json:"user,omitempty"`
+	RunId              string            `json:"run_id"`
+	Sinks              []SinkConfig      `json:"sinks"`
+	Assertions         []string          `json:"assertions,omitempty"`
+	Baseline           string            `json:"baseline,omitempty"`
+	Notify             []string          `json:"notify,omitempty"`
+	NotifyOn           string            `json:"notify_on,omitempty"`
+	EmailTo            []string          `json:"email_to,omitempty"`
+	Sync               SyncConfig        `json:"sync"`
+}
+
+type SinkConfig struct {
+	Type   string `json:"type"`
+	Target string `json:"target"`
+}
+
+type SyncConfig struct {
+	Role           string `json:"role"`
+	Server         string `json:"server,omitempty"`
+	Port           string `json:"port"`
+	Bind           string `json:"bind,omitempty"`
+	Listen         string `json:"listen,omitempty"`
+	WaitForStop    bool   `json:"wait_for_stop"`
+	FollowerConfig string `json:"follower_config,omitempty"`
+	AbortOnFailure bool   `json:"abort_on_failure,omitempty"`
+	Timeout        string `json:"timeout"`
+	Heartbeat      string `json:"heartbeat"`
+	LeaderTime     bool   `json:"leader_time"`
+}
+
+type ValidationCheck struct {
+	Name   string `json:"name"`
+	Target string `json:"target"`
+	Ok     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"`
+}
+
+type DryRunReport struct {
+	Config EffectiveConfig   `json:"config"`
+	Checks []ValidationCheck `json:"checks"`
+}
+
+// Resolve the effective configuration from the parsed flags and env vars
+func effectiveConfig(cmd []string) EffectiveConfig {
+	startTime := "now"
+	if metricsStartTimeOverride != -1 {
+		startTime = strconv.FormatInt(metricsStartTimeOverride, 10)
+	}
+
+	labels := make(map[string]string)
+	for key, value := range extraLabels {
+		labels[key] = value
+	}
+
+	scheduledStart := ""
+	if !startAt.IsZero() {
+		scheduledStart = startAt.Format(time.RFC3339Nano)
+	}
+
+	config := EffectiveConfig{
+		Command:            cmd,
+		Instance:           instance,
+		Hostname:           hostname,
+		Job:                jobName,
+		MetricsFile:        metricsFile,
+		MetricsStartTime:   startTime,
+		DelayBeforeCommand: delayBeforeCommand.String(),
+		DelayAfterCommand:  delayAfterCommand.String(),
+		StartAt:            scheduledStart,
+		Encrypt:            encryptTarget,
+		Triggers:           triggerExpressions(),
+		StopWhen:           stopConditionExpressions(),
+		Duration:           formatCommandDuration(),
+		Retries:            commandRetries,
+		RetryBackoff:       formatRetryBackoff(),
+		Hooks:              hookStrings(),
+		Labels:             labels,
+		Collectors:         enabledCollectorNames(),
+		CollectPhases:      enabledCollectPhases(),
+		CollectorBackend:   collectorBackend,
+		CollectorTimeout:   collectorTimeout,
+		TargetPprof:        targetPprofUrl,
+		Jmx:                jmxTarget,
+		Ethtool:            ethtoolInterfaces,
+		Smart:              smartEnabled,
+		TextfileDir:        textfileDir,
+		TraceChildren:      traceChildren,
+		Normalize:          normalizeStrings(),
+		Realtime:           realtime,
+		Perf:               perfEvents,
+		Probes:             probeTargets,
+		ProbeInterval:      probeInterval,
+		ProbeBuckets:       probeBuckets,
+		LegacyNames:        legacyNames,
+		RedactLabels:       redactedLabelNames(),
+		Anonymize:          anonymize,
+		RequireConfirm:     requireConfirm,
+		DenyPatterns:       denyPatternStrings(),
+		User:               commandUser,
+		RunId:              runId,
+		Sinks: []SinkConfig{
+			{Type: "file", Target: metricsFile},
+		},
+		Sync: SyncConfig{
+			Role:           role,
+			Port:           syncPort,
+			Bind:           syncBind,
+			Listen:         syncListen,
+			WaitForStop:    syncWaitForStop,
+			FollowerConfig: followerConfig,
+			AbortOnFailure: abortOnFailure,
+			Timeout:        syncTimeout.String(),
+			Heartbeat:      syncHeartbeat.String(),
+			LeaderTime:     leaderTime,
+		},
+	}
+	if annotationsFile != "" {
+		config.Sinks = append(config.Sinks, SinkConfig{Type: "annotations", Target: annotationsFile})
+	}
+	if openMetricsFile != "" {
+		config.Sinks = append(config.Sinks, SinkConfig{Type: "openmetrics", Target: openMetricsFile})
+	}
+	if jsonResultFile != "" {
+		config.Sinks = append(config.Sinks, SinkConfig{Type: "json", Target: jsonResultFile})
+	}
+	if splitOutputDir != "" {
+		config.Sinks = append(config.Sinks, SinkConfig{Type: "split", Target: splitOutputDir})
+	}
+	if tsdbDir != "" {
+		config.Sinks = append(config.Sinks, SinkConfig{Type: "tsdb", Target: tsdbDir})
+	}
+	if tsdbBlockDir != "" {
+		config.Sinks = append(config.Sinks, SinkConfig{Type: "tsdb_block", Target: tsdbBlockDir})
+	}
+	if objstoreBucket != nil {
+		config.Sinks = append(config.Sinks, SinkConfig{Type: "objstore", Target: objstoreBucket.String()})
+	}
+	if remoteWriteEndpoint != "" {
+		config.Sinks = append(config.Sinks, SinkConfig{Type: "remote_write", Target: remoteWriteEndpoint})
+	}
+	if lokiUrl != "" {
+		config.Sinks = append(config.Sinks, SinkConfig{Type: "loki", Target: lokiPushUrl(lokiUrl)})
+	}
+	if len(emailTo) > 0 {
+		config.Sinks = append(config.Sinks, SinkConfig{Type: "email", Target: smtpServer})
+	}
+	if junitFile != "" {
+		config.Sinks = append(config.Sinks, SinkConfig{Type: "junit", Target: junitFile})
+	}
+	if ciSummaryTarget != "" {
+		config.Sinks = append(config.Sinks, SinkConfig{Type: "ci_summary", Target: ciSummaryTarget})
+	}
+	if manifestFile != "" {
+		config.Sinks = append(config.Sinks, SinkConfig{Type: "manifest", Target: manifestFile})
+	}
+	if fakeCollectorsSeed >= 0 {
+		config.FakeCollectors = "seed=" + strconv.FormatInt(fakeCollectorsSeed, 10)
+	}
+	if streamListen != "" {
+		config.Sinks = append(config.Sinks, SinkConfig{Type: "stream", Target: streamListen})
+	}
+	for _, assertion := range assertions {
+		config.Assertions = append(config.Assertions, assertion.Expression)
+	}
+	if checkEnabled {
+		config.Baseline = baselineFile
+	}
+	if len(notifyTargets) > 0 || len(emailTo) > 0 {
+		config.Notify = notifyTargets
+		config.NotifyOn = notifyOn
+		config.EmailTo = emailTo
+	}
+	if role == "client" {
+		config.Sync.Server = syncServerAddress()
+	}
+	return config
+}
+
+// Copy of the configuration without secrets, to be written along the results
+func redactConfig(config EffectiveConfig) EffectiveConfig {
+	redacted := config
+	redacted.Sinks = nil
+	for _, sink := range config.Sinks {
+		if sink.Type == "loki" {
+			sink.Target = redactUrl(sink.Target, false)
+		}
+		redacted.Sinks = append(redacted.Sinks, sink)
+	}
+	// The token of a webhook is in its path
+	redacted.Notify = nil
+	for _, target := range config.Notify {
+		kind, webhookUrl := notifyKind(target)
+		redacted.Notify = append(redacted.Notify, kind+":"+redactUrl(webhookUrl, true))
+	}
+	return redacted
+}
+
+// Replace the password, the query and optionally the path of an url
+func redactUrl(raw string, redactPath bool) string {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return "REDACTED"
+	}
+	if _, hasPassword := parsed.User.Password(); hasPassword {
+		parsed.User = url.UserPassword(parsed.User.Username(), "REDACTED")
+	}
+	if parsed.RawQuery != "" {
+		parsed.RawQuery = "REDACTED"
+	}
+	if redactPath && parsed.Path != "" {
+		parsed.Path = "/REDACTED"
+		parsed.RawPath = ""
+	}
+	return parsed.String()
+}
+
+// Resolved configuration as a comment of the result file, so it tells how to re-run the same experiment
+func configComment() string {
+	configJson, err := json.Marshal(redactConfig(effectiveConfig(command)))
+	if err != nil {
+		fatal("Cannot marshal configuration", "error", err)
+	}
+	return ConfigCommentPrefix + anonymizeText(string(configJson)) + "\n"
+}
+
+// Validate the configuration without running anything
+func validateConfig(config EffectiveConfig) []ValidationCheck {
+	var checks []ValidationCheck
+
+	commandCheck := ValidationCheck{Name: "command", Target: strings.Join(config.Command, " "), Ok: true}
+	if len(config.Command) == 0 {
+		commandCheck.Ok = false
+		commandCheck.Error = "no command to execute"
+	} else if pattern, denied := deniedBy(config.Command); denied && !assumeYes {
+		commandCheck.Ok = false
+		commandCheck.Error = "matches deny pattern " + pattern + ", needs --yes"
+	}
+	checks = append(checks, commandCheck)
+
+	for _, sink := range config.Sinks {
+		check := ValidationCheck{Name: "sink_" + sink.Type, Target: sink.Target, Ok: true}
+		if sink.Type == "loki" {
+			check.Name = "sink_loki_reachable"
+			if err := checkUrlReachable(sink.Target); err != nil {
+				check.Ok = false
+				check.Error = err.Error()
+			}
+			checks = append(checks, check)
+			continue
+		}
+		if sink.Type == "email" {
+			check.Name = "sink_smtp_reachable"
+			conn, err := net.DialTimeout("tcp", sink.Target, 2*time.Second)
+			if err != nil {
+				check.Ok = false
+				check.Error = err.Error()
+			} else {
+				conn.Close()
+			}
+			checks = append(checks, check)
+			continue
+		}
+		// The object store config is checked when loaded, nothing is sent before the end of the run
+		if sink.Type == "objstore" {
+			checks = append(checks, check)
+			continue
+		}
+		if err := checkFileWritable(sink.Target); err != nil {
+			check.Ok = false
+			check.Error = err.Error()
+		}
+		checks = append(checks, check)
+	}
+
+	if config.TextfileDir != "" {
+		check := ValidationCheck{Name: "textfile_dir_readable", Target: config.TextfileDir, Ok: true}
+		if err := checkTextfileDir(config.TextfileDir); err != nil {
+			check.Ok = false
+			check.Error = err.Error()
+		}
+		checks = append(checks, check)
+	}
+
+	switch config.Sync.Role {
+	case "client":
+		check := ValidationCheck{Name: "sync_server_reachable", Target: config.Sync.Server, Ok: true}
+		network, address := "tcp", config.Sync.Server
+		if path, ok := syncSocketPath(config.Sync.Server); ok {
+			network, address = "unix", path
+		}
+		conn, err := net.DialTimeout(network, address, 2*time.Second)
+		if err != nil {
+			check.Ok = false
+			check.Error = err.Error()
+		} else {
+			conn.Close()
+		}
+		checks = append(checks, check)
+	case "server":
+		address := syncListenAddress()
+		check := ValidationCheck{Name: "sync_port_available", Target: address, Ok: true}
+		listener, err := listenSync()
+		if err != nil {
+			check.Ok = false
+			check.Error = err.Error()
+		} else {
+			// Closing a unix listener removes its socket file
+			listener.Close()
+		}
+		checks = append(checks, check)
+	}
+
+	return checks
+}
+
+// Check that the host of an url accepts TCP connections
+func checkUrlReachable(target string) error {
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+	host := parsed.Host
+	if parsed.Port() == "" {
+		port := "80"
+		if parsed.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(parsed.Hostname(), port)
+	}
+	conn, err := net.DialTimeout("tcp", host, 2*time.Second)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// Check that a file can be created in the directory of the given path
+func checkFileWritable(path string) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), ".statexec-dry-run-*")
+	if err != nil {
+		return err
+	}
+	tmpFile.Close()
+	return os.Remove(tmpFile.Name())
+}
+
+// Print the effective configuration and validation results, then exit
+func dryRun(cmd []string) {
+	report := DryRunReport{
+		Config: effectiveConfig(cmd),
+	}
+	report.Checks = validateConfig(report.Config)
+	report.Config = redactConfig(report.Config)
+
+	jsonReport, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		fatal("Cannot marshal configuration", "error", err)
+	}
+
+	switch dryRunFormat {
+	case "json":
+		fmt.Println(string(jsonReport))
+	default:
+		var generic interface{}
+		if err := json.Unmarshal(jsonReport, &generic); err != nil {
+			fatal("Cannot convert configuration", "error", err)
+		}
+		fmt.Print(renderYaml(generic, 0))
+	}
+
+	for _, check := range report.Checks {
+		if !check.Ok {
+			os.Exit(ExitConfig)
+		}
+	}
+	os.Exit(0)
+}
+
+// Render a generic JSON document (maps, slices and scalars) as YAML
+func renderYaml(value interface{}, indent int) string {
+	padding := strings.Repeat("  ", indent)
+	result := ""
+
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(typedValue))
+		for key := range typedValue {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			child := typedValue[key]
+			if isYamlScalar(child) {
+				result += fmt.Sprintf("%s%s: %s\n", padding, key, renderYamlScalar(child))
+			} else if isYamlEmpty(child) {
+				result += fmt.Sprintf("%s%s: %s\n", padding, key, renderYamlEmpty(child))
+			} else {
+				result += fmt.Sprintf("%s%s:\n%s", padding, key, renderYaml(child, indent+1))
+			}
+		}
+	case []interface{}:
+		for _, child := range typedValue {
+			if isYamlScalar(child) {
+				result += fmt.Sprintf("%s- %s\n", padding, renderYamlScalar(child))
+			} else {
+				// Inline the first line of the nested block after the dash
+				nested := renderYaml(child, indent+1)
+				result += padding + "- " + strings.TrimPrefix(nested, padding+"  ")
+			}
+		}
+	default:
+		result += padding + renderYamlScalar(value) + "\n"
+	}
+	return result
+}
+
+func isYamlScalar(value interface{}) bool {
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		return false
+	}
+	return true
+}
+
+func isYamlEmpty(value interface{}) bool {
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		return len(typedValue) == 0
+	case []interface{}:
+		return len(typedValue) == 0
+	}
+	return false
+}
+
+func renderYamlEmpty(value interface{}) string {
+	if _, ok := value.([]interface{}); ok {
+		return "[]"
+	}
+	return "{}"
+}
+
+func renderYamlScalar(value interface{}) string {
+	switch typedValue := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return strconv.Quote(typedValue)
+	case float64:
+		return strconv.FormatFloat(typedValue, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(typedValue)
+	}
+	return fmt.Sprint(value)
+}
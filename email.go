@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+var (
+	emailTo      []string
+	emailFrom    string = ""
+	smtpServer   string = "localhost:25"
+	smtpUser     string = ""
+	smtpPassword string = "" // only from env, to keep it out of the process list
+)
+
+func addEmailRecipients(value string) {
+	for _, recipient := range strings.Split(value, ",") {
+		recipient = strings.TrimSpace(recipient)
+		if recipient == "" {
+			continue
+		}
+		if !strings.Contains(recipient, "@") {
+			fatalWith(ExitConfig, "Invalid email recipient", "recipient", recipient)
+		}
+		emailTo = append(emailTo, recipient)
+	}
+}
+
+// Sender address, statexec@<hostname> unless set
+func emailSender() string {
+	if emailFrom != "" {
+		return emailFrom
+	}
+	return "statexec@" + hostname
+}
+
+// Build a MIME message with the HTML report as body
+func emailMessage(from string, to []string, subject string, html string) []byte {
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", from)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: text/html; charset=utf-8\r\n")
+	fmt.Fprintf(&message, "Content-Transfer-Encoding: quoted-printable\r\n")
+	fmt.Fprintf(&message, "\r\n")
+
+	body := quotedprintable.NewWriter(&message)
+	body.Write([]byte(html))
+	body.Close()
+	return message.Bytes()
+}
+
+// Send the HTML report of the run by email, a failing email never fails the run
+func sendEmailReport() {
+	if notifyOn == "failure" && !runFailed() {
+		return
+	}
+
+	report := buildFileReport(metricsFile)
+	message := emailMessage(emailSender(), emailTo, notifyTitle(), renderHtmlReport(report))
+
+	var auth smtp.Auth
+	if smtpUser != "" {
+		host, _, err := net.SplitHostPort(smtpServer)
+		if err != nil {
+			logger.Warn("Cannot parse SMTP server", "server", smtpServer, "error", err)
+			return
+		}
+		auth = smtp.PlainAuth("", smtpUser, smtpPassword, host)
+	}
+
+	// STARTTLS is used when the server offers it
+	if err := smtp.SendMail(smtpServer, auth, emailSender(), emailTo, message); err != nil {
+		logger.Warn("Cannot send email report", "server", smtpServer, "error", err)
+		return
+	}
+	logger.Debug("Email report sent", "server", smtpServer, "to", strings.Join(emailTo, ","))
+}
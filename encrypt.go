@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/blackswifthosting/statexec/promfile"
+)
+
+// Encryption of the result file, "age:<recipient>" or "pgp:<recipient>", done with the age or gpg binary
+var encryptTarget string = ""
+
+const ageHeader = "age-encryption.org/v1"
+
+func parseEncryptTarget(value string) string {
+	scheme, recipient, ok := strings.Cut(value, ":")
+	if !ok || recipient == "" || (scheme != "age" && scheme != "pgp") {
+		fatalWith(ExitConfig, "Encrypt must be age:<recipient> or pgp:<recipient>", "value", value)
+	}
+	return value
+}
+
+// Command encrypting a file for the recipient
+func encryptCommand(target string, input string, output string) []string {
+	scheme, recipient, _ := strings.Cut(target, ":")
+	if scheme == "pgp" {
+		return []string{"gpg", "--batch", "--yes", "--trust-model", "always", "--encrypt", "--recipient", recipient, "--output", output, input}
+	}
+	// An age recipient is a public key, or a file listing public keys
+	recipientFlag := "--recipient"
+	if _, err := os.Stat(recipient); err == nil {
+		recipientFlag = "--recipients-file"
+	}
+	return []string{"age", "--encrypt", recipientFlag, recipient, "--output", output, input}
+}
+
+// Replace the result file by its encrypted version, under the same name
+func encryptResultFile(path string) {
+	encryptedFile, err := os.CreateTemp(filepath.Dir(path), ".statexec-encrypt-*")
+	if err != nil {
+		fatalWith(ExitOutput, "Cannot create encrypted file", "error", err)
+	}
+	encryptedFile.Close()
+	defer os.Remove(encryptedFile.Name())
+
+	args := encryptCommand(encryptTarget, path, encryptedFile.Name())
+	cmd := exec.Command(args[0], args[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		fatalWith(ExitOutput, "Cannot encrypt result file", "file", path, "command", args[0], "error", err, "stderr", strings.TrimSpace(stderr.String()))
+	}
+	if err := os.Rename(encryptedFile.Name(), path); err != nil {
+		fatalWith(ExitOutput, "Cannot replace result file with its encrypted version", "file", path, "error", err)
+	}
+	logger.Debug("Result file encrypted", "file", path, "target", encryptTarget)
+}
+
+// Encryption scheme of a result file content, "" when it is in clear
+func encryptionScheme(content []byte) string {
+	switch {
+	case bytes.HasPrefix(content, []byte(ageHeader)), bytes.HasPrefix(content, []byte("-----BEGIN AGE ENCRYPTED FILE-----")):
+		return "age"
+	case bytes.HasPrefix(content, []byte("-----BEGIN PGP MESSAGE-----")):
+		return "pgp"
+	case len(content) > 0 && content[0]&0x80 != 0: // binary OpenPGP packet, a result file is plain text
+		return "pgp"
+	}
+	return ""
+}
+
+// Whether a result file was written with --encrypt
+func resultFileEncrypted(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	header := make([]byte, len("-----BEGIN AGE ENCRYPTED FILE-----"))
+	n, _ := file.Read(header)
+	return encryptionScheme(header[:n]) != ""
+}
+
+// Decrypt a result file content with the age identity from env or the gpg keyring
+func decryptResultContent(scheme string, path string) ([]byte, error) {
+	var args []string
+	switch scheme {
+	case "age":
+		identity := os.Getenv(EnvVarPrefix + "AGE_IDENTITY")
+		if identity == "" {
+			return nil, fmt.Errorf("file is encrypted with age, set %sAGE_IDENTITY to the identity file", EnvVarPrefix)
+		}
+		args = []string{"age", "--decrypt", "--identity", identity, path}
+	default:
+		args = []string{"gpg", "--batch", "--quiet", "--decrypt", path}
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	content, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt with %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return content, nil
+}
+
+// Read a result file, decrypting it when it was written with --encrypt
+func readResultContent(path string) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if scheme := encryptionScheme(content); scheme != "" {
+		return decryptResultContent(scheme, path)
+	}
+	return content, nil
+}
+
+// Parse a result file, decrypting it when it was written with --encrypt
+func parseResultFile(path string) (*promfile.File, error) {
+	content, err := readResultContent(path)
+	if err != nil {
+		return nil, err
+	}
+	return promfile.Parse(bytes.NewReader(content))
+}
@@ -0,0 +1,83 @@
+package main
+
+// Transition of the command lifecycle, timestamped when it happened instead of on the 1s grid of the samples
+type CommandEvent struct {
+	name      string // start, end
+	timestamp int64
+}
+
+func recordCommandEvent(name string, timestamp int64) {
+	event := CommandEvent{name: name, timestamp: timestamp}
+	store.AddEvent(event)
+	streamCommandEvent(event)
+}
+
+// Timestamp of a lifecycle event, if it happened, of the last attempt if the command was retried
+func (snapshot MetricsSnapshot) event(name string) (int64, bool) {
+	for i := len(snapshot.events) - 1; i >= 0; i-- {
+		if snapshot.events[i].name == name {
+			return snapshot.events[i].timestamp, true
+		}
+	}
+	return 0, false
+}
+
+// Lifecycle events of the command, a series of their own apart from the samples
+func commandEventsSection() ResultSection {
+	section := ResultSection{title: "Command lifecycle"}
+	for _, event := range store.Metrics().events {
+		section.add("command_event", 1, 0, event.timestamp, "event", event.name)
+	}
+	return section
+}
+
+// Window of a phase of the run, annotated as a region
+type phaseRegion struct {
+	phase string
+	text  string
+	from  int64
+	to    int64
+}
+
+// Annotate the phases of the run as regions so Grafana shades them: warmup before the command, the command, from its first
+// attempt to its last one, and cooldown until the end of the collection
+func annotatePhases(end int64) {
+	events := store.Metrics().events
+	commandStart, started := int64(0), false
+	for _, event := range events {
+		if event.name == "start" {
+			commandStart, started = event.timestamp, true
+			break
+		}
+	}
+	commandEnd, ended := MetricsSnapshot{events: events}.event("end")
+	if !started || !ended {
+		return
+	}
+
+	regions := []phaseRegion{
+		{phase: "pre", text: "Warmup, before the command", from: metricsStartTime, to: commandStart},
+		{phase: "run", text: "Command running", from: commandStart, to: commandEnd},
+		{phase: "post", text: "Cooldown, after the command", from: commandEnd, to: end},
+	}
+	for _, region := range regions {
+		if region.to <= region.from {
+			continue
+		}
+		store.AddAnnotation(GrafanaAnnotation{
+			Time:    region.from,
+			TimeEnd: region.to,
+			Text:    region.text,
+			Tags: []string{
+				"statexec",
+				"phase",
+				"phase=" + region.phase,
+				"instance=" + instance,
+				"job=" + jobName,
+				"role=" + role,
+				"hostname=" + hostname,
+				"run_id=" + runId,
+			},
+		})
+	}
+}
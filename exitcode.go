@@ -0,0 +1,13 @@
+package main
+
+// Exit codes of statexec by kind of failure, documented in the README for the automation around it
+const (
+	ExitOk        = 0 // run done, whatever the exit code of the command which is recorded in the results
+	ExitError     = 1 // other errors, and failures of subcommands reading their inputs or reaching a service
+	ExitConfig    = 2 // invalid flags, environment variables or configuration files, failed dry run checks
+	ExitOutput    = 3 // results cannot be written: metrics file, summary, reports, manifest, encryption
+	ExitSync      = 4 // sync failures: server unreachable, peer lost, session aborted
+	ExitAssertion = 5 // the run failed its assertions or regressed compared to the baseline
+	ExitCommand   = 6 // the command cannot be started, or interrupted when statexec is
+	ExitCollector = 7 // the counters cannot be read: no collector backend available, a collector failing for the whole run
+)
@@ -53,8 +53,8 @@ function importPromFile {
                 || { echo "Cannot create grafana annotations from $file" ;  exit 1; }
         done
 
-        startTime=$(grep "^statexec_metric_collect_duration_ms" $file | sed -e 's/.*\} .* //' | head -n 1)
-        endTime=$(grep "^statexec_metric_collect_duration_ms" $file | sed -e 's/.*\} .* //' | tail -n 1)
+        startTime=$(grep -E "^statexec_metric_collect_duration_(seconds|ms)" $file | sed -e 's/.*\} .* //' | head -n 1)
+        endTime=$(grep -E "^statexec_metric_collect_duration_(seconds|ms)" $file | sed -e 's/.*\} .* //' | tail -n 1)
         echo "View stats for $file : ${DASHBOARDURL}?orgId=1&from=${startTime}&to=${endTime}&var-instance=${instance}&var-role=${role}"
         echo -e "${startTime}\n${endTime}" >> $tmpfile
     done
@@ -68,12 +68,42 @@ function importPromFile {
     open "${DASHBOARDURL}?orgId=1&from=${minStartTime}&to=${maxEndTime}"
 }
 
+# Serve a local TSDB written by statexec run --tsdb with Prometheus, then import its annotations
+function exploreTsdb {
+    tsdb=$1
+    from=$2
+    to=$3
+    [ "$tsdb" = "" ] && { echo "usage: $0 explore-tsdb <tsdb dir> [from] [to]" ; exit 1; }
+    tsdb=$(cd "$tsdb" && pwd)
+
+    stop
+    (cd $CURDIR && TSDB_DIR=$tsdb DATASOURCE_URL=http://prometheus:9090 $DOCKERCOMPOSE_CMD --profile tsdb up -d)
+
+    echo "Waiting for Prometheus to start"
+    while ! curl -s http://localhost:9090/-/ready -o /dev/null; do
+        echo -n "."
+        sleep 1
+    done
+    echo
+    waitForGrafana
+
+    # Grafana annotations, one JSON line per annotation
+    find $tsdb/annotations -type f -name "*.jsonl" 2>/dev/null | while IFS= read -r file; do
+        while IFS= read -r annotation; do
+            curl -so /dev/null -X POST -H "Content-Type: application/json" -d "${annotation}" http://localhost:3000/api/annotations \
+                || { echo "Cannot create grafana annotations from $file" ;  exit 1; }
+        done < $file
+    done
+
+    open "${DASHBOARDURL}?orgId=1&from=${from}&to=${to}"
+}
+
 function start {
     (cd $CURDIR && $DOCKERCOMPOSE_CMD up -d)
 }
 
 function stop {
-    (cd $CURDIR && $DOCKERCOMPOSE_CMD down)
+    (cd $CURDIR && $DOCKERCOMPOSE_CMD --profile tsdb down)
 }
 
 # See https://docs.victoriametrics.com/Single-server-VictoriaMetrics.html#how-to-import-data-in-prometheus-exposition-format
@@ -97,6 +127,9 @@ case $1 in
     import)
         importPromFile ${@:2}
         ;;
+    explore-tsdb)
+        exploreTsdb ${@:2}
+        ;;
     open)
         open "${DASHBOARDURL}"
         ;;
@@ -0,0 +1,103 @@
+package main
+
+import "fmt"
+
+// Destination of the result of a run. Exporters receive the typed result once the command is done, a new metric only
+// has to be collected or added to a section to reach all of them.
+type Exporter interface {
+	Name() string
+	Export(result RunResult) error
+}
+
+// Exporters of the run, the result file first as the other outputs refer to it
+func resultExporters() []Exporter {
+	exporters := []Exporter{promTextExporter{path: metricsFile}}
+	if openMetricsFile != "" {
+		exporters = append(exporters, openMetricsExporter{path: openMetricsFile})
+	}
+	if jsonResultFile != "" {
+		exporters = append(exporters, jsonExporter{path: jsonResultFile})
+	}
+	if annotationsFile != "" {
+		exporters = append(exporters, annotationsExporter{path: annotationsFile})
+	}
+	if splitOutputDir != "" {
+		exporters = append(exporters, splitExporter{dir: splitOutputDir})
+	}
+	if tsdbDir != "" {
+		exporters = append(exporters, tsdbExporter{dir: tsdbDir})
+	}
+	if tsdbBlockDir != "" || objstoreBucket != nil {
+		exporters = append(exporters, tsdbBlockExporter{dir: tsdbBlockDir, bucket: objstoreBucket})
+	}
+	if remoteWriteEndpoint != "" {
+		exporters = append(exporters, remoteWriteExporter{url: remoteWriteEndpoint})
+	}
+	return exporters
+}
+
+// Gather the result of the run and hand it to every exporter, the first failing one exits with ExitOutput
+func exportRunResult() {
+	result := collectRunResult()
+	for _, exporter := range resultExporters() {
+		if err := exporter.Export(result); err != nil {
+			fatalWith(ExitOutput, "Cannot export result", "exporter", exporter.Name(), "error", err)
+		}
+		logger.Debug("Result exported", "exporter", exporter.Name())
+	}
+}
+
+// The result file in the Prometheus text format, with the metadata and annotations as comments, as the subcommands read it
+type promTextExporter struct {
+	path string
+}
+
+func (exporter promTextExporter) Name() string {
+	return "file"
+}
+
+func (exporter promTextExporter) Export(result RunResult) error {
+	// Buffered, the file is written in many small chunks, and renamed to its path once complete
+	resultFile, err := createAtomicFile(exporter.path)
+	if err != nil {
+		return fmt.Errorf("cannot open metrics file %s: %w", exporter.path, err)
+	}
+	defer resultFile.Discard()
+	// Errors of the buffered writer are sticky, the first one is returned by Commit
+	write := func(text string) {
+		_, _ = resultFile.WriteString(text)
+	}
+
+	write(resultHeader())
+	write(renderAnnotations(result.annotations))
+	for _, section := range result.before {
+		write(renderResultSection(section) + "\n")
+	}
+
+	// Series are written sample by sample, a single buffer is reused for every sample
+	metricsBuffer := make([]byte, 0, 64*1024)
+	cursors := make([]int, len(result.metrics.series))
+	for _, sample := range result.metrics.samples {
+		metricsBuffer = metricsBuffer[:0]
+		for i, series := range result.metrics.series {
+			if cursors[i] < len(series.timestamps) && series.timestamps[cursors[i]] == sample.timestamp {
+				metricsBuffer = series.appendPoint(metricsBuffer, cursors[i])
+				cursors[i]++
+			}
+		}
+		_, _ = resultFile.Write(metricsBuffer)
+	}
+
+	for _, section := range result.after {
+		if section.title != "" {
+			write("\n")
+		}
+		write(renderResultSection(section))
+	}
+
+	if err := resultFile.Commit(); err != nil {
+		return fmt.Errorf("cannot write metrics file %s: %w", exporter.path, err)
+	}
+	logger.Debug("Metrics written", "file", exporter.path, "samples", len(result.metrics.samples))
+	return nil
+}
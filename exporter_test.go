@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/blackswifthosting/statexec/promfile"
+	"github.com/blackswifthosting/statexec/tsdb"
+)
+
+// Result of the synthetic run of the golden files
+func goldenRunResult(t *testing.T) RunResult {
+	t.Helper()
+	generateGoldenRun(t)
+	return collectRunResult()
+}
+
+// Points of the result in every exporter, series of the samples and of the sections
+func resultPoints(result RunResult) int {
+	points := 0
+	for _, series := range result.series() {
+		points += len(series.timestamps)
+	}
+	return points
+}
+
+func TestOpenMetricsExporter(t *testing.T) {
+	result := goldenRunResult(t)
+	path := filepath.Join(t.TempDir(), "result.om")
+	if err := (openMetricsExporter{path: path}).Export(result); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(content), "\n# EOF\n") {
+		t.Error("OpenMetrics file does not end with # EOF")
+	}
+
+	// Families are declared once, their points contiguous and belonging to them
+	declared := make(map[string]bool)
+	family := ""
+	points := 0
+	scanner := bufio.NewScanner(strings.NewReader(string(content)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "# EOF":
+		case strings.HasPrefix(line, "# TYPE "):
+			family = strings.Fields(line)[2]
+			if declared[family] {
+				t.Errorf("family %s declared twice", family)
+			}
+			declared[family] = true
+		case strings.HasPrefix(line, "# HELP "):
+			if name := strings.Fields(line)[2]; name != family {
+				t.Errorf("help of %s under family %s", name, family)
+			}
+		case strings.HasPrefix(line, "#"):
+			t.Errorf("comment not allowed in OpenMetrics: %s", line)
+		default:
+			name := line[:strings.IndexAny(line, "{ ")]
+			if name != family && strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(name, "_total"), "_bucket"), "_count"), "_sum") != family {
+				t.Errorf("point of %s under family %s", name, family)
+			}
+			points++
+		}
+	}
+	if want := resultPoints(result); points != want {
+		t.Errorf("%d points written, want %d", points, want)
+	}
+}
+
+func TestJsonExporter(t *testing.T) {
+	result := goldenRunResult(t)
+	path := filepath.Join(t.TempDir(), "result.json")
+	if err := (jsonExporter{path: path}).Export(result); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document JsonResult
+	if err := json.Unmarshal(content, &document); err != nil {
+		t.Fatal(err)
+	}
+	if document.RunId != runId {
+		t.Errorf("run id = %s, want %s", document.RunId, runId)
+	}
+	if len(document.Annotations) != len(result.annotations) {
+		t.Errorf("%d annotations, want %d", len(document.Annotations), len(result.annotations))
+	}
+	points := 0
+	for _, series := range document.Series {
+		if !strings.HasPrefix(series.Name, MetricPrefix) {
+			t.Errorf("series %s without the metric prefix", series.Name)
+		}
+		points += len(series.Points)
+	}
+	if want := resultPoints(result); points != want {
+		t.Errorf("%d points written, want %d", points, want)
+	}
+}
+
+func TestSplitExporter(t *testing.T) {
+	result := goldenRunResult(t)
+	exporter := splitExporter{dir: filepath.Join(t.TempDir(), "split")}
+	if err := exporter.Export(result); err != nil {
+		t.Fatal(err)
+	}
+	for _, group := range splitGroupNames() {
+		content, err := os.ReadFile(exporter.path(group))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			name, ok := renderedMetricName(line)
+			if !ok || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if name != "command_status" && splitGroup(name) != group {
+				t.Errorf("%s written to %s.prom, want %s.prom", name, group, splitGroup(name))
+			}
+		}
+		// The status delimits the run in every file
+		if !strings.Contains(string(content), MetricPrefix+"command_status{") {
+			t.Errorf("%s.prom without command_status", group)
+		}
+	}
+}
+
+func TestTsdbExporter(t *testing.T) {
+	result := goldenRunResult(t)
+	dir := t.TempDir()
+	if err := (tsdbExporter{dir: dir}).Export(result); err != nil {
+		t.Fatal(err)
+	}
+	metas, err := tsdb.ReadBlockMetas(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metas) != 1 || metas[0].Stats.NumSamples != uint64(resultPoints(result)) {
+		t.Fatalf("blocks = %+v, want one block with the %d points of the run", metas, resultPoints(result))
+	}
+	annotations, err := os.ReadFile(filepath.Join(dir, tsdbAnnotationsDir, metas[0].Ulid+".jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(annotations), "\n"); lines != len(result.annotations) {
+		t.Errorf("%d annotations next to the block, want %d", lines, len(result.annotations))
+	}
+}
+
+func TestRemoteWriteExporter(t *testing.T) {
+	result := goldenRunResult(t)
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	if err := (remoteWriteExporter{url: server.URL}).Export(result); err != nil {
+		t.Fatal(err)
+	}
+	series := len(result.series())
+	if want := (series + remoteWriteBatchSeries - 1) / remoteWriteBatchSeries; int(requests.Load()) != want {
+		t.Errorf("%d requests for %d series, want %d", requests.Load(), series, want)
+	}
+}
+
+// The result file of a previous run is replaced by the rename, never removed beforehand
+func TestPromTextExporterReplacesPreviousResult(t *testing.T) {
+	result := goldenRunResult(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "result.prom")
+	if err := os.WriteFile(path, []byte("stale content\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := (promTextExporter{path: path}).Export(result); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "# Collector: ") || strings.Contains(string(content), "stale content") {
+		t.Error("result file not replaced by the new result")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("%d files in the output directory, want the result file only", len(entries))
+	}
+}
+
+// Label values given by the user are escaped, the result file parses back to them
+func TestResultFileEscapesLabelValues(t *testing.T) {
+	peer := "say \"hi\" to C:\\data\nnext line"
+	path := filepath.Join(t.TempDir(), "node-1.prom")
+	generateSampleRun(sampleNode{name: "node-1", role: "server", peer: peer, seed: 42, startMs: goldenEnd.UnixMilli()}, "escape-session", 2*time.Second, path)
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report := validateResultContent(path, content); report.Errors != 0 {
+		t.Fatalf("result file has %d errors: %+v", report.Errors, report.Issues)
+	}
+	file, err := promfile.ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := file.Label("sync_peer"); got != peer {
+		t.Errorf("sync_peer label = %q, want %q", got, peer)
+	}
+}
+
+// A failing exporter returns its error for exportRunResult to exit with, rather than exiting itself
+func TestExportersReturnErrors(t *testing.T) {
+	result := goldenRunResult(t)
+	// A regular file where the exporters expect a directory
+	notDir := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(notDir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "rejected", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	for _, exporter := range []Exporter{
+		promTextExporter{path: filepath.Join(notDir, "result.prom")},
+		openMetricsExporter{path: filepath.Join(notDir, "result.om")},
+		jsonExporter{path: filepath.Join(notDir, "result.json")},
+		annotationsExporter{path: filepath.Join(notDir, "annotations.json")},
+		splitExporter{dir: filepath.Join(notDir, "split")},
+		tsdbExporter{dir: filepath.Join(notDir, "tsdb")},
+		tsdbBlockExporter{dir: filepath.Join(notDir, "blocks")},
+		remoteWriteExporter{url: server.URL},
+	} {
+		if err := exporter.Export(result); err == nil {
+			t.Errorf("%s exporter succeeded, want an error", exporter.Name())
+		}
+	}
+	if len(pendingFiles) != 0 {
+		t.Errorf("%d temporary files left behind", len(pendingFiles))
+	}
+}
+
+// Fill the store with a run of the synthetic collectors, one sample per second
+func fillBenchmarkStore(samples int) {
+	setupBenchmarkCollectors()
+	store = newTestStore()
+	store.SetCommandStatus(CommandStatusRunning)
+	for i := 0; i < samples; i++ {
+		store.AddMetric(benchmarkSample(int64(i) * 1000))
+	}
+	store.AddMetric(InstantMetric{cmdStatus: CommandStatusDone, timestamp: int64(samples) * 1000})
+}
+
+// Result file of a 2-hour run
+func BenchmarkPromTextExport(b *testing.B) {
+	fillBenchmarkStore(2 * 3600)
+	exporter := promTextExporter{path: filepath.Join(b.TempDir(), "result.prom")}
+	result := collectRunResult()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := exporter.Export(result); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Gathering the result of a 2-hour run, the snapshot and summary every exporter starts from
+func BenchmarkCollectRunResult(b *testing.B) {
+	fillBenchmarkStore(2 * 3600)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		collectRunResult()
+	}
+}
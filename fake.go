@@ -0,0 +1,175 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/blackswifthosting/statexec/collectors"
+)
+
+var fakeCollectorsSeed int64 = -1 // seed of the synthetic metrics replacing the collectors, disabled if negative (--fake-collectors)
+
+// Load of the synthetic host by phase, busier while the command runs
+var fakeLoad = map[int]float64{
+	CommandStatusPending: 0.05,
+	CommandStatusRunning: 0.6,
+	CommandStatusDone:    0.05,
+}
+
+const (
+	fakeCpus        = 4
+	fakeMemoryBytes = 16 * 1024 * 1024 * 1024
+)
+
+// Counters of a synthetic collector, with its own random source so concurrent collectors stay deterministic
+type fakeCollector struct {
+	mutex    sync.Mutex
+	rand     *rand.Rand
+	counters map[string]float64
+}
+
+var (
+	fakeCollectorsMutex sync.Mutex
+	fakeCollectorStates = make(map[string]*fakeCollector)
+)
+
+// Parse the seed of --fake-collectors, seed=42 or 42
+func parseFakeCollectors(value string) int64 {
+	seed, err := strconv.ParseInt(strings.TrimPrefix(value, "seed="), 10, 64)
+	if err != nil || seed < 0 {
+		fatalWith(ExitConfig, "Cannot parse fake collectors seed, must be seed=<positive integer>", "value", value)
+	}
+	return seed
+}
+
+func fakeCollectorState(name string) *fakeCollector {
+	fakeCollectorsMutex.Lock()
+	defer fakeCollectorsMutex.Unlock()
+	state, ok := fakeCollectorStates[name]
+	if !ok {
+		hash := fnv.New64a()
+		hash.Write([]byte(name))
+		state = &fakeCollector{
+			rand:     rand.New(rand.NewSource(fakeCollectorsSeed ^ int64(hash.Sum64()))),
+			counters: make(map[string]float64),
+		}
+		fakeCollectorStates[name] = state
+	}
+	return state
+}
+
+// Add a random increment around a mean to a counter and return it
+func (f *fakeCollector) increase(counter string, mean float64) float64 {
+	f.counters[counter] += mean * (0.5 + f.rand.Float64())
+	return f.counters[counter]
+}
+
+// Value around a mean, +/- 10%
+func (f *fakeCollector) around(mean float64) float64 {
+	return mean * (0.9 + 0.2*f.rand.Float64())
+}
+
+// Synthetic cpu, memory, network and disk collectors, deterministic for a seed, to test outputs or build dashboards where collectors are limited
+func fakeSampleCollectors() []sampleCollector {
+	all := []sampleCollector{
+		{"cpu", func() ([]collectors.Sample, error) {
+			return collectors.CpuSamples(fakeCpuMetrics(fakeCollectorState("cpu"), fakeLoad[store.CommandStatus()])), nil
+		}},
+		{"memory", func() ([]collectors.Sample, error) {
+			return collectors.MemorySamples(fakeMemoryMetrics(fakeCollectorState("memory"), fakeLoad[store.CommandStatus()])), nil
+		}},
+		{"network", func() ([]collectors.Sample, error) {
+			return collectors.NetworkSamples(fakeNetworkMetrics(fakeCollectorState("network"), fakeLoad[store.CommandStatus()])), nil
+		}},
+		{"disk", func() ([]collectors.Sample, error) {
+			return collectors.DiskSamples(fakeDiskMetrics(fakeCollectorState("disk"), fakeLoad[store.CommandStatus()])), nil
+		}},
+	}
+
+	var enabled []sampleCollector
+	for _, collector := range all {
+		if enabledCollectors[collector.name] {
+			enabled = append(enabled, collector)
+		}
+	}
+	return enabled
+}
+
+func fakeCpuMetrics(f *fakeCollector, load float64) []collectors.CpuMetrics {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var cpuMetrics []collectors.CpuMetrics
+	for i := 0; i < fakeCpus; i++ {
+		cpu := "cpu" + strconv.Itoa(i)
+		busy := min(f.around(load), 1)
+		cpuMetrics = append(cpuMetrics, collectors.CpuMetrics{Cpu: cpu, CpuTimePerMode: map[string]float64{
+			"user":      f.increase(cpu+"user", busy*0.7),
+			"system":    f.increase(cpu+"system", busy*0.25),
+			"softirq":   f.increase(cpu+"softirq", busy*0.05),
+			"iowait":    f.increase(cpu+"iowait", 0.01),
+			"idle":      f.increase(cpu+"idle", 1-busy),
+			"nice":      0,
+			"irq":       0,
+			"steal":     0,
+			"guest":     0,
+			"guestNice": 0,
+		}})
+	}
+	return cpuMetrics
+}
+
+func fakeMemoryMetrics(f *fakeCollector, load float64) collectors.MemoryMetrics {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	used := uint64(f.around(fakeMemoryBytes * (0.2 + load/2)))
+	cached := uint64(fakeMemoryBytes / 5)
+	buffers := uint64(256 * 1024 * 1024)
+	return collectors.MemoryMetrics{
+		Total:             fakeMemoryBytes,
+		Available:         fakeMemoryBytes - used,
+		Used:              used,
+		Free:              fakeMemoryBytes - used - cached - buffers,
+		Buffers:           buffers,
+		Cached:            cached,
+		UsedPercent:       100 * float64(used) / fakeMemoryBytes,
+		HugePageSizeBytes: 2 * 1024 * 1024,
+		SwapTotal:         2 * 1024 * 1024 * 1024,
+	}
+}
+
+func fakeNetworkMetrics(f *fakeCollector, load float64) []collectors.NetworkMetrics {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return []collectors.NetworkMetrics{
+		{Interface: "eth0", SentTotalBytes: uint64(f.increase("eth0sent", load*100e6)), RecvTotalBytes: uint64(f.increase("eth0recv", load*50e6))},
+		{Interface: "lo", SentTotalBytes: uint64(f.increase("lo", 10e3)), RecvTotalBytes: uint64(f.counters["lo"])},
+	}
+}
+
+func fakeDiskMetrics(f *fakeCollector, load float64) []collectors.DiskMetrics {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return []collectors.DiskMetrics{
+		{Device: "vda", ReadBytesTotal: uint64(f.increase("vdaread", load*20e6)), WriteBytesTotal: uint64(f.increase("vdawrite", load*80e6))},
+	}
+}
+
+// Inventory of the synthetic host
+func addFakeInventory(timestamp int64) {
+	addStaticMetric("host_info", map[string]string{
+		"os":               "linux",
+		"platform":         "fake",
+		"platform_version": "1",
+		"kernel":           "6.0.0-fake",
+		"arch":             "x86_64",
+		"cpus":             strconv.Itoa(fakeCpus),
+		"mem_bytes":        strconv.Itoa(fakeMemoryBytes),
+	}, 1, timestamp)
+}
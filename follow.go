@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Run spec of the followers with per-node overrides, e.g. node a runs iperf3 -s while nodes b to d run iperf3 -c {node:a}
+type FollowerScenario struct {
+	RunSpec
+	Nodes map[string]RunSpec `json:"nodes,omitempty"`
+}
+
+var (
+	followerConfig   string = "" // run spec served to followers by the leader (--follower-config)
+	followerScenario *FollowerScenario
+	syncNode         string = "" // name of the node of a follower in the scenario (--node)
+)
+
+// Addresses of the nodes which fetched their spec, replacing {node:<name>} in the commands of the others
+var (
+	followerAddresses = map[string]string{}
+	followerMutex     sync.Mutex
+)
+
+var followFlags = []string{"--sync-port", "-sp", "--output-dir", "--node"}
+
+// How long a follower waits for the nodes its command refers to
+const followWaitTimeout = 2 * time.Minute
+
+var nodePlaceholderPattern = regexp.MustCompile(`\{node:([^}]+)\}`)
+
+// Load the run spec of the followers, only the leader (server) distributes one
+func loadFollowerSpec() {
+	if followerConfig == "" {
+		return
+	}
+	if role != "server" {
+		fatalWith(ExitConfig, "Follower configuration is distributed by the server (--server)")
+	}
+	var scenario FollowerScenario
+	if err := loadSpecFile(followerConfig, &scenario); err != nil {
+		fatalWith(ExitConfig, "Cannot load follower configuration", "file", followerConfig, "error", err)
+	}
+	if len(scenario.Command) == 0 && len(scenario.Nodes) == 0 {
+		fatalWith(ExitConfig, "Follower configuration has no command, neither a default one nor per node", "file", followerConfig)
+	}
+	if len(scenario.Command) > 0 {
+		if err := scenario.RunSpec.validate(); err != nil {
+			fatalWith(ExitConfig, "Invalid follower configuration", "file", followerConfig, "error", err)
+		}
+	}
+	for node := range scenario.Nodes {
+		spec, _ := scenario.nodeSpec(node)
+		if err := spec.validate(); err != nil {
+			fatalWith(ExitConfig, "Invalid follower configuration", "file", followerConfig, "node", node, "error", err)
+		}
+	}
+	// Followers must stop the same way the leader waits for them
+	if !syncWaitForStop {
+		scenario.Args = append(scenario.Args, "--sync-start-only")
+	}
+	followerScenario = &scenario
+}
+
+// Spec of a node: the default spec, overridden by the fields set for the node, labels are merged and args appended
+func (scenario FollowerScenario) nodeSpec(node string) (RunSpec, bool) {
+	spec := scenario.RunSpec
+	override, ok := scenario.Nodes[node]
+	if !ok {
+		return spec, len(spec.Command) > 0
+	}
+	if len(override.Command) > 0 {
+		spec.Command = override.Command
+	}
+	if override.Instance != "" {
+		spec.Instance = override.Instance
+	}
+	spec.Labels = map[string]string{}
+	for key, value := range scenario.Labels {
+		spec.Labels[key] = value
+	}
+	for key, value := range override.Labels {
+		spec.Labels[key] = value
+	}
+	spec.Args = append(append([]string{}, scenario.Args...), override.Args...)
+	if override.Duration != "" {
+		spec.Duration = override.Duration
+	}
+	if len(override.Outputs) > 0 {
+		spec.Outputs = override.Outputs
+	}
+	return spec, true
+}
+
+// Replace {node:<name>} by the address of the node, returns the first node not known yet
+func expandNodeAddresses(command []string) ([]string, string) {
+	missing := ""
+	expanded := make([]string, len(command))
+	for i, word := range command {
+		expanded[i] = nodePlaceholderPattern.ReplaceAllStringFunc(word, func(placeholder string) string {
+			node := nodePlaceholderPattern.FindStringSubmatch(placeholder)[1]
+			address, ok := followerAddresses[node]
+			if !ok && missing == "" {
+				missing = node
+			}
+			return address
+		})
+	}
+	return expanded, missing
+}
+
+// Serve the run spec of a follower on the sync server, its node is given by the node query parameter
+func handleFollowerSpec(w http.ResponseWriter, r *http.Request) {
+	if followerScenario == nil {
+		writeApiError(w, http.StatusNotFound, "no follower configuration, start the leader with --follower-config")
+		return
+	}
+	node := r.URL.Query().Get("node")
+	spec, ok := followerScenario.nodeSpec(node)
+	if !ok {
+		writeApiError(w, http.StatusNotFound, fmt.Sprintf("no command for node %q", node))
+		return
+	}
+
+	followerMutex.Lock()
+	if node != "" {
+		followerAddresses[node] = syncPeerAddress(r)
+	}
+	command, missing := expandNodeAddresses(spec.Command)
+	followerMutex.Unlock()
+	if missing != "" {
+		w.Header().Set("Retry-After", "1")
+		writeApiError(w, http.StatusServiceUnavailable, fmt.Sprintf("waiting for node %q", missing))
+		return
+	}
+	spec.Command = command
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(spec)
+}
+
+// Fetch the run spec of a node, waiting for the nodes its command refers to
+func fetchFollowerSpec(syncServerUrl string, node string) (RunSpec, error) {
+	var spec RunSpec
+	specUrl := syncServerUrl + "/spec?node=" + url.QueryEscape(node)
+	deadline := time.Now().Add(followWaitTimeout)
+	client := syncClient(30 * time.Second)
+	for {
+		resp, err := client.Get(specUrl)
+		if err != nil {
+			return spec, err
+		}
+		if resp.StatusCode == http.StatusServiceUnavailable && time.Now().Before(deadline) {
+			var apiError map[string]string
+			json.NewDecoder(resp.Body).Decode(&apiError)
+			resp.Body.Close()
+			logger.Info("Waiting for the leader to send the run spec", "reason", apiError["error"])
+			time.Sleep(time.Second)
+			continue
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			var apiError map[string]string
+			json.NewDecoder(resp.Body).Decode(&apiError)
+			return spec, fmt.Errorf("unexpected status %s: %s", resp.Status, apiError["error"])
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&spec); err != nil {
+			return spec, err
+		}
+		return spec, spec.validate()
+	}
+}
+
+// Replace the placeholders of the command of a follower: {hostname} and {leader}
+func expandFollowerCommand(command []string, leader string) []string {
+	replacer := strings.NewReplacer("{hostname}", resolveHostname(), "{leader}", leader)
+	expanded := make([]string, len(command))
+	for i, word := range command {
+		expanded[i] = replacer.Replace(word)
+	}
+	return expanded
+}
+
+func followSubcommand(args []string) {
+	leader := ""
+	port := syncPort
+	if value := os.Getenv(EnvVarPrefix + "SYNC_PORT"); value != "" {
+		port = value
+	}
+	outputDir := "."
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-sp", "--sync-port":
+			port = flagValue(args, i)
+			i++
+		case "--output-dir":
+			outputDir = flagValue(args, i)
+			i++
+		case "--node":
+			syncNode = flagValue(args, i)
+			i++
+		case "-h", "--help":
+			fmt.Printf("Usage: %s follow <leader>[:port] [--node <name>] [--sync-port <port>] [--output-dir <dir>]\n", os.Args[0])
+			fmt.Println("  Fetch the run spec from a leader started with --server --follower-config, then run it synchronized with the leader")
+			fmt.Printf("  --sync-port, -sp <port>   %sSYNC_PORT   Sync port of the leader (default: 8080)\n", EnvVarPrefix)
+			fmt.Println("  --node <name>             Node of this follower in the scenario of the leader, to run its own command (default: the default command)")
+			fmt.Println("  --output-dir <dir>        Directory of the metrics, summary, manifest and outputs of the run (default: .)")
+			os.Exit(0)
+		default:
+			if leader != "" {
+				fatalWith(ExitConfig, "Unknown follow argument", "argument", args[i])
+			}
+			leader = args[i]
+		}
+	}
+	if leader == "" {
+		fatalWith(ExitConfig, "Follow needs the address of the leader")
+	}
+	connect := leader
+	leader, leaderPort := splitSyncServer(leader)
+	if leaderPort != "" {
+		port = leaderPort
+	}
+	if _, ok := syncSocketPath(leader); !ok {
+		connect = net.JoinHostPort(leader, port)
+	}
+	setSyncServer(connect)
+
+	syncServerUrl := syncUrl(leader, port)
+	spec, err := fetchFollowerSpec(syncServerUrl, syncNode)
+	if err != nil {
+		fatalWith(ExitSync, "Cannot fetch run spec from the leader", "leader", connect, "error", err)
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		fatalWith(ExitOutput, "Cannot create output directory", "dir", outputDir, "error", err)
+	}
+	spec.Command = expandFollowerCommand(spec.Command, leader)
+	logger.Info("Run spec received from the leader", "leader", connect, "command", strings.Join(spec.Command, " "))
+
+	runSubcommand(append([]string{"--connect", connect}, spec.runArgs(outputDir)...))
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+var generateSampleFlags = []string{"--nodes", "--duration", "--seed", "--end", "-o", "--output-dir"}
+
+// Idle time sampled around the command of the generated runs
+const generateSampleIdle = 10 * time.Second
+
+// Simulated run of a node, written as a result file as if statexec had run the command
+type sampleNode struct {
+	name    string
+	role    string
+	peer    string
+	seed    int64
+	startMs int64
+}
+
+func generateSampleSubcommand(args []string) {
+	nodes := 2
+	duration := 5 * time.Minute
+	seed := int64(42)
+	outputDir := "."
+	end := time.Now()
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--nodes":
+			value, err := strconv.Atoi(flagValue(args, i))
+			if err != nil || value < 1 {
+				fatalWith(ExitConfig, "Nodes must be a positive integer", "value", args[i+1])
+			}
+			nodes = value
+			i++
+		case "--duration":
+			value, err := time.ParseDuration(flagValue(args, i))
+			if err != nil || value < time.Second {
+				fatalWith(ExitConfig, "Duration must be a duration of at least 1s, e.g. 5m", "value", args[i+1])
+			}
+			duration = value
+			i++
+		case "--seed":
+			value, err := strconv.ParseInt(flagValue(args, i), 10, 64)
+			if err != nil || value < 0 {
+				fatalWith(ExitConfig, "Seed must be a positive integer", "value", args[i+1])
+			}
+			seed = value
+			i++
+		case "--end":
+			value, err := time.Parse(time.RFC3339, flagValue(args, i))
+			if err != nil {
+				fatalWith(ExitConfig, "End must be a RFC3339 time, e.g. 2024-01-01T12:00:00Z", "value", args[i+1])
+			}
+			end = value
+			i++
+		case "-o", "--output-dir":
+			outputDir = flagValue(args, i)
+			i++
+		case "-h", "--help":
+			fmt.Printf("Usage: %s generate-sample [--nodes <n>] [--duration <duration>] [--seed <n>] [--end <time>] [-o <dir>]\n", os.Args[0])
+			fmt.Println("  Write realistic result files of a synchronized run of several nodes, with annotations, without running anything")
+			fmt.Println("  --nodes <n>              Number of nodes, the first one is the sync server (default: 2)")
+			fmt.Println("  --duration <duration>    Duration of the command (default: 5m)")
+			fmt.Println("  --seed <n>               Seed of the synthetic metrics, the same seed gives the same files (default: 42)")
+			fmt.Println("  --end <time>             End of the runs in RFC3339, recent enough to be imported (default: now)")
+			fmt.Println("  -o, --output-dir <dir>   Directory of the result files, one per node (default: .)")
+			os.Exit(0)
+		default:
+			fatalWith(ExitConfig, "Unknown argument", "argument", args[i])
+		}
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		fatalWith(ExitOutput, "Cannot create output directory", "dir", outputDir, "error", err)
+	}
+
+	// All nodes share the same session and timeline, as a synchronized run would
+	session := newRunId()
+	startMs := end.Add(-duration - 2*generateSampleIdle).Truncate(time.Second).UnixMilli()
+	for n := 1; n <= nodes; n++ {
+		node := sampleNode{name: fmt.Sprintf("node-%d", n), role: "standalone", seed: seed + int64(n), startMs: startMs}
+		if nodes > 1 {
+			node.role, node.peer = "client", "node-1"
+			if n == 1 {
+				node.role, node.peer = "server", "node-2"
+			}
+		}
+		path := filepath.Join(outputDir, node.name+".prom")
+		generateSampleRun(node, session, duration, path)
+		fmt.Println(path)
+	}
+}
+
+// Simulate the samples of a run with the synthetic collectors and write them as statexec does
+func generateSampleRun(node sampleNode, session string, duration time.Duration, path string) {
+	store = &Store{seriesIndex: make(map[string]*Series)}
+	fakeCollectorStates = make(map[string]*fakeCollector)
+	fakeCollectorsSeed = node.seed
+	metricsStartTime = node.startMs
+	metricsFile = path
+	instance, hostname, role, jobName = node.name, node.name, node.role, "statexec-sample"
+	runId = newRunId()
+	command = []string{"./bench.sh"}
+	commandExitCode = 0
+	delayBeforeCommand, delayAfterCommand = generateSampleIdle, generateSampleIdle
+	extraLabels = map[string]string{}
+	// All collectors, the synthetic ones being those of cpu, memory, network and disk
+	enabledCollectors = make(map[string]bool)
+	for _, collector := range availableCollectors {
+		enabledCollectors[collector] = true
+	}
+	if node.role != "standalone" {
+		extraLabels["sync_role"] = syncRoles[node.role]
+		extraLabels["sync_peer"] = node.peer
+		extraLabels["sync_session"] = session
+	}
+
+	addFakeInventory(metricsStartTime)
+	addBuildInfoMetric(metricsStartTime)
+
+	idleMs := delayBeforeCommand.Milliseconds()
+	commandMs := duration.Milliseconds()
+	for msSinceStart := int64(0); msSinceStart <= commandMs+2*idleMs; msSinceStart += 1000 {
+		status := CommandStatusPending
+		switch {
+		case msSinceStart >= idleMs+commandMs:
+			status = CommandStatusDone
+		case msSinceStart >= idleMs:
+			status = CommandStatusRunning
+		}
+		if status != store.CommandStatus() {
+			generateSampleTransition(status, metricsStartTime+msSinceStart)
+		}
+		store.SetCommandStatus(status)
+
+		instantMetric := InstantMetric{
+			cmdStatus:          status,
+			msSinceStart:       msSinceStart,
+			timestamp:          metricsStartTime + msSinceStart,
+			collectorDurations: make(map[string]int64),
+			collectorFailures:  make(map[string]bool),
+		}
+		// Synthetic collectors never fail
+		for _, collector := range fakeSampleCollectors() {
+			samples, _ := collector.collect()
+			instantMetric.storeCollector(collector.name, samples)
+			instantMetric.collectorDurations[collector.name] = 0
+		}
+		store.AddMetric(instantMetric)
+	}
+	annotatePhases(metricsStartTime + commandMs + 2*idleMs)
+
+	exportRunResult()
+}
+
+// Record the start or the end of the simulated command, with its annotation
+func generateSampleTransition(status int, timestamp int64) {
+	name, tag, text := "start", "start", "Command started"
+	if status == CommandStatusDone {
+		name, tag, text = "end", "done", "Command done with status 0"
+	}
+	recordCommandEvent(name, timestamp)
+	store.AddAnnotation(GrafanaAnnotation{
+		Time:    timestamp,
+		TimeEnd: timestamp,
+		Text:    text,
+		Tags: []string{
+			"statexec",
+			tag,
+			"instance=" + instance,
+			"job=" + jobName,
+			"role=" + role,
+			"hostname=" + hostname,
+			"run_id=" + runId,
+		},
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files of testdata/ with the current outputs")
+
+// End of the synthetic run of the golden files, fixed so the timestamps do not move
+var goldenEnd = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+var goldenBuildLine = regexp.MustCompile(`(?m)^# Build: .*$`)
+
+// Write the result file of a short synthetic run of a sync server, deterministic for its seed, and return its path
+func generateGoldenRun(t *testing.T) string {
+	t.Helper()
+	duration := 5 * time.Second
+	node := sampleNode{
+		name:    "node-1",
+		role:    "server",
+		peer:    "node-2",
+		seed:    42,
+		startMs: goldenEnd.Add(-duration - 2*generateSampleIdle).UnixMilli(),
+	}
+	path := filepath.Join(t.TempDir(), "node-1.prom")
+	generateSampleRun(node, "golden-session", duration, path)
+	return path
+}
+
+// Replace what changes from one run to the other: the run id, the output directory and the build
+func normalizeGolden(output []byte, dir string) []byte {
+	output = bytes.ReplaceAll(output, []byte(runId), []byte("RUN_ID"))
+	output = bytes.ReplaceAll(output, []byte(dir), []byte("OUTPUT_DIR"))
+	return goldenBuildLine.ReplaceAll(output, []byte("# Build: BUILD"))
+}
+
+// Compare an output to its golden file in testdata/, or rewrite the golden file with -update
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := os.MkdirAll("testdata", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("cannot read golden file, run go test -run %s -update to create it: %v", t.Name(), err)
+	}
+	if !bytes.Equal(got, want) {
+		gotLines, wantLines := bytes.Split(got, []byte("\n")), bytes.Split(want, []byte("\n"))
+		for i := 0; i < len(gotLines) && i < len(wantLines); i++ {
+			if !bytes.Equal(gotLines[i], wantLines[i]) {
+				t.Fatalf("%s differs at line %d\n got: %s\nwant: %s\nrun go test -run %s -update if the change is intended", path, i+1, gotLines[i], wantLines[i], t.Name())
+			}
+		}
+		t.Fatalf("%s differs in length, %d lines instead of %d\nrun go test -run %s -update if the change is intended", path, len(gotLines), len(wantLines), t.Name())
+	}
+}
+
+func goldenJson(t *testing.T, value any) []byte {
+	t.Helper()
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(data, '\n')
+}
+
+// Normalized result file of the synthetic run
+func goldenRunOutput(t *testing.T) []byte {
+	t.Helper()
+	path := generateGoldenRun(t)
+	output, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return normalizeGolden(output, filepath.Dir(path))
+}
+
+func TestResultFileGolden(t *testing.T) {
+	checkGolden(t, "generate_sample.prom.golden", goldenRunOutput(t))
+}
+
+func TestSummaryGolden(t *testing.T) {
+	generateGoldenRun(t)
+	summary := runSummary()
+	summary.Build = BuildInfo{}
+	checkGolden(t, "summary.json.golden", goldenJson(t, summary))
+}
+
+// The result file read back as report does, through the parser the importers use
+func TestReportGolden(t *testing.T) {
+	path := generateGoldenRun(t)
+	report := buildFileReport(path)
+	checkGolden(t, "report.json.golden", normalizeGolden(goldenJson(t, report), filepath.Dir(path)))
+}
+
+// The same seed gives the same metrics
+func TestFakeCollectorsDeterministic(t *testing.T) {
+	if !bytes.Equal(goldenRunOutput(t), goldenRunOutput(t)) {
+		t.Error("two runs with the same seed differ")
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Points of the run lifecycle hooks are executed at: around the start of the command, and around the end of the monitoring
+var hookPoints = []string{"pre-start", "post-start", "pre-stop", "post-stop"}
+
+type Hook struct {
+	point   string
+	command string
+}
+
+var hooks []Hook // shell commands executed at lifecycle points, in the order given (--hook)
+
+// Parse a hook, <point>=<command>
+func parseHook(value string) Hook {
+	point, command, ok := strings.Cut(value, "=")
+	if !ok || strings.TrimSpace(command) == "" {
+		fatalWith(ExitConfig, "Cannot parse hook, expected <point>=<command>, e.g. pre-start=./prep.sh", "hook", value)
+	}
+	if !slices.Contains(hookPoints, point) {
+		fatalWith(ExitConfig, "Unknown hook point", "point", point, "available", strings.Join(hookPoints, ","))
+	}
+	return Hook{point: point, command: command}
+}
+
+func hookStrings() []string {
+	var values []string
+	for _, hook := range hooks {
+		values = append(values, hook.point+"="+hook.command)
+	}
+	return values
+}
+
+// Metadata of the run exported to the hooks
+func hookEnv(point string, cmd *exec.Cmd) []string {
+	env := append(os.Environ(),
+		"STATEXEC_HOOK="+point,
+		"STATEXEC_RUN_ID="+runId,
+		"STATEXEC_INSTANCE="+instance,
+		"STATEXEC_JOB="+jobName,
+		"STATEXEC_ROLE="+role,
+		"STATEXEC_HOSTNAME="+hostname,
+		"STATEXEC_METRICS_FILE="+metricsFile,
+		"STATEXEC_COMMAND="+strings.Join(cmd.Args, " "),
+	)
+	if session, ok := extraLabels["sync_session"]; ok {
+		env = append(env, "STATEXEC_SESSION="+session)
+	}
+	if cmd.Process != nil {
+		env = append(env, "STATEXEC_PID="+strconv.Itoa(cmd.Process.Pid))
+	}
+	if cmd.ProcessState != nil {
+		env = append(env, "STATEXEC_EXIT_CODE="+strconv.Itoa(commandExitCode))
+	}
+	return env
+}
+
+// Execute the hooks of a lifecycle point, each annotated as a region over its execution. The post-stop hooks run once the
+// results are written and are not annotated. A failing pre-start hook aborts the run before the command starts.
+func runHooks(point string, cmd *exec.Cmd, realStartTime time.Time) {
+	for _, hook := range hooks {
+		if hook.point != point {
+			continue
+		}
+		hookCmd := exec.Command("sh", "-c", hook.command)
+		hookCmd.Env = hookEnv(point, cmd)
+		// Hooks run with the credentials of the command, even when statexec stays privileged
+		restrictCommandPrivileges(hookCmd)
+		// Standard output is reserved to the command
+		hookCmd.Stdout = os.Stderr
+		hookCmd.Stderr = os.Stderr
+
+		startedAt := time.Now()
+		err := startRestricted(hookCmd)
+		if err == nil {
+			err = hookCmd.Wait()
+		}
+		duration := time.Since(startedAt)
+		exitCode := 0
+		if err != nil {
+			exitCode = -1
+			if hookCmd.ProcessState != nil {
+				exitCode = hookCmd.ProcessState.ExitCode()
+			}
+		}
+
+		text := "Hook " + point + " done in " + duration.Round(time.Millisecond).String()
+		if err != nil {
+			text = "Hook " + point + " failed with status " + strconv.Itoa(exitCode) + " after " + duration.Round(time.Millisecond).String()
+			logger.Warn("Hook failed", "point", point, "hook", hook.command, "error", err)
+		} else {
+			logger.Debug("Hook done", "point", point, "hook", hook.command, "duration", duration)
+		}
+		if point != "post-stop" {
+			hookStart := metricsStartTime + startedAt.Sub(realStartTime).Milliseconds()
+			store.AddAnnotation(GrafanaAnnotation{
+				Time:    hookStart,
+				TimeEnd: hookStart + duration.Milliseconds(),
+				Text:    text,
+				Tags: []string{
+					"statexec",
+					"hook",
+					"hook=" + point,
+					"instance=" + instance,
+					"job=" + jobName,
+					"role=" + role,
+					"hostname=" + hostname,
+					"run_id=" + runId,
+				},
+			})
+		}
+		if err != nil && point == "pre-start" {
+			fatalWith(ExitCommand, "Pre-start hook failed, command not started", "hook", hook.command, "error", err)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"html/template"
+	"strings"
+)
+
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{"join": strings.Join}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>statexec report: {{.Instance}}</title>
+<style>
+body { font-family: sans-serif; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+td.value { text-align: right; font-family: monospace; }
+</style>
+</head>
+<body>
+<h2>statexec report: {{.Instance}}</h2>
+<table>
+<tr><th>File</th><td>{{.File}}</td></tr>
+<tr><th>Instance</th><td>{{.Instance}}</td></tr>
+<tr><th>Role</th><td>{{.Role}}</td></tr>
+<tr><th>Job</th><td>{{.Job}}</td></tr>
+{{if .Command}}<tr><th>Command</th><td><code>{{join .Command " "}}</code></td></tr>
+{{end}}<tr><th>Duration</th><td>{{printf "%.3f" .DurationSeconds}}s</td></tr>
+<tr><th>Samples</th><td>{{.Samples}}</td></tr>
+</table>
+<h3>Summary</h3>
+<table>
+<tr><th>Metric</th><th>Value</th></tr>
+{{range $key := .Keys}}<tr><td>{{$key}}</td><td class="value">{{printf "%f" (index $.Summary $key)}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// Render a file report as a standalone HTML page
+func renderHtmlReport(report FileReport) string {
+	var html strings.Builder
+	err := htmlReportTemplate.Execute(&html, struct {
+		FileReport
+		Keys []string
+	}{report, sortedKeys(report.Summary)})
+	if err != nil {
+		fatal("Cannot render HTML report", "error", err)
+	}
+	return html.String()
+}
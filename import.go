@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/blackswifthosting/statexec/promfile"
+)
+
+var importFlags = []string{"--vm-url", "--grafana-url", "--no-annotations", "--max-age", "--max-future", "--shift-to-now"}
+
+// Target of an import, and the window of timestamps it accepts
+type ImportOptions struct {
+	vmUrl       string
+	grafanaUrl  string
+	annotations bool
+	maxAge      time.Duration // 0 accepts any past timestamp
+	maxFuture   time.Duration
+	shiftToNow  bool
+	shift       int64 // in milliseconds, the same for all the files so the runs of a sync session stay aligned
+}
+
+func parseImportWindow(flag string, value string) time.Duration {
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		fatalWith(ExitConfig, "Cannot parse import window, expected a duration like 720h, 0 to disable", flag, value)
+	}
+	return duration
+}
+
+func importSubcommand(args []string) {
+	// Defaults of VictoriaMetrics: one month of retention, two days in the future
+	options := ImportOptions{
+		vmUrl:       "http://localhost:8428",
+		grafanaUrl:  "http://localhost:3000",
+		annotations: true,
+		maxAge:      720 * time.Hour,
+		maxFuture:   48 * time.Hour,
+	}
+	if value := os.Getenv(EnvVarPrefix + "VM_URL"); value != "" {
+		options.vmUrl = value
+	}
+	if value := os.Getenv(EnvVarPrefix + "GRAFANA_URL"); value != "" {
+		options.grafanaUrl = value
+	}
+
+	paths := []string{}
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--vm-url":
+			options.vmUrl = flagValue(args, i)
+			i++
+		case "--grafana-url":
+			options.grafanaUrl = flagValue(args, i)
+			i++
+		case "--no-annotations":
+			options.annotations = false
+		case "--max-age":
+			options.maxAge = parseImportWindow(args[i], flagValue(args, i))
+			i++
+		case "--max-future":
+			options.maxFuture = parseImportWindow(args[i], flagValue(args, i))
+			i++
+		case "--shift-to-now":
+			options.shiftToNow = true
+		case "-h", "--help":
+			fmt.Printf("Usage: %s import [OPTIONS] <file.prom|annotations.json|dir> [...]\n", os.Args[0])
+			fmt.Printf("  --vm-url <url>        %sVM_URL        VictoriaMetrics url (default: http://localhost:8428)\n", EnvVarPrefix)
+			fmt.Printf("  --grafana-url <url>   %sGRAFANA_URL   Grafana url for annotations (default: http://localhost:3000)\n", EnvVarPrefix)
+			fmt.Printf("  --no-annotations                    Do not import annotations into Grafana\n")
+			fmt.Printf("  --max-age <duration>                Oldest sample accepted by the target, 0 to disable (default: 720h)\n")
+			fmt.Printf("  --max-future <duration>             Farthest sample in the future accepted by the target (default: 48h)\n")
+			fmt.Printf("  --shift-to-now                      Shift the runs so their last sample is now, to backfill old recordings\n")
+			os.Exit(0)
+		default:
+			paths = append(paths, args[i])
+		}
+	}
+	if len(paths) == 0 {
+		fatalWith(ExitConfig, "No file or directory to import")
+	}
+
+	var files, annotationsFiles []string
+	for _, file := range findResultFiles(paths) {
+		if isAnnotationsFile(file) {
+			annotationsFiles = append(annotationsFiles, file)
+		} else {
+			files = append(files, file)
+		}
+	}
+	if options.shiftToNow {
+		options.shift = shiftToNow(files, time.Now())
+	}
+	for _, file := range files {
+		if err := importResultFile(file, options); err != nil {
+			fatal("Cannot import result file", "file", file, "error", err)
+		}
+		logger.Info("Result file imported", "file", file)
+	}
+	if !options.annotations {
+		return
+	}
+	for _, file := range annotationsFiles {
+		if err := importAnnotationsFile(file, options); err != nil {
+			fatal("Cannot import annotations file", "file", file, "error", err)
+		}
+		logger.Info("Annotations file imported", "file", file)
+	}
+}
+
+// Expand directories into the *.prom files they contain, annotations files are only imported when given as the run files hold them too
+func findResultFiles(paths []string) []string {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			fatal("Cannot read path", "error", err)
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		_ = filepath.Walk(path, func(walkedPath string, walkedInfo os.FileInfo, err error) error {
+			if err == nil && !walkedInfo.IsDir() && strings.HasSuffix(walkedPath, ".prom") {
+				files = append(files, walkedPath)
+			}
+			return nil
+		})
+	}
+	return files
+}
+
+// Offset moving the last sample of the files to now
+func shiftToNow(files []string, now time.Time) int64 {
+	var last int64
+	for _, path := range files {
+		file, err := parseResultFile(path)
+		if err != nil {
+			fatal("Cannot parse result file", "file", path, "error", err)
+		}
+		_, fileLast := file.TimeRange()
+		last = max(last, fileLast)
+	}
+	if last == 0 {
+		return 0
+	}
+	shift := now.UnixMilli() - last
+	logger.Info("Runs shifted to now", "shift", time.Duration(shift)*time.Millisecond)
+	return shift
+}
+
+// Check the samples of a run fit in the window of timestamps accepted by the target, rather than have them silently dropped
+func checkTimestampBounds(file *promfile.File, now time.Time, options ImportOptions) error {
+	if len(file.Samples) == 0 {
+		return nil
+	}
+	first, last := file.TimeRange()
+	if options.maxAge > 0 && first < now.Add(-options.maxAge).UnixMilli() {
+		return fmt.Errorf("samples from %s are older than the %s accepted by the target, use --shift-to-now to rebase the run or --max-age", formatTimestamp(first), options.maxAge)
+	}
+	if options.maxFuture > 0 && last > now.Add(options.maxFuture).UnixMilli() {
+		return fmt.Errorf("samples up to %s are further than %s in the future, use --shift-to-now to rebase the run or --max-future", formatTimestamp(last), options.maxFuture)
+	}
+	return nil
+}
+
+func importResultFile(path string, options ImportOptions) error {
+	content, err := readResultContent(path)
+	if err != nil {
+		return err
+	}
+	file, err := promfile.Parse(bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	// Files of a newer schema may not be understood, rather than half imported
+	if err := file.CheckSchema(); err != nil {
+		return err
+	}
+
+	if options.shift != 0 {
+		file.Shift(options.shift)
+		var shifted bytes.Buffer
+		if err := file.Write(&shifted); err != nil {
+			return err
+		}
+		content = shifted.Bytes()
+	}
+	if err := checkTimestampBounds(file, time.Now(), options); err != nil {
+		return err
+	}
+
+	// Prometheus metrics
+	// See https://docs.victoriametrics.com/Single-server-VictoriaMetrics.html#how-to-import-data-in-prometheus-exposition-format
+	if err := postData(strings.TrimSuffix(options.vmUrl, "/")+"/api/v1/import/prometheus", "text/plain", content); err != nil {
+		return fmt.Errorf("cannot import metrics: %w", err)
+	}
+
+	if !options.annotations {
+		return nil
+	}
+
+	return postAnnotations(file.Annotations, options)
+}
+
+// Create the annotations in Grafana
+func postAnnotations(annotations []promfile.Annotation, options ImportOptions) error {
+	for _, annotation := range annotations {
+		annotationJson, err := json.Marshal(annotation)
+		if err != nil {
+			return err
+		}
+		if err := postJson(strings.TrimSuffix(options.grafanaUrl, "/")+"/api/annotations", annotationJson); err != nil {
+			return fmt.Errorf("cannot create grafana annotation: %w", err)
+		}
+	}
+	return nil
+}
+
+func postJson(url string, body []byte) error {
+	return postData(url, "application/json", body)
+}
+
+// Post data to an url, fail on non 2xx status
+func postData(url string, contentType string, body []byte) error {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(url, contentType, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Hostname of the node, as the hostname label of all metrics
+func resolveHostname() string {
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		logger.Warn("Cannot get hostname", "error", err)
+		return "unknown"
+	}
+	return name
+}
+
+// Expand placeholders of an instance name, e.g. '{hostname}-{command}'
+func expandInstanceTemplate(template string, cmd []string) string {
+	commandName := ""
+	if len(cmd) > 0 {
+		commandName = filepath.Base(cmd[0])
+	}
+	return strings.NewReplacer(
+		"{hostname}", hostname,
+		"{command}", commandName,
+		"{job}", jobName,
+		"{role}", role,
+	).Replace(template)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/blackswifthosting/statexec/collectors"
+)
+
+// One-time metric written once in the result file (inventory, info-style series)
+type StaticMetric struct {
+	name      string
+	labels    map[string]string
+	value     float64
+	timestamp int64
+}
+
+var diskUsageBeforeRun []collectors.DiskUsageMetrics
+
+func addStaticMetric(name string, labels map[string]string, value float64, timestamp int64) {
+	store.AddStaticMetric(StaticMetric{
+		name:      name,
+		labels:    labels,
+		value:     value,
+		timestamp: timestamp,
+	})
+}
+
+// Snapshot host inventory and slow-moving resources before the run
+func collectInventoryBeforeRun(timestamp int64) {
+	snapshotTextfiles("before", timestamp)
+	if fakeCollectorsSeed >= 0 {
+		addFakeInventory(timestamp)
+		return
+	}
+	// The inventory describes the host, a part failing to be read is left out rather than failing the run
+	if hostInfo, err := collectors.CollectHostInfo(); err != nil {
+		logger.Warn("Cannot collect host info, left out of the inventory", "error", err)
+	} else {
+		addStaticMetric("host_info", map[string]string{
+			"os":               hostInfo.Os,
+			"platform":         hostInfo.Platform,
+			"platform_version": hostInfo.PlatformVersion,
+			"kernel":           hostInfo.KernelVersion,
+			"arch":             hostInfo.KernelArch,
+			"cpus":             strconv.Itoa(hostInfo.Cpus),
+			"mem_bytes":        strconv.FormatUint(hostInfo.MemoryBytes, 10),
+		}, 1, timestamp)
+	}
+
+	virtualizationInfo := collectors.CollectVirtualizationInfo()
+	addStaticMetric("host_virtualization_info", map[string]string{
+		"hypervisor": virtualizationInfo.Hypervisor,
+		"container":  virtualizationInfo.Container,
+		"cloud":      virtualizationInfo.Cloud,
+	}, 1, timestamp)
+
+	addCollectorBackendMetrics(timestamp)
+
+	if enabledCollectors["cpu"] {
+		addCpuTuningMetrics(timestamp)
+	}
+
+	if enabledCollectors["network"] {
+		interfacesInfo, err := collectors.CollectNetworkInterfaceInfo()
+		if err != nil {
+			logger.Warn("Cannot collect network interfaces info, left out of the inventory", "error", err)
+		}
+		for _, interfaceInfo := range interfacesInfo {
+			addStaticMetric("network_interface_info", map[string]string{
+				"interface":  interfaceInfo.Interface,
+				"operstate":  interfaceInfo.Operstate,
+				"duplex":     interfaceInfo.Duplex,
+				"speed_mbps": strconv.FormatInt(interfaceInfo.SpeedMbps, 10),
+				"mtu":        strconv.Itoa(interfaceInfo.Mtu),
+			}, 1, timestamp)
+			if interfaceInfo.SpeedMbps > 0 {
+				addStaticMetric("network_interface_speed_bytes", map[string]string{
+					"interface": interfaceInfo.Interface,
+				}, float64(interfaceInfo.SpeedMbps)*1000*1000/8, timestamp)
+			}
+		}
+	}
+
+	if enabledCollectors["disk"] {
+		for _, queueInfo := range collectors.CollectDiskQueueInfo() {
+			addStaticMetric("disk_queue_info", map[string]string{
+				"disk":       queueInfo.Device,
+				"scheduler":  queueInfo.Scheduler,
+				"rotational": strconv.FormatBool(queueInfo.Rotational),
+			}, 1, timestamp)
+			if queueInfo.Requests >= 0 {
+				addStaticMetric("disk_queue_requests", map[string]string{"disk": queueInfo.Device}, float64(queueInfo.Requests), timestamp)
+			}
+			if queueInfo.ReadAheadKb >= 0 {
+				addStaticMetric("disk_read_ahead_bytes", map[string]string{"disk": queueInfo.Device}, float64(queueInfo.ReadAheadKb)*1024, timestamp)
+			}
+		}
+	}
+
+	var err error
+	diskUsageBeforeRun, err = collectors.CollectDiskUsageMetrics()
+	if err != nil {
+		logger.Warn("Cannot collect disk usage, left out of the inventory", "error", err)
+	}
+	for _, diskUsage := range diskUsageBeforeRun {
+		addDiskUsageMetrics(diskUsage, "before", timestamp)
+	}
+
+	if smartEnabled {
+		addSmartMetrics("before", timestamp)
+	}
+}
+
+// Snapshot slow-moving resources after the run, and their delta since the start
+func collectInventoryAfterRun(timestamp int64) {
+	snapshotTextfiles("after", timestamp)
+	if fakeCollectorsSeed >= 0 {
+		return
+	}
+	usedBytesBefore := make(map[string]uint64)
+	for _, diskUsage := range diskUsageBeforeRun {
+		usedBytesBefore[diskUsage.Mountpoint] = diskUsage.UsedBytes
+	}
+
+	diskUsageAfterRun, err := collectors.CollectDiskUsageMetrics()
+	if err != nil {
+		logger.Warn("Cannot collect disk usage, left out of the inventory", "error", err)
+	}
+	for _, diskUsage := range diskUsageAfterRun {
+		addDiskUsageMetrics(diskUsage, "after", timestamp)
+
+		if before, ok := usedBytesBefore[diskUsage.Mountpoint]; ok {
+			addStaticMetric("disk_used_delta_bytes", map[string]string{
+				"mountpoint": diskUsage.Mountpoint,
+				"device":     diskUsage.Device,
+			}, float64(diskUsage.UsedBytes)-float64(before), timestamp)
+		}
+	}
+
+	if smartEnabled {
+		addSmartMetrics("after", timestamp)
+	}
+}
+
+// Frequency governors, turbo and SMT of the CPUs as they are, whether --normalize changed them or not
+func addCpuTuningMetrics(timestamp int64) {
+	tuningInfo := collectors.CollectCpuTuningInfo()
+	for governor, cpus := range tuningInfo.Governors {
+		addStaticMetric("cpu_frequency_info", map[string]string{
+			"driver":   tuningInfo.Driver,
+			"governor": governor,
+		}, float64(cpus), timestamp)
+	}
+	if tuningInfo.Turbo != "" {
+		turboEnabled := 0.0
+		if tuningInfo.Turbo == "on" {
+			turboEnabled = 1
+		}
+		addStaticMetric("cpu_turbo_enabled", nil, turboEnabled, timestamp)
+	}
+	if tuningInfo.Smt != "" {
+		smtActive := 0.0
+		if tuningInfo.SmtActive {
+			smtActive = 1
+		}
+		addStaticMetric("cpu_smt_info", map[string]string{"control": tuningInfo.Smt}, smtActive, timestamp)
+	}
+}
+
+func addDiskUsageMetrics(diskUsage collectors.DiskUsageMetrics, phase string, timestamp int64) {
+	metricLabels := map[string]string{
+		"mountpoint": diskUsage.Mountpoint,
+		"device":     diskUsage.Device,
+		"fstype":     diskUsage.Fstype,
+		"phase":      phase,
+	}
+	addStaticMetric("disk_total_bytes", metricLabels, float64(diskUsage.TotalBytes), timestamp)
+	addStaticMetric("disk_used_bytes", metricLabels, float64(diskUsage.UsedBytes), timestamp)
+	addStaticMetric("disk_free_bytes", metricLabels, float64(diskUsage.FreeBytes), timestamp)
+}
+
+// Snapshot SMART/NVMe health of storage devices
+func addSmartMetrics(phase string, timestamp int64) {
+	for _, smartMetric := range collectors.CollectSmartMetrics() {
+		addStaticMetric("smart_info", map[string]string{
+			"device":   smartMetric.Device,
+			"model":    smartMetric.Model,
+			"serial":   smartMetric.Serial,
+			"protocol": smartMetric.Protocol,
+			"phase":    phase,
+		}, 1, timestamp)
+
+		metricLabels := map[string]string{
+			"device": smartMetric.Device,
+			"phase":  phase,
+		}
+		passed := 0.0
+		if smartMetric.Passed {
+			passed = 1
+		}
+		addStaticMetric("smart_passed", metricLabels, passed, timestamp)
+		addStaticMetric("smart_temperature_celsius", metricLabels, float64(smartMetric.TemperatureCelsius), timestamp)
+		addStaticMetric("smart_power_on_hours", metricLabels, float64(smartMetric.PowerOnHours), timestamp)
+		addStaticMetric("smart_media_errors", metricLabels, float64(smartMetric.MediaErrors), timestamp)
+		if smartMetric.PercentageUsed >= 0 {
+			addStaticMetric("smart_percentage_used", metricLabels, float64(smartMetric.PercentageUsed), timestamp)
+		}
+	}
+}
+
+// Static metrics, written with their exact value
+func staticMetricsSection() ResultSection {
+	section := ResultSection{title: "Inventory"}
+	for _, staticMetric := range store.StaticMetrics() {
+		section.points = append(section.points, ResultPoint{
+			name:      staticMetric.name,
+			labels:    staticMetric.labels,
+			value:     staticMetric.value,
+			precision: -1,
+			timestamp: staticMetric.timestamp,
+		})
+	}
+	return section
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+var jsonResultFile string = "" // result written as a single JSON document (--json-file)
+
+type JsonResultSeries struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels"`
+	Points [][2]float64      `json:"points"` // [timestamp in milliseconds, value]
+}
+
+type JsonResult struct {
+	RunId       string              `json:"run_id"`
+	Summary     RunSummary          `json:"summary"`
+	Annotations []GrafanaAnnotation `json:"annotations"`
+	Series      []JsonResultSeries  `json:"series"`
+}
+
+// The result as a JSON document, for tools which would rather not parse the text formats
+type jsonExporter struct {
+	path string
+}
+
+func (exporter jsonExporter) Name() string {
+	return "json"
+}
+
+func (exporter jsonExporter) Export(result RunResult) error {
+	document := JsonResult{
+		RunId:       runId,
+		Summary:     result.summary,
+		Annotations: result.annotations,
+		Series:      []JsonResultSeries{},
+	}
+	if document.Annotations == nil {
+		document.Annotations = []GrafanaAnnotation{}
+	}
+	for _, series := range result.series() {
+		points := make([][2]float64, len(series.timestamps))
+		for i, timestamp := range series.timestamps {
+			points[i] = [2]float64{float64(timestamp), series.values[i]}
+		}
+		document.Series = append(document.Series, JsonResultSeries{
+			Name:   MetricPrefix + series.name,
+			Labels: seriesLabels(series.labels),
+			Points: points,
+		})
+	}
+
+	data, err := json.Marshal(document)
+	if err != nil {
+		return fmt.Errorf("cannot encode JSON result: %w", err)
+	}
+	if err := writeFileAtomic(exporter.path, append(data, '\n')); err != nil {
+		return fmt.Errorf("cannot write JSON result %s: %w", exporter.path, err)
+	}
+	logger.Debug("JSON result written", "file", exporter.path, "series", len(document.Series))
+	return nil
+}
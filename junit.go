@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"time"
+)
+
+// JUnit XML report, as understood by Jenkins and GitLab test report UIs
+type JunitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Suites   []JunitTestSuite `xml:"testsuite"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Time     float64          `xml:"time,attr"`
+}
+
+type JunitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      float64         `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []JunitTestCase `xml:"testcase"`
+}
+
+type JunitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *JunitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type JunitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+var junitFile string = ""
+
+// Write the run, and each assertion, as JUnit test cases
+func writeJunit(path string) {
+	summary := runSummary()
+	classname := "statexec." + jobName
+
+	runCase := JunitTestCase{
+		Name:      instance,
+		Classname: classname,
+		Time:      summary.DurationSeconds,
+		SystemOut: fmt.Sprintf("command: %v\nrun id: %s\nmetrics file: %s\n", command, runId, metricsFile),
+	}
+	if summary.ExitCode != 0 {
+		runCase.Failure = &JunitFailure{
+			Message: fmt.Sprintf("command exited with status %d", summary.ExitCode),
+			Type:    "exit_code",
+		}
+	}
+
+	suite := JunitTestSuite{
+		Name:      jobName,
+		Time:      summary.DurationSeconds,
+		Timestamp: time.UnixMilli(summary.Timestamp).UTC().Format("2006-01-02T15:04:05"),
+		Cases:     []JunitTestCase{runCase},
+	}
+	for _, result := range assertionResults {
+		assertionCase := JunitTestCase{
+			Name:      "assert " + result.Assertion.Expression,
+			Classname: classname + "." + instance,
+		}
+		if !result.Ok {
+			assertionCase.Failure = &JunitFailure{
+				Message: "assertion failed",
+				Type:    "assertion",
+				Text:    result.String(),
+			}
+		}
+		suite.Cases = append(suite.Cases, assertionCase)
+	}
+	for _, testCase := range suite.Cases {
+		suite.Tests++
+		if testCase.Failure != nil {
+			suite.Failures++
+		}
+	}
+
+	report := JunitTestSuites{
+		Suites:   []JunitTestSuite{suite},
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Time:     suite.Time,
+	}
+	reportXml, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		fatalWith(ExitOutput, "Cannot marshal junit report", "error", err)
+	}
+	if err := writeFileAtomic(path, append([]byte(xml.Header), append(reportXml, '\n')...)); err != nil {
+		fatalWith(ExitOutput, "Cannot write junit report", "file", path, "error", err)
+	}
+}
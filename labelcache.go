@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"sync"
+)
+
+// Rendered label sets, cached by their key/value pairs since every sample renders the same sets again
+var labelCache = struct {
+	sync.Mutex
+	rendered map[string]string
+}{rendered: make(map[string]string)}
+
+// Render labels given as key/value pairs, like renderLabels but computed only once per label set
+func cachedLabels(pairs ...string) string {
+	cacheKey := strings.Join(pairs, "\xff")
+
+	labelCache.Lock()
+	defer labelCache.Unlock()
+	if rendered, ok := labelCache.rendered[cacheKey]; ok {
+		return rendered
+	}
+
+	metricLabels := make(map[string]string, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		metricLabels[pairs[i]] = pairs[i+1]
+	}
+	rendered := renderLabels(metricLabels)
+	labelCache.rendered[cacheKey] = rendered
+	return rendered
+}
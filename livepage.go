@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Root page of the sync server: command status, elapsed time and mini charts fed over a WebSocket
+const livePage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>statexec</title>
+<style>
+body { font-family: sans-serif; color: #222; margin: 1.5em; }
+.status { font-size: 1.4em; margin: 0.5em 0; }
+.pending { color: #9a6700; }
+.running { color: #1f6feb; }
+.done { color: #1a7f37; }
+.charts { display: flex; flex-wrap: wrap; gap: 1em; }
+.chart { border: 1px solid #ccc; padding: 6px 8px; }
+.chart .title { font-size: 0.85em; color: #555; }
+.chart .value { font-family: monospace; float: right; font-size: 0.85em; }
+canvas { display: block; margin-top: 4px; }
+</style>
+</head>
+<body>
+<h2>statexec <span id="instance"></span></h2>
+<p><code id="command"></code></p>
+<div class="status"><span id="status" class="pending">connecting</span> <span id="elapsed"></span></div>
+<p id="start"><a href="/start">/start</a> : Start the command</p>
+<div class="charts" id="charts"></div>
+<script>
+const points = 120;
+const charts = {};
+function chart(id, title, format) {
+  const div = document.createElement("div");
+  div.className = "chart";
+  div.innerHTML = '<span class="title">' + title + '</span><span class="value"></span><canvas width="240" height="60"></canvas>';
+  document.getElementById("charts").appendChild(div);
+  charts[id] = {values: [], format: format, value: div.querySelector(".value"), canvas: div.querySelector("canvas")};
+}
+function push(id, value) {
+  const c = charts[id];
+  c.values.push(value);
+  if (c.values.length > points) c.values.shift();
+  c.value.textContent = c.format(value);
+  const ctx = c.canvas.getContext("2d");
+  const w = c.canvas.width, h = c.canvas.height;
+  const max = Math.max(...c.values, 1e-9);
+  ctx.clearRect(0, 0, w, h);
+  ctx.strokeStyle = "#1f6feb";
+  ctx.beginPath();
+  c.values.forEach((v, i) => {
+    const x = i * w / (points - 1), y = h - 2 - v / max * (h - 4);
+    i ? ctx.lineTo(x, y) : ctx.moveTo(x, y);
+  });
+  ctx.stroke();
+}
+function bytes(value) {
+  const units = ["B", "KB", "MB", "GB", "TB"];
+  let i = 0;
+  while (value >= 1024 && i < units.length - 1) { value /= 1024; i++; }
+  return value.toFixed(1) + " " + units[i];
+}
+chart("cpu", "CPU", v => v.toFixed(1) + " %");
+chart("memory", "Memory used", bytes);
+chart("sent", "Network sent", v => bytes(v) + "/s");
+chart("received", "Network received", v => bytes(v) + "/s");
+chart("read", "Disk read", v => bytes(v) + "/s");
+chart("write", "Disk written", v => bytes(v) + "/s");
+
+let startedAt = 0, endedAt = 0, previous = null;
+function setStatus(text, className) {
+  const status = document.getElementById("status");
+  status.textContent = text;
+  status.className = className;
+  document.getElementById("start").style.display = className == "pending" ? "" : "none";
+}
+function elapsed(now) {
+  if (!startedAt) return;
+  document.getElementById("elapsed").textContent = (((endedAt || now) - startedAt) / 1000).toFixed(0) + " s";
+}
+function totals(sample) {
+  const t = {time: sample.timestamp, busy: 0, cpu: 0, memTotal: 0, memAvailable: 0, sent: 0, received: 0, read: 0, write: 0};
+  for (const m of sample.metrics) {
+    const v = m.value;
+    switch (m.name) {
+    case "statexec_cpu_seconds_total": t.cpu += v; if (m.labels.mode != "idle" && m.labels.mode != "iowait") t.busy += v; break;
+    case "statexec_memory_total_bytes": t.memTotal = v; break;
+    case "statexec_memory_available_bytes": t.memAvailable = v; break;
+    case "statexec_network_sent_bytes_total": t.sent += v; break;
+    case "statexec_network_received_bytes_total": t.received += v; break;
+    case "statexec_disk_read_bytes_total": t.read += v; break;
+    case "statexec_disk_write_bytes_total": t.write += v; break;
+    }
+  }
+  return t;
+}
+function onSample(sample) {
+  const t = totals(sample);
+  push("memory", t.memTotal - t.memAvailable);
+  if (previous && t.time > previous.time) {
+    const seconds = (t.time - previous.time) / 1000;
+    const rate = key => Math.max(t[key] - previous[key], 0) / seconds;
+    push("cpu", t.cpu > previous.cpu ? 100 * (t.busy - previous.busy) / (t.cpu - previous.cpu) : 0);
+    push("sent", rate("sent"));
+    push("received", rate("received"));
+    push("read", rate("read"));
+    push("write", rate("write"));
+  }
+  previous = t;
+  elapsed(t.time);
+}
+
+const socket = new WebSocket((location.protocol == "https:" ? "wss://" : "ws://") + location.host + "/live");
+socket.onmessage = message => {
+  const event = JSON.parse(message.data);
+  const data = event.data;
+  switch (event.event) {
+  case "run":
+    document.getElementById("instance").textContent = data.instance + " (" + data.role + ")";
+    document.getElementById("command").textContent = (data.command || []).join(" ");
+    startedAt = data.started_at || 0;
+    setStatus(data.phase == "pre" ? "waiting for start" : data.phase == "run" ? "running" : "done", data.phase == "pre" ? "pending" : data.phase == "run" ? "running" : "done");
+    break;
+  case "command":
+    if (data.event == "start") { startedAt = data.timestamp; setStatus("running", "running"); }
+    if (data.event == "end") { endedAt = data.timestamp; setStatus("done", "done"); elapsed(endedAt); }
+    break;
+  case "sample":
+    onSample(data);
+    break;
+  case "end":
+    setStatus("done with status " + data.exit_code, "done");
+    break;
+  }
+};
+socket.onclose = () => { if (!endedAt) setStatus("disconnected", "pending"); };
+</script>
+</body>
+</html>
+`
+
+func handleLivePage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, livePage)
+}
+
+// Push the events of the run to the live page until it closes or the run ends
+func handleLiveSocket(w http.ResponseWriter, r *http.Request) {
+	conn, rw, err := upgradeWebsocket(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer conn.Close()
+
+	client := broker.subscribe()
+	defer broker.unsubscribe(client)
+
+	// The page sends nothing but a close frame, a read error means it is gone
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			opcode, _, err := readWebsocketFrame(rw.Reader)
+			if err != nil || opcode == websocketOpClose {
+				return
+			}
+		}
+	}()
+
+	send := func(event StreamEvent) bool {
+		message, _ := json.Marshal(event)
+		return writeWebsocketFrame(rw.Writer, websocketOpText, message) == nil
+	}
+	if !send(streamRunEvent()) {
+		return
+	}
+	for {
+		select {
+		case event, open := <-client:
+			if !open || !send(event) {
+				return
+			}
+			if event.Event == "end" {
+				writeWebsocketFrame(rw.Writer, websocketOpClose, nil)
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/blackswifthosting/statexec/objstore"
+	"github.com/blackswifthosting/statexec/tsdb"
+)
+
+var (
+	tsdbDir      string = "" // local TSDB directory the runs are appended to as blocks (--tsdb)
+	tsdbBlockDir string = "" // directory the run is written to as a standalone block, for object stores (--tsdb-block)
+
+	objstoreConfigFile string = "" // Thanos object store configuration the block is uploaded to (--objstore)
+	objstoreBucket     objstore.Bucket
+)
+
+// Directory of the annotations of the blocks, one JSON line file per block, ignored by Prometheus as it is not a block
+const tsdbAnnotationsDir = "annotations"
+
+// Source of the blocks in their Thanos meta
+const tsdbBlockSource = "statexec"
+
+// The run appended to the local TSDB as a new block, with its annotations next to it
+type tsdbExporter struct {
+	dir string
+}
+
+func (exporter tsdbExporter) Name() string {
+	return "tsdb"
+}
+
+func (exporter tsdbExporter) Export(result RunResult) error {
+	meta, err := writeRunBlock(exporter.dir, result, nil)
+	if err != nil {
+		return err
+	}
+
+	annotationsDir := filepath.Join(exporter.dir, tsdbAnnotationsDir)
+	if err := os.MkdirAll(annotationsDir, 0755); err != nil {
+		return fmt.Errorf("cannot create tsdb annotations directory %s: %w", annotationsDir, err)
+	}
+	annotations := ""
+	for _, annotation := range result.annotations {
+		annotationJson, err := json.Marshal(annotation)
+		if err != nil {
+			return fmt.Errorf("cannot marshal annotation: %w", err)
+		}
+		annotations += string(annotationJson) + "\n"
+	}
+	annotationsFile := filepath.Join(annotationsDir, meta.Ulid+".jsonl")
+	if err := writeFileAtomic(annotationsFile, []byte(annotations)); err != nil {
+		return fmt.Errorf("cannot write tsdb annotations %s: %w", annotationsFile, err)
+	}
+
+	logger.Info("Metrics appended to tsdb", "dir", exporter.dir, "block", meta.Ulid, "series", meta.Stats.NumSeries, "samples", meta.Stats.NumSamples)
+	return nil
+}
+
+// The run as a standalone block with a Thanos meta, uploaded to the object store if any,
+// without --tsdb-block the uploaded block is written to a temporary directory
+type tsdbBlockExporter struct {
+	dir    string
+	bucket objstore.Bucket
+}
+
+func (exporter tsdbBlockExporter) Name() string {
+	return "tsdb_block"
+}
+
+func (exporter tsdbBlockExporter) Export(result RunResult) error {
+	blockDir := exporter.dir
+	if blockDir == "" {
+		tmpDir, err := os.MkdirTemp("", "statexec-block-")
+		if err != nil {
+			return fmt.Errorf("cannot create temporary tsdb directory: %w", err)
+		}
+		defer os.RemoveAll(tmpDir)
+		blockDir = tmpDir
+	}
+	meta, err := writeRunBlock(blockDir, result, &tsdb.ThanosMeta{Labels: map[string]string{}, Source: tsdbBlockSource})
+	if err != nil {
+		return err
+	}
+	if exporter.dir != "" {
+		logger.Info("Metrics written as tsdb block", "block", filepath.Join(exporter.dir, meta.Ulid), "series", meta.Stats.NumSeries, "samples", meta.Stats.NumSamples)
+	}
+
+	if exporter.bucket == nil {
+		return nil
+	}
+	if err := objstore.UploadBlock(exporter.bucket, filepath.Join(blockDir, meta.Ulid)); err != nil {
+		return fmt.Errorf("cannot upload tsdb block to %s: %w", exporter.bucket.String(), err)
+	}
+	logger.Info("Tsdb block uploaded", "bucket", exporter.bucket.String(), "block", meta.Ulid)
+	return nil
+}
+
+// Load the object store configuration before the run, a mistake must not be found once the run is over
+func loadObjstoreConfig() {
+	if objstoreConfigFile == "" {
+		return
+	}
+	data, err := os.ReadFile(objstoreConfigFile)
+	if err != nil {
+		fatalWith(ExitConfig, "Cannot read object store config", "file", objstoreConfigFile, "error", err)
+	}
+	generic, err := parseYaml(data)
+	if err != nil {
+		fatalWith(ExitConfig, "Cannot parse object store config", "file", objstoreConfigFile, "error", err)
+	}
+	jsonConfig, err := json.Marshal(generic)
+	if err != nil {
+		fatalWith(ExitConfig, "Cannot parse object store config", "file", objstoreConfigFile, "error", err)
+	}
+	var config objstore.Config
+	if err := json.Unmarshal(jsonConfig, &config); err != nil {
+		fatalWith(ExitConfig, "Cannot parse object store config", "file", objstoreConfigFile, "error", err)
+	}
+	objstoreBucket, err = objstore.NewBucket(config)
+	if err != nil {
+		fatalWith(ExitConfig, "Invalid object store config", "file", objstoreConfigFile, "error", err)
+	}
+}
+
+func writeRunBlock(dir string, result RunResult, thanos *tsdb.ThanosMeta) (tsdb.BlockMeta, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return tsdb.BlockMeta{}, fmt.Errorf("cannot create tsdb directory %s: %w", dir, err)
+	}
+	meta, err := tsdb.WriteBlock(dir, runBlockSeries(result), thanos)
+	if err != nil {
+		return tsdb.BlockMeta{}, fmt.Errorf("cannot write tsdb block in %s: %w", dir, err)
+	}
+	return meta, nil
+}
+
+// Series of the run with the labels of the result file
+func runBlockSeries(result RunResult) []tsdb.Series {
+	var series []tsdb.Series
+	for _, oneSeries := range result.series() {
+		samples := make([]tsdb.Sample, len(oneSeries.timestamps))
+		for i, timestamp := range oneSeries.timestamps {
+			samples[i] = tsdb.Sample{Value: oneSeries.values[i], Timestamp: timestamp}
+		}
+		series = append(series, tsdb.Series{Labels: blockLabels(oneSeries.name, oneSeries.labels), Samples: samples})
+	}
+	return series
+}
+
+// Labels of a series as renderLabels writes them, with its name
+func blockLabels(name string, metricsLabels map[string]string) map[string]string {
+	labels := seriesLabels(metricsLabels)
+	labels["__name__"] = MetricPrefix + name
+	return labels
+}
+
+// Time range of the blocks of a local TSDB, in milliseconds
+func tsdbTimeRange(dir string) (int64, int64, error) {
+	metas, err := tsdb.ReadBlockMetas(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(metas) == 0 {
+		return 0, 0, fmt.Errorf("no block in %s", dir)
+	}
+	from, to := metas[0].MinTime, metas[0].MaxTime
+	for _, meta := range metas[1:] {
+		from, to = min(from, meta.MinTime), max(to, meta.MaxTime)
+	}
+	return from, to, nil
+}
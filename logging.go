@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+)
+
+var (
+	logLevel         = new(slog.LevelVar)
+	logFormat string = "text"
+	logFile   string = ""
+
+	// Diagnostics are written to stderr so they never mix with the command output
+	logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+)
+
+// Parse a log level name (debug, info, warn, error)
+func parseLogLevel(value string) slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.ToUpper(value))); err != nil {
+		fatalWith(ExitConfig, "Log level must be debug, info, warn or error", "level", value)
+	}
+	return level
+}
+
+func parseLogFormat(value string) string {
+	if value != "text" && value != "json" {
+		fatalWith(ExitConfig, "Log format must be text or json", "format", value)
+	}
+	return value
+}
+
+// Parse logging env vars (--log-level, --log-format, --log-file)
+func parseLogEnvVars() {
+	if value := os.Getenv(EnvVarPrefix + "LOG_LEVEL"); value != "" {
+		logLevel.Set(parseLogLevel(value))
+	}
+	if value := os.Getenv(EnvVarPrefix + "LOG_FORMAT"); value != "" {
+		logFormat = parseLogFormat(value)
+	}
+	if value := os.Getenv(EnvVarPrefix + "LOG_FILE"); value != "" {
+		logFile = value
+	}
+}
+
+// Configure the logger once flags and env vars are parsed
+func setupLogger() {
+	output := os.Stderr
+	if logFile != "" {
+		file, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			fatalWith(ExitOutput, "Cannot open log file", "file", logFile, "error", err)
+		}
+		output = file
+	}
+
+	options := &slog.HandlerOptions{Level: logLevel}
+	if logFormat == "json" {
+		logger = slog.New(slog.NewJSONHandler(output, options))
+	} else {
+		logger = slog.New(slog.NewTextHandler(output, options))
+	}
+
+	// Collectors log through the default logger
+	slog.SetDefault(logger)
+}
+
+// Log an error and exit
+func fatal(msg string, args ...any) {
+	fatalWith(ExitError, msg, args...)
+}
+
+// Log an error and exit with the exit code of its kind
+func fatalWith(code int, msg string, args ...any) {
+	logger.Error(msg, args...)
+	discardPendingFiles()
+	os.Exit(code)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var lokiUrl string = ""
+
+// Writer of the command output pushing its lines to Loki, in batches
+type LokiWriter struct {
+	mutex      sync.Mutex
+	stream     string
+	realStart  time.Time
+	partial    string
+	values     [][]string
+	lastPushed time.Time
+}
+
+var lokiClient = &http.Client{Timeout: 10 * time.Second}
+
+// Push endpoint of a Loki base url, e.g. http://loki:3100
+func lokiPushUrl(baseUrl string) string {
+	parsed, err := url.Parse(baseUrl)
+	if err == nil && (parsed.Path == "" || parsed.Path == "/") {
+		return strings.TrimSuffix(baseUrl, "/") + "/loki/api/v1/push"
+	}
+	return baseUrl
+}
+
+func newLokiWriter(stream string, realStart time.Time) *LokiWriter {
+	return &LokiWriter{stream: stream, realStart: realStart, lastPushed: time.Now()}
+}
+
+// Timestamp in nanoseconds, with the same time base as metrics
+func (writer *LokiWriter) timestamp() int64 {
+	return metricsStartTime*int64(time.Millisecond) + int64(time.Since(writer.realStart))
+}
+
+func (writer *LokiWriter) Write(data []byte) (int, error) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	timestamp := writer.timestamp()
+	lines := strings.Split(writer.partial+string(data), "\n")
+	writer.partial = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		writer.values = append(writer.values, []string{strconv.FormatInt(timestamp, 10), strings.TrimSuffix(line, "\r")})
+	}
+
+	if len(writer.values) >= 1000 || time.Since(writer.lastPushed) >= time.Second {
+		writer.push()
+	}
+	return len(data), nil
+}
+
+// Push the remaining lines, once the command is done
+func (writer *LokiWriter) Flush() {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if writer.partial != "" {
+		writer.values = append(writer.values, []string{strconv.FormatInt(writer.timestamp(), 10), writer.partial})
+		writer.partial = ""
+	}
+	writer.push()
+}
+
+// Push buffered lines, a failing Loki never fails the run
+func (writer *LokiWriter) push() {
+	writer.lastPushed = time.Now()
+	if len(writer.values) == 0 {
+		return
+	}
+
+	labels := map[string]string{
+		"instance": instance,
+		"job":      jobName,
+		"role":     role,
+		"hostname": hostname,
+		"run_id":   runId,
+		"stream":   writer.stream,
+	}
+	for key, value := range extraLabels {
+		labels[key] = value
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"streams": []map[string]interface{}{
+			{"stream": labels, "values": writer.values},
+		},
+	})
+	writer.values = nil
+	if err != nil {
+		logger.Warn("Cannot marshal logs for Loki", "error", err)
+		return
+	}
+
+	resp, err := lokiClient.Post(lokiPushUrl(lokiUrl), "application/json", bytes.NewReader(body))
+	if err != nil {
+		logger.Warn("Cannot push logs to Loki", "url", lokiUrl, "error", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		logger.Warn("Cannot push logs to Loki", "url", lokiUrl, "error", fmt.Sprintf("%s: %s", resp.Status, strings.TrimSpace(string(respBody))))
+	}
+}
@@ -3,12 +3,17 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"math"
 	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -16,17 +21,28 @@ import (
 	"time"
 
 	"github.com/blackswifthosting/statexec/collectors"
+	"github.com/blackswifthosting/statexec/promfile"
 )
 
 var (
 	version        = "dev"
 	jobName string = "statexec"
 
-	metricsFile              string = ""
-	metricsStartTimeOverride int64  = -1 // in milliseconds
-	delayBeforeCommand       int64  = 0
-	delayAfterCommand        int64  = 0
-	instanceOverride         string = ""
+	metricsFile              string        = ""
+	metricsStartTimeOverride int64         = -1 // in milliseconds
+	delayBeforeCommand       time.Duration = 0
+	delayAfterCommand        time.Duration = 0
+	instanceOverride         string        = ""
+	hostname                 string        = ""
+	reservedLabelPrefix      string        = "label_"
+	dryRunEnabled            bool          = false
+	dryRunFormat             string        = "yaml"
+	summaryJsonTarget        string        = ""
+	targetPprofUrl           string        = ""
+	jmxTarget                string        = ""
+	ethtoolInterfaces        []string      // interfaces to read the driver statistics of (--ethtool)
+	traceChildren            bool          = false
+	smartEnabled             bool          = false
 
 	role            string = "standalone"
 	serverIp        string = ""
@@ -35,12 +51,13 @@ var (
 
 	extraLabels map[string]string
 
+	// Collectors enabled by default, see --collectors
+	availableCollectors = []string{"cpu", "memory", "network", "disk", "nfs", "conntrack", "netstat", "numa", "kernel", "clock", "interrupts"}
+	enabledCollectors   map[string]bool
+
 	metricsStartTime int64 // in milliseconds
 	instance         string
-	commandState     int = 0
-
-	metricStore     []InstantMetric
-	annotationStore []GrafanaAnnotation
+	commandExitCode  int = 0
 )
 
 const (
@@ -65,35 +82,137 @@ type GrafanaAnnotation struct {
 
 type InstantMetric struct {
 	cmdStatus       int
-	cpu             []collectors.CpuMetrics
-	memory          collectors.MemoryMetrics
-	network         []collectors.NetworkMetrics
-	disk            []collectors.DiskMetrics
 	msSinceStart    int64
 	collectDuration int64
 	timestamp       int64
+
+	collected          []collectorSamples // samples of the collectors which reported, the others have no points in the sample
+	collectorDurations map[string]int64
+	collectorFailures  map[string]bool
 }
 
 func main() {
+	args := os.Args[1:]
+
+	// Logging is configurable by env vars for all subcommands
+	parseLogEnvVars()
+
+	// Dispatch to a subcommand if the first argument is one, else run a command
+	if len(args) > 0 {
+		if subcommand, ok := findSubcommand(args[0]); ok {
+			setupLogger()
+			subcommand.Run(args[1:])
+			return
+		}
+	}
+	runSubcommand(args)
+}
+
+// Execute a command and collect metrics (default subcommand)
+func runSubcommand(args []string) {
 	// Default values
 	metricsFile = jobName + "_metrics.prom"
 
 	// Initialize extra labels to an empty map
 	extraLabels = make(map[string]string)
 
+	// Enable all collectors by default
+	enabledCollectors = make(map[string]bool)
+	for _, collector := range availableCollectors {
+		enabledCollectors[collector] = true
+	}
+
 	// Parse environment variables
 	parseEnvVars()
 
 	// Parse command line arguments
-	cmd := parseArgs()
+	cmd := parseArgs(args)
+
+	// Configure logging now that flags are parsed
+	setupLogger()
+
+	// Labels of the pipeline or workload running statexec, after the ones given
+	applyAutoLabels()
+
+	// Extra labels may use names reserved by statexec, once the prefix is known
+	namespaceReservedLabels()
+
+	// Run spec distributed to the followers by the leader
+	loadFollowerSpec()
+
+	// A heartbeat must fit several times in the timeout
+	if syncHeartbeat*2 > syncTimeout {
+		fatalWith(ExitConfig, "Sync timeout must be at least twice the heartbeat interval", "timeout", syncTimeout, "heartbeat", syncHeartbeat)
+	}
+
+	// Abort fan-out needs the server to wait for its followers
+	if abortOnFailure && (role != "server" || !syncWaitForStop) {
+		fatalWith(ExitConfig, "Aborting the session on failure needs server mode (--server), without --sync-start-only")
+	}
+
+	// Checked in background, the run does not wait for GitHub
+	if checkUpdate {
+		go checkForUpdate()
+	}
+
+	// Hostname is a label of its own, so that instances of the same command on several nodes are distinguishable
+	hostname = resolveHostname()
 
 	// Override instance name if set, else use command name
 	if instanceOverride != "" {
-		instance = instanceOverride
-	} else {
+		instance = expandInstanceTemplate(instanceOverride, cmd)
+	} else if len(cmd) > 0 {
 		instance = cmd[0]
 	}
 
+	// Results may be shared publicly, without internal naming
+	applyRedaction()
+
+	// A baseline is needed to check the run against
+	if checkEnabled && baselineFile == "" {
+		fatalWith(ExitConfig, "Check needs a baseline result file (--baseline)")
+	}
+
+	// The object store is checked before the run
+	loadObjstoreConfig()
+
+	// Deny patterns of the machine, checked by the dry run as well
+	loadDenyFile()
+
+	// Print effective configuration and exit without running anything
+	if dryRunEnabled {
+		dryRun(cmd)
+	}
+
+	if len(cmd) == 0 {
+		logger.Error("No command to execute")
+		usage(os.Stderr)
+		os.Exit(ExitConfig)
+	}
+
+	// Refuse dangerous commands before anything runs or syncs with peers
+	checkCommandSafety(cmd)
+
+	// The command does not need the privileges statexec may have to read the counters
+	dropCommandPrivileges(cmd)
+
+	// Keep the command as given for the manifest
+	command = cmd
+
+	// Source of the counters, /proc or syscalls when it is not mounted
+	selectCollectorBackend()
+
+	// Find the metrics this platform cannot provide, not to emit them
+	probeCapabilities()
+
+	// Count hardware events of the command with perf stat
+	if perfEvents != "" {
+		cmd = wrapWithPerf(cmd)
+	}
+
+	// Before syncing with peers, not to delay the start of the command
+	normalizeSystem()
+
 	// Create command to execute
 	execCmd := exec.Command(cmd[0], cmd[1:]...)
 
@@ -102,141 +221,472 @@ func main() {
 	case "standalone":
 		startCommand(execCmd)
 	case "client":
-		syncStartCommand(execCmd, fmt.Sprintf("http://%s:%s", serverIp, syncPort), syncWaitForStop)
+		syncStartCommand(execCmd, syncUrl(serverIp, syncPort), syncWaitForStop)
 	case "server":
 		waitForHttpSyncToStartCommand(execCmd, syncWaitForStop)
 	}
+	restoreNormalization()
+
+	// Fail when the sync session was aborted, results are written anyway
+	exitIfSessionAborted()
+	exitIfCollectorsFailed()
+
+	// Fail when the run does not meet its assertions, or regressed compared to the baseline
+	if assertionsFailed() {
+		os.Exit(ExitAssertion)
+	}
+	if checkFailed() {
+		logger.Error("Regression compared to the baseline", "baseline", baselineFile)
+		os.Exit(ExitAssertion)
+	}
 }
 
-func usage() {
+func usage(w io.Writer) {
 	binself := os.Args[0]
-	fmt.Printf("Usage: %s [OPTIONS] <command> [command args]\n", binself)
-	fmt.Printf("Version: %s\n", version)
-	fmt.Println("")
-	fmt.Printf("Common options:\n")
-	fmt.Printf("  --file, -f <file>                       %sFILE                 Metrics file (default: statexec_metrics.prom)\n", EnvVarPrefix)
-	fmt.Printf("  --instance, -i <instance>               %sINSTANCE             Instance name (default: <command>)\n", EnvVarPrefix)
-	fmt.Printf("  --metrics-start-time, -mst <timestamp>  %sMETRICS_START_TIME   Metrics start time in milliseconds (default: now)\n", EnvVarPrefix)
-	fmt.Printf("  --delay, -d <seconds>                   %sDELAY                Delay in seconds before and after the command (default: 0)\n", EnvVarPrefix)
-	fmt.Printf("  --delay-before-command, -dbc <seconds>  %sDELAY_BEFORE_COMMAND Delay in seconds  before the command (default: 0)\n", EnvVarPrefix)
-	fmt.Printf("  --delay-after-command, -dac <seconds>   %sDELAY_AFTER_COMMAND  Delay in seconds  after the command (default: 0)\n", EnvVarPrefix)
-	fmt.Printf("  --label, -l <key>=<value>               %sLABEL_<key>          Extra label to add to all metrics (no default)\n", EnvVarPrefix)
-	fmt.Printf("Synchronization options:\n")
-	fmt.Printf("  --server, -s               %s                   Start server mode (no default)\n", strings.Repeat(" ", len(EnvVarPrefix)))
-	fmt.Printf("  --connect, -c <ip>         %sCONNECT            Connect to server on <ip> (no default)\n", EnvVarPrefix)
-	fmt.Printf("  --sync-port, -sp <port>    %sSYNC_PORT          Sync port (default: 8080)\n", EnvVarPrefix)
-	fmt.Printf("  --sync-start-only, -sso    %sSYNC_START_ONLY    Sync start only (default: false)\n", EnvVarPrefix)
-	fmt.Println("Other options:")
-	fmt.Printf("  --version, -v        Print version and exit\n")
-	fmt.Printf("  --help, -help, -h    Print help and exit\n")
-	fmt.Printf("  --                   Stop parsing arguments\n")
-	fmt.Println("")
-	fmt.Println("Standalone examples:")
-	fmt.Printf("  %s ping 8.8.8.8 -c 4\n", binself)
-	fmt.Printf("  %sFILE=data.prom %sLABEL_type=sample %s -d 3 -l env=dev -- ./mycommand.sh arg1 arg2\n", EnvVarPrefix, EnvVarPrefix, binself)
-	fmt.Println("")
-	fmt.Println("Sync mode examples:")
-	fmt.Println("  # Wait for a client sync to start the command")
-	fmt.Printf("  %s -s -- date\n", binself)
-	fmt.Println("  # Connect to server on <localhost> to start and stop the command")
-	fmt.Printf("  %s -c localhost -- echo start date now\n", binself)
+	fmt.Fprintf(w, "Usage: %s [run] [OPTIONS] <command> [command args]\n", binself)
+	fmt.Fprintf(w, "       %s <subcommand> [OPTIONS] [args]\n", binself)
+	fmt.Fprintf(w, "Version: %s\n", version)
+	fmt.Fprintln(w, "")
+	fmt.Fprintf(w, "Subcommands:\n")
+	for _, subcommand := range subcommands() {
+		fmt.Fprintf(w, "  %-12s %s\n", subcommand.Name, subcommand.Description)
+	}
+	fmt.Fprintln(w, "")
+	fmt.Fprintf(w, "Common options:\n")
+	fmt.Fprintf(w, "  --file, -f <file>                       %sFILE                 Metrics file (default: statexec_metrics.prom)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --annotations-file <file>               %sANNOTATIONS_FILE     Also write the annotations to a JSON file in the schema of the Grafana annotations API, e.g. annotations.json (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --openmetrics-file <file>               %sOPENMETRICS_FILE     Also write the metrics in the OpenMetrics text format, grouped by metric family (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --json-file <file>                      %sJSON_FILE            Also write the metrics and annotations as a single JSON document (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --split-output <dir>                    %sSPLIT_OUTPUT         Also write the metrics split per collector, cpu.prom, memory.prom, network.prom, disk.prom and run.prom (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --tsdb <dir>                            %sTSDB                 Also append the run as a block to a local Prometheus TSDB directory, readable by statexec explore --tsdb (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --tsdb-block <dir>                      %sTSDB_BLOCK           Also write the run as a Prometheus TSDB block with a Thanos meta, to drop into an object store (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --objstore <file>                       %sOBJSTORE             Upload the run as a TSDB block to the object store of a Thanos objstore config file, S3, GCS, AZURE or FILESYSTEM (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --remote-write <url>                    %sREMOTE_WRITE         Also push the metrics to a Prometheus remote write endpoint once the command is done, e.g. http://localhost:9090/api/v1/write (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --instance, -i <instance>               %sINSTANCE             Instance name, {hostname}, {command}, {job} and {role} are replaced, e.g. '{hostname}-{command}' (default: <command>)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --metrics-start-time, -mst <timestamp>  %sMETRICS_START_TIME   Metrics start time in milliseconds (default: now)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --delay, -d <duration>                  %sDELAY                Delay before and after the command, like 1.5s or 500ms, bare numbers are seconds (default: 0)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --delay-before-command, -dbc <duration> %sDELAY_BEFORE_COMMAND Delay before the command, overrides --delay (default: 0)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --delay-after-command, -dac <duration>  %sDELAY_AFTER_COMMAND  Delay after the command, overrides --delay (default: 0)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --start-at <time|+duration>             %sSTART_AT             Start the command at a scheduled time, RFC3339 or relative like +30s (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --trigger <condition>                   %sTRIGGER              Start the command once the host load matches, like cpu>20%%, network>10MB/s or disk>50MB/s, can be repeated (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --stop-when <condition> for <duration>  %sSTOP_WHEN            Terminate the command once the host load matches for a duration, like network_idle for 30s, can be repeated (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --duration <duration>                   %sDURATION             Terminate the command after a duration, like 10m (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --retries <n>                           %sRETRIES              Run the command again up to n times while it exits nonzero, the summary covers the last attempt (default: 0)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --retry-backoff <duration>              %sRETRY_BACKOFF        Delay before running a failed command again (default: 10s)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --hook <point>=<command>                %sHOOK                 Shell command executed at pre-start, post-start, pre-stop or post-stop with the run metadata in STATEXEC_* env vars, can be repeated (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --label, -l <key>=<value>               %sLABEL_<key>          Extra label to add to all metrics (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --auto-labels <sources>                 %sAUTO_LABELS          Add labels from the env vars of orchestrators, comma separated: ci, k8s, nomad (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --reserved-label-prefix <prefix>        %sRESERVED_LABEL_PREFIX Prefix of extra labels using a name reserved by statexec, e.g. cpu (default: label_)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --collectors, -C <list>                 %sCOLLECTORS           Collectors to enable, comma separated, prefix with +/- to add/remove (default: %s)\n", EnvVarPrefix, strings.Join(availableCollectors, ","))
+	fmt.Fprintf(w, "  --collect-phases <list>                 %sCOLLECT_PHASES       Phases to sample, comma separated among pre,run,post, run is required (default: pre,run,post)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --collector-backend <name>              %sCOLLECTOR_BACKEND    Source of the cpu and memory counters: procfs or sysinfo on Linux, sysctl on the BSDs, auto for the first available (default: auto)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --collector-timeout <ms>                %sCOLLECTOR_TIMEOUT    Timeout of each collector in milliseconds, slower collectors are left out of the sample (default: 800)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --target-pprof <url>                    %sTARGET_PPROF         Sample Go runtime metrics of the command from its expvar/pprof endpoint (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --jmx <host:port|url>                   %sJMX                  Sample JVM heap, threads and GC of the command through a Jolokia agent (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --ethtool <interfaces>                  %sETHTOOL              Sample driver statistics of interfaces, comma separated, per queue packets, bytes and drops (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --smart                                 %sSMART                Snapshot SMART/NVMe health of storage devices before and after the run, needs smartctl (default: false)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --textfile-dir <dir>                    %sTEXTFILE_DIR         Snapshot the node_exporter textfile metrics of the directory before and after the run, with their deltas (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --trace-children                        %sTRACE_CHILDREN       Count processes spawned by the command and the CPU of its whole tree, short-lived ones included, Linux only, needs root (default: false)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --normalize <actions>                   %sNORMALIZE            Normalize the system before the run, comma separated: sync, drop-caches, no-turbo, governor=<governor>, needs root (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --realtime                              %sREALTIME             Run the collect loop under SCHED_FIFO with the memory locked to reduce sampling jitter, Linux only, needs root (default: false)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --fake-collectors <seed=n>              %sFAKE_COLLECTORS      Replace the cpu, memory, network and disk collectors by deterministic synthetic metrics (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --perf <events>                         %sPERF                 Count perf events of the command, comma separated, e.g. cycles,instructions (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --probe <target>                                             Active probe during the run: icmp://host, tcp://host:port, http(s)://url, dns://name[@resolver], can be repeated (no default)\n")
+	fmt.Fprintf(w, "  --probe-interval <seconds>              %sPROBE_INTERVAL       Interval between probes in seconds (default: 1)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --probe-buckets <seconds,...>           %sPROBE_BUCKETS        Also record probe durations as a histogram with these bucket upper bounds (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --legacy-names                          %sLEGACY_NAMES         Emit self metrics under their names of older versions, in milliseconds (default: false)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --redact-labels <list>                  %sREDACT_LABELS        Replace the values of these labels by a stable hash in all outputs, comma separated (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --anonymize                             %sANONYMIZE            Replace the hostname and IP addresses by a stable hash in all outputs (default: false)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --dry-run, -n                           %sDRY_RUN              Print effective configuration, validate it and exit (default: false)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --dry-run-format <yaml|json>            %sDRY_RUN_FORMAT       Format of the dry run output (default: yaml)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --require-confirm                       %sREQUIRE_CONFIRM      Ask for a confirmation on the terminal before running the command (default: false)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --deny <regex>                          %sDENY                 Refuse to run commands matching a regular expression unless --yes, can be repeated, ; separated in env (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --deny-file <file>                      %sDENY_FILE            Deny patterns of the machine, one per line (default: %s)\n", EnvVarPrefix, defaultDenyFile)
+	fmt.Fprintf(w, "  --user <user>[:<group>]                 %sUSER                 Run the command as this user under no_new_privs, statexec dropping to it too unless a feature needs root, Linux only (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --yes, -y                               %sYES                  Run without confirmation, even a command matching a deny pattern (default: false)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "Synchronization options:\n")
+	fmt.Fprintf(w, "  --server, -s                 %s                   Start server mode (no default)\n", strings.Repeat(" ", len(EnvVarPrefix)))
+	fmt.Fprintf(w, "  --connect, -c <host>         %sCONNECT            Connect to server on <host>, an IPv4, IPv6 or hostname, with an optional :port, or unix://<path> (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --sync-port, -sp <port>      %sSYNC_PORT          Sync port (default: 8080)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --sync-bind <addr|iface>     %sSYNC_BIND          Address or interface the server listens on (default: all, IPv4 and IPv6)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --sync-listen <addr>         %sSYNC_LISTEN        Server listen address overriding bind and port, host:port or unix://<path> (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --sync-start-only, -sso      %sSYNC_START_ONLY    Sync start only (default: false)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --follower-config <file>     %sFOLLOWER_CONFIG    Run spec served to 'statexec follow' clients, server mode only (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --abort-on-failure           %sABORT_ON_FAILURE   Stop every node when a command fails, exit 4, server mode only (default: false)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --sync-timeout <duration>    %sSYNC_TIMEOUT       Peer without heartbeat for longer is lost, the survivor stops and exits 4 (default: 10s)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --sync-heartbeat <duration>  %sSYNC_HEARTBEAT     Interval of the client heartbeats, set by the server (default: 1s)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --no-leader-time             %sNO_LEADER_TIME     Keep the local metrics start time instead of the server one, client mode only (default: false)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "Output options:\n")
+	fmt.Fprintf(w, "  --summary-json <target>                 %sSUMMARY_JSON         Write the run summary as JSON to a file, \"-\" for stdout or \"fd:<n>\" (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --loki-url <url>                        %sLOKI_URL             Push the command output lines to Loki with the metrics labels (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --assert <assertion>                    %sASSERT               Assertion on a summary value, e.g. 'duration_seconds<60', can be repeated, exit 5 if one fails (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --junit <file>                          %sJUNIT                Write the run and assertions results as a JUnit XML report (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --notify <webhook url>                  %sNOTIFY               Post a run summary card to a Slack, Teams or Discord webhook, can be repeated (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --notify-on <always|failure>            %sNOTIFY_ON            Notify after every run, or only when it failed (default: always)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --dashboard-url <url>                   %sDASHBOARD_URL        Dashboard link of the notifications (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --email-to <address>[,<address>...]     %sEMAIL_TO             Email the HTML report of the run to these recipients (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --email-from <address>                  %sEMAIL_FROM           Sender of the email report (default: statexec@<hostname>)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --smtp-server <host:port>               %sSMTP_SERVER          SMTP server sending the email report (default: localhost:25)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --smtp-user <user>                      %sSMTP_USER            SMTP user, password from %sSMTP_PASSWORD (no default)\n", EnvVarPrefix, EnvVarPrefix)
+	fmt.Fprintf(w, "  --ci-summary <file|auto>                %sCI_SUMMARY           Append a Markdown summary of the run to a file, auto for $GITHUB_STEP_SUMMARY (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --baseline <file.prom>                  %sBASELINE             Reference result file the run is compared to (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --manifest <file>                       %sMANIFEST             Write a manifest with the SHA-256 of the produced files, the run id and the effective configuration (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --stream <address>                      %sSTREAM               Serve each sample live as a Server-Sent Event on GET /stream, e.g. :9099 (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --encrypt <age|pgp>:<recipient>         %sENCRYPT              Encrypt the metrics file with age or gpg once the run is done (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "Logging options:\n")
+	fmt.Fprintf(w, "  --log-level <level>        %sLOG_LEVEL          Log level: debug, info, warn, error (default: info)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --log-format <text|json>   %sLOG_FORMAT         Log format (default: text)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --log-file <file>          %sLOG_FILE           Write logs to a file instead of stderr (no default)\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --quiet, -q                %sQUIET              Only log errors (default: false)\n", EnvVarPrefix)
+	fmt.Fprintln(w, "Other options:")
+	fmt.Fprintf(w, "  --version, -v        Print version, platform and build info and exit\n")
+	fmt.Fprintf(w, "  --check-update       Log when a newer release is available, env %sCHECK_UPDATE=true\n", EnvVarPrefix)
+	fmt.Fprintf(w, "  --help, -help, -h    Print help and exit\n")
+	fmt.Fprintf(w, "  --                   Stop parsing arguments\n")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Standalone examples:")
+	fmt.Fprintf(w, "  %s ping 8.8.8.8 -c 4\n", binself)
+	fmt.Fprintf(w, "  %sFILE=data.prom %sLABEL_type=sample %s -d 3 -l env=dev -- ./mycommand.sh arg1 arg2\n", EnvVarPrefix, EnvVarPrefix, binself)
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Sync mode examples:")
+	fmt.Fprintln(w, "  # Wait for a client sync to start the command")
+	fmt.Fprintf(w, "  %s -s -- date\n", binself)
+	fmt.Fprintln(w, "  # Connect to server on <localhost> to start and stop the command")
+	fmt.Fprintf(w, "  %s -c localhost -- echo start date now\n", binself)
+	fmt.Fprintln(w, "  # Distribute the command of the followers, started with: statexec follow <server>")
+	fmt.Fprintf(w, "  %s -s --follower-config follower.yaml -- iperf3 -s -1\n", binself)
+}
+
+// Flags of the run subcommand, used by shell completion
+var runFlags = []string{
+	"--file", "-f", "--annotations-file", "--openmetrics-file", "--json-file", "--split-output", "--tsdb", "--tsdb-block", "--objstore", "--remote-write", "--instance", "-i", "--metrics-start-time", "-mst",
+	"--delay", "-d", "--delay-before-command", "-dbc", "--delay-after-command", "-dac", "--start-at", "--trigger", "--stop-when", "--duration", "--retries", "--retry-backoff", "--hook",
+	"--label", "-l", "--auto-labels", "--reserved-label-prefix", "--collectors", "-C", "--collect-phases", "--collector-backend", "--collector-timeout", "--target-pprof", "--jmx", "--ethtool", "--smart", "--textfile-dir", "--trace-children", "--normalize", "--realtime", "--fake-collectors", "--perf", "--probe", "--probe-interval", "--probe-buckets", "--legacy-names", "--redact-labels", "--anonymize", "--dry-run", "-n", "--dry-run-format", "--require-confirm", "--deny", "--deny-file", "--user", "--yes", "-y",
+	"--server", "-s", "--connect", "-c", "--sync-port", "-sp", "--sync-bind", "--sync-listen", "--sync-start-only", "-sso", "--follower-config", "--abort-on-failure", "--sync-timeout", "--sync-heartbeat", "--no-leader-time",
+	"--summary-json", "--loki-url", "--assert", "--notify", "--notify-on", "--dashboard-url", "--email-to", "--email-from", "--smtp-server", "--smtp-user", "--junit", "--ci-summary", "--baseline", "--manifest", "--stream", "--encrypt", "--log-level", "--log-format", "--log-file", "--quiet", "-q",
+	"--version", "-v", "--check-update", "--help", "-h",
 }
 
-func parseArgs() []string {
+func parseArgs(args []string) []string {
 	var err error
 	cmd := []string{}
+	// Delays before and after the command override --delay whatever the order of the flags
+	delay := time.Duration(-1)
+	delayBeforeSet, delayAfterSet := false, false
 
-	for i := 1; i < len(os.Args); i++ {
-		switch os.Args[i] {
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
 		case "-f", "--file":
-			metricsFile = os.Args[i+1]
+			metricsFile = flagValue(args, i)
+			i++
+		case "--annotations-file":
+			annotationsFile = flagValue(args, i)
+			i++
+		case "--openmetrics-file":
+			openMetricsFile = flagValue(args, i)
+			i++
+		case "--json-file":
+			jsonResultFile = flagValue(args, i)
+			i++
+		case "--split-output":
+			splitOutputDir = flagValue(args, i)
+			i++
+		case "--tsdb":
+			tsdbDir = flagValue(args, i)
+			i++
+		case "--tsdb-block":
+			tsdbBlockDir = flagValue(args, i)
+			i++
+		case "--objstore":
+			objstoreConfigFile = flagValue(args, i)
+			i++
+		case "--remote-write":
+			remoteWriteEndpoint = flagValue(args, i)
 			i++
 
 		case "-i", "--instance":
-			instanceOverride = os.Args[i+1]
+			instanceOverride = flagValue(args, i)
 			i++
 
 		case "-mst", "--metrics-start-time":
-			metricsStartTimeOverride, err = strconv.ParseInt(os.Args[i+1], 10, 64)
+			metricsStartTimeOverride, err = strconv.ParseInt(flagValue(args, i), 10, 64)
 			if err != nil {
-				fmt.Println("Error parsing metrics time override:", err)
-				os.Exit(1)
+				fatalWith(ExitConfig, "Cannot parse metrics start time", "value", args[i+1], "error", err)
 			}
 			i++
 
 		case "-c", "--connect":
 			if role == "server" {
-				fmt.Println("Error: server and client modes are mutually exclusive")
-				os.Exit(1)
+				fatalWith(ExitConfig, "Server and client modes are mutually exclusive")
 			}
 			role = "client"
-			serverIp = os.Args[i+1]
+			setSyncServer(flagValue(args, i))
 			i++
 		case "-s", "--server":
 			if role == "client" {
-				fmt.Println("Error: server and client modes are mutually exclusive")
-				os.Exit(1)
+				fatalWith(ExitConfig, "Server and client modes are mutually exclusive")
 			}
 			role = "server"
 
 		case "-sp", "--sync-port":
-			syncPort = os.Args[i+1]
+			syncPort = flagValue(args, i)
 			i++
 		case "-sso", "--sync-start-only":
 			syncWaitForStop = false
+		case "--follower-config":
+			followerConfig = flagValue(args, i)
+			i++
+		case "--abort-on-failure":
+			abortOnFailure = true
+		case "--sync-bind":
+			syncBind = flagValue(args, i)
+			i++
+		case "--sync-listen":
+			syncListen = flagValue(args, i)
+			i++
+		case "--sync-timeout":
+			syncTimeout = parseSyncDuration("sync_timeout", flagValue(args, i))
+			i++
+		case "--sync-heartbeat":
+			syncHeartbeat = parseSyncDuration("sync_heartbeat", flagValue(args, i))
+			i++
+		case "--no-leader-time":
+			leaderTime = false
 
-		// Delay in seconds
+		// Delays, a duration or a number of seconds
 		case "-d", "--delay":
-			timeToWaitInScd, err := strconv.ParseInt(os.Args[i+1], 10, 64)
-			if err != nil {
-				fmt.Println("Error parsing wait time:", err)
-				os.Exit(1)
-			}
-			delayBeforeCommand = timeToWaitInScd
-			delayAfterCommand = timeToWaitInScd
+			delay = parseDelay(args[i], flagValue(args, i))
 			i++
 		case "-dbc", "--delay-before-command":
-			timeToWaitInMs, err := strconv.ParseInt(os.Args[i+1], 10, 64)
-			if err != nil {
-				fmt.Println("Error parsing wait time:", err)
-				os.Exit(1)
-			}
-			delayBeforeCommand = timeToWaitInMs
+			delayBeforeCommand = parseDelay(args[i], flagValue(args, i))
+			delayBeforeSet = true
 			i++
 		case "-dac", "--delay-after-command":
-			timeToWaitInMs, err := strconv.ParseInt(os.Args[i+1], 10, 64)
-			if err != nil {
-				fmt.Println("Error parsing wait time:", err)
-				os.Exit(1)
-			}
-			delayAfterCommand = timeToWaitInMs
+			delayAfterCommand = parseDelay(args[i], flagValue(args, i))
+			delayAfterSet = true
+			i++
+
+		case "--loki-url":
+			lokiUrl = flagValue(args, i)
+			i++
+
+		case "--start-at":
+			startAt = parseStartAt(flagValue(args, i))
+			i++
+		case "--trigger":
+			triggers = append(triggers, parseTrigger(flagValue(args, i)))
+			i++
+		case "--stop-when":
+			stopConditions = append(stopConditions, parseStopCondition(flagValue(args, i)))
+			i++
+		case "--duration":
+			commandDuration = parseCommandDuration(flagValue(args, i))
+			i++
+		case "--retries":
+			commandRetries = parseRetries(flagValue(args, i))
+			i++
+		case "--retry-backoff":
+			retryBackoff = parseDelay("retry_backoff", flagValue(args, i))
+			i++
+		case "--hook":
+			hooks = append(hooks, parseHook(flagValue(args, i)))
+			i++
+
+		case "--auto-labels":
+			parseAutoLabels(flagValue(args, i))
+			i++
+
+		case "--reserved-label-prefix":
+			reservedLabelPrefix = flagValue(args, i)
 			i++
 
 		// Extra labels
 		case "-l", "--label":
-			parts := strings.SplitN(os.Args[i+1], "=", 2)
+			parts := strings.SplitN(flagValue(args, i), "=", 2)
 			if len(parts) == 2 {
 				addLabel(parts[0], parts[1])
 			} else {
-				fmt.Println("Error parsing label:", os.Args[i+1])
-				os.Exit(1)
+				fatalWith(ExitConfig, "Cannot parse label, expected <key>=<value>", "label", args[i+1])
 			}
 			i++
 
+		// Collectors selection
+		case "-C", "--collectors":
+			parseCollectors(flagValue(args, i))
+			i++
+
+		case "--collect-phases":
+			parseCollectPhases(flagValue(args, i))
+			i++
+
+		case "--collector-backend":
+			collectorBackend = flagValue(args, i)
+			i++
+		case "--collector-timeout":
+			collectorTimeout, err = strconv.ParseInt(flagValue(args, i), 10, 64)
+			if err != nil || collectorTimeout < 1 {
+				fatalWith(ExitConfig, "Cannot parse collector timeout, must be a positive number of milliseconds", "value", args[i+1])
+			}
+			i++
+
+		case "--target-pprof":
+			targetPprofUrl = flagValue(args, i)
+			i++
+
+		case "--jmx":
+			jmxTarget = flagValue(args, i)
+			i++
+
+		case "--ethtool":
+			ethtoolInterfaces = parseEthtoolInterfaces(flagValue(args, i))
+			i++
+
+		case "--smart":
+			smartEnabled = true
+		case "--textfile-dir":
+			textfileDir = flagValue(args, i)
+			i++
+		case "--trace-children":
+			traceChildren = true
+		case "--normalize":
+			parseNormalize(flagValue(args, i))
+			i++
+		case "--realtime":
+			realtime = true
+		case "--fake-collectors":
+			fakeCollectorsSeed = parseFakeCollectors(flagValue(args, i))
+			i++
+		case "--legacy-names":
+			legacyNames = true
+		case "--redact-labels":
+			parseRedactLabels(flagValue(args, i))
+			i++
+		case "--anonymize":
+			anonymize = true
+		case "--check-update":
+			checkUpdate = true
+
+		case "--perf":
+			perfEvents = flagValue(args, i)
+			i++
+
+		// Active probes
+		case "--probe":
+			addProbe(flagValue(args, i))
+			i++
+		case "--probe-interval":
+			probeInterval, err = strconv.ParseInt(flagValue(args, i), 10, 64)
+			if err != nil || probeInterval < 1 {
+				fatalWith(ExitConfig, "Cannot parse probe interval, must be a positive number of seconds", "value", args[i+1])
+			}
+			i++
+		case "--probe-buckets":
+			probeBuckets = parseBuckets(flagValue(args, i))
+			i++
+
+		case "-n", "--dry-run":
+			dryRunEnabled = true
+		case "--dry-run-format":
+			dryRunFormat = flagValue(args, i)
+			if dryRunFormat != "yaml" && dryRunFormat != "json" {
+				fatalWith(ExitConfig, "Dry run format must be yaml or json", "format", dryRunFormat)
+			}
+			i++
+
+		// Safety checks
+		case "--require-confirm":
+			requireConfirm = true
+		case "--deny":
+			addDenyPattern(flagValue(args, i))
+			i++
+		case "--deny-file":
+			denyFile = flagValue(args, i)
+			i++
+		case "--user":
+			commandUser = flagValue(args, i)
+			i++
+		case "-y", "--yes":
+			assumeYes = true
+
+		// Logging
+		case "--log-level":
+			logLevel.Set(parseLogLevel(flagValue(args, i)))
+			i++
+		case "--log-format":
+			logFormat = parseLogFormat(flagValue(args, i))
+			i++
+		case "--log-file":
+			logFile = flagValue(args, i)
+			i++
+		case "-q", "--quiet":
+			logLevel.Set(slog.LevelError)
+
+		case "--summary-json":
+			summaryJsonTarget = flagValue(args, i)
+			i++
+		case "--manifest":
+			manifestFile = flagValue(args, i)
+			i++
+		case "--stream":
+			streamListen = flagValue(args, i)
+			i++
+		case "--encrypt":
+			encryptTarget = parseEncryptTarget(flagValue(args, i))
+			i++
+		case "--assert":
+			assertions = append(assertions, parseAssertion(flagValue(args, i)))
+			i++
+		case "--notify":
+			notifyTargets = append(notifyTargets, parseNotifyTarget(flagValue(args, i)))
+			i++
+		case "--notify-on":
+			notifyOn = parseNotifyOn(flagValue(args, i))
+			i++
+		case "--dashboard-url":
+			dashboardUrl = flagValue(args, i)
+			i++
+		case "--email-to":
+			addEmailRecipients(flagValue(args, i))
+			i++
+		case "--email-from":
+			emailFrom = flagValue(args, i)
+			i++
+		case "--smtp-server":
+			smtpServer = flagValue(args, i)
+			i++
+		case "--smtp-user":
+			smtpUser = flagValue(args, i)
+			i++
+		case "--junit":
+			junitFile = flagValue(args, i)
+			i++
+		case "--ci-summary":
+			ciSummaryTarget = flagValue(args, i)
+			i++
+		case "--baseline":
+			baselineFile = flagValue(args, i)
+			i++
+
 		case "-v", "--version":
-			fmt.Println(version)
+			printVersion()
 			os.Exit(0)
 		case "-h", "-help", "--help":
-			usage()
+			usage(os.Stdout)
 			os.Exit(0)
 		case "--":
-			cmd = os.Args[i+1:]
-			i = len(os.Args)
+			cmd = args[i+1:]
+			i = len(args)
 		default:
-			cmd = os.Args[i:]
-			i = len(os.Args)
+			cmd = args[i:]
+			i = len(args)
 		}
 	}
+	if delay >= 0 && !delayBeforeSet {
+		delayBeforeCommand = delay
+	}
+	if delay >= 0 && !delayAfterSet {
+		delayAfterCommand = delay
+	}
 	return cmd
 }
 
@@ -247,6 +697,46 @@ func parseEnvVars() {
 		metricsFile = value
 	}
 
+	// Annotations JSON file (--annotations-file)
+	if value := os.Getenv(EnvVarPrefix + "ANNOTATIONS_FILE"); value != "" {
+		annotationsFile = value
+	}
+
+	// OpenMetrics file (--openmetrics-file)
+	if value := os.Getenv(EnvVarPrefix + "OPENMETRICS_FILE"); value != "" {
+		openMetricsFile = value
+	}
+
+	// JSON result file (--json-file)
+	if value := os.Getenv(EnvVarPrefix + "JSON_FILE"); value != "" {
+		jsonResultFile = value
+	}
+
+	// Split metrics files directory (--split-output)
+	if value := os.Getenv(EnvVarPrefix + "SPLIT_OUTPUT"); value != "" {
+		splitOutputDir = value
+	}
+
+	// Local TSDB directory (--tsdb)
+	if value := os.Getenv(EnvVarPrefix + "TSDB"); value != "" {
+		tsdbDir = value
+	}
+
+	// Standalone TSDB block directory (--tsdb-block)
+	if value := os.Getenv(EnvVarPrefix + "TSDB_BLOCK"); value != "" {
+		tsdbBlockDir = value
+	}
+
+	// Object store config file (--objstore)
+	if value := os.Getenv(EnvVarPrefix + "OBJSTORE"); value != "" {
+		objstoreConfigFile = value
+	}
+
+	// Remote write endpoint (--remote-write)
+	if value := os.Getenv(EnvVarPrefix + "REMOTE_WRITE"); value != "" {
+		remoteWriteEndpoint = value
+	}
+
 	// Instance name (-i, --instance)
 	if value := os.Getenv(EnvVarPrefix + "INSTANCE"); value != "" {
 		instanceOverride = value
@@ -256,26 +746,23 @@ func parseEnvVars() {
 	if value := os.Getenv(EnvVarPrefix + "METRICS_START_TIME"); value != "" {
 		metricsStartTimeOverride, err = strconv.ParseInt(value, 10, 64)
 		if err != nil {
-			fmt.Println("Error parsing "+EnvVarPrefix+"METRICS_START_TIME env var, must be an int64 (timestamp in ms since epoch), found : ", value)
-			os.Exit(1)
+			fatalWith(ExitConfig, "Cannot parse env var, must be an int64 (timestamp in ms since epoch)", "env", EnvVarPrefix+"METRICS_START_TIME", "value", value)
 		}
 	}
 
 	// Connect to server (-c, --connect)
 	if value := os.Getenv(EnvVarPrefix + "CONNECT"); value != "" {
 		if role == "server" {
-			fmt.Println("Error: server and client modes are mutually exclusive")
-			os.Exit(1)
+			fatalWith(ExitConfig, "Server and client modes are mutually exclusive")
 		}
 		role = "client"
-		serverIp = value
+		setSyncServer(value)
 	}
 
 	// Start server (-s, --server)
 	if value := os.Getenv(EnvVarPrefix + "SERVER"); value != "" {
 		if role == "client" {
-			fmt.Println("Error: server and client modes are mutually exclusive")
-			os.Exit(1)
+			fatalWith(ExitConfig, "Server and client modes are mutually exclusive")
 		}
 		role = "server"
 	}
@@ -293,57 +780,449 @@ func parseEnvVars() {
 		}
 	}
 
-	// Delay in seconds (-d, --delay)
+	// Follower configuration (--follower-config)
+	if value := os.Getenv(EnvVarPrefix + "FOLLOWER_CONFIG"); value != "" {
+		followerConfig = value
+	}
+
+	// Abort the sync session on failure (--abort-on-failure)
+	if value := os.Getenv(EnvVarPrefix + "ABORT_ON_FAILURE"); value == "true" {
+		abortOnFailure = true
+	}
+
+	// Sync bind address (--sync-bind)
+	if value := os.Getenv(EnvVarPrefix + "SYNC_BIND"); value != "" {
+		syncBind = value
+	}
+
+	// Sync listen address (--sync-listen)
+	if value := os.Getenv(EnvVarPrefix + "SYNC_LISTEN"); value != "" {
+		syncListen = value
+	}
+
+	// Sync timeout (--sync-timeout)
+	if value := os.Getenv(EnvVarPrefix + "SYNC_TIMEOUT"); value != "" {
+		syncTimeout = parseSyncDuration(EnvVarPrefix+"SYNC_TIMEOUT", value)
+	}
+
+	// Sync heartbeat interval (--sync-heartbeat)
+	if value := os.Getenv(EnvVarPrefix + "SYNC_HEARTBEAT"); value != "" {
+		syncHeartbeat = parseSyncDuration(EnvVarPrefix+"SYNC_HEARTBEAT", value)
+	}
+
+	// Keep the local time base in client mode (--no-leader-time)
+	if value := os.Getenv(EnvVarPrefix + "NO_LEADER_TIME"); value == "true" {
+		leaderTime = false
+	}
+
+	// Delay before and after the command (-d, --delay)
 	if value := os.Getenv(EnvVarPrefix + "DELAY"); value != "" {
-		timeToWaitInScd, err := strconv.ParseInt(value, 10, 64)
-		if err != nil {
-			fmt.Println("Error parsing "+EnvVarPrefix+"DELAY env var, must be an int64 (time in ms), found : ", value)
-			os.Exit(1)
-		}
-		delayBeforeCommand = timeToWaitInScd
-		delayAfterCommand = timeToWaitInScd
+		delayBeforeCommand = parseDelay(EnvVarPrefix+"DELAY", value)
+		delayAfterCommand = delayBeforeCommand
 	}
 
-	// Delay before command in seconds (-dbc, --delay-before-command)
+	// Delay before the command (-dbc, --delay-before-command)
 	if value := os.Getenv(EnvVarPrefix + "DELAY_BEFORE_COMMAND"); value != "" {
-		timeToWaitInScd, err := strconv.ParseInt(value, 10, 64)
-		if err != nil {
-			fmt.Println("Error parsing "+EnvVarPrefix+"DELAY_BEFORE_COMMAND env var, must be an int64 (time in ms), found : ", value)
-			os.Exit(1)
-		}
-		delayBeforeCommand = timeToWaitInScd
+		delayBeforeCommand = parseDelay(EnvVarPrefix+"DELAY_BEFORE_COMMAND", value)
 	}
 
-	// Delay after command in seconds (-dac, --delay-after-command)
+	// Delay after the command (-dac, --delay-after-command)
 	if value := os.Getenv(EnvVarPrefix + "DELAY_AFTER_COMMAND"); value != "" {
-		timeToWaitInScd, err := strconv.ParseInt(value, 10, 64)
-		if err != nil {
-			fmt.Println("Error parsing "+EnvVarPrefix+"DELAY_AFTER_COMMAND env var, must be an int64 (time in ms), found : ", value)
-			os.Exit(1)
+		delayAfterCommand = parseDelay(EnvVarPrefix+"DELAY_AFTER_COMMAND", value)
+	}
+
+	// Collectors selection (-C, --collectors)
+	if value := os.Getenv(EnvVarPrefix + "COLLECTORS"); value != "" {
+		parseCollectors(value)
+	}
+
+	// Phases of the run to sample (--collect-phases)
+	if value := os.Getenv(EnvVarPrefix + "COLLECT_PHASES"); value != "" {
+		parseCollectPhases(value)
+	}
+
+	// Source of the CPU and memory counters (--collector-backend)
+	if value := os.Getenv(EnvVarPrefix + "COLLECTOR_BACKEND"); value != "" {
+		collectorBackend = value
+	}
+
+	// Collector timeout (--collector-timeout)
+	if value := os.Getenv(EnvVarPrefix + "COLLECTOR_TIMEOUT"); value != "" {
+		collectorTimeout, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || collectorTimeout < 1 {
+			fatalWith(ExitConfig, "Cannot parse env var, must be a positive int64 (time in ms)", "env", EnvVarPrefix+"COLLECTOR_TIMEOUT", "value", value)
+		}
+	}
+
+	// Go target endpoint (--target-pprof)
+	if value := os.Getenv(EnvVarPrefix + "TARGET_PPROF"); value != "" {
+		targetPprofUrl = value
+	}
+
+	// JVM Jolokia endpoint (--jmx)
+	if value := os.Getenv(EnvVarPrefix + "JMX"); value != "" {
+		jmxTarget = value
+	}
+
+	// Driver statistics of interfaces (--ethtool)
+	if value := os.Getenv(EnvVarPrefix + "ETHTOOL"); value != "" {
+		ethtoolInterfaces = parseEthtoolInterfaces(value)
+	}
+
+	// SMART snapshot (--smart)
+	if value := os.Getenv(EnvVarPrefix + "SMART"); value == "true" {
+		smartEnabled = true
+	}
+
+	// node_exporter textfiles snapshot (--textfile-dir)
+	if value := os.Getenv(EnvVarPrefix + "TEXTFILE_DIR"); value != "" {
+		textfileDir = value
+	}
+
+	// Synthetic metrics (--fake-collectors)
+	if value := os.Getenv(EnvVarPrefix + "FAKE_COLLECTORS"); value != "" {
+		fakeCollectorsSeed = parseFakeCollectors(value)
+	}
+
+	// Processes of the command tree (--trace-children)
+	if value := os.Getenv(EnvVarPrefix + "TRACE_CHILDREN"); value == "true" {
+		traceChildren = true
+	}
+
+	// System normalization (--normalize)
+	if value := os.Getenv(EnvVarPrefix + "NORMALIZE"); value != "" {
+		parseNormalize(value)
+	}
+
+	// Real-time collect loop (--realtime)
+	if value := os.Getenv(EnvVarPrefix + "REALTIME"); value == "true" {
+		realtime = true
+	}
+
+	// Metric names of older versions (--legacy-names)
+	if value := os.Getenv(EnvVarPrefix + "LEGACY_NAMES"); value == "true" {
+		legacyNames = true
+	}
+
+	// Labels to redact (--redact-labels)
+	if value := os.Getenv(EnvVarPrefix + "REDACT_LABELS"); value != "" {
+		parseRedactLabels(value)
+	}
+
+	// Hostname and IP addresses anonymization (--anonymize)
+	if value := os.Getenv(EnvVarPrefix + "ANONYMIZE"); value == "true" {
+		anonymize = true
+	}
+
+	// Update check (--check-update), against the release url of self-update
+	if value := os.Getenv(EnvVarPrefix + "CHECK_UPDATE"); value == "true" {
+		checkUpdate = true
+	}
+	if value := os.Getenv(EnvVarPrefix + "RELEASE_URL"); value != "" {
+		releaseUrl = value
+	}
+
+	// Perf events (--perf)
+	if value := os.Getenv(EnvVarPrefix + "PERF"); value != "" {
+		perfEvents = value
+	}
+
+	// Probe interval (--probe-interval)
+	if value := os.Getenv(EnvVarPrefix + "PROBE_INTERVAL"); value != "" {
+		probeInterval, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || probeInterval < 1 {
+			fatalWith(ExitConfig, "Cannot parse env var, must be a positive int64 (time in seconds)", "env", EnvVarPrefix+"PROBE_INTERVAL", "value", value)
+		}
+	}
+
+	// Probe histogram buckets (--probe-buckets)
+	if value := os.Getenv(EnvVarPrefix + "PROBE_BUCKETS"); value != "" {
+		probeBuckets = parseBuckets(value)
+	}
+
+	// Dry run (-n, --dry-run)
+	if value := os.Getenv(EnvVarPrefix + "DRY_RUN"); value == "true" {
+		dryRunEnabled = true
+	}
+
+	// Dry run format (--dry-run-format)
+	if value := os.Getenv(EnvVarPrefix + "DRY_RUN_FORMAT"); value != "" {
+		if value != "yaml" && value != "json" {
+			fatalWith(ExitConfig, "Cannot parse env var, must be yaml or json", "env", EnvVarPrefix+"DRY_RUN_FORMAT", "value", value)
+		}
+		dryRunFormat = value
+	}
+
+	// Confirmation before running the command (--require-confirm)
+	if value := os.Getenv(EnvVarPrefix + "REQUIRE_CONFIRM"); value == "true" {
+		requireConfirm = true
+	}
+
+	// Deny patterns (--deny, --deny-file)
+	if value := os.Getenv(EnvVarPrefix + "DENY"); value != "" {
+		for _, pattern := range strings.Split(value, ";") {
+			addDenyPattern(pattern)
+		}
+	}
+	if value, ok := os.LookupEnv(EnvVarPrefix + "DENY_FILE"); ok {
+		denyFile = value
+	}
+
+	// User running the command (--user)
+	if value := os.Getenv(EnvVarPrefix + "USER"); value != "" {
+		commandUser = value
+	}
+
+	// Run without confirmation (-y, --yes)
+	if value := os.Getenv(EnvVarPrefix + "YES"); value == "true" {
+		assumeYes = true
+	}
+
+	// Summary JSON target (--summary-json)
+	if value := os.Getenv(EnvVarPrefix + "SUMMARY_JSON"); value != "" {
+		summaryJsonTarget = value
+	}
+
+	// Manifest file (--manifest)
+	if value := os.Getenv(EnvVarPrefix + "MANIFEST"); value != "" {
+		manifestFile = value
+	}
+
+	// Live stream of the samples (--stream)
+	if value := os.Getenv(EnvVarPrefix + "STREAM"); value != "" {
+		streamListen = value
+	}
+
+	// Result file encryption (--encrypt)
+	if value := os.Getenv(EnvVarPrefix + "ENCRYPT"); value != "" {
+		encryptTarget = parseEncryptTarget(value)
+	}
+
+	// Assertions, semicolon separated (--assert)
+	if value := os.Getenv(EnvVarPrefix + "ASSERT"); value != "" {
+		for _, expression := range strings.Split(value, ";") {
+			assertions = append(assertions, parseAssertion(expression))
 		}
-		delayAfterCommand = timeToWaitInScd
+	}
+
+	// Notifications (--notify, --notify-on, --dashboard-url)
+	if value := os.Getenv(EnvVarPrefix + "NOTIFY"); value != "" {
+		for _, target := range strings.Split(value, ";") {
+			notifyTargets = append(notifyTargets, parseNotifyTarget(target))
+		}
+	}
+	if value := os.Getenv(EnvVarPrefix + "NOTIFY_ON"); value != "" {
+		notifyOn = parseNotifyOn(value)
+	}
+	if value := os.Getenv(EnvVarPrefix + "DASHBOARD_URL"); value != "" {
+		dashboardUrl = value
+	}
+
+	// Email report (--email-to, --email-from, --smtp-server, --smtp-user), the password is only read from env
+	if value := os.Getenv(EnvVarPrefix + "EMAIL_TO"); value != "" {
+		addEmailRecipients(value)
+	}
+	if value := os.Getenv(EnvVarPrefix + "EMAIL_FROM"); value != "" {
+		emailFrom = value
+	}
+	if value := os.Getenv(EnvVarPrefix + "SMTP_SERVER"); value != "" {
+		smtpServer = value
+	}
+	if value := os.Getenv(EnvVarPrefix + "SMTP_USER"); value != "" {
+		smtpUser = value
+	}
+	if value := os.Getenv(EnvVarPrefix + "SMTP_PASSWORD"); value != "" {
+		smtpPassword = value
+	}
+
+	// JUnit report (--junit)
+	if value := os.Getenv(EnvVarPrefix + "JUNIT"); value != "" {
+		junitFile = value
+	}
+
+	// CI summary target (--ci-summary)
+	if value := os.Getenv(EnvVarPrefix + "CI_SUMMARY"); value != "" {
+		ciSummaryTarget = value
+	}
+
+	// Baseline result file (--baseline)
+	if value := os.Getenv(EnvVarPrefix + "BASELINE"); value != "" {
+		baselineFile = value
+	}
+
+	// Quiet mode (-q, --quiet)
+	if value := os.Getenv(EnvVarPrefix + "QUIET"); value == "true" {
+		logLevel.Set(slog.LevelError)
 	}
 
 	// Get extra labels from environment variables (-l, --label)
 	parseExtraLabelsFromEnv()
+
+	// Loki url (--loki-url)
+	if value := os.Getenv(EnvVarPrefix + "LOKI_URL"); value != "" {
+		lokiUrl = value
+	}
+
+	// Scheduled start time (--start-at)
+	if value := os.Getenv(EnvVarPrefix + "START_AT"); value != "" {
+		startAt = parseStartAt(value)
+	}
+
+	// Triggers, semicolon separated (--trigger)
+	if value := os.Getenv(EnvVarPrefix + "TRIGGER"); value != "" {
+		for _, expression := range strings.Split(value, ";") {
+			triggers = append(triggers, parseTrigger(expression))
+		}
+	}
+
+	// Stop conditions, semicolon separated (--stop-when)
+	if value := os.Getenv(EnvVarPrefix + "STOP_WHEN"); value != "" {
+		for _, expression := range strings.Split(value, ";") {
+			stopConditions = append(stopConditions, parseStopCondition(expression))
+		}
+	}
+
+	// Command duration (--duration)
+	if value := os.Getenv(EnvVarPrefix + "DURATION"); value != "" {
+		commandDuration = parseCommandDuration(value)
+	}
+
+	// Retries of a failed command (--retries)
+	if value := os.Getenv(EnvVarPrefix + "RETRIES"); value != "" {
+		commandRetries = parseRetries(value)
+	}
+
+	// Delay before a retry (--retry-backoff)
+	if value := os.Getenv(EnvVarPrefix + "RETRY_BACKOFF"); value != "" {
+		retryBackoff = parseDelay(EnvVarPrefix+"RETRY_BACKOFF", value)
+	}
+
+	// Lifecycle hooks (--hook)
+	if value := os.Getenv(EnvVarPrefix + "HOOK"); value != "" {
+		for _, hook := range strings.Split(value, ";") {
+			hooks = append(hooks, parseHook(hook))
+		}
+	}
+
+	// Sources of automatic labels (--auto-labels)
+	if value := os.Getenv(EnvVarPrefix + "AUTO_LABELS"); value != "" {
+		parseAutoLabels(value)
+	}
+
+	// Prefix of reserved extra labels (--reserved-label-prefix)
+	if value, ok := os.LookupEnv(EnvVarPrefix + "RESERVED_LABEL_PREFIX"); ok {
+		reservedLabelPrefix = value
+	}
 }
 
-func addLabel(key string, value string) {
-	// List of forbidden label names
-	forbiddenKeys := []string{"instance", "job", "cpu", "mode", "interface"}
+// Label names used by statexec itself, extra labels with these names are prefixed
+var reservedLabels = []string{"instance", "job", "role", "cpu", "mode", "interface", "disk", "mountpoint", "device", "fstype", "phase", "export", "op", "protocol",
+	"operstate", "duplex", "speed_mbps", "mtu", "node", "gc", "model", "serial", "event", "probe", "type", "le", "collector", "irq", "queue", "direction", "stat", "scheduler", "rotational", "memory_locked",
+	"sync_role", "sync_peer", "sync_session", "sync_node", "source_address", "gateway", "backend", "feature", "wait", "attempt", "action", "setting", "driver", "governor", "control", "hypervisor", "container", "cloud",
+	"hostname", "os", "platform", "platform_version", "kernel", "arch", "cpus", "mem_bytes",
+	"version", "goversion", "goos", "goarch", "revision", "static"}
+
+func isReservedLabel(key string) bool {
+	for _, reservedLabel := range reservedLabels {
+		if key == reservedLabel {
+			return true
+		}
+	}
+	return false
+}
 
+func addLabel(key string, value string) {
 	// Replace non-alphanumeric characters with underscores
 	safeKey := regexp.MustCompile(`[^a-zA-Z0-9]`).ReplaceAllString(key, "_")
 
-	// Check if key is not forbidden
-	for _, forbiddenKey := range forbiddenKeys {
-		if safeKey == forbiddenKey {
-			fmt.Printf("Override label %s is forbidden", key)
-			os.Exit(1)
+	extraLabels[strings.ToLower(safeKey)] = value
+}
+
+// Prefix extra labels conflicting with labels used by statexec, e.g. cpu is exported as label_cpu
+func namespaceReservedLabels() {
+	for key, value := range extraLabels {
+		if !isReservedLabel(key) {
+			continue
 		}
+		namespacedKey := reservedLabelPrefix + key
+		if _, exists := extraLabels[namespacedKey]; exists || isReservedLabel(namespacedKey) {
+			fatalWith(ExitConfig, "Label is reserved and cannot be prefixed", "label", key, "prefix", reservedLabelPrefix)
+		}
+		logger.Warn("Label is reserved, exported with a prefix", "label", key, "exported_as", namespacedKey)
+		delete(extraLabels, key)
+		extraLabels[namespacedKey] = value
 	}
+}
 
-	extraLabels[strings.ToLower(safeKey)] = value
+// Parse a delay, a duration like 1.5s or 500ms, a bare number is in seconds as in older versions
+func parseDelay(name string, value string) time.Duration {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		// Inf, NaN and numbers beyond the 292 years a duration holds are not delays
+		if math.IsNaN(seconds) || seconds < 0 || seconds*float64(time.Second) >= math.MaxInt64 {
+			fatalWith(ExitConfig, "Cannot parse delay, expected a duration like 1.5s or 500ms, or a number of seconds", name, value)
+		}
+		return time.Duration(seconds * float64(time.Second))
+	}
+	delay, err := time.ParseDuration(value)
+	if err != nil || delay < 0 {
+		fatalWith(ExitConfig, "Cannot parse delay, expected a duration like 1.5s or 500ms, or a number of seconds", name, value)
+	}
+	return delay
+}
+
+// Interfaces of --ethtool, comma separated
+func parseEthtoolInterfaces(value string) []string {
+	var interfaces []string
+	for _, iface := range strings.Split(value, ",") {
+		if iface = strings.TrimSpace(iface); iface != "" {
+			interfaces = append(interfaces, iface)
+		}
+	}
+	return interfaces
+}
+
+// Parse a list of collectors : "cpu,memory" enables only those, "+nfs,-disk" adds or removes from the current selection
+func parseCollectors(value string) {
+	for index, collector := range strings.Split(value, ",") {
+		collector = strings.TrimSpace(collector)
+		name := strings.TrimLeft(collector, "+-")
+
+		known := false
+		for _, availableCollector := range availableCollectors {
+			if name == availableCollector {
+				known = true
+			}
+		}
+		if !known {
+			fatalWith(ExitConfig, "Unknown collector", "collector", name, "available", strings.Join(availableCollectors, ","))
+		}
+
+		switch {
+		case strings.HasPrefix(collector, "+"):
+			enabledCollectors[name] = true
+		case strings.HasPrefix(collector, "-"):
+			enabledCollectors[name] = false
+		default:
+			// A plain list replaces the current selection
+			if index == 0 {
+				for key := range enabledCollectors {
+					enabledCollectors[key] = false
+				}
+			}
+			enabledCollectors[name] = true
+		}
+	}
+}
+
+// Names of enabled collectors, in the order of available collectors
+func enabledCollectorNames() []string {
+	var names []string
+	for _, collector := range availableCollectors {
+		if enabledCollectors[collector] {
+			names = append(names, collector)
+		}
+	}
+	return names
 }
 
 func parseExtraLabelsFromEnv() map[string]string {
@@ -355,8 +1234,7 @@ func parseExtraLabelsFromEnv() map[string]string {
 				value := parts[1]
 				addLabel(key, value)
 			} else {
-				fmt.Println("Error parsing label of ENV :", env)
-				os.Exit(1)
+				fatalWith(ExitConfig, "Cannot parse label env var", "env", env)
 			}
 		}
 	}
@@ -366,24 +1244,44 @@ func parseExtraLabelsFromEnv() map[string]string {
 func syncStartCommand(cmd *exec.Cmd, syncServerUrl string, syncStop bool) {
 
 	// Sending start sync at server
-	_, err := http.Post(syncServerUrl+"/start", "text/plain", nil)
+	sentAt := time.Now()
+	resp, err := postSync(syncServerUrl + "/start")
 	if err != nil {
-		fmt.Println("Error sending start sync request:", err)
-		os.Exit(1)
+		fatalWith(ExitSync, "Cannot send start sync request", "server", syncServerUrl, "error", err)
+	}
+	recordWait("sync_start", sentAt)
+	useLeaderTime(resp.Header.Get(syncStartTimeHeader), time.Since(sentAt))
+
+	// Join the session of the server, older servers have none
+	setSyncLabels(serverIp, resp.Header.Get(syncSessionHeader))
+	leaderAddress = followerLeaderAddress(resp.Header.Get(syncLeaderAddressHeader))
+	if index, err := strconv.Atoi(resp.Header.Get(syncNodeIndexHeader)); err == nil {
+		syncNodeIndex = index
 	}
 
+	// Send heartbeats while the command runs, following the session state, when the server waits for the stop
+	if interval, ok := parseHeartbeatHeader(resp.Header.Get(syncHeartbeatHeader)); ok {
+		syncHeartbeat = interval
+		sessionLeaderUrl = syncServerUrl
+	}
+	leaderAborts = resp.Header.Get(syncAbortHeader) == "true"
+
 	// Start the command
 	startCommand(cmd)
 
-	// Check if we need to sync the stop to the server
-	if syncStop {
-		// Sending stop sync at server
-		_, err := http.Post(syncServerUrl+"/stop", "text/plain", nil)
+	// Check if we need to sync the stop to the server, unless it was lost
+	if syncStop && session.abortReason() != lostLeaderReason {
+		// Sending stop sync at server, with the exit code of the command
+		_, err := postSync(syncServerUrl + "/stop?exit_code=" + strconv.Itoa(commandExitCode))
 		if err != nil {
-			fmt.Println("Error sending stop sync request:", err)
-			os.Exit(1)
+			fatalWith(ExitSync, "Cannot send stop sync request", "server", syncServerUrl, "error", err)
 		}
 	}
+
+	// The server aborts the whole session on this failure
+	if leaderAborts && commandExitCode != 0 {
+		session.abort("command failed with exit code " + strconv.Itoa(commandExitCode))
+	}
 }
 
 func waitForHttpSyncToStartCommand(cmd *exec.Cmd, waitForStop bool) {
@@ -392,29 +1290,95 @@ func waitForHttpSyncToStartCommand(cmd *exec.Cmd, waitForStop bool) {
 	var wg sync.WaitGroup
 	var cmdStarted = false
 	var cmdFinished = false
+	var listeningAt = time.Now()
 
 	server := &http.Server{
-		Addr: ":" + syncPort,
+		Addr: syncListenAddress(),
 	}
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintf(w, `<html><body><a href="/start">/start</a> : Start the command</body></html>`)
-	})
+	// Shutdown the server once the command and every follower are done
+	shutdown := func() {
+		// Create a context with a timeout
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		wg.Wait()
+
+		// Shutdown the server gracefully
+		if err := server.Shutdown(ctx); err != nil {
+			fatalWith(ExitSync, "Cannot shut down the sync server", "address", server.Addr, "error", err)
+		}
+	}
+
+	// Fail the session, interrupting the command, followers terminate theirs when polling the session
+	abort := func(reason string) {
+		if !session.abort(reason) {
+			return
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		if cmdStarted && !cmdFinished {
+			cmd.Process.Signal(os.Interrupt)
+		}
+	}
+	watchDone := make(chan struct{})
+	defer close(watchDone)
+	if waitForStop {
+		go watchFollowers(watchDone, func(follower string) {
+			abort("lost contact with follower " + follower)
+			if session.leave(follower) == 0 {
+				go shutdown()
+			}
+		})
+	}
+
+	http.HandleFunc("/session", handleSessionState)
+
+	http.HandleFunc("/", handleLivePage)
+	http.HandleFunc("/live", handleLiveSocket)
+
+	http.HandleFunc("/spec", handleFollowerSpec)
 
 	http.HandleFunc("/start", func(w http.ResponseWriter, r *http.Request) {
 		mutex.Lock()
 		defer mutex.Unlock()
 
+		// Every follower joins the session, including those arriving once the command started
+		w.Header().Set(syncSessionHeader, runId)
+		nodeIndex := session.join(syncFollowerId(r), r.Header.Get(syncFollowerHeader) != "")
+		w.Header().Set(syncNodeIndexHeader, strconv.Itoa(nodeIndex))
+		w.Header().Set(syncLeaderAddressHeader, syncLocalAddress(r))
+		if waitForStop {
+			w.Header().Set(syncHeartbeatHeader, syncHeartbeat.String())
+		}
+		if abortOnFailure {
+			w.Header().Set(syncAbortHeader, "true")
+		}
+
+		// Time base of the session, fixed now so the followers can offset their metrics to it
+		if !cmdStarted && metricsStartTimeOverride == -1 {
+			metricsStartTimeOverride = time.Now().UnixMilli()
+		}
+		w.Header().Set(syncStartTimeHeader, strconv.FormatInt(metricsStartTimeOverride, 10))
+
 		if cmdStarted {
 			w.WriteHeader(http.StatusConflict)
 			fmt.Fprintf(w, "KO")
 		} else {
 			wg.Add(1)
+			cmdStarted = true
+			recordWait("sync_start", listeningAt)
+			setSyncLabels(syncPeerAddress(r), runId)
+			leaderAddress = syncLocalAddress(r)
 			// Start the command in a goroutine
 			go func() {
-				cmdStarted = true
 				startCommand(cmd)
+				mutex.Lock()
 				cmdFinished = true
+				mutex.Unlock()
+				if abortOnFailure && commandExitCode != 0 {
+					abort("command of the leader failed with exit code " + strconv.Itoa(commandExitCode))
+				}
 				wg.Done()
 
 				if !waitForStop {
@@ -428,38 +1392,48 @@ func waitForHttpSyncToStartCommand(cmd *exec.Cmd, waitForStop bool) {
 	})
 
 	http.HandleFunc("/stop", func(w http.ResponseWriter, r *http.Request) {
+		follower := syncFollowerId(r)
+		if exitCode, err := strconv.Atoi(r.URL.Query().Get("exit_code")); err == nil && exitCode != 0 && abortOnFailure {
+			abort(fmt.Sprintf("command of follower %s failed with exit code %d", follower, exitCode))
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
 		if cmdStarted {
+			// The command runs until the last follower stops
+			if session.leave(follower) > 0 {
+				w.WriteHeader(http.StatusAccepted)
+				fmt.Fprintf(w, "Waiting for other followers to stop")
+				return
+			}
 			if cmdFinished {
 				w.WriteHeader(http.StatusNoContent)
 				fmt.Fprintf(w, "Command already finished")
 			} else {
 				w.WriteHeader(http.StatusAccepted)
+				cancelRetries()
 				cmd.Process.Signal(os.Interrupt)
 				fmt.Fprintf(w, "Command stopped")
 			}
 
-			go func() {
-				// Create a context with a timeout
-				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-				defer cancel()
-
-				wg.Wait()
-
-				// Shutdown the server gracefully
-				if err := server.Shutdown(ctx); err != nil {
-					panic(err)
-				}
-			}()
+			go shutdown()
 
 		} else {
 			w.WriteHeader(http.StatusPreconditionFailed)
 			fmt.Fprintf(w, "Command not started yet")
 		}
 	})
-	err := server.ListenAndServe()
+	listener, err := listenSync()
+	if err != nil {
+		fatalWith(ExitSync, "Cannot start the sync server", "address", server.Addr, "error", err)
+	}
+	if path, ok := syncSocketPath(server.Addr); ok {
+		defer os.Remove(path)
+	}
+	err = server.Serve(listener)
 	if err != nil && err != http.ErrServerClosed {
-		fmt.Println("Error starting the server:", err)
-		os.Exit(1)
+		fatalWith(ExitSync, "Cannot start the sync server", "address", server.Addr, "error", err)
 	}
 }
 
@@ -467,6 +1441,10 @@ func startCommand(cmd *exec.Cmd) {
 	var err error
 	var wg sync.WaitGroup
 
+	// Placeholders refer to the sync handshake, done by now
+	expandCommandTemplate(cmd)
+	restrictCommandPrivileges(cmd)
+
 	realStartTime := time.Now()
 
 	if metricsStartTimeOverride != -1 {
@@ -474,12 +1452,32 @@ func startCommand(cmd *exec.Cmd) {
 	} else {
 		metricsStartTime = realStartTime.UnixMilli()
 	}
+	setWaitTimeBase(realStartTime)
+
+	// Snapshot host inventory before the run
+	collectInventoryBeforeRun(metricsStartTime)
+	addBuildInfoMetric(metricsStartTime)
+	addNormalizeMetrics(metricsStartTime)
+	recordPeerRoute(metricsStartTime)
 
 	// Connect the command's standard input/output/error to those of the program
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
+	// Also push the command output to Loki, line by line
+	var lokiWriters []*LokiWriter
+	if lokiUrl != "" {
+		stdoutWriter := newLokiWriter("stdout", realStartTime)
+		stderrWriter := newLokiWriter("stderr", realStartTime)
+		lokiWriters = append(lokiWriters, stdoutWriter, stderrWriter)
+		cmd.Stdout = io.MultiWriter(os.Stdout, stdoutWriter)
+		cmd.Stderr = io.MultiWriter(os.Stderr, stderrWriter)
+	}
+
+	// Serve the samples live while they are collected
+	startStreamServer()
+
 	// Channel to signal when to stop gathering metrics
 	quit := make(chan struct{})
 	defer close(quit)
@@ -491,10 +1489,26 @@ func startCommand(cmd *exec.Cmd) {
 		startMetricCollectLoop(quit)
 	}()
 
+	// Start active probes
+	startProbes(realStartTime)
+
 	// Wait before starting the command
 	if delayBeforeCommand > 0 {
-		time.Sleep(time.Duration(delayBeforeCommand) * time.Second)
+		waitStartedAt := time.Now()
+		time.Sleep(delayBeforeCommand)
+		recordWait("delay_before", waitStartedAt)
+	}
+	if !startAt.IsZero() {
+		waitStartedAt := time.Now()
+		waitForScheduledStart()
+		recordWait("schedule", waitStartedAt)
+	}
+	if len(triggers) > 0 {
+		waitStartedAt := time.Now()
+		waitForTrigger()
+		recordWait("trigger", waitStartedAt)
 	}
+	runHooks("pre-start", cmd, realStartTime)
 
 	// Catch interrupt signal and forward it to the child process
 	sigs := make(chan os.Signal, 1)
@@ -502,74 +1516,151 @@ func startCommand(cmd *exec.Cmd) {
 
 	go func() {
 		sig := <-sigs
+		cancelRetries()
 		// Transmettre le signal SIGINT au processus enfant
-		if err := cmd.Process.Signal(sig); err != nil {
-			panic(err)
+		if err := cmd.Process.Signal(sig); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			fatalWith(ExitCommand, "Cannot forward the interrupt to the command", "pid", cmd.Process.Pid, "error", err)
 		}
 	}()
 
-	// Start the command
-	err = cmd.Start()
-	if err != nil {
-		fmt.Println("Error starting command:", err)
-		os.Exit(1)
+	// Subscribe to process events before the command forks anything
+	if traceChildren {
+		if err := collectors.StartChildrenTracker(); err != nil {
+			logger.Warn("Cannot trace the processes of the command, needs CAP_NET_ADMIN", "error", err)
+			traceChildren = false
+		}
 	}
 
-	commandState = CommandStatusRunning
-	commandStartedAtTime := time.Now().UnixMilli() - realStartTime.UnixMilli()
-	collectInstantMetrics(commandStartedAtTime)
+	// Start the command, again while it fails and retries are left
+	var commandStartedAt, commandFinishedAt int64
+	for commandAttempt = 1; ; commandAttempt++ {
+		err = startRestricted(cmd)
+		if err != nil {
+			fatalWith(ExitCommand, "Cannot start command", "command", cmd.String(), "error", err)
+		}
+		if traceChildren {
+			collectors.TrackChildren(cmd.Process.Pid)
+		}
 
-	// Annotate the command start
-	currentTimestamp := metricsStartTime + commandStartedAtTime
-	annotationStore = append(annotationStore, GrafanaAnnotation{
-		Time:    currentTimestamp,
-		TimeEnd: currentTimestamp,
-		Text:    "Command started",
-		Tags: []string{
-			"statexec",
-			"start",
-			"instance=" + instance,
-			"job=" + jobName,
-			"role=" + role,
-		},
-	})
+		startedAt := time.Now()
+		store.SetCommandStatus(CommandStatusRunning)
+		logger.Debug("Command started", "command", cmd.String(), "pid", cmd.Process.Pid, "attempt", commandAttempt)
+		commandStartedAt = metricsStartTime + startedAt.UnixMilli() - realStartTime.UnixMilli()
+		if commandAttempt == 1 {
+			recordStartSkew(startedAt, commandStartedAt)
+		}
+		recordCommandEvent("start", commandStartedAt)
 
-	// Wait for the command to finish
-	_ = cmd.Wait()
+		// Annotate the command start
+		startText := "Command started"
+		if commandAttempt > 1 {
+			startText += " (attempt " + strconv.Itoa(commandAttempt) + ")"
+		}
+		store.AddAnnotation(GrafanaAnnotation{
+			Time:    commandStartedAt,
+			TimeEnd: commandStartedAt,
+			Text:    startText,
+			Tags: []string{
+				"statexec",
+				"start",
+				"instance=" + instance,
+				"job=" + jobName,
+				"role=" + role,
+				"hostname=" + hostname,
+				"run_id=" + runId,
+			},
+		})
+
+		// Wait for the command to finish, or a stop condition to terminate it
+		stopWatchDone := make(chan struct{})
+		go watchStopConditions(cmd, stopWatchDone)
+		if sessionLeaderUrl != "" {
+			go watchSyncSession(sessionLeaderUrl, cmd, stopWatchDone)
+		}
+		if commandAttempt == 1 {
+			runHooks("post-start", cmd, realStartTime)
+		}
+		_ = cmd.Wait()
+		close(stopWatchDone)
+		commandFinishedAt = metricsStartTime + time.Now().UnixMilli() - realStartTime.UnixMilli()
+
+		exitCode := cmd.ProcessState.ExitCode()
+		recordCommandAttempt(exitCode, commandStartedAt, commandFinishedAt)
+		if !shouldRetry(exitCode) {
+			break
+		}
+		logger.Warn("Command failed, retrying", "command", cmd.String(), "exit_code", exitCode, "attempt", commandAttempt, "backoff", retryBackoff)
+		if !waitRetryBackoff() {
+			// Interrupted meanwhile, the failed attempt is the last one
+			break
+		}
+		recordCommandEvent("end", commandFinishedAt)
+		annotateFailedAttempt(exitCode, commandFinishedAt)
+		resetCommand(cmd)
+	}
 
-	commandState = CommandStatusDone
-	commandFinishedAtTime := time.Now().UnixMilli() - realStartTime.UnixMilli()
-	collectInstantMetrics(commandFinishedAtTime)
+	store.SetCommandStatus(CommandStatusDone)
+	for _, lokiWriter := range lokiWriters {
+		lokiWriter.Flush()
+	}
+	commandExitCode = cmd.ProcessState.ExitCode()
+	commandUsage = commandResourceUsage(cmd.ProcessState)
+	if traceChildren && commandUsage != nil {
+		collectors.FinishChildren(commandUsage.UserCpuSeconds, commandUsage.SystemCpuSeconds)
+	}
+	doneText := "Command done with status " + strconv.Itoa(commandExitCode)
+	if stoppedByCondition.Load() {
+		// Expected end of the command, not a failure
+		commandExitCode = 0
+		doneText = "Command stopped by stop condition"
+	}
+	if reason := session.abortReason(); reason != "" {
+		doneText = "Command aborted, sync session failed: " + reason
+	}
+	collectPerfCounters()
+	logger.Debug("Command done", "command", cmd.String(), "exit_code", cmd.ProcessState.ExitCode())
+	recordCommandEvent("end", commandFinishedAt)
 
 	// Annotate the command end
-	currentTimestamp = metricsStartTime + commandFinishedAtTime
-	annotationStore = append(annotationStore, GrafanaAnnotation{
-		Time:    currentTimestamp,
-		TimeEnd: currentTimestamp,
-		Text:    "Command done with status " + strconv.Itoa(cmd.ProcessState.ExitCode()),
+	store.AddAnnotation(GrafanaAnnotation{
+		Time:    commandFinishedAt,
+		TimeEnd: commandFinishedAt,
+		Text:    doneText,
 		Tags: []string{
 			"statexec",
 			"done",
 			"instance=" + instance,
 			"job=" + jobName,
 			"role=" + role,
+			"hostname=" + hostname,
+			"run_id=" + runId,
 		},
 	})
 
 	// Wait after the command
 	if delayAfterCommand > 0 {
-		time.Sleep(time.Duration(delayAfterCommand) * time.Second)
+		waitStartedAt := time.Now()
+		time.Sleep(delayAfterCommand)
+		recordWait("delay_after", waitStartedAt)
 	}
+	runHooks("pre-stop", cmd, realStartTime)
+
+	// Snapshot slow-moving resources after the run
+	collectInventoryAfterRun(metricsStartTime + time.Now().UnixMilli() - realStartTime.UnixMilli())
 
-	// Signal to stop gathering metrics
+	// Stop probes, then signal to stop gathering metrics
+	stopProbes()
 	stopCollectingMetrics(quit)
 
-	// Wait for the metrics goroutine to finish
+	// Wait for the metrics goroutine to finish, the results are written by then
 	wg.Wait()
+	stopStreamServer()
+	runHooks("post-stop", cmd, realStartTime)
 }
 
 // Start gathering metrics with a 1 second interval
 func startMetricCollectLoop(quit chan struct{}) {
+	enableRealtime()
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 
@@ -584,7 +1675,38 @@ func startMetricCollectLoop(quit chan struct{}) {
 			msSinceStart += 1000
 			collectInstantMetrics(msSinceStart)
 			if stopGatheringNextIteration {
-				writeResultToFile()
+				measureOverhead(float64(msSinceStart) / 1000)
+				annotatePhases(metricsStartTime + msSinceStart)
+				exportRunResult()
+				warnCpuSteal()
+				if summaryJsonTarget != "" {
+					writeSummaryJson(summaryJsonTarget)
+				}
+				if len(assertions) > 0 {
+					assertionResults = evaluateAssertions(currentSummaryValues())
+				}
+				if checkEnabled {
+					toleranceResults = checkBaseline()
+					printCheckReport(toleranceResults)
+				}
+				if junitFile != "" {
+					writeJunit(junitFile)
+				}
+				if ciSummaryTarget != "" {
+					writeCiSummary(ciSummaryTarget)
+				}
+				if len(notifyTargets) > 0 {
+					sendNotifications()
+				}
+				if len(emailTo) > 0 {
+					sendEmailReport()
+				}
+				if encryptTarget != "" {
+					encryptResultFile(metricsFile)
+				}
+				if manifestFile != "" {
+					writeManifest(manifestFile)
+				}
 				return
 			}
 		case <-quit:
@@ -597,154 +1719,59 @@ func stopCollectingMetrics(quit chan struct{}) {
 	quit <- struct{}{}
 }
 
+func sortedLabelNames(labels map[string]string) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // Generate a string to render labels in prometheus format
 func renderLabels(metricsLabels map[string]string) string {
 	var result []string
 
-	// Static labels
-	result = append(result, fmt.Sprintf("instance=\"%s\"", instance))
-	result = append(result, fmt.Sprintf("job=\"%s\"", jobName))
-	result = append(result, fmt.Sprintf("role=\"%s\"", role))
+	// Values are escaped, they come from the command line, the environment or textfiles
+	label := func(key string, value string) string {
+		return key + "=\"" + promfile.EscapeLabelValue(value) + "\""
+	}
 
-	// Metrics labels
-	for key, value := range metricsLabels {
-		result = append(result, fmt.Sprintf("%s=\"%s\"", key, value))
+	// Static labels
+	result = append(result, label("instance", instance))
+	result = append(result, label("job", jobName))
+	result = append(result, label("role", role))
+	result = append(result, label("hostname", hostname))
+
+	// Metrics labels, sorted so the same series is always written the same way
+	for _, key := range sortedLabelNames(metricsLabels) {
+		result = append(result, label(key, redactLabelValue(key, metricsLabels[key])))
 	}
 
 	// Extra labels
-	for key, value := range extraLabels {
-		result = append(result, fmt.Sprintf("%s=\"%s\"", key, value))
+	for _, key := range sortedLabelNames(extraLabels) {
+		result = append(result, label(key, extraLabels[key]))
 	}
 	return strings.Join(result, ",")
 }
 
-// Gather metrics
-func collectInstantMetrics(msSinceStart int64) {
-	timeBeforeGathering := time.Now()
-	currentTimestamp := metricsStartTime + msSinceStart
-
-	instantMetric := InstantMetric{
-		cmdStatus:    commandState,
-		cpu:          collectors.CollectCpuMetrics(),
-		memory:       collectors.CollectMemoryMetrics(),
-		network:      collectors.CollectNetworkMetrics(),
-		disk:         collectors.CollectDiskMetrics(),
-		msSinceStart: msSinceStart,
-		timestamp:    currentTimestamp,
-	}
-	instantMetric.collectDuration = time.Since(timeBeforeGathering).Milliseconds()
-
-	// Add metric to store
-	metricStore = append(metricStore, instantMetric)
-}
-
-func computeSummary(firstMetricIndex int, lastMetricIndex int) string {
-	timestamp := metricStore[lastMetricIndex].timestamp
-	totalDuration := metricStore[lastMetricIndex].timestamp - metricStore[firstMetricIndex].timestamp
-	totalDurationSeconds := float64(totalDuration) / 1000.0
-
-	defaultLabels := renderLabels(nil)
-
-	summaryBuffer := "\n# Summary of metrics while command was running\n"
-
-	// CPU usage
-	cpuSumStart := make(map[string]float64)
-	for _, cpuMetric := range metricStore[firstMetricIndex].cpu {
-		for mode, cpuTime := range cpuMetric.CpuTimePerMode {
-			cpuSumStart[mode] += cpuTime
-		}
-	}
-	cpuSumStop := make(map[string]float64)
-	for _, cpuMetric := range metricStore[lastMetricIndex].cpu {
-		for mode, cpuTime := range cpuMetric.CpuTimePerMode {
-			cpuSumStop[mode] += cpuTime
-		}
-	}
-	for mode, cpuTimeStop := range cpuSumStop {
-		cpuMeanTime := (cpuTimeStop - cpuSumStart[mode]) / totalDurationSeconds
-		metricLabels := map[string]string{
-			"mode": mode,
-		}
-		summaryBuffer += fmt.Sprintf(MetricPrefix+"summary_cpu_mean_seconds{%s} %f %d\n", renderLabels(metricLabels), cpuMeanTime, timestamp)
-	}
-
-	numberOfCores := len(metricStore[firstMetricIndex].cpu)
-	summaryBuffer += fmt.Sprintf(MetricPrefix+"summary_cpu_cores{%s} %d %d\n", defaultLabels, numberOfCores, timestamp)
-
-	// Memory usage
-	var memorySumUsed uint64 = 0
-	var memorySumFree uint64 = 0
-	var memorySumBuffers uint64 = 0
-	var memorySumCached uint64 = 0
-	var numberOfMemorySamples = 0
-	for i := firstMetricIndex; i <= lastMetricIndex; i++ {
-		memorySumUsed += metricStore[i].memory.Used
-		memorySumFree += metricStore[i].memory.Free
-		memorySumBuffers += metricStore[i].memory.Buffers
-		memorySumCached += metricStore[i].memory.Cached
-		numberOfMemorySamples++
-	}
-	summaryBuffer += fmt.Sprintf(MetricPrefix+"summary_memory_used_bytes{%s} %d %d\n", defaultLabels, memorySumUsed/uint64(numberOfMemorySamples), timestamp)
-	summaryBuffer += fmt.Sprintf(MetricPrefix+"summary_memory_free_bytes{%s} %d %d\n", defaultLabels, memorySumFree/uint64(numberOfMemorySamples), timestamp)
-	summaryBuffer += fmt.Sprintf(MetricPrefix+"summary_memory_buffers_bytes{%s} %d %d\n", defaultLabels, memorySumBuffers/uint64(numberOfMemorySamples), timestamp)
-	summaryBuffer += fmt.Sprintf(MetricPrefix+"summary_memory_cached_bytes{%s} %d %d\n", defaultLabels, memorySumCached/uint64(numberOfMemorySamples), timestamp)
-	summaryBuffer += fmt.Sprintf(MetricPrefix+"summary_memory_total_bytes{%s} %d %d\n", defaultLabels, metricStore[lastMetricIndex].memory.Total, timestamp)
-
-	// Network counters
-	var networkSumSentTotalBytesStart uint64 = 0
-	var networkSumRecvTotalBytesStart uint64 = 0
-	for _, networkMetric := range metricStore[firstMetricIndex].network {
-		networkSumSentTotalBytesStart += networkMetric.SentTotalBytes
-		networkSumRecvTotalBytesStart += networkMetric.RecvTotalBytes
-	}
-	var networkSumSentTotalBytesStop uint64 = 0
-	var networkSumRecvTotalBytesStop uint64 = 0
-	for _, networkMetric := range metricStore[lastMetricIndex].network {
-		networkSumSentTotalBytesStop += networkMetric.SentTotalBytes
-		networkSumRecvTotalBytesStop += networkMetric.RecvTotalBytes
-	}
-	networkMeanRateSent := float64(networkSumSentTotalBytesStop-networkSumSentTotalBytesStart) / totalDurationSeconds
-	networkMeanRateRecv := float64(networkSumRecvTotalBytesStop-networkSumRecvTotalBytesStart) / totalDurationSeconds
-
-	summaryBuffer += fmt.Sprintf(MetricPrefix+"summary_network_mean_sent_bytes_per_second{%s} %f %d\n", defaultLabels, networkMeanRateSent, timestamp)
-	summaryBuffer += fmt.Sprintf(MetricPrefix+"summary_network_mean_received_bytes_per_second{%s} %f %d\n", defaultLabels, networkMeanRateRecv, timestamp)
-
-	// Disk monitoring
-	var diskSumReadBytesTotalStart uint64 = 0
-	var diskSumWriteBytesTotalStart uint64 = 0
-	for _, diskMetric := range metricStore[firstMetricIndex].disk {
-		diskSumReadBytesTotalStart += diskMetric.ReadBytesTotal
-		diskSumWriteBytesTotalStart += diskMetric.WriteBytesTotal
-	}
-	var diskSumReadBytesTotalStop uint64 = 0
-	var diskSumWriteBytesTotalStop uint64 = 0
-	for _, diskMetric := range metricStore[lastMetricIndex].disk {
-		diskSumReadBytesTotalStop += diskMetric.ReadBytesTotal
-		diskSumWriteBytesTotalStop += diskMetric.WriteBytesTotal
-	}
-	diskMeanRateRead := float64(diskSumReadBytesTotalStop-diskSumReadBytesTotalStart) / totalDurationSeconds
-	diskMeanRateWrite := float64(diskSumWriteBytesTotalStop-diskSumWriteBytesTotalStart) / totalDurationSeconds
-
-	summaryBuffer += fmt.Sprintf(MetricPrefix+"summary_disk_mean_read_bytes_per_second{%s} %f %d\n", defaultLabels, diskMeanRateRead, timestamp)
-	summaryBuffer += fmt.Sprintf(MetricPrefix+"summary_disk_mean_write_bytes_per_second{%s} %f %d\n", defaultLabels, diskMeanRateWrite, timestamp)
-
-	return summaryBuffer
-}
-
-func writeResultToFile() error {
-	defaultLabels := renderLabels(nil)
+// Render the annotations as comments of the result file, read back by import
+func renderAnnotations(annotations []GrafanaAnnotation) string {
+	annotationsBuffer := ""
+	for _, annotation := range annotations {
 
-	// Delete metrics file
-	_ = os.Remove(metricsFile)
+		annotationJson, err := json.Marshal(annotation)
+		if err != nil {
+			fatalWith(ExitOutput, "Cannot marshal annotation", "error", err)
+		}
 
-	// Open metrics file in append mode
-	resultFile, err := os.OpenFile(metricsFile, os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		fmt.Println("Error opening metrics file:", err)
-		os.Exit(1)
+		annotationsBuffer += "#grafana-annotation " + string(annotationJson) + "\n"
 	}
-	defer resultFile.Close()
+	return annotationsBuffer + "\n"
+}
 
+// Header of the result files: metadata comments, then the help and type of every metric
+func resultHeader() string {
 	urlSuffix := ""
 	if version != "dev" {
 		urlSuffix = "tree/" + version
@@ -752,10 +1779,18 @@ func writeResultToFile() error {
 	commentBlock := `
 # Collector: blackswift/statexec
 # Version: ` + version + `
+# Schema: ` + strconv.Itoa(promfile.SchemaVersion) + `
+# Build: ` + buildInfo.Platform() + ` ` + buildInfo.GoVersion + ` static=` + strconv.FormatBool(buildInfo.Static) + `
 # Url: https://github.com/blackswifthosting/statexec/` + urlSuffix + `
-
+` + capabilityComment() + configComment() + `
 # HELP statexec_command_status Status of the command (0: pending, 1: running, 2: done)
 # TYPE statexec_command_status gauge
+# HELP statexec_command_event Transition of the command lifecycle (start, end) at the time it happened
+# TYPE statexec_command_event gauge
+# HELP statexec_command_attempt_exit_code Exit code of an attempt of the command (--retries)
+# TYPE statexec_command_attempt_exit_code gauge
+# HELP statexec_command_attempt_duration_seconds Duration of an attempt of the command in seconds (--retries)
+# TYPE statexec_command_attempt_duration_seconds gauge
 # HELP statexec_cpu_seconds_total CPU time spent in seconds
 # TYPE statexec_cpu_seconds_total counter
 # HELP statexec_memory_total_bytes Total memory in bytes
@@ -772,6 +1807,34 @@ func writeResultToFile() error {
 # TYPE statexec_memory_cached_bytes gauge
 # HELP statexec_memory_used_percent Used memory in percent
 # TYPE statexec_memory_used_percent gauge
+# HELP statexec_memory_swap_total_bytes Total swap in bytes
+# TYPE statexec_memory_swap_total_bytes gauge
+# HELP statexec_memory_swap_used_bytes Used swap in bytes
+# TYPE statexec_memory_swap_used_bytes gauge
+# HELP statexec_memory_swap_in_bytes_total Total bytes swapped in from disk
+# TYPE statexec_memory_swap_in_bytes_total counter
+# HELP statexec_memory_swap_out_bytes_total Total bytes swapped out to disk
+# TYPE statexec_memory_swap_out_bytes_total counter
+# HELP statexec_memory_hugepages_total Total number of hugepages
+# TYPE statexec_memory_hugepages_total gauge
+# HELP statexec_memory_hugepages_free Number of free hugepages
+# TYPE statexec_memory_hugepages_free gauge
+# HELP statexec_memory_hugepages_reserved Number of reserved hugepages
+# TYPE statexec_memory_hugepages_reserved gauge
+# HELP statexec_memory_hugepages_surplus Number of surplus hugepages
+# TYPE statexec_memory_hugepages_surplus gauge
+# HELP statexec_memory_hugepage_size_bytes Size of a hugepage in bytes
+# TYPE statexec_memory_hugepage_size_bytes gauge
+# HELP statexec_numa_memory_total_bytes Total memory of a NUMA node in bytes
+# TYPE statexec_numa_memory_total_bytes gauge
+# HELP statexec_numa_memory_free_bytes Free memory of a NUMA node in bytes
+# TYPE statexec_numa_memory_free_bytes gauge
+# HELP statexec_numa_memory_used_bytes Used memory of a NUMA node in bytes
+# TYPE statexec_numa_memory_used_bytes gauge
+# HELP statexec_numa_hugepages_total Total number of hugepages of a NUMA node
+# TYPE statexec_numa_hugepages_total gauge
+# HELP statexec_numa_hugepages_free Number of free hugepages of a NUMA node
+# TYPE statexec_numa_hugepages_free gauge
 # HELP statexec_network_sent_bytes_total Total sent bytes
 # TYPE statexec_network_sent_bytes_total counter
 # HELP statexec_network_received_bytes_total Total received bytes
@@ -780,109 +1843,211 @@ func writeResultToFile() error {
 # TYPE statexec_disk_read_bytes_total counter
 # HELP statexec_disk_write_bytes_total Total written bytes
 # TYPE statexec_disk_write_bytes_total counter
-# HELP statexec_time_since_start_ms Milliseconds since monitoring start
-# TYPE statexec_time_since_start_ms gauge
-# HELP statexec_metric_collect_duration_ms Duration of the metric collection in milliseconds
-# TYPE statexec_metric_collect_duration_ms gauge
+# HELP statexec_nfs_read_bytes_total Total bytes read from the NFS server
+# TYPE statexec_nfs_read_bytes_total counter
+# HELP statexec_nfs_write_bytes_total Total bytes written to the NFS server
+# TYPE statexec_nfs_write_bytes_total counter
+# HELP statexec_nfs_ops_total Total NFS operations
+# TYPE statexec_nfs_ops_total counter
+# HELP statexec_nfs_retransmissions_total Total NFS RPC retransmissions
+# TYPE statexec_nfs_retransmissions_total counter
+# HELP statexec_nfs_major_timeouts_total Total NFS RPC major timeouts
+# TYPE statexec_nfs_major_timeouts_total counter
+# HELP statexec_nfs_rtt_seconds_total Total NFS RPC round trip time in seconds
+# TYPE statexec_nfs_rtt_seconds_total counter
+# HELP statexec_nfs_execute_seconds_total Total NFS RPC execution time in seconds (queue + rtt)
+# TYPE statexec_nfs_execute_seconds_total counter
+# HELP statexec_conntrack_entries Number of entries in the connection tracking table
+# TYPE statexec_conntrack_entries gauge
+# HELP statexec_conntrack_entries_limit Maximum number of entries in the connection tracking table
+# TYPE statexec_conntrack_entries_limit gauge
+# HELP statexec_udp_in_datagrams_total Total UDP datagrams received
+# TYPE statexec_udp_in_datagrams_total counter
+# HELP statexec_udp_out_datagrams_total Total UDP datagrams sent
+# TYPE statexec_udp_out_datagrams_total counter
+# HELP statexec_udp_no_ports_total Total UDP datagrams received for a port without listener
+# TYPE statexec_udp_no_ports_total counter
+# HELP statexec_udp_in_errors_total Total UDP datagrams that could not be delivered
+# TYPE statexec_udp_in_errors_total counter
+# HELP statexec_udp_receive_buffer_errors_total Total UDP datagrams dropped because of a full receive buffer
+# TYPE statexec_udp_receive_buffer_errors_total counter
+# HELP statexec_udp_send_buffer_errors_total Total UDP datagrams dropped because of a full send buffer
+# TYPE statexec_udp_send_buffer_errors_total counter
+# HELP statexec_udp_socket_drops_total Total UDP datagrams dropped, summed over open sockets
+# TYPE statexec_udp_socket_drops_total counter
+# HELP statexec_tcp_in_segments_total Total TCP segments received
+# TYPE statexec_tcp_in_segments_total counter
+# HELP statexec_tcp_out_segments_total Total TCP segments sent
+# TYPE statexec_tcp_out_segments_total counter
+# HELP statexec_tcp_retransmitted_segments_total Total TCP segments retransmitted
+# TYPE statexec_tcp_retransmitted_segments_total counter
+# HELP statexec_tcp_in_errors_total Total TCP segments received in error
+# TYPE statexec_tcp_in_errors_total counter
+# HELP statexec_kernel_file_handles_allocated Number of allocated file handles
+# TYPE statexec_kernel_file_handles_allocated gauge
+# HELP statexec_kernel_file_handles_max Maximum number of file handles
+# TYPE statexec_kernel_file_handles_max gauge
+# HELP statexec_kernel_inodes_allocated Number of allocated inodes
+# TYPE statexec_kernel_inodes_allocated gauge
+# HELP statexec_kernel_inodes_free Number of free inodes
+# TYPE statexec_kernel_inodes_free gauge
+# HELP statexec_kernel_entropy_available_bits Available entropy of the kernel random pool in bits
+# TYPE statexec_kernel_entropy_available_bits gauge
+# HELP statexec_clock_synchronized Whether the kernel clock is synchronized by chrony/ntpd (1) or not (0)
+# TYPE statexec_clock_synchronized gauge
+# HELP statexec_clock_offset_seconds Estimated offset of the kernel clock to its NTP reference in seconds
+# TYPE statexec_clock_offset_seconds gauge
+# HELP statexec_clock_max_error_seconds Maximum error of the kernel clock in seconds
+# TYPE statexec_clock_max_error_seconds gauge
+# HELP statexec_clock_estimated_error_seconds Estimated error of the kernel clock in seconds
+# TYPE statexec_clock_estimated_error_seconds gauge
+# HELP statexec_target_go_goroutines Number of goroutines of the command (--target-pprof)
+# TYPE statexec_target_go_goroutines gauge
+# HELP statexec_target_go_threads Number of OS threads created by the command (--target-pprof)
+# TYPE statexec_target_go_threads gauge
+# HELP statexec_target_go_heap_inuse_bytes Heap in use of the command in bytes (--target-pprof)
+# TYPE statexec_target_go_heap_inuse_bytes gauge
+# HELP statexec_target_go_gc_cycles_total Total GC cycles of the command (--target-pprof)
+# TYPE statexec_target_go_gc_cycles_total counter
+# HELP statexec_target_go_gc_pause_seconds_total Total GC pause time of the command in seconds (--target-pprof)
+# TYPE statexec_target_go_gc_pause_seconds_total counter
+# HELP statexec_target_jvm_heap_used_bytes Heap used by the JVM of the command in bytes (--jmx)
+# TYPE statexec_target_jvm_heap_used_bytes gauge
+# HELP statexec_target_jvm_heap_committed_bytes Heap committed by the JVM of the command in bytes (--jmx)
+# TYPE statexec_target_jvm_heap_committed_bytes gauge
+# HELP statexec_target_jvm_heap_max_bytes Maximum heap of the JVM of the command in bytes, -1 if undefined (--jmx)
+# TYPE statexec_target_jvm_heap_max_bytes gauge
+# HELP statexec_target_jvm_threads Number of live threads of the JVM of the command (--jmx)
+# TYPE statexec_target_jvm_threads gauge
+# HELP statexec_target_jvm_gc_collections_total Total collections per garbage collector of the JVM of the command (--jmx)
+# TYPE statexec_target_jvm_gc_collections_total counter
+# HELP statexec_target_jvm_gc_seconds_total Total collection time per garbage collector of the JVM of the command in seconds (--jmx)
+# TYPE statexec_target_jvm_gc_seconds_total counter
+# HELP statexec_cpu_thermal_throttle_events_total Total CPU thermal throttling events, summed over CPUs (x86 only)
+# TYPE statexec_cpu_thermal_throttle_events_total counter
+# HELP statexec_softirqs_total Softirqs handled per CPU and class (NET_RX, NET_TX, BLOCK, TIMER...)
+# TYPE statexec_softirqs_total counter
+# HELP statexec_interrupts_total Hardware interrupts handled per CPU and IRQ, with the device raising it
+# TYPE statexec_interrupts_total counter
+# HELP statexec_ethtool_queue_packets_total Packets per queue and direction of a network interface from its driver statistics (--ethtool)
+# TYPE statexec_ethtool_queue_packets_total counter
+# HELP statexec_ethtool_queue_bytes_total Bytes per queue and direction of a network interface from its driver statistics (--ethtool)
+# TYPE statexec_ethtool_queue_bytes_total counter
+# HELP statexec_ethtool_queue_drops_total Dropped packets per queue and direction of a network interface from its driver statistics (--ethtool)
+# TYPE statexec_ethtool_queue_drops_total counter
+# HELP statexec_ethtool_stat Other driver statistic of a network interface, as ethtool -S shows it (--ethtool)
+# TYPE statexec_ethtool_stat untyped
+# HELP statexec_command_processes_spawned_total Processes forked by the command and its descendants, threads excluded (--trace-children)
+# TYPE statexec_command_processes_spawned_total counter
+# HELP statexec_command_execs_total Programs executed by the command and its descendants (--trace-children)
+# TYPE statexec_command_execs_total counter
+# HELP statexec_command_processes_exited_total Processes of the command tree that exited (--trace-children)
+# TYPE statexec_command_processes_exited_total counter
+# HELP statexec_command_processes Processes of the command tree alive, the command included (--trace-children)
+# TYPE statexec_command_processes gauge
+# HELP statexec_command_cpu_seconds_total CPU time of the command tree in seconds, exited processes included (--trace-children)
+# TYPE statexec_command_cpu_seconds_total counter
+# HELP statexec_sync_peer_route_info Route toward the sync peer when the run starts (source_address, interface, gateway)
+# TYPE statexec_sync_peer_route_info gauge
+# HELP statexec_sync_peer_mtu_bytes MTU of the route toward the sync peer when the run starts, lowered by path MTU discovery
+# TYPE statexec_sync_peer_mtu_bytes gauge
+# HELP statexec_host_virtualization_info Hypervisor, container runtime and cloud provider of the host, none when not detected
+# TYPE statexec_host_virtualization_info gauge
+# HELP statexec_cpu_frequency_info Number of CPUs per cpufreq scaling driver and governor at the start of the run
+# TYPE statexec_cpu_frequency_info gauge
+# HELP statexec_cpu_turbo_enabled Whether turbo/boost is enabled (1) or not (0) at the start of the run
+# TYPE statexec_cpu_turbo_enabled gauge
+# HELP statexec_cpu_smt_info SMT control of the kernel, 1 if SMT is active, 0 if not, at the start of the run
+# TYPE statexec_cpu_smt_info gauge
+# HELP statexec_normalize_info Normalization action of the system before the run, 1 if done, 0 if it failed (--normalize)
+# TYPE statexec_normalize_info gauge
+# HELP statexec_realtime_info Scheduler of the collect loop and whether the memory of statexec is locked (--realtime)
+# TYPE statexec_realtime_info gauge
+# HELP statexec_collector_backend_info Source of the CPU and memory counters, /proc or a syscall fallback (--collector-backend)
+# TYPE statexec_collector_backend_info gauge
+# HELP statexec_collector_backend_feature Whether the collector backend provides a feature (1) or leaves its series out (0)
+# TYPE statexec_collector_backend_feature gauge
+# HELP statexec_host_info Host inventory (hostname, os, kernel, cpus, memory)
+# TYPE statexec_host_info gauge
+# HELP statexec_build_info Build of the statexec binary (version, revision, go version, os, architecture, static)
+# TYPE statexec_build_info gauge
+# HELP statexec_network_interface_info Network interface link state, duplex, negotiated speed (-1 if unknown) and MTU
+# TYPE statexec_network_interface_info gauge
+# HELP statexec_network_interface_speed_bytes Network interface negotiated speed in bytes per second
+# TYPE statexec_network_interface_speed_bytes gauge
+# HELP statexec_disk_queue_info Block device IO scheduler and rotational flag
+# TYPE statexec_disk_queue_info gauge
+# HELP statexec_disk_queue_requests Block device queue depth (nr_requests)
+# TYPE statexec_disk_queue_requests gauge
+# HELP statexec_disk_read_ahead_bytes Block device read-ahead in bytes
+# TYPE statexec_disk_read_ahead_bytes gauge
+# HELP statexec_disk_total_bytes Total disk space of a partition before and after the run
+# TYPE statexec_disk_total_bytes gauge
+# HELP statexec_disk_used_bytes Used disk space of a partition before and after the run
+# TYPE statexec_disk_used_bytes gauge
+# HELP statexec_disk_free_bytes Free disk space of a partition before and after the run
+# TYPE statexec_disk_free_bytes gauge
+# HELP statexec_command_start_skew_seconds Difference between the actual and the scheduled start time of the command in seconds (--start-at)
+# TYPE statexec_command_start_skew_seconds gauge
+# HELP statexec_disk_used_delta_bytes Used disk space difference of a partition between before and after the run
+# TYPE statexec_disk_used_delta_bytes gauge
+# HELP statexec_smart_info Storage device model, serial and protocol before and after the run (--smart)
+# TYPE statexec_smart_info gauge
+# HELP statexec_smart_passed SMART overall health self-assessment (1: passed) before and after the run (--smart)
+# TYPE statexec_smart_passed gauge
+# HELP statexec_smart_temperature_celsius Storage device temperature before and after the run (--smart)
+# TYPE statexec_smart_temperature_celsius gauge
+# HELP statexec_smart_power_on_hours Storage device power on hours before and after the run (--smart)
+# TYPE statexec_smart_power_on_hours gauge
+# HELP statexec_smart_media_errors NVMe media errors or ATA reallocated sectors before and after the run (--smart)
+# TYPE statexec_smart_media_errors gauge
+# HELP statexec_smart_percentage_used NVMe estimated percentage of device life used before and after the run (--smart)
+# TYPE statexec_smart_percentage_used gauge
+# HELP statexec_summary_wait_seconds Seconds statexec waited rather than running the command, by step: sync_start, delay_before, schedule, trigger, delay_after
+# TYPE statexec_summary_wait_seconds gauge
+# HELP statexec_summary_command_attempts Attempts of the command, the summary covers the last one (--retries)
+# TYPE statexec_summary_command_attempts gauge
+# HELP statexec_summary_command_max_rss_bytes Maximum resident set size of the command and its waited descendants, from wait4
+# TYPE statexec_summary_command_max_rss_bytes gauge
+# HELP statexec_summary_command_cpu_seconds CPU time of the command in user and system mode, from wait4
+# TYPE statexec_summary_command_cpu_seconds gauge
+# HELP statexec_summary_command_block_operations Block input and output operations of the command, from wait4
+# TYPE statexec_summary_command_block_operations gauge
+# HELP statexec_summary_command_context_switches Voluntary and involuntary context switches of the command, from wait4
+# TYPE statexec_summary_command_context_switches gauge
+# HELP statexec_summary_command_major_page_faults Page faults of the command which needed IO, from wait4
+# TYPE statexec_summary_command_major_page_faults gauge
+# HELP statexec_overhead_cpu_seconds CPU time statexec itself consumed in user and system mode, from getrusage
+# TYPE statexec_overhead_cpu_seconds gauge
+# HELP statexec_overhead_cpu_cores Mean CPU cores statexec itself used over the collection
+# TYPE statexec_overhead_cpu_cores gauge
+# HELP statexec_overhead_command_cpu_ratio CPU time of statexec over the CPU time of the command
+# TYPE statexec_overhead_command_cpu_ratio gauge
+# HELP statexec_overhead_max_rss_bytes Maximum resident set size of statexec itself, from getrusage
+# TYPE statexec_overhead_max_rss_bytes gauge
+# HELP statexec_summary_perf_counter Perf event count of the command (--perf)
+# TYPE statexec_summary_perf_counter gauge
+# HELP statexec_summary_perf_instructions_per_cycle Instructions per cycle of the command (--perf with cycles and instructions)
+# TYPE statexec_summary_perf_instructions_per_cycle gauge
+# HELP statexec_probe_success Whether the probe succeeded (1) or failed (0)
+# TYPE statexec_probe_success gauge
+# HELP statexec_probe_duration_seconds Duration of the probe in seconds (round trip time for icmp, connect time for tcp, request time for http, lookup time for dns)
+# TYPE statexec_probe_duration_seconds gauge
+# HELP statexec_probe_latency_seconds Duration of the successful probes in seconds, with --probe-buckets
+# TYPE statexec_probe_latency_seconds histogram
+# HELP statexec_probe_dns_nxdomain_total Total DNS lookups of the probe answered with NXDOMAIN
+# TYPE statexec_probe_dns_nxdomain_total counter
+# HELP statexec_probe_dns_failures_total Total DNS lookups of the probe failed for another reason (timeout, SERVFAIL, refused)
+# TYPE statexec_probe_dns_failures_total counter
+# HELP statexec_time_since_start_seconds Seconds since monitoring start
+# TYPE statexec_time_since_start_seconds gauge
+# HELP statexec_metric_collect_duration_seconds Duration of the metric collection in seconds
+# TYPE statexec_metric_collect_duration_seconds gauge
+# HELP statexec_collector_duration_seconds Duration of a collector in seconds, collectors run concurrently
+# TYPE statexec_collector_duration_seconds gauge
+# HELP statexec_collector_success Whether the collector finished before the timeout (1) or was left out of the sample (0)
+# TYPE statexec_collector_success gauge
 
 `
-	if _, err := resultFile.WriteString(commentBlock); err != nil {
-		fmt.Println("Error writing to metrics file:", err)
-		os.Exit(1)
-	}
-
-	// ====== Write annotation to file ======
-	annotationsBuffer := ""
-	for _, annotation := range annotationStore {
-
-		annotationJson, err := json.Marshal(annotation)
-		if err != nil {
-			fmt.Println("Error marshalling annotation:", err)
-			os.Exit(1)
-		}
-
-		annotationsBuffer += "#grafana-annotation " + string(annotationJson) + "\n"
-	}
-	annotationsBuffer += "\n"
-	if _, err := resultFile.WriteString(annotationsBuffer); err != nil {
-		fmt.Println("Error writing to metrics file:", err)
-		os.Exit(1)
-	}
-
-	var firstMetricWhileRunning int = -1
-	var lastMetricWhileRunning int = -1
-	// ====== Write metrics to file ======
-	for index, metric := range metricStore {
-		metricsBuffer := ""
-
-		if metric.cmdStatus == CommandStatusRunning {
-			if firstMetricWhileRunning == -1 {
-				firstMetricWhileRunning = index
-			}
-		}
-		if metric.cmdStatus == CommandStatusDone {
-			if lastMetricWhileRunning == -1 {
-				lastMetricWhileRunning = index
-			}
-		}
-
-		// Command status
-		metricsBuffer += fmt.Sprintf(MetricPrefix+"command_status{%s} %d %d\n", defaultLabels, metric.cmdStatus, metric.timestamp)
-
-		// CPU usage
-		for _, cpuMetric := range metric.cpu {
-			for mode, cpuTime := range cpuMetric.CpuTimePerMode {
-				metricLabels := map[string]string{
-					"cpu":  cpuMetric.Cpu,
-					"mode": mode,
-				}
-				metricsBuffer += fmt.Sprintf(MetricPrefix+"cpu_seconds_total{%s} %f %d\n", renderLabels(metricLabels), cpuTime, metric.timestamp)
-			}
-		}
-
-		// Memory usage
-		metricsBuffer += fmt.Sprintf(MetricPrefix+"memory_total_bytes{%s} %d %d\n", defaultLabels, metric.memory.Total, metric.timestamp)
-		metricsBuffer += fmt.Sprintf(MetricPrefix+"memory_available_bytes{%s} %d %d\n", defaultLabels, metric.memory.Available, metric.timestamp)
-		metricsBuffer += fmt.Sprintf(MetricPrefix+"memory_used_bytes{%s} %d %d\n", defaultLabels, metric.memory.Used, metric.timestamp)
-		metricsBuffer += fmt.Sprintf(MetricPrefix+"memory_free_bytes{%s} %d %d\n", defaultLabels, metric.memory.Free, metric.timestamp)
-		metricsBuffer += fmt.Sprintf(MetricPrefix+"memory_buffers_bytes{%s} %d %d\n", defaultLabels, metric.memory.Buffers, metric.timestamp)
-		metricsBuffer += fmt.Sprintf(MetricPrefix+"memory_cached_bytes{%s} %d %d\n", defaultLabels, metric.memory.Cached, metric.timestamp)
-		metricsBuffer += fmt.Sprintf(MetricPrefix+"memory_used_percent{%s} %f %d\n", defaultLabels, metric.memory.UsedPercent, metric.timestamp)
-
-		// Network counters
-		for _, networkMetric := range metric.network {
-			metricLabels := map[string]string{
-				"interface": networkMetric.Interface,
-			}
-			metricsBuffer += fmt.Sprintf(MetricPrefix+"network_sent_bytes_total{%s} %d %d\n", renderLabels(metricLabels), networkMetric.SentTotalBytes, metric.timestamp)
-			metricsBuffer += fmt.Sprintf(MetricPrefix+"network_received_bytes_total{%s} %d %d\n", renderLabels(metricLabels), networkMetric.RecvTotalBytes, metric.timestamp)
-		}
-
-		// Disk monitoring
-		for _, diskMetric := range metric.disk {
-			metricLabels := map[string]string{
-				"disk": diskMetric.Device,
-			}
-			renderedLabels := renderLabels(metricLabels)
-			metricsBuffer += fmt.Sprintf(MetricPrefix+"disk_read_bytes_total{%s} %d %d\n", renderedLabels, diskMetric.ReadBytesTotal, metric.timestamp)
-			metricsBuffer += fmt.Sprintf(MetricPrefix+"disk_write_bytes_total{%s} %d %d\n", renderedLabels, diskMetric.WriteBytesTotal, metric.timestamp)
-		}
-
-		// Self monitoring
-		metricsBuffer += fmt.Sprintf(MetricPrefix+"statexec_time_since_start_ms{%s} %d %d\n", defaultLabels, metric.msSinceStart, metric.timestamp)
-		metricsBuffer += fmt.Sprintf(MetricPrefix+"metric_collect_duration_ms{%s} %d %d\n", defaultLabels, metric.collectDuration, metric.timestamp)
-
-		// Write metrics to file
-		if _, err := resultFile.WriteString(metricsBuffer); err != nil {
-			fmt.Println("Error writing to metrics file:", err)
-			os.Exit(1)
-		}
-	}
-
-	if _, err := resultFile.WriteString(computeSummary(firstMetricWhileRunning, lastMetricWhileRunning)); err != nil {
-		fmt.Println("Error writing to metrics file:", err)
-		os.Exit(1)
-	}
-
-	return nil
+	return legacyHelpComment(commentBlock)
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Manifest of a run : integrity of the produced artifacts and the configuration used to produce them
+type Manifest struct {
+	RunId              string                  `json:"run_id"`
+	Version            string                  `json:"version"`
+	Build              BuildInfo               `json:"build"`
+	CreatedAt          string                  `json:"created_at"`
+	Hostname           string                  `json:"hostname"`
+	ExitCode           int                     `json:"exit_code"`
+	Config             EffectiveConfig         `json:"config"`
+	UnavailableMetrics []string                `json:"unavailable_metrics"`
+	EmptyCollectors    []string                `json:"empty_collectors"`
+	Realtime           *RealtimeStatus         `json:"realtime,omitempty"`
+	Normalize          []NormalizeResult       `json:"normalize,omitempty"`
+	CollectorBackend   *CollectorBackendStatus `json:"collector_backend,omitempty"`
+	Artifacts          []ManifestArtifact      `json:"artifacts"`
+}
+
+type ManifestArtifact struct {
+	Type      string `json:"type"`
+	Path      string `json:"path"`
+	SizeBytes int64  `json:"size_bytes"`
+	Sha256    string `json:"sha256"`
+}
+
+var (
+	manifestFile string = ""
+	runId        string = newRunId()
+	command      []string
+)
+
+// Random identifier of the run, shared by all artifacts it produces
+func newRunId() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		fatal("Cannot generate run id", "error", err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// Compute size and SHA-256 of a file
+func hashArtifact(artifactType string, path string) (ManifestArtifact, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return ManifestArtifact{}, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return ManifestArtifact{}, err
+	}
+	return ManifestArtifact{
+		Type:      artifactType,
+		Path:      path,
+		SizeBytes: size,
+		Sha256:    hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// Write the manifest once all artifacts are written
+func writeManifest(path string) {
+	manifest := Manifest{
+		RunId:              runId,
+		Version:            version,
+		Build:              buildInfo,
+		CreatedAt:          time.Now().UTC().Format(time.RFC3339),
+		Hostname:           hostname,
+		ExitCode:           commandExitCode,
+		Config:             redactConfig(effectiveConfig(command)),
+		UnavailableMetrics: unavailableMetricNames(),
+		EmptyCollectors:    emptyCollectors,
+		Realtime:           realtimeStatus,
+		Normalize:          normalizeResults,
+		CollectorBackend:   collectorBackendStatus,
+	}
+
+	artifacts := map[string]string{"metrics": metricsFile}
+	if logFile != "" {
+		artifacts["logs"] = logFile
+	}
+	if summaryJsonTarget != "" && summaryJsonTarget != "-" && !strings.HasPrefix(summaryJsonTarget, "fd:") {
+		artifacts["summary"] = summaryJsonTarget
+	}
+	if junitFile != "" {
+		artifacts["junit"] = junitFile
+	}
+	for _, artifactType := range []string{"metrics", "summary", "junit", "logs"} {
+		artifactPath, ok := artifacts[artifactType]
+		if !ok {
+			continue
+		}
+		artifact, err := hashArtifact(artifactType, artifactPath)
+		if err != nil {
+			fatalWith(ExitOutput, "Cannot hash artifact", "file", artifactPath, "error", err)
+		}
+		manifest.Artifacts = append(manifest.Artifacts, artifact)
+	}
+	if splitOutputDir != "" {
+		for _, group := range splitGroupNames() {
+			artifact, err := hashArtifact("metrics_"+group, splitResultPath(group))
+			if err != nil {
+				fatalWith(ExitOutput, "Cannot hash artifact", "file", splitResultPath(group), "error", err)
+			}
+			manifest.Artifacts = append(manifest.Artifacts, artifact)
+		}
+	}
+
+	manifestJson, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		fatalWith(ExitOutput, "Cannot marshal manifest", "error", err)
+	}
+	if err := writeFileAtomic(path, append(manifestJson, '\n')); err != nil {
+		fatalWith(ExitOutput, "Cannot write manifest", "file", path, "error", err)
+	}
+	logger.Debug("Manifest written", "file", path, "run_id", runId)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/blackswifthosting/statexec/promfile"
+)
+
+var mergeFlags = []string{"--output", "-o", "--source-label", "--rebase"}
+
+func mergeSubcommand(args []string) {
+	output := ""
+	sourceLabel := ""
+	rebase := false
+
+	files := []string{}
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-o", "--output":
+			output = flagValue(args, i)
+			i++
+		case "--source-label":
+			sourceLabel = flagValue(args, i)
+			i++
+		case "--rebase":
+			rebase = true
+		case "-h", "--help":
+			fmt.Printf("Usage: %s merge [OPTIONS] -o <merged.prom> <a.prom> <b.prom> [...]\n", os.Args[0])
+			fmt.Println("  --output, -o <file>       Merged result file")
+			fmt.Println("  --source-label <name>     Add a label with the source file name to all samples, when series are not disjoint")
+			fmt.Println("  --rebase                  Shift all runs so their commands start at the same time as the first one")
+			os.Exit(0)
+		default:
+			files = append(files, args[i])
+		}
+	}
+	if output == "" {
+		fatalWith(ExitConfig, "Merge needs an output file (-o)")
+	}
+	if len(files) < 2 {
+		fatalWith(ExitConfig, "Merge needs at least two result files")
+	}
+
+	merged := &promfile.File{}
+	seenComments := make(map[string]bool)
+	seenSeries := make(map[string]string)
+	var baseStartTime int64
+
+	for index, path := range files {
+		file, err := parseResultFile(path)
+		if err != nil {
+			fatal("Cannot parse result file", "file", path, "error", err)
+		}
+
+		// Shift the run onto the timeline of the first one
+		var shift int64
+		if rebase {
+			if index == 0 {
+				baseStartTime = file.StartTime()
+			}
+			shift = baseStartTime - file.StartTime()
+		}
+
+		source := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+		for _, comment := range file.Comments {
+			if !seenComments[comment] {
+				seenComments[comment] = true
+				merged.Comments = append(merged.Comments, comment)
+			}
+		}
+
+		for _, annotation := range file.Annotations {
+			annotation.Time += shift
+			annotation.TimeEnd += shift
+			if sourceLabel != "" {
+				annotation.Tags = append(annotation.Tags, sourceLabel+"="+source)
+			}
+			merged.Annotations = append(merged.Annotations, annotation)
+		}
+
+		// Series of one file must not collide with series of another one
+		fileSeries := make(map[string]bool)
+		for _, sample := range file.Samples {
+			sample.Timestamp += shift
+			if sourceLabel != "" {
+				if _, exists := sample.Labels[sourceLabel]; exists {
+					fatal("Source label already exists in result file", "label", sourceLabel, "file", path)
+				}
+				sample.Labels[sourceLabel] = source
+			}
+
+			key := sample.SeriesKey()
+			if otherPath, exists := seenSeries[key]; exists && otherPath != path {
+				fatal("Series are not disjoint, use --source-label or different instance names", "series", key, "files", otherPath+","+path)
+			}
+			fileSeries[key] = true
+			merged.Samples = append(merged.Samples, sample)
+		}
+		for key := range fileSeries {
+			seenSeries[key] = path
+		}
+		logger.Debug("Result file merged", "file", path, "samples", len(file.Samples), "shift_ms", shift)
+	}
+
+	if err := merged.WriteFile(output); err != nil {
+		fatalWith(ExitOutput, "Cannot write merged file", "file", output, "error", err)
+	}
+	logger.Info("Result files merged", "output", output, "files", len(files), "samples", len(merged.Samples))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"math"
+	"strings"
+
+	"github.com/blackswifthosting/statexec/collectors"
+)
+
+// Name of a self metric before it followed the Prometheus conventions, durations were in milliseconds
+type LegacyName struct {
+	name    string
+	help    string
+	scale   float64 // factor from seconds to the legacy unit
+	integer bool
+}
+
+var legacyNames bool = false // emit the metric names of older versions (--legacy-names)
+
+// Legacy names by conventional name, the time since start was prefixed twice and is kept as such for existing dashboards
+var legacyMetricNames = map[string]LegacyName{
+	"time_since_start_seconds":        {"statexec_time_since_start_ms", "Milliseconds since monitoring start", 1000, true},
+	"metric_collect_duration_seconds": {"metric_collect_duration_ms", "Duration of the metric collection in milliseconds", 1000, true},
+	"collector_duration_seconds":      {"collector_duration_ms", "Duration of a collector in milliseconds, collectors run concurrently", 1000, true},
+	"clock_offset_seconds":            {"clock_offset_ms", "Estimated offset of the kernel clock to its NTP reference in milliseconds", 1000, false},
+	"clock_max_error_seconds":         {"clock_max_error_ms", "Maximum error of the kernel clock in milliseconds", 1000, false},
+	"clock_estimated_error_seconds":   {"clock_estimated_error_ms", "Estimated error of the kernel clock in milliseconds", 1000, false},
+	"command_start_skew_seconds":      {"command_start_skew_ms", "Difference between the actual and the scheduled start time of the command in milliseconds (--start-at)", 1000, false},
+}
+
+// Name and value of a metric as written, its legacy name and unit with --legacy-names
+func metricName(name string, value float64, integer bool) (string, float64, bool) {
+	legacy, ok := legacyMetricNames[name]
+	if !legacyNames || !ok {
+		return name, value, integer
+	}
+	value *= legacy.scale
+	if legacy.integer {
+		value = math.Round(value)
+	}
+	return legacy.name, value, legacy.integer
+}
+
+// A sample under the name selected by --legacy-names
+func namedSample(sample collectors.Sample) collectors.Sample {
+	sample.Name, sample.Value, sample.Integer = metricName(sample.Name, sample.Value, sample.Integer)
+	return sample
+}
+
+// Rewrite the HELP and TYPE comments of the renamed metrics with --legacy-names
+func legacyHelpComment(comment string) string {
+	if !legacyNames {
+		return comment
+	}
+	lines := strings.Split(comment, "\n")
+	for i, line := range lines {
+		for name, legacy := range legacyMetricNames {
+			if strings.HasPrefix(line, "# HELP "+MetricPrefix+name+" ") {
+				lines[i] = "# HELP " + MetricPrefix + legacy.name + " " + legacy.help
+			} else if rest, ok := strings.CutPrefix(line, "# TYPE "+MetricPrefix+name+" "); ok {
+				lines[i] = "# TYPE " + MetricPrefix + legacy.name + " " + rest
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Normalization actions, applied in this order whatever the order given
+var normalizeActions = []string{"sync", "drop-caches", "no-turbo", "governor"}
+
+var (
+	normalize         []string // actions normalizing the system before the run, e.g. drop-caches,governor=performance (--normalize)
+	normalizeGovernor string   // CPU frequency governor of the governor action
+)
+
+// Outcome of a normalization action, the previous setting is restored after the run
+type NormalizeResult struct {
+	Action   string `json:"action"`
+	Value    string `json:"value,omitempty"`
+	Previous string `json:"previous,omitempty"`
+	Ok       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+}
+
+var normalizeResults []NormalizeResult
+
+func parseNormalize(value string) {
+	normalize = nil
+	for _, action := range strings.Split(value, ",") {
+		action = strings.TrimSpace(action)
+		if governor, ok := strings.CutPrefix(action, "governor="); ok && governor != "" {
+			action, normalizeGovernor = "governor", governor
+		}
+		if action == "governor" && normalizeGovernor == "" {
+			fatalWith(ExitConfig, "Governor action needs a governor, e.g. governor=performance")
+		}
+		if !slices.Contains(normalizeActions, action) {
+			fatalWith(ExitConfig, "Unknown normalize action", "action", action, "available", "sync,drop-caches,no-turbo,governor=<governor>")
+		}
+		normalize = append(normalize, action)
+	}
+}
+
+func normalizeEnabled(action string) bool {
+	return slices.Contains(normalize, action)
+}
+
+func normalizeStrings() []string {
+	var actions []string
+	for _, action := range normalizeActions {
+		if !normalizeEnabled(action) {
+			continue
+		}
+		if action == "governor" {
+			action += "=" + normalizeGovernor
+		}
+		actions = append(actions, action)
+	}
+	return actions
+}
+
+// Normalize the system before the run to reduce run-to-run variance, each action logged and recorded. An action which
+// cannot be done, e.g. without root or on a machine without cpufreq, is left out with a warning.
+func normalizeSystem() {
+	record := func(result NormalizeResult, err error) {
+		if err != nil {
+			result.Error = err.Error()
+			logger.Warn("Cannot normalize the system", "action", result.Action, "error", err)
+		} else {
+			result.Ok = true
+			logger.Info("System normalized", "action", result.Action, "value", result.Value, "previous", result.Previous)
+		}
+		normalizeResults = append(normalizeResults, result)
+	}
+
+	// Dropping the caches only frees clean pages, the dirty ones are written first
+	if normalizeEnabled("sync") || normalizeEnabled("drop-caches") {
+		record(NormalizeResult{Action: "sync"}, syncFilesystems())
+	}
+	if normalizeEnabled("drop-caches") {
+		record(NormalizeResult{Action: "drop-caches", Value: "3"}, dropCaches())
+	}
+	if normalizeEnabled("no-turbo") {
+		previous, err := setTurbo(false)
+		record(NormalizeResult{Action: "no-turbo", Value: "off", Previous: previous}, err)
+	}
+	if normalizeEnabled("governor") {
+		previous, err := setGovernor(normalizeGovernor)
+		record(NormalizeResult{Action: "governor", Value: normalizeGovernor, Previous: previous}, err)
+	}
+}
+
+// Record the normalization of the run, 1 if the action was done, 0 if not
+func addNormalizeMetrics(timestamp int64) {
+	for _, result := range normalizeResults {
+		value := 0.0
+		if result.Ok {
+			value = 1
+		}
+		labels := map[string]string{"action": result.Action}
+		if result.Value != "" {
+			labels["setting"] = result.Value
+		}
+		addStaticMetric("normalize_info", labels, value, timestamp)
+	}
+}
+
+// Put back the settings changed by the normalization, the caches need not be
+func restoreNormalization() {
+	for _, result := range normalizeResults {
+		if !result.Ok || result.Previous == "" {
+			continue
+		}
+		var err error
+		switch result.Action {
+		case "no-turbo":
+			_, err = setTurbo(result.Previous == "on")
+		case "governor":
+			err = restoreGovernors(result.Previous)
+		}
+		if err != nil {
+			logger.Warn("Cannot restore the system setting", "action", result.Action, "previous", result.Previous, "error", err)
+			continue
+		}
+		logger.Debug("System setting restored", "action", result.Action, "previous", result.Previous)
+	}
+}
+
+// Governors of the CPUs as recorded: the governor if they all had the same one, else cpu0=powersave,cpu1=performance,...
+func formatGovernors(governors map[int]string) string {
+	cpus := make([]int, 0, len(governors))
+	for cpu := range governors {
+		cpus = append(cpus, cpu)
+	}
+	slices.Sort(cpus)
+
+	var parts []string
+	same := true
+	for _, cpu := range cpus {
+		parts = append(parts, "cpu"+strconv.Itoa(cpu)+"="+governors[cpu])
+		same = same && governors[cpu] == governors[cpus[0]]
+	}
+	if same && len(cpus) > 0 {
+		return governors[cpus[0]]
+	}
+	return strings.Join(parts, ",")
+}
+
+// Governor of each CPU from a recorded setting, all is the governor of the CPUs not listed
+func parseGovernors(value string) (map[int]string, string) {
+	if !strings.Contains(value, "=") {
+		return nil, value
+	}
+	governors := make(map[int]string)
+	for _, part := range strings.Split(value, ",") {
+		name, governor, _ := strings.Cut(part, "=")
+		if cpu, err := strconv.Atoi(strings.TrimPrefix(name, "cpu")); err == nil {
+			governors[cpu] = governor
+		}
+	}
+	return governors, ""
+}
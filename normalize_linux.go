@@ -0,0 +1,100 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	dropCachesFile   = "/proc/sys/vm/drop_caches"
+	intelNoTurboFile = "/sys/devices/system/cpu/intel_pstate/no_turbo" // 1 disables turbo
+	cpufreqBoostFile = "/sys/devices/system/cpu/cpufreq/boost"         // 0 disables boost, acpi-cpufreq and amd-pstate
+	cpufreqGovernors = "/sys/devices/system/cpu/cpu[0-9]*/cpufreq/scaling_governor"
+)
+
+func syncFilesystems() error {
+	syscall.Sync()
+	return nil
+}
+
+// Free the page cache, dentries and inodes
+func dropCaches() error {
+	return os.WriteFile(dropCachesFile, []byte("3"), 0644)
+}
+
+// Enable or disable turbo/boost, returning the previous state, on or off
+func setTurbo(enabled bool) (string, error) {
+	if current, err := os.ReadFile(intelNoTurboFile); err == nil {
+		previous := "on"
+		if strings.TrimSpace(string(current)) == "1" {
+			previous = "off"
+		}
+		value := "1"
+		if enabled {
+			value = "0"
+		}
+		return previous, os.WriteFile(intelNoTurboFile, []byte(value), 0644)
+	}
+	if current, err := os.ReadFile(cpufreqBoostFile); err == nil {
+		previous := "off"
+		if strings.TrimSpace(string(current)) == "1" {
+			previous = "on"
+		}
+		value := "0"
+		if enabled {
+			value = "1"
+		}
+		return previous, os.WriteFile(cpufreqBoostFile, []byte(value), 0644)
+	}
+	return "", errors.New("no turbo control, neither intel_pstate nor cpufreq boost")
+}
+
+// Set the governor of all CPUs, returning their previous governors
+func setGovernor(governor string) (string, error) {
+	paths, _ := filepath.Glob(cpufreqGovernors)
+	if len(paths) == 0 {
+		return "", errors.New("no cpufreq governor to set")
+	}
+	previous := make(map[int]string)
+	for _, path := range paths {
+		current, err := os.ReadFile(path)
+		if err != nil {
+			return formatGovernors(previous), err
+		}
+		previous[governorCpu(path)] = strings.TrimSpace(string(current))
+		if err := os.WriteFile(path, []byte(governor), 0644); err != nil {
+			return formatGovernors(previous), err
+		}
+	}
+	return formatGovernors(previous), nil
+}
+
+// CPU of a scaling_governor file, /sys/devices/system/cpu/cpu<n>/cpufreq/scaling_governor
+func governorCpu(path string) int {
+	cpu, _ := strconv.Atoi(strings.TrimPrefix(filepath.Base(filepath.Dir(filepath.Dir(path))), "cpu"))
+	return cpu
+}
+
+func restoreGovernors(recorded string) error {
+	governors, all := parseGovernors(recorded)
+	paths, _ := filepath.Glob(cpufreqGovernors)
+	for _, path := range paths {
+		governor, ok := governors[governorCpu(path)]
+		if !ok {
+			governor = all
+		}
+		if governor == "" {
+			continue
+		}
+		if err := os.WriteFile(path, []byte(governor), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
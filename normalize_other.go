@@ -0,0 +1,29 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// Caches, turbo and governors are only controlled on Linux, sync(2) is not available on all platforms (e.g. Windows)
+func syncFilesystems() error {
+	return exec.Command("sync").Run()
+}
+
+func dropCaches() error {
+	return errors.New("dropping caches only available on Linux")
+}
+
+func setTurbo(enabled bool) (string, error) {
+	return "", errors.New("turbo control only available on Linux")
+}
+
+func setGovernor(governor string) (string, error) {
+	return "", errors.New("cpufreq governors only available on Linux")
+}
+
+func restoreGovernors(recorded string) error {
+	return errors.New("cpufreq governors only available on Linux")
+}
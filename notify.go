@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	notifyTargets []string
+	notifyOn      string = "always"
+	dashboardUrl  string = ""
+)
+
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+// A fact of the run summary card
+type NotifyFact struct {
+	Name  string
+	Value string
+}
+
+// Kind of a notification target, "slack:<url>" or guessed from the webhook url
+func notifyKind(target string) (string, string) {
+	for _, kind := range []string{"slack", "teams", "discord"} {
+		if strings.HasPrefix(target, kind+":") {
+			return kind, strings.TrimPrefix(target, kind+":")
+		}
+	}
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return "", target
+	}
+	switch {
+	case strings.HasSuffix(parsed.Host, "slack.com"):
+		return "slack", target
+	case strings.HasSuffix(parsed.Host, "discord.com") || strings.HasSuffix(parsed.Host, "discordapp.com"):
+		return "discord", target
+	case strings.HasSuffix(parsed.Host, "office.com") || strings.HasSuffix(parsed.Host, "logic.azure.com"):
+		return "teams", target
+	}
+	return "", target
+}
+
+func parseNotifyTarget(target string) string {
+	if kind, _ := notifyKind(target); kind == "" {
+		fatalWith(ExitConfig, "Cannot guess notification kind from url, prefix it with slack:, teams: or discord:", "target", target)
+	}
+	return target
+}
+
+func parseNotifyOn(value string) string {
+	if value != "always" && value != "failure" {
+		fatalWith(ExitConfig, "Notify on must be always or failure", "value", value)
+	}
+	return value
+}
+
+// Whether the command failed, or the run failed its assertions or baseline check
+func runFailed() bool {
+	return commandExitCode != 0 || assertionsFailed() || checkFailed()
+}
+
+// Facts of the run summary card
+func notifyFacts() []NotifyFact {
+	metrics := store.Metrics()
+	first, last := commandWindow(metrics)
+	summary := computeSummary(metrics, first, last)
+
+	facts := []NotifyFact{
+		{"Command", strings.Join(command, " ")},
+		{"Host", hostname},
+		{"Exit code", fmt.Sprintf("%d", summary.ExitCode)},
+		{"Duration", fmt.Sprintf("%.3fs", summary.DurationSeconds)},
+	}
+	if enabledCollectors["cpu"] {
+		facts = append(facts, NotifyFact{"Peak CPU", fmt.Sprintf("%.1f%% of %d cores", peakCpuPercent(metrics, first, last), summary.CpuCores)})
+	}
+	if enabledCollectors["memory"] {
+		facts = append(facts, NotifyFact{"Peak memory used", formatBytes(float64(peakMemoryUsedBytes(metrics, first, last)))})
+	}
+	if len(assertionResults) > 0 {
+		failed := 0
+		for _, result := range assertionResults {
+			if !result.Ok {
+				failed++
+			}
+		}
+		facts = append(facts, NotifyFact{"Assertions", fmt.Sprintf("%d/%d passed", len(assertionResults)-failed, len(assertionResults))})
+	}
+	if checkEnabled {
+		status := "no regression"
+		if checkFailed() {
+			status = "regression"
+		}
+		facts = append(facts, NotifyFact{"Baseline", status})
+	}
+	return facts
+}
+
+func notifyTitle() string {
+	status := "succeeded"
+	if runFailed() {
+		status = "failed"
+	}
+	return fmt.Sprintf("statexec: %s (%s) %s", instance, jobName, status)
+}
+
+// Body of the webhook message for a kind of target
+func notifyPayload(kind string, title string, facts []NotifyFact, failed bool) interface{} {
+	switch kind {
+	case "slack":
+		fields := []map[string]string{}
+		text := title
+		for _, fact := range facts {
+			fields = append(fields, map[string]string{"type": "mrkdwn", "text": fmt.Sprintf("*%s*\n%s", fact.Name, fact.Value)})
+			text += fmt.Sprintf("\n%s: %s", fact.Name, fact.Value)
+		}
+		blocks := []map[string]interface{}{
+			{"type": "header", "text": map[string]string{"type": "plain_text", "text": title}},
+			{"type": "section", "fields": fields},
+		}
+		if dashboardUrl != "" {
+			blocks = append(blocks, map[string]interface{}{
+				"type": "section", "text": map[string]string{"type": "mrkdwn", "text": fmt.Sprintf("<%s|Open dashboard>", dashboardUrl)},
+			})
+		}
+		return map[string]interface{}{"text": text, "blocks": blocks}
+
+	case "discord":
+		color := 0x2eb886
+		if failed {
+			color = 0xd00000
+		}
+		fields := []map[string]interface{}{}
+		for _, fact := range facts {
+			fields = append(fields, map[string]interface{}{"name": fact.Name, "value": fact.Value, "inline": true})
+		}
+		embed := map[string]interface{}{"title": title, "color": color, "fields": fields}
+		if dashboardUrl != "" {
+			embed["url"] = dashboardUrl
+		}
+		return map[string]interface{}{"embeds": []interface{}{embed}}
+
+	default: // teams
+		themeColor := "2EB886"
+		if failed {
+			themeColor = "D00000"
+		}
+		teamsFacts := []map[string]string{}
+		for _, fact := range facts {
+			teamsFacts = append(teamsFacts, map[string]string{"name": fact.Name, "value": fact.Value})
+		}
+		card := map[string]interface{}{
+			"@type":      "MessageCard",
+			"@context":   "http://schema.org/extensions",
+			"themeColor": themeColor,
+			"summary":    title,
+			"title":      title,
+			"sections":   []interface{}{map[string]interface{}{"facts": teamsFacts}},
+		}
+		if dashboardUrl != "" {
+			card["potentialAction"] = []interface{}{map[string]interface{}{
+				"@type":   "OpenUri",
+				"name":    "Open dashboard",
+				"targets": []interface{}{map[string]string{"os": "default", "uri": dashboardUrl}},
+			}}
+		}
+		return card
+	}
+}
+
+// Post the run summary card to the notification targets, a failing notification never fails the run
+func sendNotifications() {
+	failed := runFailed()
+	if notifyOn == "failure" && !failed {
+		return
+	}
+
+	title := notifyTitle()
+	facts := notifyFacts()
+	for _, target := range notifyTargets {
+		kind, webhookUrl := notifyKind(target)
+		body, err := json.Marshal(notifyPayload(kind, title, facts, failed))
+		if err != nil {
+			logger.Warn("Cannot marshal notification", "kind", kind, "error", err)
+			continue
+		}
+		resp, err := notifyClient.Post(webhookUrl, "application/json", bytes.NewReader(body))
+		if err != nil {
+			logger.Warn("Cannot send notification", "kind", kind, "error", err)
+			continue
+		}
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			logger.Warn("Cannot send notification", "kind", kind, "error", fmt.Sprintf("%s: %s", resp.Status, strings.TrimSpace(string(respBody))))
+		}
+		resp.Body.Close()
+	}
+}
@@ -0,0 +1,577 @@
+// Package objstore uploads TSDB blocks to object stores in the Thanos layout, configured like Thanos (type and config),
+// the S3, GCS and Azure APIs and their authentication are implemented here to avoid heavy dependencies.
+package objstore
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Object store configuration, the content of a Thanos objstore.config-file
+type Config struct {
+	Type   string          `json:"type"` // S3, GCS, AZURE or FILESYSTEM
+	Config json.RawMessage `json:"config"`
+}
+
+// Bucket of an object store, objects are uploaded from local files
+type Bucket interface {
+	Upload(name string, path string) error
+	String() string
+}
+
+// Uploads are large, a chunks file is up to 512MB
+var client = &http.Client{Timeout: 30 * time.Minute}
+
+// Create the bucket of a configuration, checking its settings without any request
+func NewBucket(config Config) (Bucket, error) {
+	switch strings.ToUpper(config.Type) {
+	case "S3":
+		bucket := &s3Bucket{}
+		if err := decodeConfig(config, bucket); err != nil {
+			return nil, err
+		}
+		return bucket, bucket.init()
+	case "GCS":
+		bucket := &gcsBucket{}
+		if err := decodeConfig(config, bucket); err != nil {
+			return nil, err
+		}
+		return bucket, bucket.init()
+	case "AZURE":
+		bucket := &azureBucket{}
+		if err := decodeConfig(config, bucket); err != nil {
+			return nil, err
+		}
+		return bucket, bucket.init()
+	case "FILESYSTEM":
+		bucket := &filesystemBucket{}
+		if err := decodeConfig(config, bucket); err != nil {
+			return nil, err
+		}
+		if bucket.Directory == "" {
+			return nil, fmt.Errorf("missing directory of the filesystem bucket")
+		}
+		return bucket, nil
+	case "":
+		return nil, fmt.Errorf("missing type of the object store")
+	default:
+		return nil, fmt.Errorf("unsupported object store type %q, must be S3, GCS, AZURE or FILESYSTEM", config.Type)
+	}
+}
+
+func decodeConfig(config Config, bucket interface{}) error {
+	if len(config.Config) == 0 {
+		return fmt.Errorf("missing config of the %s object store", config.Type)
+	}
+	if err := json.Unmarshal(config.Config, bucket); err != nil {
+		return fmt.Errorf("invalid config of the %s object store: %w", config.Type, err)
+	}
+	return nil
+}
+
+// Upload a block directory under its ULID, meta.json last as Thanos does so readers never see a partial block.
+// Tombstones are not uploaded, Thanos ignores them.
+func UploadBlock(bucket Bucket, blockDir string) error {
+	ulid := filepath.Base(blockDir)
+	var files []string
+	err := filepath.WalkDir(blockDir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name, _ := filepath.Rel(blockDir, path)
+		if entry.IsDir() || name == "meta.json" || name == "tombstones" {
+			return nil
+		}
+		files = append(files, filepath.ToSlash(name))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range append(files, "meta.json") {
+		if err := bucket.Upload(ulid+"/"+name, filepath.Join(blockDir, filepath.FromSlash(name))); err != nil {
+			return fmt.Errorf("cannot upload %s/%s: %w", ulid, name, err)
+		}
+	}
+	return nil
+}
+
+// Send a request whose body is a file, fail on non 2xx status
+func doUpload(request *http.Request, file *os.File, size int64) error {
+	request.Body = file
+	request.ContentLength = size
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp)
+}
+
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return nil
+}
+
+func openFile(path string) (*os.File, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+//===============================================================================
+// S3, signature version 4
+//===============================================================================
+
+type s3Bucket struct {
+	Bucket       string `json:"bucket"`
+	Endpoint     string `json:"endpoint"`
+	Region       string `json:"region"`
+	AccessKey    string `json:"access_key"`
+	SecretKey    string `json:"secret_key"`
+	SessionToken string `json:"session_token"`
+	Insecure     bool   `json:"insecure"`
+}
+
+// Credentials default to the AWS environment variables, the region to us-east-1 and the endpoint to AWS
+func (b *s3Bucket) init() error {
+	if b.Bucket == "" {
+		return fmt.Errorf("missing bucket of the S3 object store")
+	}
+	if b.Region == "" {
+		b.Region = "us-east-1"
+	}
+	if b.Endpoint == "" {
+		b.Endpoint = "s3." + b.Region + ".amazonaws.com"
+	}
+	if b.AccessKey == "" {
+		b.AccessKey, b.SecretKey, b.SessionToken = os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if b.AccessKey == "" || b.SecretKey == "" {
+		return fmt.Errorf("missing access_key and secret_key of the S3 object store, or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return nil
+}
+
+func (b *s3Bucket) String() string {
+	return "s3://" + b.Bucket
+}
+
+// Path style requests, supported by AWS and all S3 compatible stores
+func (b *s3Bucket) Upload(name string, path string) error {
+	file, size, err := openFile(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// The payload is signed, hashing it needs a first pass over the file
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	payloadHash := hex.EncodeToString(hash.Sum(nil))
+
+	scheme := "https"
+	if b.Insecure {
+		scheme = "http"
+	}
+	objectPath := "/" + s3UriEncode(b.Bucket) + "/" + s3UriEncode(name)
+	request, err := http.NewRequest(http.MethodPut, scheme+"://"+b.Endpoint+objectPath, nil)
+	if err != nil {
+		return err
+	}
+	b.sign(request, objectPath, payloadHash, time.Now().UTC())
+	return doUpload(request, file, size)
+}
+
+func (b *s3Bucket) sign(request *http.Request, objectPath string, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	headers := map[string]string{
+		"host":                 request.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+	if b.SessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", b.SessionToken)
+		headers["x-amz-security-token"] = b.SessionToken
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{request.Method, objectPath, "", canonicalHeaders, signedHeaders, payloadHash}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := date + "/" + b.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSha256([]byte("AWS4"+b.SecretKey), date)
+	key = hmacSha256(key, b.Region)
+	key = hmacSha256(key, "s3")
+	key = hmacSha256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+	request.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+b.AccessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// Encode an object key as S3 does, everything but unreserved characters and slashes
+func s3UriEncode(value string) string {
+	var encoded strings.Builder
+	for _, c := range []byte(value) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			encoded.WriteByte(c)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", c)
+		}
+	}
+	return encoded.String()
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+//===============================================================================
+// Google Cloud Storage, OAuth2 with a service account or the metadata server
+//===============================================================================
+
+const (
+	gcsScope            = "https://www.googleapis.com/auth/devstorage.read_write"
+	gcsMetadataTokenUrl = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+type gcsBucket struct {
+	Bucket         string `json:"bucket"`
+	ServiceAccount string `json:"service_account"` // content of the JSON key of a service account
+
+	account *gcsServiceAccount
+	token   string
+	expiry  time.Time
+}
+
+type gcsServiceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenUri    string `json:"token_uri"`
+}
+
+// The service account defaults to the file of GOOGLE_APPLICATION_CREDENTIALS, then to the metadata server of the instance
+func (b *gcsBucket) init() error {
+	if b.Bucket == "" {
+		return fmt.Errorf("missing bucket of the GCS object store")
+	}
+	serviceAccount := []byte(b.ServiceAccount)
+	if len(serviceAccount) == 0 {
+		if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("cannot read GOOGLE_APPLICATION_CREDENTIALS: %w", err)
+			}
+			serviceAccount = content
+		}
+	}
+	if len(serviceAccount) == 0 {
+		return nil
+	}
+	b.account = &gcsServiceAccount{}
+	if err := json.Unmarshal(serviceAccount, b.account); err != nil {
+		return fmt.Errorf("invalid GCS service account: %w", err)
+	}
+	if b.account.TokenUri == "" {
+		b.account.TokenUri = "https://oauth2.googleapis.com/token"
+	}
+	if _, err := b.account.key(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (b *gcsBucket) String() string {
+	return "gs://" + b.Bucket
+}
+
+func (b *gcsBucket) Upload(name string, path string) error {
+	token, err := b.accessToken()
+	if err != nil {
+		return fmt.Errorf("cannot get GCS access token: %w", err)
+	}
+	file, size, err := openFile(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	uploadUrl := "https://storage.googleapis.com/upload/storage/v1/b/" + url.PathEscape(b.Bucket) + "/o?uploadType=media&name=" + url.QueryEscape(name)
+	request, err := http.NewRequest(http.MethodPost, uploadUrl, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	request.Header.Set("Content-Type", "application/octet-stream")
+	return doUpload(request, file, size)
+}
+
+// Access token, cached until shortly before it expires
+func (b *gcsBucket) accessToken() (string, error) {
+	if b.token != "" && time.Now().Before(b.expiry) {
+		return b.token, nil
+	}
+
+	var request *http.Request
+	var err error
+	if b.account != nil {
+		assertion, err := b.account.jwt(time.Now())
+		if err != nil {
+			return "", err
+		}
+		form := url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"}, "assertion": {assertion}}
+		request, err = http.NewRequest(http.MethodPost, b.account.TokenUri, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		request, err = http.NewRequest(http.MethodGet, gcsMetadataTokenUrl, nil)
+		if err != nil {
+			return "", err
+		}
+		request.Header.Set("Metadata-Flavor", "Google")
+	}
+
+	resp, err := client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return "", err
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	b.token = token.AccessToken
+	b.expiry = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return b.token, nil
+}
+
+func (account *gcsServiceAccount) key() (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("invalid private key of the GCS service account")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+			return key, nil
+		}
+		return nil, fmt.Errorf("invalid private key of the GCS service account: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key of the GCS service account is not a RSA key")
+	}
+	return key, nil
+}
+
+// Signed JWT exchanged for an access token, valid for an hour
+func (account *gcsServiceAccount) jwt(now time.Time) (string, error) {
+	key, err := account.key()
+	if err != nil {
+		return "", err
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   account.ClientEmail,
+		"scope": gcsScope,
+		"aud":   account.TokenUri,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(nil, key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+//===============================================================================
+// Azure Blob Storage, shared key authorization
+//===============================================================================
+
+const azureApiVersion = "2020-04-08"
+
+type azureBucket struct {
+	StorageAccount    string `json:"storage_account"`
+	StorageAccountKey string `json:"storage_account_key"`
+	Container         string `json:"container"`
+	Endpoint          string `json:"endpoint"`
+
+	key []byte
+}
+
+func (b *azureBucket) init() error {
+	if b.StorageAccount == "" || b.Container == "" {
+		return fmt.Errorf("missing storage_account or container of the Azure object store")
+	}
+	if b.StorageAccountKey == "" {
+		b.StorageAccountKey = os.Getenv("AZURE_STORAGE_KEY")
+	}
+	key, err := base64.StdEncoding.DecodeString(b.StorageAccountKey)
+	if err != nil || len(key) == 0 {
+		return fmt.Errorf("missing or invalid storage_account_key of the Azure object store, or AZURE_STORAGE_KEY")
+	}
+	b.key = key
+	if b.Endpoint == "" {
+		b.Endpoint = "blob.core.windows.net"
+	}
+	return nil
+}
+
+func (b *azureBucket) String() string {
+	return "azure://" + b.StorageAccount + "/" + b.Container
+}
+
+// Single put of a block blob, up to 5000 MiB with this API version
+func (b *azureBucket) Upload(name string, path string) error {
+	file, size, err := openFile(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	objectPath := "/" + b.Container + "/" + s3UriEncode(name)
+	request, err := http.NewRequest(http.MethodPut, "https://"+b.StorageAccount+"."+b.Endpoint+objectPath, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/octet-stream")
+	request.Header.Set("X-Ms-Blob-Type", "BlockBlob")
+	request.Header.Set("X-Ms-Date", time.Now().UTC().Format(http.TimeFormat))
+	request.Header.Set("X-Ms-Version", azureApiVersion)
+	b.sign(request, objectPath, size)
+	return doUpload(request, file, size)
+}
+
+func (b *azureBucket) sign(request *http.Request, objectPath string, size int64) {
+	var msHeaders []string
+	for name, values := range request.Header {
+		if name := strings.ToLower(name); strings.HasPrefix(name, "x-ms-") {
+			msHeaders = append(msHeaders, name+":"+strings.Join(values, ","))
+		}
+	}
+	sort.Strings(msHeaders)
+
+	contentLength := ""
+	if size > 0 {
+		contentLength = strconv.FormatInt(size, 10)
+	}
+	var stringToSign bytes.Buffer
+	for _, value := range []string{
+		request.Method,
+		"", // Content-Encoding
+		"", // Content-Language
+		contentLength,
+		"", // Content-MD5
+		request.Header.Get("Content-Type"),
+		"", // Date, x-ms-date is used instead
+		"", // If-Modified-Since
+		"", // If-Match
+		"", // If-None-Match
+		"", // If-Unmodified-Since
+		"", // Range
+	} {
+		stringToSign.WriteString(value + "\n")
+	}
+	for _, header := range msHeaders {
+		stringToSign.WriteString(header + "\n")
+	}
+	stringToSign.WriteString("/" + b.StorageAccount + objectPath)
+
+	mac := hmac.New(sha256.New, b.key)
+	mac.Write(stringToSign.Bytes())
+	request.Header.Set("Authorization", "SharedKey "+b.StorageAccount+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+//===============================================================================
+// Filesystem, a directory laid out like a bucket
+//===============================================================================
+
+type filesystemBucket struct {
+	Directory string `json:"directory"`
+}
+
+func (b *filesystemBucket) String() string {
+	return b.Directory
+}
+
+func (b *filesystemBucket) Upload(name string, path string) error {
+	source, _, err := openFile(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	target := filepath.Join(b.Directory, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	destination, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(destination, source); err != nil {
+		destination.Close()
+		return err
+	}
+	return destination.Close()
+}
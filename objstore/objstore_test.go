@@ -0,0 +1,172 @@
+package objstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testUlid = "01HKZ3Q4Y5T6V7W8X9Y0Z1A2B3"
+
+// Block directory laid out as tsdb writes it
+func writeTestBlock(t *testing.T) string {
+	t.Helper()
+	blockDir := filepath.Join(t.TempDir(), testUlid)
+	for name, content := range map[string]string{
+		"meta.json":                       `{"ulid":"` + testUlid + `"}`,
+		"index":                           "index",
+		"tombstones":                      "tombstones",
+		filepath.Join("chunks", "000001"): "chunks 1",
+		filepath.Join("chunks", "000002"): "chunks 2",
+	} {
+		path := filepath.Join(blockDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return blockDir
+}
+
+// Bucket recording the uploads in order
+type recordingBucket struct {
+	names []string
+}
+
+func (b *recordingBucket) String() string {
+	return "recording"
+}
+
+func (b *recordingBucket) Upload(name string, path string) error {
+	b.names = append(b.names, name)
+	return nil
+}
+
+func TestUploadBlockOrder(t *testing.T) {
+	bucket := &recordingBucket{}
+	if err := UploadBlock(bucket, writeTestBlock(t)); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{testUlid + "/chunks/000001", testUlid + "/chunks/000002", testUlid + "/index", testUlid + "/meta.json"}
+	if strings.Join(bucket.names, ",") != strings.Join(want, ",") {
+		t.Errorf("uploads = %v, want %v with meta.json last and no tombstones", bucket.names, want)
+	}
+}
+
+func TestFilesystemBucket(t *testing.T) {
+	dir := t.TempDir()
+	config, _ := json.Marshal(map[string]string{"directory": dir})
+	bucket, err := NewBucket(Config{Type: "filesystem", Config: config})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UploadBlock(bucket, writeTestBlock(t)); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, testUlid, "chunks", "000002"))
+	if err != nil || string(content) != "chunks 2" {
+		t.Errorf("uploaded chunks = %q, %v", content, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, testUlid, "tombstones")); !os.IsNotExist(err) {
+		t.Errorf("tombstones uploaded: %v", err)
+	}
+}
+
+func TestNewBucketInvalidConfig(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	tests := []struct {
+		config Config
+		want   string
+	}{
+		{Config{}, "missing type"},
+		{Config{Type: "SWIFT", Config: json.RawMessage(`{}`)}, "unsupported object store type"},
+		{Config{Type: "S3"}, "missing config"},
+		{Config{Type: "S3", Config: json.RawMessage(`{"bucket":`)}, "invalid config"},
+		{Config{Type: "S3", Config: json.RawMessage(`{}`)}, "missing bucket"},
+		{Config{Type: "S3", Config: json.RawMessage(`{"bucket":"b"}`)}, "missing access_key"},
+		{Config{Type: "FILESYSTEM", Config: json.RawMessage(`{}`)}, "missing directory"},
+	}
+	for _, test := range tests {
+		_, err := NewBucket(test.config)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("NewBucket(%s %s) = %v, want an error with %q", test.config.Type, test.config.Config, err, test.want)
+		}
+	}
+}
+
+func TestS3Upload(t *testing.T) {
+	type upload struct {
+		path, body, sha256, authorization string
+	}
+	var uploads []upload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPut {
+			t.Errorf("method = %s, want PUT", r.Method)
+		}
+		uploads = append(uploads, upload{r.URL.Path, string(body), r.Header.Get("X-Amz-Content-Sha256"), r.Header.Get("Authorization")})
+	}))
+	defer server.Close()
+
+	config, _ := json.Marshal(map[string]any{
+		"bucket":     "metrics",
+		"endpoint":   strings.TrimPrefix(server.URL, "http://"),
+		"region":     "eu-west-3",
+		"access_key": "AKIDEXAMPLE",
+		"secret_key": "secret",
+		"insecure":   true,
+	})
+	bucket, err := NewBucket(Config{Type: "S3", Config: config})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UploadBlock(bucket, writeTestBlock(t)); err != nil {
+		t.Fatal(err)
+	}
+	if len(uploads) != 4 {
+		t.Fatalf("%d uploads, want 4", len(uploads))
+	}
+	last := uploads[len(uploads)-1]
+	if last.path != "/metrics/"+testUlid+"/meta.json" {
+		t.Errorf("last upload = %s, want the meta.json of the block", last.path)
+	}
+	hash := sha256.Sum256([]byte(last.body))
+	if last.sha256 != hex.EncodeToString(hash[:]) {
+		t.Errorf("payload hash = %s, want the hash of the body", last.sha256)
+	}
+	if !strings.HasPrefix(last.authorization, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") || !strings.Contains(last.authorization, "/eu-west-3/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=") {
+		t.Errorf("authorization = %s", last.authorization)
+	}
+}
+
+func TestS3UploadRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	bucket := &s3Bucket{Bucket: "metrics", Endpoint: strings.TrimPrefix(server.URL, "http://"), AccessKey: "a", SecretKey: "s", Insecure: true}
+	if err := bucket.init(); err != nil {
+		t.Fatal(err)
+	}
+	err := UploadBlock(bucket, writeTestBlock(t))
+	if err == nil || !strings.Contains(err.Error(), "403") || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("error = %v, want the status and body of the rejection", err)
+	}
+}
+
+func TestS3UriEncode(t *testing.T) {
+	if got := s3UriEncode("block/chunks/000001 a+b=c"); got != "block/chunks/000001%20a%2Bb%3Dc" {
+		t.Errorf("s3UriEncode = %s", got)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var openMetricsFile string = "" // result written in the OpenMetrics text format, series grouped by metric family (--openmetrics-file)
+
+// The result in the OpenMetrics text format. Points of a family must be contiguous, unlike the result file which is
+// written sample by sample, and the comments of the result file are not allowed: annotations and metadata are left out.
+type openMetricsExporter struct {
+	path string
+}
+
+func (exporter openMetricsExporter) Name() string {
+	return "openmetrics"
+}
+
+// Help and type of the metrics from the header of the result file, by name with the prefix
+func metricMetadata() (map[string]string, map[string]string) {
+	helps := make(map[string]string)
+	types := make(map[string]string)
+	for _, line := range strings.Split(resultHeader(), "\n") {
+		if text, ok := strings.CutPrefix(line, "# HELP "); ok {
+			name, help, _ := strings.Cut(text, " ")
+			helps[name] = help
+		} else if text, ok := strings.CutPrefix(line, "# TYPE "); ok {
+			name, metricType, _ := strings.Cut(text, " ")
+			types[name] = metricType
+		}
+	}
+	return helps, types
+}
+
+// Family of a metric and its type: counters are named without _total and histograms without the suffix of their series
+func openMetricsFamily(name string, types map[string]string) (string, string) {
+	switch types[name] {
+	case "counter":
+		if family, ok := strings.CutSuffix(name, "_total"); ok {
+			return family, "counter"
+		}
+		return name, "unknown"
+	case "gauge", "histogram":
+		return name, types[name]
+	}
+	for _, suffix := range []string{"_bucket", "_count", "_sum"} {
+		if family, ok := strings.CutSuffix(name, suffix); ok && types[family] == "histogram" {
+			return family, "histogram"
+		}
+	}
+	return name, "unknown"
+}
+
+type openMetricsPoint struct {
+	series    *Series
+	index     int
+	metricKey string // labels of the point but le, the buckets, count and sum of a histogram at a time being written together
+	rank      int
+}
+
+type openMetricsFamilyPoints struct {
+	name       string
+	metricType string
+	points     []openMetricsPoint
+}
+
+func (exporter openMetricsExporter) Export(result RunResult) error {
+	helps, types := metricMetadata()
+
+	// Families in the order they first appear
+	var families []*openMetricsFamilyPoints
+	familyIndex := make(map[string]*openMetricsFamilyPoints)
+	series := result.series()
+	for i := range series {
+		oneSeries := &series[i]
+		name, metricType := openMetricsFamily(MetricPrefix+oneSeries.name, types)
+		family, ok := familyIndex[name]
+		if !ok {
+			family = &openMetricsFamilyPoints{name: name, metricType: metricType}
+			familyIndex[name] = family
+			families = append(families, family)
+		}
+		metricLabels := make(map[string]string, len(oneSeries.labels))
+		for key, value := range oneSeries.labels {
+			if key != "le" {
+				metricLabels[key] = value
+			}
+		}
+		metricKey := renderLabels(metricLabels)
+		rank := 0
+		switch {
+		case strings.HasSuffix(oneSeries.name, "_count") && metricType == "histogram":
+			rank = 1
+		case strings.HasSuffix(oneSeries.name, "_sum") && metricType == "histogram":
+			rank = 2
+		}
+		for index := range oneSeries.timestamps {
+			family.points = append(family.points, openMetricsPoint{series: oneSeries, index: index, metricKey: metricKey, rank: rank})
+		}
+	}
+
+	writer, err := createAtomicFile(exporter.path)
+	if err != nil {
+		return fmt.Errorf("cannot open OpenMetrics file %s: %w", exporter.path, err)
+	}
+	defer writer.Discard()
+
+	buffer := make([]byte, 0, 64*1024)
+	for _, family := range families {
+		// Points of a metric in time order, the buckets of a histogram staying in the order of their bounds
+		sort.SliceStable(family.points, func(i, j int) bool {
+			a, b := family.points[i], family.points[j]
+			if a.metricKey != b.metricKey {
+				return a.metricKey < b.metricKey
+			}
+			if a.series.timestamps[a.index] != b.series.timestamps[b.index] {
+				return a.series.timestamps[a.index] < b.series.timestamps[b.index]
+			}
+			return a.rank < b.rank
+		})
+
+		buffer = buffer[:0]
+		buffer = append(buffer, "# TYPE "+family.name+" "+family.metricType+"\n"...)
+		if help, ok := helps[MetricPrefix+family.points[0].series.name]; ok {
+			buffer = append(buffer, "# HELP "+family.name+" "+help+"\n"...)
+		}
+		for _, point := range family.points {
+			buffer = append(buffer, MetricPrefix...)
+			buffer = append(buffer, point.series.name...)
+			buffer = append(buffer, '{')
+			buffer = append(buffer, point.series.rendered...)
+			buffer = append(buffer, "} "...)
+			if point.series.integer {
+				buffer = strconv.AppendInt(buffer, int64(point.series.values[point.index]), 10)
+			} else {
+				buffer = strconv.AppendFloat(buffer, point.series.values[point.index], 'f', -1, 64)
+			}
+			buffer = append(buffer, ' ')
+			// Timestamps are in seconds
+			buffer = strconv.AppendFloat(buffer, float64(point.series.timestamps[point.index])/1000, 'f', 3, 64)
+			buffer = append(buffer, '\n')
+		}
+		// Errors of the buffered writer are sticky, the first one is returned by Commit
+		_, _ = writer.Write(buffer)
+	}
+	_, _ = writer.WriteString("# EOF\n")
+	if err := writer.Commit(); err != nil {
+		return fmt.Errorf("cannot write OpenMetrics file %s: %w", exporter.path, err)
+	}
+	logger.Debug("OpenMetrics written", "file", exporter.path, "families", len(families))
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	perfEvents     string = ""
+	perfOutputFile string = ""
+	perfCounters   map[string]float64
+)
+
+// Wrap the command with perf stat, counters are written as CSV to a temporary file
+func wrapWithPerf(cmd []string) []string {
+	outputFile, err := os.CreateTemp("", "statexec-perf-*.csv")
+	if err != nil {
+		fatalWith(ExitOutput, "Cannot create perf output file", "error", err)
+	}
+	outputFile.Close()
+	perfOutputFile = outputFile.Name()
+
+	return append([]string{"perf", "stat", "-x,", "-e", perfEvents, "-o", perfOutputFile, "--"}, cmd...)
+}
+
+// Read perf stat CSV output : <value>,<unit>,<event>,<run time>,<percent running>,...
+func readPerfCounters(path string) map[string]float64 {
+	counters := make(map[string]float64)
+
+	file, err := os.Open(path)
+	if err != nil {
+		logger.Warn("Cannot read perf output", "file", path, "error", err)
+		return counters
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) < 3 {
+			continue
+		}
+		// "<not supported>" and "<not counted>" events are skipped
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			logger.Warn("Perf event not counted", "event", fields[2], "value", fields[0])
+			continue
+		}
+		// Drop modifiers added by perf, e.g. "cycles:u" when not running as root
+		event := strings.SplitN(fields[2], ":", 2)[0]
+		counters[event] = value
+	}
+	return counters
+}
+
+// Collect perf counters once the command is done
+func collectPerfCounters() {
+	if perfOutputFile == "" {
+		return
+	}
+	perfCounters = readPerfCounters(perfOutputFile)
+	_ = os.Remove(perfOutputFile)
+}
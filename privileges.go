@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var commandUser string = "" // user the command is run as, statexec keeping its privileges for the collectors (--user)
+
+// Features of the run needing statexec to stay privileged while the command runs
+func privilegedFeatures() []string {
+	var features []string
+	if traceChildren {
+		features = append(features, "trace-children")
+	}
+	if realtime {
+		features = append(features, "realtime")
+	}
+	if smartEnabled {
+		features = append(features, "smart")
+	}
+	if len(normalize) > 0 {
+		features = append(features, "normalize")
+	}
+	return features
+}
+
+// Run the command as --user, under no_new_privs so neither it nor its children regain privileges through setuid binaries
+// or file capabilities. Unless a feature of the run needs root, statexec drops its own privileges as well once the
+// config is parsed, and checks it can still write its outputs so a mistake is found before the run rather than after.
+func dropCommandPrivileges(cmd []string) {
+	if commandUser == "" {
+		return
+	}
+	if os.Geteuid() != 0 {
+		fatalWith(ExitConfig, "Running the command as another user needs statexec to run as root", "user", commandUser)
+	}
+	if err := setCommandUser(commandUser); err != nil {
+		fatalWith(ExitConfig, "Cannot run the command as user", "user", commandUser, "error", err)
+	}
+	if features := privilegedFeatures(); len(features) > 0 {
+		logger.Info("Command run unprivileged, statexec stays root", "command", strings.Join(cmd, " "), "user", commandUser, "privileged_features", strings.Join(features, ","))
+		return
+	}
+
+	if err := dropOwnPrivileges(); err != nil {
+		fatalWith(ExitConfig, "Cannot drop the privileges of statexec", "user", commandUser, "error", err)
+	}
+	for _, output := range localOutputs(cmd) {
+		if err := checkFileWritable(output); err != nil {
+			fatalWith(ExitConfig, "Output not writable by the user statexec runs as", "output", output, "user", commandUser, "error", err)
+		}
+	}
+	logger.Info("Command and statexec run unprivileged", "command", strings.Join(cmd, " "), "user", commandUser)
+}
+
+// Paths of the outputs written on the local filesystem, a file of a directory output standing for the directory
+func localOutputs(cmd []string) []string {
+	var outputs []string
+	for _, sink := range effectiveConfig(cmd).Sinks {
+		switch sink.Type {
+		case "file", "annotations", "openmetrics", "json", "junit", "manifest":
+			outputs = append(outputs, sink.Target)
+		case "split", "tsdb", "tsdb_block":
+			// Created by the export when missing, in its parent directory
+			if _, err := os.Stat(sink.Target); err == nil {
+				outputs = append(outputs, filepath.Join(sink.Target, "output"))
+			} else {
+				outputs = append(outputs, sink.Target)
+			}
+		}
+	}
+	return outputs
+}
@@ -0,0 +1,125 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const prSetNoNewPrivs = 38 // PR_SET_NO_NEW_PRIVS
+
+// Credentials and environment of the command user, resolved once the config is parsed
+var (
+	commandCredential *syscall.Credential
+	commandUserEnv    []string
+)
+
+// Resolve <user>[:<group>], names or ids, the group defaulting to the primary group of the user
+func setCommandUser(value string) error {
+	userName, groupName, _ := strings.Cut(value, ":")
+	account, err := user.Lookup(userName)
+	if err != nil {
+		byId, idErr := user.LookupId(userName)
+		if idErr != nil {
+			return err
+		}
+		account = byId
+	}
+	uid, err := strconv.ParseUint(account.Uid, 10, 32)
+	if err != nil {
+		return err
+	}
+	gidValue := account.Gid
+	if groupName != "" {
+		group, err := user.LookupGroup(groupName)
+		if err != nil {
+			byId, idErr := user.LookupGroupId(groupName)
+			if idErr != nil {
+				return err
+			}
+			group = byId
+		}
+		gidValue = group.Gid
+	}
+	gid, err := strconv.ParseUint(gidValue, 10, 32)
+	if err != nil {
+		return err
+	}
+
+	// Supplementary groups of the user, none if they cannot be listed
+	var groups []uint32
+	if groupIds, err := account.GroupIds(); err == nil {
+		for _, groupId := range groupIds {
+			if id, err := strconv.ParseUint(groupId, 10, 32); err == nil {
+				groups = append(groups, uint32(id))
+			}
+		}
+	}
+
+	commandCredential = &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: groups}
+	commandUserEnv = []string{"HOME=" + account.HomeDir, "USER=" + account.Username, "LOGNAME=" + account.Username}
+	return nil
+}
+
+// Switch every thread of statexec to the command user, the command then runs as the user without a credential of its own
+func dropOwnPrivileges() error {
+	groups := make([]int, len(commandCredential.Groups))
+	for i, group := range commandCredential.Groups {
+		groups[i] = int(group)
+	}
+	if err := syscall.Setgroups(groups); err != nil {
+		return err
+	}
+	if err := syscall.Setgid(int(commandCredential.Gid)); err != nil {
+		return err
+	}
+	if err := syscall.Setuid(int(commandCredential.Uid)); err != nil {
+		return err
+	}
+	commandCredential = nil
+	return nil
+}
+
+// Run a process of the run, the command or a hook, as the user, with the environment of the user added to its own
+func restrictCommandPrivileges(cmd *exec.Cmd) {
+	if commandUserEnv == nil {
+		return
+	}
+	if commandCredential != nil {
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.Credential = commandCredential
+	}
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = append(env, commandUserEnv...)
+}
+
+// Start a process of the run, the command or a hook, under no_new_privs when running as --user. The flag is set on a
+// thread of its own which starts the process and is then terminated, the goroutine exiting without unlocking it: the
+// flag cannot be removed and the Go runtime must not reuse the thread elsewhere.
+func startRestricted(cmd *exec.Cmd) error {
+	if commandUserEnv == nil {
+		return cmd.Start()
+	}
+	started := make(chan error)
+	go func() {
+		runtime.LockOSThread()
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+			started <- fmt.Errorf("cannot set no_new_privs: %w", errno)
+			return
+		}
+		started <- cmd.Start()
+	}()
+	return <-started
+}
@@ -0,0 +1,94 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func noNewPrivs(t *testing.T, status string) string {
+	t.Helper()
+	for _, line := range strings.Split(status, "\n") {
+		if value, ok := strings.CutPrefix(line, "NoNewPrivs:"); ok {
+			return strings.TrimSpace(value)
+		}
+	}
+	t.Skip("NoNewPrivs not reported by the kernel")
+	return ""
+}
+
+// The process started as --user is under no_new_privs, statexec is not: the thread which set the flag is not reused
+func TestStartRestricted(t *testing.T) {
+	commandUserEnv = []string{}
+	defer func() { commandUserEnv = nil }()
+
+	for i := 0; i < 20; i++ {
+		cmd := exec.Command("cat", "/proc/self/status")
+		var output strings.Builder
+		cmd.Stdout = &output
+		if err := startRestricted(cmd); err != nil {
+			t.Fatal(err)
+		}
+		if err := cmd.Wait(); err != nil {
+			t.Fatal(err)
+		}
+		if got := noNewPrivs(t, output.String()); got != "1" {
+			t.Fatalf("started process NoNewPrivs = %s, want 1", got)
+		}
+	}
+
+	// Without the flag on any thread, a plain start is not restricted
+	commandUserEnv = nil
+	output, err := exec.Command("cat", "/proc/self/status").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := noNewPrivs(t, string(output)); got != "0" {
+		t.Errorf("process started after the restricted ones has NoNewPrivs = %s, want 0", got)
+	}
+	status, err := os.ReadFile("/proc/thread-self/status")
+	if err != nil {
+		t.Skip(err)
+	}
+	if got := noNewPrivs(t, string(status)); got != "0" {
+		t.Errorf("statexec thread NoNewPrivs = %s, want 0", got)
+	}
+}
+
+// Hooks run as --user with its environment, even when statexec keeps its privileges for another feature
+func TestHooksRunAsCommandUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("switching to another user needs root")
+	}
+	commandCredential = &syscall.Credential{Uid: 65534, Gid: 65534}
+	commandUserEnv = []string{"USER=nobody"}
+	defer func() { commandCredential, commandUserEnv = nil, nil }()
+	store = newTestStore()
+	// Outside of t.TempDir, whose parent the user cannot enter
+	dir, err := os.MkdirTemp("", "statexec-hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Chmod(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "hook")
+	hooks = []Hook{{point: "post-start", command: "echo $(id -u) $USER $STATEXEC_HOOK > " + output}}
+	defer func() { hooks = nil }()
+
+	runHooks("post-start", exec.Command("true"), time.Now())
+	content, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(content)); got != "65534 nobody post-start" {
+		t.Errorf("hook ran as %q, want 65534 nobody post-start", got)
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// Running the command as another user is only implemented on Linux
+func setCommandUser(value string) error {
+	return errors.New("running the command as another user only available on Linux")
+}
+
+func dropOwnPrivileges() error {
+	return errors.New("dropping privileges only available on Linux")
+}
+
+func restrictCommandPrivileges(cmd *exec.Cmd) {}
+
+func startRestricted(cmd *exec.Cmd) error {
+	return cmd.Start()
+}
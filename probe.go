@@ -0,0 +1,149 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blackswifthosting/statexec/collectors"
+)
+
+type ProbeSample struct {
+	probe     collectors.Probe
+	result    collectors.ProbeResult
+	timestamp int64
+}
+
+var (
+	probeTargets  []string
+	probeInterval int64 = 1 // in seconds
+
+	// Upper bounds of the probe latency histogram, disabled if empty
+	probeBuckets []float64
+
+	probes         []collectors.Probe
+	probeWaitGroup sync.WaitGroup
+	probeQuit      chan struct{}
+)
+
+func addProbe(target string) {
+	probe, err := collectors.ParseProbe(target)
+	if err != nil {
+		fatalWith(ExitConfig, "Cannot parse probe", "probe", target, "error", err)
+	}
+	probeTargets = append(probeTargets, target)
+	probes = append(probes, probe)
+}
+
+// Parse histogram bucket upper bounds, comma separated, in seconds
+func parseBuckets(value string) []float64 {
+	var buckets []float64
+	for _, bound := range strings.Split(value, ",") {
+		bucket, err := strconv.ParseFloat(strings.TrimSpace(bound), 64)
+		if err != nil || bucket <= 0 {
+			fatalWith(ExitConfig, "Cannot parse histogram bucket, must be a positive number of seconds", "bucket", bound)
+		}
+		buckets = append(buckets, bucket)
+	}
+	sort.Float64s(buckets)
+	return buckets
+}
+
+// Start each probe in its own goroutine, so a slow probe does not delay the others nor the collectors
+func startProbes(realStartTime time.Time) {
+	probeQuit = make(chan struct{})
+	interval := time.Duration(probeInterval) * time.Second
+
+	for _, probe := range probes {
+		probeWaitGroup.Add(1)
+		go func(probe collectors.Probe) {
+			defer probeWaitGroup.Done()
+
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				probeTime := time.Now()
+				result := probe.Run(interval)
+
+				store.AddProbeSample(ProbeSample{
+					probe:     probe,
+					result:    result,
+					timestamp: metricsStartTime + probeTime.Sub(realStartTime).Milliseconds(),
+				})
+
+				select {
+				case <-ticker.C:
+				case <-probeQuit:
+					return
+				}
+			}
+		}(probe)
+	}
+}
+
+// Stop probes and wait for running ones to finish
+func stopProbes() {
+	if probeQuit == nil {
+		return
+	}
+	close(probeQuit)
+	probeWaitGroup.Wait()
+}
+
+// Results of the probes, with their latency histogram and lookup failures cumulated over the run
+func probeResultsSection() ResultSection {
+	// Lookup failures are also counted per probe, NXDOMAIN apart from other failures
+	dnsNotFound := make(map[string]int)
+	dnsFailures := make(map[string]int)
+
+	// Latency histogram of successful probes, cumulated over the run
+	bucketCounts := make(map[string][]int)
+	latencySum := make(map[string]float64)
+	latencyCount := make(map[string]int)
+
+	var section ResultSection
+	for _, sample := range store.ProbeSamples() {
+		labels := []string{"probe", sample.probe.Target, "type", sample.probe.Type}
+		success := 0
+		if sample.result.Success {
+			success = 1
+		}
+		section.add("probe_success", float64(success), 0, sample.timestamp, labels...)
+		section.add("probe_duration_seconds", sample.result.DurationSeconds, 6, sample.timestamp, labels...)
+
+		if len(probeBuckets) > 0 {
+			key := sample.probe.Type + " " + sample.probe.Target
+			if bucketCounts[key] == nil {
+				bucketCounts[key] = make([]int, len(probeBuckets))
+			}
+			if sample.result.Success {
+				for index, bucket := range probeBuckets {
+					if sample.result.DurationSeconds <= bucket {
+						bucketCounts[key][index]++
+					}
+				}
+				latencySum[key] += sample.result.DurationSeconds
+				latencyCount[key]++
+			}
+			for index, bucket := range probeBuckets {
+				section.add("probe_latency_seconds_bucket", float64(bucketCounts[key][index]), 0, sample.timestamp, append(labels, "le", strconv.FormatFloat(bucket, 'f', -1, 64))...)
+			}
+			section.add("probe_latency_seconds_bucket", float64(latencyCount[key]), 0, sample.timestamp, append(labels, "le", "+Inf")...)
+			section.add("probe_latency_seconds_sum", latencySum[key], 6, sample.timestamp, labels...)
+			section.add("probe_latency_seconds_count", float64(latencyCount[key]), 0, sample.timestamp, labels...)
+		}
+
+		if sample.probe.Type == "dns" {
+			if sample.result.NotFound {
+				dnsNotFound[sample.probe.Target]++
+			} else if !sample.result.Success {
+				dnsFailures[sample.probe.Target]++
+			}
+			section.add("probe_dns_nxdomain_total", float64(dnsNotFound[sample.probe.Target]), 0, sample.timestamp, labels...)
+			section.add("probe_dns_failures_total", float64(dnsFailures[sample.probe.Target]), 0, sample.timestamp, labels...)
+		}
+	}
+	return section
+}
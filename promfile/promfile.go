@@ -0,0 +1,368 @@
+package promfile
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const AnnotationPrefix string = "#grafana-annotation "
+
+// Version of the result file format stamped in its header, bumped on incompatible changes of series or comments
+const (
+	SchemaVersion       int    = 1
+	SchemaCommentPrefix string = "# Schema: "
+)
+
+type Sample struct {
+	Name      string
+	Labels    map[string]string
+	Value     float64
+	Timestamp int64
+}
+
+type Annotation struct {
+	Time    int64    `json:"time"`
+	TimeEnd int64    `json:"timeEnd"`
+	Text    string   `json:"text"`
+	Tags    []string `json:"tags"`
+}
+
+// File is the parsed content of a statexec result file
+type File struct {
+	Comments    []string
+	Annotations []Annotation
+	Samples     []Sample
+}
+
+// Parse a result file from disk
+func ParseFile(path string) (*File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return Parse(file)
+}
+
+// Parse a result file in Prometheus exposition format with grafana annotations comments
+func Parse(reader io.Reader) (*File, error) {
+	result := &File{}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, AnnotationPrefix) {
+			var annotation Annotation
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, AnnotationPrefix)), &annotation); err != nil {
+				return nil, fmt.Errorf("line %d: invalid annotation: %w", lineNumber, err)
+			}
+			result.Annotations = append(result.Annotations, annotation)
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			result.Comments = append(result.Comments, line)
+			continue
+		}
+
+		sample, err := ParseSample(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		result.Samples = append(result.Samples, sample)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Parse a single sample line : name{label="value",...} value [timestamp]
+func ParseSample(line string) (Sample, error) {
+	sample := Sample{Labels: make(map[string]string)}
+
+	nameEnd := strings.IndexAny(line, "{ ")
+	if nameEnd <= 0 {
+		return sample, fmt.Errorf("invalid sample %q", line)
+	}
+	sample.Name = line[:nameEnd]
+	rest := line[nameEnd:]
+
+	if strings.HasPrefix(rest, "{") {
+		labels, consumed, err := parseLabels(rest)
+		if err != nil {
+			return sample, err
+		}
+		sample.Labels = labels
+		rest = rest[consumed:]
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) < 1 || len(fields) > 2 {
+		return sample, fmt.Errorf("invalid value/timestamp in sample %q", line)
+	}
+
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return sample, fmt.Errorf("invalid value in sample %q: %w", line, err)
+	}
+	sample.Value = value
+
+	if len(fields) == 2 {
+		timestamp, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return sample, fmt.Errorf("invalid timestamp in sample %q: %w", line, err)
+		}
+		sample.Timestamp = timestamp
+	}
+
+	return sample, nil
+}
+
+// Parse a label set starting with '{', return labels and number of bytes consumed
+func parseLabels(input string) (map[string]string, int, error) {
+	labels := make(map[string]string)
+	i := 1
+	for {
+		for i < len(input) && (input[i] == ',' || input[i] == ' ') {
+			i++
+		}
+		if i >= len(input) {
+			return nil, 0, fmt.Errorf("unterminated label set in %q", input)
+		}
+		if input[i] == '}' {
+			return labels, i + 1, nil
+		}
+
+		equal := strings.IndexByte(input[i:], '=')
+		if equal <= 0 {
+			return nil, 0, fmt.Errorf("invalid label in %q", input)
+		}
+		key := strings.TrimSpace(input[i : i+equal])
+		i += equal + 1
+		if i >= len(input) || input[i] != '"' {
+			return nil, 0, fmt.Errorf("label %s value must be quoted in %q", key, input)
+		}
+		i++
+
+		var value strings.Builder
+		for {
+			if i >= len(input) {
+				return nil, 0, fmt.Errorf("unterminated label value in %q", input)
+			}
+			if input[i] == '\\' && i+1 < len(input) {
+				switch input[i+1] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(input[i+1])
+				}
+				i += 2
+				continue
+			}
+			if input[i] == '"' {
+				i++
+				break
+			}
+			value.WriteByte(input[i])
+			i++
+		}
+		labels[key] = value.String()
+	}
+}
+
+// Render labels in a stable order, suitable as a series key or in an output file
+func RenderLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, fmt.Sprintf("%s=\"%s\"", key, EscapeLabelValue(labels[key])))
+	}
+	return strings.Join(parts, ",")
+}
+
+// Escape a label value according to the exposition format
+func EscapeLabelValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	return strings.ReplaceAll(value, `"`, `\"`)
+}
+
+// Unique key of the series of a sample
+func (s Sample) SeriesKey() string {
+	return s.Name + "{" + RenderLabels(s.Labels) + "}"
+}
+
+// Return the first sample of a metric, matching the given labels
+func (f *File) Find(name string, labels map[string]string) (Sample, bool) {
+	for _, sample := range f.Samples {
+		if sample.Name != name {
+			continue
+		}
+		match := true
+		for key, value := range labels {
+			if sample.Labels[key] != value {
+				match = false
+				break
+			}
+		}
+		if match {
+			return sample, true
+		}
+	}
+	return Sample{}, false
+}
+
+// Return the value of a label shared by the samples of the file (instance, role, job...)
+func (f *File) Label(name string) string {
+	for _, sample := range f.Samples {
+		if value, ok := sample.Labels[name]; ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// Return the first and last sample timestamps of the file
+func (f *File) TimeRange() (int64, int64) {
+	var first, last int64
+	for i, sample := range f.Samples {
+		if i == 0 || sample.Timestamp < first {
+			first = sample.Timestamp
+		}
+		if sample.Timestamp > last {
+			last = sample.Timestamp
+		}
+	}
+	return first, last
+}
+
+// Return the command duration in milliseconds, based on start and done annotations
+func (f *File) CommandDuration() (int64, bool) {
+	var start, done int64 = -1, -1
+	for _, annotation := range f.Annotations {
+		for _, tag := range annotation.Tags {
+			switch tag {
+			case "start":
+				start = annotation.Time
+			case "done":
+				done = annotation.Time
+			}
+		}
+	}
+	if start == -1 || done == -1 {
+		return 0, false
+	}
+	return done - start, true
+}
+
+// Shift the samples and annotations of the file by an offset in milliseconds
+func (f *File) Shift(offset int64) {
+	for i := range f.Samples {
+		f.Samples[i].Timestamp += offset
+	}
+	for i := range f.Annotations {
+		f.Annotations[i].Time += offset
+		if f.Annotations[i].TimeEnd != 0 {
+			f.Annotations[i].TimeEnd += offset
+		}
+	}
+}
+
+// Render a sample as a line of the exposition format
+func (s Sample) String() string {
+	return fmt.Sprintf("%s{%s} %s %d", s.Name, RenderLabels(s.Labels), strconv.FormatFloat(s.Value, 'f', -1, 64), s.Timestamp)
+}
+
+// Write a result file : comments, annotations then samples
+func (f *File) Write(writer io.Writer) error {
+	buffered := bufio.NewWriter(writer)
+	for _, comment := range f.Comments {
+		fmt.Fprintln(buffered, comment)
+	}
+	fmt.Fprintln(buffered, "")
+	for _, annotation := range f.Annotations {
+		annotationJson, err := json.Marshal(annotation)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(buffered, AnnotationPrefix+string(annotationJson))
+	}
+	fmt.Fprintln(buffered, "")
+	for _, sample := range f.Samples {
+		fmt.Fprintln(buffered, sample.String())
+	}
+	return buffered.Flush()
+}
+
+// Write a result file to disk
+func (f *File) WriteFile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := f.Write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Schema version of the file, 0 for files written before versioning
+func (f *File) Schema() (int, error) {
+	for _, comment := range f.Comments {
+		if value, ok := strings.CutPrefix(comment, SchemaCommentPrefix); ok {
+			version, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || version < 1 {
+				return 0, fmt.Errorf("invalid schema version %q", value)
+			}
+			return version, nil
+		}
+	}
+	return 0, nil
+}
+
+// Check the file can be read by this version, files of a newer schema are rejected
+func (f *File) CheckSchema() error {
+	version, err := f.Schema()
+	if err != nil {
+		return err
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("schema version %d is newer than the supported one (%d), upgrade statexec", version, SchemaVersion)
+	}
+	return nil
+}
+
+// Return the command start timestamp from the start annotation, or the first sample timestamp
+func (f *File) StartTime() int64 {
+	for _, annotation := range f.Annotations {
+		for _, tag := range annotation.Tags {
+			if tag == "start" {
+				return annotation.Time
+			}
+		}
+	}
+	first, _ := f.TimeRange()
+	return first
+}
@@ -0,0 +1,166 @@
+package promfile
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const testFile = `# Statexec result
+# Schema: 1
+
+#grafana-annotation {"time":1000,"timeEnd":1000,"text":"start","tags":["start"]}
+#grafana-annotation {"time":4000,"timeEnd":5000,"text":"done","tags":["done"]}
+
+statexec_command_status{instance="node-1",role="standalone"} 1 1000
+statexec_memory_used_bytes{instance="node-1",role="standalone"} 2048 2000
+statexec_disk_read_bytes_total{device="sda",instance="node-1",path="C:\\data \"x\""} 12.5 3000
+`
+
+func TestParseSample(t *testing.T) {
+	tests := []struct {
+		line string
+		want Sample
+	}{
+		{`up 1`, Sample{Name: "up", Labels: map[string]string{}, Value: 1}},
+		{`up{job="a"} 0.5 1700000000000`, Sample{Name: "up", Labels: map[string]string{"job": "a"}, Value: 0.5, Timestamp: 1700000000000}},
+		{`up{a="x,y", b="line\nbreak"} 2 10`, Sample{Name: "up", Labels: map[string]string{"a": "x,y", "b": "line\nbreak"}, Value: 2, Timestamp: 10}},
+		{`up{a="quote \" and \\"} 3 10`, Sample{Name: "up", Labels: map[string]string{"a": `quote " and \`}, Value: 3, Timestamp: 10}},
+	}
+	for _, test := range tests {
+		got, err := ParseSample(test.line)
+		if err != nil {
+			t.Errorf("ParseSample(%q): %v", test.line, err)
+			continue
+		}
+		if got.SeriesKey() != test.want.SeriesKey() || got.Value != test.want.Value || got.Timestamp != test.want.Timestamp {
+			t.Errorf("ParseSample(%q) = %v, want %v", test.line, got, test.want)
+		}
+	}
+}
+
+func TestParseSampleInvalid(t *testing.T) {
+	for _, line := range []string{
+		`{job="a"} 1`,
+		`up{job="a" 1`,
+		`up{job=a} 1`,
+		`up{job="a"}`,
+		`up{job="a"} x`,
+		`up{job="a"} 1 2 3`,
+		`up{job="a"} 1 1.5`,
+	} {
+		if _, err := ParseSample(line); err == nil {
+			t.Errorf("ParseSample(%q) succeeded, want an error", line)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	file, err := Parse(strings.NewReader(testFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(file.Comments) != 2 || len(file.Annotations) != 2 || len(file.Samples) != 3 {
+		t.Fatalf("parsed %d comments, %d annotations, %d samples, want 2, 2, 3", len(file.Comments), len(file.Annotations), len(file.Samples))
+	}
+	if version, err := file.Schema(); err != nil || version != 1 {
+		t.Errorf("schema = %d, %v, want 1", version, err)
+	}
+	if got := file.Label("instance"); got != "node-1" {
+		t.Errorf("instance label = %q, want node-1", got)
+	}
+	sample, ok := file.Find("statexec_disk_read_bytes_total", map[string]string{"device": "sda"})
+	if !ok || sample.Value != 12.5 || sample.Labels["path"] != `C:\data "x"` {
+		t.Errorf("disk sample = %v, %v", sample, ok)
+	}
+	if first, last := file.TimeRange(); first != 1000 || last != 3000 {
+		t.Errorf("time range = %d-%d, want 1000-3000", first, last)
+	}
+	if duration, ok := file.CommandDuration(); !ok || duration != 3000 {
+		t.Errorf("command duration = %d, %v, want 3000", duration, ok)
+	}
+	if start := file.StartTime(); start != 1000 {
+		t.Errorf("start time = %d, want 1000", start)
+	}
+}
+
+func TestParseInvalidAnnotation(t *testing.T) {
+	_, err := Parse(strings.NewReader("up 1 1000\n" + AnnotationPrefix + "{not json\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("error = %v, want an invalid annotation at line 2", err)
+	}
+}
+
+// A written file parses back to the same content
+func TestWriteRoundTrip(t *testing.T) {
+	file, err := Parse(strings.NewReader(testFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output bytes.Buffer
+	if err := file.Write(&output); err != nil {
+		t.Fatal(err)
+	}
+	written, err := Parse(&output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written.Comments) != len(file.Comments) || len(written.Annotations) != len(file.Annotations) || len(written.Samples) != len(file.Samples) {
+		t.Fatalf("written file has %d comments, %d annotations, %d samples", len(written.Comments), len(written.Annotations), len(written.Samples))
+	}
+	for i, sample := range file.Samples {
+		if written.Samples[i].String() != sample.String() {
+			t.Errorf("sample %d = %v, want %v", i, written.Samples[i], sample)
+		}
+	}
+	for i, annotation := range file.Annotations {
+		if written.Annotations[i].Time != annotation.Time || written.Annotations[i].Text != annotation.Text {
+			t.Errorf("annotation %d = %v, want %v", i, written.Annotations[i], annotation)
+		}
+	}
+}
+
+func TestShift(t *testing.T) {
+	file, err := Parse(strings.NewReader(testFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Annotations = append(file.Annotations, Annotation{Time: 2000, Text: "point"})
+	file.Shift(10000)
+	if first, last := file.TimeRange(); first != 11000 || last != 13000 {
+		t.Errorf("shifted time range = %d-%d, want 11000-13000", first, last)
+	}
+	if got := file.Annotations[1]; got.Time != 14000 || got.TimeEnd != 15000 {
+		t.Errorf("shifted region = %d-%d, want 14000-15000", got.Time, got.TimeEnd)
+	}
+	// A point annotation has no end, it stays without one
+	if got := file.Annotations[2]; got.Time != 12000 || got.TimeEnd != 0 {
+		t.Errorf("shifted point = %d-%d, want 12000-0", got.Time, got.TimeEnd)
+	}
+}
+
+func TestCheckSchema(t *testing.T) {
+	tests := []struct {
+		comment string
+		wantErr bool
+	}{
+		{"# Statexec result", false},
+		{SchemaCommentPrefix + "1", false},
+		{SchemaCommentPrefix + "2", true},
+		{SchemaCommentPrefix + "x", true},
+	}
+	for _, test := range tests {
+		file := &File{Comments: []string{test.comment}}
+		if err := file.CheckSchema(); (err != nil) != test.wantErr {
+			t.Errorf("CheckSchema with %q = %v, want error %v", test.comment, err, test.wantErr)
+		}
+	}
+}
+
+func TestRenderLabels(t *testing.T) {
+	got := RenderLabels(map[string]string{"b": "2", "a": "x\"y\\z\n"})
+	want := `a="x\"y\\z\n",b="2"`
+	if got != want {
+		t.Errorf("RenderLabels = %s, want %s", got, want)
+	}
+}
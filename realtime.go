@@ -0,0 +1,50 @@
+package main
+
+import (
+	"runtime"
+	"strconv"
+)
+
+var realtime bool = false // run the collect loop under SCHED_FIFO with the memory of statexec locked (--realtime)
+
+// Real-time priority of the collect loop thread, above regular tasks, below the kernel threads handling interrupts (50)
+const realtimePriority = 20
+
+// Outcome of --realtime, each part falls back to the regular behaviour when not permitted
+type RealtimeStatus struct {
+	Scheduler    string   `json:"scheduler"` // fifo, or other when the fallback was used
+	Priority     int      `json:"priority,omitempty"`
+	MemoryLocked bool     `json:"memory_locked"`
+	Errors       []string `json:"errors,omitempty"`
+}
+
+var realtimeStatus *RealtimeStatus
+
+// Move the calling goroutine to its own real-time thread and lock the memory, recording what could not be done.
+// The thread is never unlocked, it ends with the goroutine.
+func enableRealtime() {
+	if !realtime {
+		return
+	}
+	runtime.LockOSThread()
+
+	status := &RealtimeStatus{Scheduler: "other"}
+	if err := setRealtimeScheduler(realtimePriority); err != nil {
+		logger.Warn("Cannot set the collect loop to real-time scheduling, needs CAP_SYS_NICE", "error", err)
+		status.Errors = append(status.Errors, "scheduler: "+err.Error())
+	} else {
+		status.Scheduler, status.Priority = "fifo", realtimePriority
+	}
+	if err := lockMemory(); err != nil {
+		logger.Warn("Cannot lock the memory of statexec, needs CAP_IPC_LOCK or a higher memlock limit", "error", err)
+		status.Errors = append(status.Errors, "mlock: "+err.Error())
+	} else {
+		status.MemoryLocked = true
+	}
+	realtimeStatus = status
+
+	addStaticMetric("realtime_info", map[string]string{
+		"scheduler":     status.Scheduler,
+		"memory_locked": strconv.FormatBool(status.MemoryLocked),
+	}, 1, metricsStartTime)
+}
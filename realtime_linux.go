@@ -0,0 +1,29 @@
+//go:build linux
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+const (
+	schedFifo        = 1          // SCHED_FIFO
+	schedResetOnFork = 0x40000000 // SCHED_RESET_ON_FORK, the command forked by another thread never inherits it anyway
+	mclOnFault       = 4          // MCL_ONFAULT, lock pages once used instead of populating the reservations of the Go runtime
+)
+
+// Set the calling thread to SCHED_FIFO
+func setRealtimeScheduler(priority int) error {
+	param := struct{ priority int32 }{int32(priority)}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETSCHEDULER, uintptr(syscall.Gettid()), schedFifo|schedResetOnFork, uintptr(unsafe.Pointer(&param)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Lock current and future memory once used, so samples are never delayed by swapping, without taking memory from the command
+func lockMemory() error {
+	return syscall.Mlockall(syscall.MCL_CURRENT | syscall.MCL_FUTURE | mclOnFault)
+}
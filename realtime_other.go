@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// Real-time scheduling and memory locking are only implemented on Linux
+func setRealtimeScheduler(priority int) error {
+	return errors.New("real-time scheduling only available on Linux")
+}
+
+func lockMemory() error {
+	return errors.New("memory locking only available on Linux")
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	redactLabels    = map[string]bool{} // labels whose values are replaced by a hash in all outputs (--redact-labels)
+	anonymize       bool                // hash the hostname and IP addresses in all outputs (--anonymize)
+	hostnamePattern *regexp.Regexp      // original hostname as a whole word
+)
+
+var (
+	ipv4Pattern = regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}\b`)
+	ipv6Pattern = regexp.MustCompile(`[0-9A-Fa-f]*:[0-9A-Fa-f:]*:[0-9A-Fa-f.]*`)
+
+	redactedValuePattern = regexp.MustCompile(`^anon-[0-9a-f]{8}$`)
+)
+
+func parseRedactLabels(value string) {
+	for _, label := range strings.Split(value, ",") {
+		if label = strings.TrimSpace(label); label != "" {
+			redactLabels[label] = true
+		}
+	}
+}
+
+func redactedLabelNames() []string {
+	var names []string
+	for name := range redactLabels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Stable hash of a value, the same on every node so merged results still correlate, salted with SE_REDACT_SALT against dictionaries
+func redactValue(value string) string {
+	if redactedValuePattern.MatchString(value) {
+		return value
+	}
+	hash := sha256.Sum256([]byte(os.Getenv(EnvVarPrefix+"REDACT_SALT") + value))
+	return "anon-" + hex.EncodeToString(hash[:4])
+}
+
+// Replace the hostname and the IP addresses of a text by their hash
+func anonymizeText(text string) string {
+	if !anonymize {
+		return text
+	}
+	if hostnamePattern != nil {
+		text = hostnamePattern.ReplaceAllStringFunc(text, redactValue)
+	}
+	replaceIp := func(candidate string) string {
+		if net.ParseIP(candidate) == nil {
+			return candidate
+		}
+		return redactValue(candidate)
+	}
+	text = ipv4Pattern.ReplaceAllStringFunc(text, replaceIp)
+	return ipv6Pattern.ReplaceAllStringFunc(text, replaceIp)
+}
+
+// Value of a label as written in the outputs
+func redactLabelValue(key string, value string) string {
+	if redactLabels[key] {
+		return redactValue(value)
+	}
+	return anonymizeText(value)
+}
+
+// Redact the identity of the run once it is resolved, so every output derived from it is redacted
+func applyRedaction() {
+	if len(redactLabels) == 0 && !anonymize {
+		return
+	}
+	if hostname != "" {
+		hostnamePattern = regexp.MustCompile(`\b` + regexp.QuoteMeta(hostname) + `\b`)
+	}
+	hostname = redactLabelValue("hostname", hostname)
+	instance = redactLabelValue("instance", instance)
+	jobName = redactLabelValue("job", jobName)
+	for key, value := range extraLabels {
+		extraLabels[key] = redactLabelValue(key, value)
+	}
+}
+
+// Tags of an annotation as written in the outputs, key=value tags follow the labels
+func redactAnnotation(annotation GrafanaAnnotation) GrafanaAnnotation {
+	if len(redactLabels) == 0 && !anonymize {
+		return annotation
+	}
+	redacted := annotation
+	redacted.Text = anonymizeText(annotation.Text)
+	redacted.Tags = make([]string, len(annotation.Tags))
+	for i, tag := range annotation.Tags {
+		if key, value, ok := strings.Cut(tag, "="); ok {
+			redacted.Tags[i] = key + "=" + redactLabelValue(key, value)
+			continue
+		}
+		redacted.Tags[i] = anonymizeText(tag)
+	}
+	return redacted
+}
@@ -0,0 +1,145 @@
+// Package remotewrite pushes samples with the Prometheus remote write protocol (1.0),
+// protobuf and snappy encoding are implemented here to avoid heavy dependencies.
+package remotewrite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Sample struct {
+	Value     float64
+	Timestamp int64 // in milliseconds
+}
+
+// Exemplar attached to a series, linking a sample to external data like a run id.
+// The receiver must have exemplar storage enabled to keep them.
+type Exemplar struct {
+	Labels    map[string]string
+	Value     float64
+	Timestamp int64 // in milliseconds
+}
+
+type TimeSeries struct {
+	Labels    map[string]string // Including __name__
+	Samples   []Sample
+	Exemplars []Exemplar
+}
+
+var client = &http.Client{Timeout: 30 * time.Second}
+
+// Push series to a remote write endpoint, fail on non 2xx status
+func Push(url string, series []TimeSeries) error {
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(EncodeSnappy(EncodeWriteRequest(series))))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-protobuf")
+	request.Header.Set("Content-Encoding", "snappy")
+	request.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return nil
+}
+
+// Encode a prometheus.WriteRequest message
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; repeated Exemplar exemplars = 3; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+//	Exemplar     { repeated Label labels = 1; double value = 2; int64 timestamp = 3; }
+func EncodeWriteRequest(series []TimeSeries) []byte {
+	var request []byte
+	for _, timeSeries := range series {
+		request = appendBytesField(request, 1, encodeTimeSeries(timeSeries))
+	}
+	return request
+}
+
+func encodeTimeSeries(timeSeries TimeSeries) []byte {
+	message := appendLabels(nil, timeSeries.Labels)
+
+	for _, sample := range timeSeries.Samples {
+		var encodedSample []byte
+		encodedSample = appendTag(encodedSample, 1, 1)
+		encodedSample = binary.LittleEndian.AppendUint64(encodedSample, math.Float64bits(sample.Value))
+		encodedSample = appendTag(encodedSample, 2, 0)
+		encodedSample = binary.AppendUvarint(encodedSample, uint64(sample.Timestamp))
+		message = appendBytesField(message, 2, encodedSample)
+	}
+
+	for _, exemplar := range timeSeries.Exemplars {
+		encodedExemplar := appendLabels(nil, exemplar.Labels)
+		encodedExemplar = appendTag(encodedExemplar, 2, 1)
+		encodedExemplar = binary.LittleEndian.AppendUint64(encodedExemplar, math.Float64bits(exemplar.Value))
+		encodedExemplar = appendTag(encodedExemplar, 3, 0)
+		encodedExemplar = binary.AppendUvarint(encodedExemplar, uint64(exemplar.Timestamp))
+		message = appendBytesField(message, 3, encodedExemplar)
+	}
+	return message
+}
+
+// Append labels as field 1, sorted by name
+func appendLabels(message []byte, labels map[string]string) []byte {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var label []byte
+		label = appendBytesField(label, 1, []byte(name))
+		label = appendBytesField(label, 2, []byte(labels[name]))
+		message = appendBytesField(message, 1, label)
+	}
+	return message
+}
+
+func appendTag(buffer []byte, field int, wireType int) []byte {
+	return binary.AppendUvarint(buffer, uint64(field<<3|wireType))
+}
+
+// Append a length-delimited field (strings, bytes and embedded messages)
+func appendBytesField(buffer []byte, field int, value []byte) []byte {
+	buffer = appendTag(buffer, field, 2)
+	buffer = binary.AppendUvarint(buffer, uint64(len(value)))
+	return append(buffer, value...)
+}
+
+// Encode data in the snappy block format using literals only : valid for any decoder, without compression
+func EncodeSnappy(data []byte) []byte {
+	encoded := binary.AppendUvarint(nil, uint64(len(data)))
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > 65536 {
+			chunk = chunk[:65536]
+		}
+		length := len(chunk) - 1
+		if length < 60 {
+			encoded = append(encoded, byte(length<<2))
+		} else {
+			// Tag 61 : literal length - 1 on the next 2 bytes
+			encoded = append(encoded, 61<<2, byte(length), byte(length>>8))
+		}
+		encoded = append(encoded, chunk...)
+		data = data[len(chunk):]
+	}
+	return encoded
+}
@@ -0,0 +1,209 @@
+package remotewrite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Decode the literals of a snappy block, the only elements EncodeSnappy writes
+func decodeSnappy(t *testing.T, encoded []byte) []byte {
+	t.Helper()
+	length, n := binary.Uvarint(encoded)
+	encoded = encoded[n:]
+	var decoded []byte
+	for len(encoded) > 0 {
+		tag := encoded[0]
+		if tag&3 != 0 {
+			t.Fatalf("unexpected snappy copy element %x", tag)
+		}
+		literal := int(tag >> 2)
+		encoded = encoded[1:]
+		switch literal {
+		case 60:
+			literal = int(encoded[0])
+			encoded = encoded[1:]
+		case 61:
+			literal = int(binary.LittleEndian.Uint16(encoded))
+			encoded = encoded[2:]
+		}
+		decoded = append(decoded, encoded[:literal+1]...)
+		encoded = encoded[literal+1:]
+	}
+	if uint64(len(decoded)) != length {
+		t.Fatalf("snappy block of %d bytes, header says %d", len(decoded), length)
+	}
+	return decoded
+}
+
+// Fields of a protobuf message as field number, wire type and raw value
+type protoField struct {
+	number   int
+	wireType int
+	varint   uint64
+	bytes    []byte
+}
+
+func decodeProto(t *testing.T, message []byte) []protoField {
+	t.Helper()
+	var fields []protoField
+	for len(message) > 0 {
+		tag, n := binary.Uvarint(message)
+		message = message[n:]
+		field := protoField{number: int(tag >> 3), wireType: int(tag & 7)}
+		switch field.wireType {
+		case 0:
+			field.varint, n = binary.Uvarint(message)
+			message = message[n:]
+		case 1:
+			field.varint = binary.LittleEndian.Uint64(message)
+			message = message[8:]
+		case 2:
+			length, n := binary.Uvarint(message)
+			field.bytes = message[n : n+int(length)]
+			message = message[n+int(length):]
+		default:
+			t.Fatalf("unexpected wire type %d", field.wireType)
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+func decodeLabels(t *testing.T, labels map[string]string, message []byte) {
+	t.Helper()
+	var name, value string
+	for _, field := range decodeProto(t, message) {
+		switch field.number {
+		case 1:
+			name = string(field.bytes)
+		case 2:
+			value = string(field.bytes)
+		}
+	}
+	labels[name] = value
+}
+
+// Decode a WriteRequest back to the series it was encoded from
+func decodeWriteRequest(t *testing.T, request []byte) []TimeSeries {
+	t.Helper()
+	var series []TimeSeries
+	for _, field := range decodeProto(t, request) {
+		if field.number != 1 {
+			t.Fatalf("unexpected WriteRequest field %d", field.number)
+		}
+		timeSeries := TimeSeries{Labels: make(map[string]string)}
+		for _, field := range decodeProto(t, field.bytes) {
+			switch field.number {
+			case 1:
+				decodeLabels(t, timeSeries.Labels, field.bytes)
+			case 2:
+				var sample Sample
+				for _, field := range decodeProto(t, field.bytes) {
+					switch field.number {
+					case 1:
+						sample.Value = math.Float64frombits(field.varint)
+					case 2:
+						sample.Timestamp = int64(field.varint)
+					}
+				}
+				timeSeries.Samples = append(timeSeries.Samples, sample)
+			case 3:
+				exemplar := Exemplar{Labels: make(map[string]string)}
+				for _, field := range decodeProto(t, field.bytes) {
+					switch field.number {
+					case 1:
+						decodeLabels(t, exemplar.Labels, field.bytes)
+					case 2:
+						exemplar.Value = math.Float64frombits(field.varint)
+					case 3:
+						exemplar.Timestamp = int64(field.varint)
+					}
+				}
+				timeSeries.Exemplars = append(timeSeries.Exemplars, exemplar)
+			}
+		}
+		series = append(series, timeSeries)
+	}
+	return series
+}
+
+var testSeries = []TimeSeries{
+	{
+		Labels:  map[string]string{"__name__": "statexec_command_status", "instance": "node-1"},
+		Samples: []Sample{{Value: 0, Timestamp: 1700000000000}, {Value: 1, Timestamp: 1700000001000}},
+		Exemplars: []Exemplar{
+			{Labels: map[string]string{"run_id": "abc"}, Value: 1, Timestamp: 1700000001000},
+		},
+	},
+	{
+		Labels:  map[string]string{"__name__": "statexec_memory_used_bytes", "instance": "node-1"},
+		Samples: []Sample{{Value: 2048.5, Timestamp: 1700000000000}},
+	},
+}
+
+func TestEncodeWriteRequestRoundTrip(t *testing.T) {
+	decoded := decodeWriteRequest(t, EncodeWriteRequest(testSeries))
+	if !reflect.DeepEqual(decoded, testSeries) {
+		t.Errorf("decoded series = %+v, want %+v", decoded, testSeries)
+	}
+}
+
+func TestEncodeSnappy(t *testing.T) {
+	for _, size := range []int{0, 1, 59, 60, 61, 256, 65536, 65537, 200000} {
+		data := bytes.Repeat([]byte("statexec"), size/8+1)[:size]
+		if decoded := decodeSnappy(t, EncodeSnappy(data)); !bytes.Equal(decoded, data) {
+			t.Errorf("snappy round trip of %d bytes gave %d bytes", size, len(decoded))
+		}
+	}
+}
+
+func TestPush(t *testing.T) {
+	var received []TimeSeries
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("method = %s, want POST", r.Method)
+		}
+		for header, want := range map[string]string{
+			"Content-Type":                      "application/x-protobuf",
+			"Content-Encoding":                  "snappy",
+			"X-Prometheus-Remote-Write-Version": "0.1.0",
+		} {
+			if got := r.Header.Get(header); got != want {
+				t.Errorf("%s = %q, want %q", header, got, want)
+			}
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		received = decodeWriteRequest(t, decodeSnappy(t, body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	if err := Push(server.URL, testSeries); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(received, testSeries) {
+		t.Errorf("received series = %+v, want %+v", received, testSeries)
+	}
+}
+
+func TestPushRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	err := Push(server.URL, testSeries)
+	if err == nil || !strings.Contains(err.Error(), "400") || !strings.Contains(err.Error(), "out of order sample") {
+		t.Errorf("error = %v, want the status and body of the rejection", err)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/blackswifthosting/statexec/promfile"
+	"github.com/blackswifthosting/statexec/remotewrite"
+)
+
+var remoteWriteEndpoint string = "" // Prometheus remote write endpoint the result is pushed to once the command is done (--remote-write)
+
+// Series pushed per request, receivers limit the size of a request
+const remoteWriteBatchSeries = 500
+
+// The result pushed to a remote write endpoint, the annotations attached as exemplars to the command status as replay does
+type remoteWriteExporter struct {
+	url string
+}
+
+func (exporter remoteWriteExporter) Name() string {
+	return "remote_write"
+}
+
+func (exporter remoteWriteExporter) Export(result RunResult) error {
+	var series []remotewrite.TimeSeries
+	for _, oneSeries := range result.series() {
+		timeSeries := remotewrite.TimeSeries{Labels: blockLabels(oneSeries.name, oneSeries.labels)}
+		for i, timestamp := range oneSeries.timestamps {
+			timeSeries.Samples = append(timeSeries.Samples, remotewrite.Sample{Value: oneSeries.values[i], Timestamp: timestamp})
+		}
+		if oneSeries.name == "command_status" && len(oneSeries.values) > 0 {
+			for _, annotation := range result.annotations {
+				timeSeries.Exemplars = append(timeSeries.Exemplars, remotewrite.Exemplar{
+					Labels:    annotationExemplarLabels(promfile.Annotation(annotation)),
+					Value:     oneSeries.values[len(oneSeries.values)-1],
+					Timestamp: annotation.Time,
+				})
+			}
+		}
+		series = append(series, timeSeries)
+	}
+
+	for start := 0; start < len(series); start += remoteWriteBatchSeries {
+		end := min(start+remoteWriteBatchSeries, len(series))
+		if err := remotewrite.Push(exporter.url, series[start:end]); err != nil {
+			return fmt.Errorf("cannot push result to %s: %w", exporter.url, err)
+		}
+	}
+	logger.Debug("Result pushed", "url", exporter.url, "series", len(series))
+	return nil
+}
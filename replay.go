@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blackswifthosting/statexec/promfile"
+	"github.com/blackswifthosting/statexec/remotewrite"
+)
+
+var replayFlags = []string{"--speed", "--remote-write"}
+
+// Parse a replay speed : "10x", "10" or "0.5x"
+func parseSpeed(value string) float64 {
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+	if err != nil || speed <= 0 {
+		fatalWith(ExitConfig, "Speed must be a positive number, e.g. 10x", "speed", value)
+	}
+	return speed
+}
+
+// Labels of the exemplar of an annotation: its run id and text, within the 128 characters allowed by Prometheus
+func annotationExemplarLabels(annotation promfile.Annotation) map[string]string {
+	labels := make(map[string]string)
+	length := 0
+	for _, tag := range annotation.Tags {
+		if runId, found := strings.CutPrefix(tag, "run_id="); found {
+			labels["run_id"] = runId
+			length += len("run_id") + len(runId)
+		}
+	}
+	text := []rune(annotation.Text)
+	if available := 128 - length - len("annotation"); len(text) > available {
+		text = text[:max(available, 0)]
+	}
+	labels["annotation"] = string(text)
+	return labels
+}
+
+func replaySubcommand(args []string) {
+	speed := 1.0
+	remoteWriteUrl := os.Getenv(EnvVarPrefix + "REMOTE_WRITE")
+
+	files := []string{}
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--speed":
+			speed = parseSpeed(flagValue(args, i))
+			i++
+		case "--remote-write":
+			remoteWriteUrl = flagValue(args, i)
+			i++
+		case "-h", "--help":
+			fmt.Printf("Usage: %s replay [OPTIONS] <file.prom>\n", os.Args[0])
+			fmt.Printf("  --speed <factor>        Replay speed, e.g. 10x (default: 1x)\n")
+			fmt.Printf("  --remote-write <url>    %sREMOTE_WRITE   Prometheus remote write url, samples are printed on stdout if not set\n", EnvVarPrefix)
+			os.Exit(0)
+		default:
+			files = append(files, args[i])
+		}
+	}
+	if len(files) != 1 {
+		fatalWith(ExitConfig, "Replay needs exactly one result file")
+	}
+
+	file, err := parseResultFile(files[0])
+	if err != nil {
+		fatal("Cannot parse result file", "file", files[0], "error", err)
+	}
+	if len(file.Samples) == 0 {
+		fatal("No samples to replay", "file", files[0])
+	}
+
+	// Group samples by timestamp, each group is emitted at once
+	groups := make(map[int64][]promfile.Sample)
+	var timestamps []int64
+	for _, sample := range file.Samples {
+		if _, ok := groups[sample.Timestamp]; !ok {
+			timestamps = append(timestamps, sample.Timestamp)
+		}
+		groups[sample.Timestamp] = append(groups[sample.Timestamp], sample)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	// Annotations are attached as exemplars to the command status sample following them
+	annotationsAt := make(map[int64][]promfile.Annotation)
+	for _, annotation := range file.Annotations {
+		index := sort.Search(len(timestamps), func(i int) bool { return timestamps[i] >= annotation.Time })
+		if index < len(timestamps) {
+			annotationsAt[timestamps[index]] = append(annotationsAt[timestamps[index]], annotation)
+		}
+	}
+
+	firstTimestamp := timestamps[0]
+	replayStart := time.Now()
+	logger.Info("Replay started", "file", files[0], "samples", len(file.Samples), "speed", speed)
+
+	for _, timestamp := range timestamps {
+		// Preserve the recorded spacing between samples, divided by the speed factor
+		offset := time.Duration(float64(timestamp-firstTimestamp)/speed) * time.Millisecond
+		time.Sleep(time.Until(replayStart.Add(offset)))
+		shiftedTimestamp := replayStart.Add(offset).UnixMilli()
+
+		if remoteWriteUrl == "" {
+			for _, sample := range groups[timestamp] {
+				fmt.Printf("%s{%s} %s %d\n", sample.Name, promfile.RenderLabels(sample.Labels), strconv.FormatFloat(sample.Value, 'f', -1, 64), shiftedTimestamp)
+			}
+			continue
+		}
+
+		var series []remotewrite.TimeSeries
+		for _, sample := range groups[timestamp] {
+			labels := map[string]string{"__name__": sample.Name}
+			for key, value := range sample.Labels {
+				labels[key] = value
+			}
+			timeSeries := remotewrite.TimeSeries{
+				Labels:  labels,
+				Samples: []remotewrite.Sample{{Value: sample.Value, Timestamp: shiftedTimestamp}},
+			}
+			if sample.Name == MetricPrefix+"command_status" {
+				for _, annotation := range annotationsAt[timestamp] {
+					timeSeries.Exemplars = append(timeSeries.Exemplars, remotewrite.Exemplar{
+						Labels:    annotationExemplarLabels(annotation),
+						Value:     sample.Value,
+						Timestamp: replayStart.Add(time.Duration(float64(annotation.Time-firstTimestamp)/speed) * time.Millisecond).UnixMilli(),
+					})
+				}
+			}
+			series = append(series, timeSeries)
+		}
+		if err := remotewrite.Push(remoteWriteUrl, series); err != nil {
+			fatal("Cannot push samples", "url", remoteWriteUrl, "error", err)
+		}
+	}
+
+	logger.Info("Replay done", "file", files[0], "duration", time.Since(replayStart).String())
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/blackswifthosting/statexec/promfile"
+)
+
+const SummaryPrefix string = MetricPrefix + "summary_"
+
+// Report of a result file, built from its summary metrics and annotations
+type FileReport struct {
+	File            string             `json:"file"`
+	Instance        string             `json:"instance"`
+	Role            string             `json:"role"`
+	Job             string             `json:"job"`
+	DurationSeconds float64            `json:"duration_seconds"`
+	Samples         int                `json:"samples"`
+	Command         []string           `json:"command,omitempty"`
+	Summary         map[string]float64 `json:"summary"`
+}
+
+var reportFlags = []string{"--format"}
+var compareFlags = []string{"--format"}
+
+func buildFileReport(path string) FileReport {
+	file, err := parseResultFile(path)
+	if err != nil {
+		fatal("Cannot parse result file", "file", path, "error", err)
+	}
+
+	report := FileReport{
+		File:     path,
+		Instance: file.Label("instance"),
+		Role:     file.Label("role"),
+		Job:      file.Label("job"),
+		Samples:  len(file.Samples),
+		Summary:  summaryValues(file),
+	}
+	if duration, ok := file.CommandDuration(); ok {
+		report.DurationSeconds = float64(duration) / 1000.0
+	}
+	if config, ok := fileConfig(file); ok {
+		report.Command = config.Command
+	}
+	return report
+}
+
+// Effective configuration recorded in a result file, missing in files written by older versions
+func fileConfig(file *promfile.File) (EffectiveConfig, bool) {
+	var config EffectiveConfig
+	for _, comment := range file.Comments {
+		if configJson, ok := strings.CutPrefix(comment, ConfigCommentPrefix); ok {
+			if err := json.Unmarshal([]byte(configJson), &config); err == nil {
+				return config, true
+			}
+		}
+	}
+	return config, false
+}
+
+// Extract summary metrics from a file, keyed by name (without prefix) and specific labels
+func summaryValues(file *promfile.File) map[string]float64 {
+	var summarySamples []promfile.Sample
+	for _, sample := range file.Samples {
+		if strings.HasPrefix(sample.Name, SummaryPrefix) {
+			summarySamples = append(summarySamples, sample)
+		}
+	}
+
+	// Labels shared by all summary samples (instance, job, role, extra labels) are not part of the key
+	commonLabels := make(map[string]string)
+	if len(summarySamples) > 0 {
+		for key, value := range summarySamples[0].Labels {
+			commonLabels[key] = value
+		}
+	}
+	for _, sample := range summarySamples {
+		for key, value := range commonLabels {
+			if sample.Labels[key] != value {
+				delete(commonLabels, key)
+			}
+		}
+	}
+
+	values := make(map[string]float64)
+	for _, sample := range summarySamples {
+		specificLabels := make(map[string]string)
+		for key, value := range sample.Labels {
+			if _, common := commonLabels[key]; !common {
+				specificLabels[key] = value
+			}
+		}
+		key := strings.TrimPrefix(sample.Name, SummaryPrefix)
+		if len(specificLabels) > 0 {
+			key += "{" + promfile.RenderLabels(specificLabels) + "}"
+		}
+		values[key] = sample.Value
+	}
+	return values
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Parse --format flag and positional arguments of report/compare subcommands
+func parseReportArgs(args []string, usageLine string) (string, []string) {
+	format := "text"
+	files := []string{}
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--format":
+			format = flagValue(args, i)
+			if format != "text" && format != "json" && format != "html" {
+				fatalWith(ExitConfig, "Format must be text, json or html", "format", format)
+			}
+			i++
+		case "-h", "--help":
+			fmt.Printf("Usage: %s %s\n", os.Args[0], usageLine)
+			fmt.Println("  --format <text|json|html>   Output format, html for report only (default: text)")
+			os.Exit(0)
+		default:
+			files = append(files, args[i])
+		}
+	}
+	return format, files
+}
+
+func reportSubcommand(args []string) {
+	format, files := parseReportArgs(args, "report [--format <text|json|html>] <file.prom>")
+	if len(files) != 1 {
+		fatalWith(ExitConfig, "Report needs exactly one result file")
+	}
+
+	report := buildFileReport(files[0])
+
+	if format == "json" {
+		printJson(report)
+		return
+	}
+	if format == "html" {
+		fmt.Print(renderHtmlReport(report))
+		return
+	}
+
+	fmt.Printf("File:      %s\n", report.File)
+	fmt.Printf("Instance:  %s\n", report.Instance)
+	fmt.Printf("Role:      %s\n", report.Role)
+	fmt.Printf("Job:       %s\n", report.Job)
+	if len(report.Command) > 0 {
+		fmt.Printf("Command:   %s\n", strings.Join(report.Command, " "))
+	}
+	fmt.Printf("Duration:  %.3fs\n", report.DurationSeconds)
+	fmt.Printf("Samples:   %d\n", report.Samples)
+	fmt.Println("")
+	fmt.Println("Summary:")
+	for _, key := range sortedKeys(report.Summary) {
+		fmt.Printf("  %-60s %f\n", key, report.Summary[key])
+	}
+}
+
+type ComparedValue struct {
+	Key          string   `json:"key"`
+	A            *float64 `json:"a"`
+	B            *float64 `json:"b"`
+	DeltaPercent *float64 `json:"delta_percent"`
+}
+
+// Compare two summaries, key by key
+func compareSummaries(a map[string]float64, b map[string]float64) []ComparedValue {
+	keys := make(map[string]float64)
+	for key := range a {
+		keys[key] = 0
+	}
+	for key := range b {
+		keys[key] = 0
+	}
+
+	var result []ComparedValue
+	for _, key := range sortedKeys(keys) {
+		compared := ComparedValue{Key: key}
+		valueA, okA := a[key]
+		valueB, okB := b[key]
+		if okA {
+			compared.A = &valueA
+		}
+		if okB {
+			compared.B = &valueB
+		}
+		if okA && okB && valueA != 0 {
+			delta := (valueB - valueA) / valueA * 100
+			compared.DeltaPercent = &delta
+		}
+		result = append(result, compared)
+	}
+	return result
+}
+
+func compareSubcommand(args []string) {
+	format, files := parseReportArgs(args, "compare [--format <text|json>] <a.prom> <b.prom>")
+	if len(files) != 2 {
+		fatalWith(ExitConfig, "Compare needs exactly two result files")
+	}
+	if format == "html" {
+		fatalWith(ExitConfig, "Compare format must be text or json")
+	}
+
+	reportA := buildFileReport(files[0])
+	reportB := buildFileReport(files[1])
+	reportA.Summary["duration_seconds"] = reportA.DurationSeconds
+	reportB.Summary["duration_seconds"] = reportB.DurationSeconds
+
+	compared := compareSummaries(reportA.Summary, reportB.Summary)
+
+	if format == "json" {
+		printJson(compared)
+		return
+	}
+
+	fmt.Printf("A: %s (%s)\n", reportA.File, reportA.Instance)
+	fmt.Printf("B: %s (%s)\n", reportB.File, reportB.Instance)
+	fmt.Println("")
+	fmt.Printf("%-60s %18s %18s %10s\n", "Metric", "A", "B", "Delta")
+	for _, value := range compared {
+		fmt.Printf("%-60s %18s %18s %10s\n", value.Key, formatOptional(value.A, "%f"), formatOptional(value.B, "%f"), formatOptional(value.DeltaPercent, "%+.1f%%"))
+	}
+}
+
+func formatOptional(value *float64, format string) string {
+	if value == nil {
+		return "-"
+	}
+	return fmt.Sprintf(format, *value)
+}
+
+func printJson(value interface{}) {
+	jsonValue, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		fatal("Cannot marshal json", "error", err)
+	}
+	fmt.Println(string(jsonValue))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/blackswifthosting/statexec/promfile"
+)
+
+var resampleFlags = []string{"--step", "--from", "--to", "--output", "-o"}
+
+// Parse a time bound : a timestamp in milliseconds, or a duration relative to the first sample (e.g. 30s)
+func parseTimeBound(value string, firstTimestamp int64) int64 {
+	if timestamp, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return timestamp
+	}
+	offset, err := time.ParseDuration(value)
+	if err != nil {
+		fatalWith(ExitConfig, "Time bound must be a timestamp in milliseconds or a duration since the first sample, e.g. 30s", "value", value)
+	}
+	return firstTimestamp + offset.Milliseconds()
+}
+
+func resampleSubcommand(args []string) {
+	step := ""
+	from := ""
+	to := ""
+	output := ""
+
+	files := []string{}
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--step":
+			step = flagValue(args, i)
+			i++
+		case "--from":
+			from = flagValue(args, i)
+			i++
+		case "--to":
+			to = flagValue(args, i)
+			i++
+		case "-o", "--output":
+			output = flagValue(args, i)
+			i++
+		case "-h", "--help":
+			fmt.Printf("Usage: %s resample [OPTIONS] <file.prom>\n", os.Args[0])
+			fmt.Println("  --step <duration>        Keep one sample per series and step, e.g. 10s (default: keep all samples)")
+			fmt.Println("  --from <time>            Drop samples before, timestamp in ms or duration since the first sample (default: first sample)")
+			fmt.Println("  --to <time>              Drop samples after, timestamp in ms or duration since the first sample (default: last sample)")
+			fmt.Println("  --output, -o <file>      Output file (default: stdout)")
+			os.Exit(0)
+		default:
+			files = append(files, args[i])
+		}
+	}
+	if len(files) != 1 {
+		fatalWith(ExitConfig, "Resample needs exactly one result file")
+	}
+
+	file, err := parseResultFile(files[0])
+	if err != nil {
+		fatal("Cannot parse result file", "file", files[0], "error", err)
+	}
+
+	firstTimestamp, lastTimestamp := file.TimeRange()
+	fromTimestamp, toTimestamp := firstTimestamp, lastTimestamp
+	if from != "" {
+		fromTimestamp = parseTimeBound(from, firstTimestamp)
+	}
+	if to != "" {
+		toTimestamp = parseTimeBound(to, firstTimestamp)
+	}
+	var stepMs int64
+	if step != "" {
+		stepDuration, err := time.ParseDuration(step)
+		if err != nil || stepDuration < time.Millisecond {
+			fatalWith(ExitConfig, "Step must be a duration, e.g. 10s", "step", step)
+		}
+		stepMs = stepDuration.Milliseconds()
+	}
+
+	resampled := &promfile.File{Comments: file.Comments}
+	for _, annotation := range file.Annotations {
+		if annotation.Time > toTimestamp || max(annotation.Time, annotation.TimeEnd) < fromTimestamp {
+			continue
+		}
+		// Regions overlapping the window are cropped to it
+		annotation.Time = max(annotation.Time, fromTimestamp)
+		if annotation.TimeEnd != 0 {
+			annotation.TimeEnd = max(min(annotation.TimeEnd, toTimestamp), annotation.Time)
+		}
+		resampled.Annotations = append(resampled.Annotations, annotation)
+	}
+
+	// Keep the first sample of each series in each step, counters stay consistent as they are cumulative
+	lastBucket := make(map[string]int64)
+	for _, sample := range file.Samples {
+		if sample.Timestamp < fromTimestamp || sample.Timestamp > toTimestamp {
+			continue
+		}
+		if stepMs > 0 {
+			key := sample.SeriesKey()
+			bucket := (sample.Timestamp - fromTimestamp) / stepMs
+			if previous, ok := lastBucket[key]; ok && previous == bucket {
+				continue
+			}
+			lastBucket[key] = bucket
+		}
+		resampled.Samples = append(resampled.Samples, sample)
+	}
+
+	if output == "" {
+		err = resampled.Write(os.Stdout)
+	} else {
+		err = resampled.WriteFile(output)
+	}
+	if err != nil {
+		fatalWith(ExitOutput, "Cannot write resampled file", "error", err)
+	}
+	logger.Info("Result file resampled", "file", files[0], "samples", len(file.Samples), "kept", len(resampled.Samples))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// A point of the result outside the samples (inventory, command lifecycle, probes, summary), with its own timestamp
+type ResultPoint struct {
+	name      string
+	labels    map[string]string
+	value     float64
+	precision int // digits after the decimal point in the text formats, 0 for integers and -1 for the shortest exact value
+	timestamp int64
+}
+
+// Points of the result in the order they are written, titled by a comment in the text formats
+type ResultSection struct {
+	title  string
+	points []ResultPoint
+}
+
+// Append a point, labels are given as key/value pairs
+func (section *ResultSection) add(name string, value float64, precision int, timestamp int64, labels ...string) {
+	pointLabels := make(map[string]string, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pointLabels[labels[i]] = labels[i+1]
+	}
+	section.points = append(section.points, ResultPoint{name: name, labels: pointLabels, value: value, precision: precision, timestamp: timestamp})
+}
+
+// Everything a run produces, gathered once the command is done and given as is to every exporter
+type RunResult struct {
+	annotations []GrafanaAnnotation // redacted
+	before      []ResultSection     // written before the samples: inventory and command lifecycle
+	metrics     MetricsSnapshot
+	after       []ResultSection // written after the samples: probes and summary
+	summary     RunSummary
+}
+
+func collectRunResult() RunResult {
+	var annotations []GrafanaAnnotation
+	for _, annotation := range store.Annotations() {
+		annotations = append(annotations, redactAnnotation(annotation))
+	}
+	summary := runSummary()
+	return RunResult{
+		annotations: annotations,
+		before:      []ResultSection{staticMetricsSection(), commandEventsSection()},
+		metrics:     store.Metrics(),
+		after:       []ResultSection{probeResultsSection(), summarySection(summary)},
+		summary:     summary,
+	}
+}
+
+// Render a point in prometheus format
+func appendResultPoint(buffer []byte, point ResultPoint) []byte {
+	buffer = append(buffer, MetricPrefix...)
+	buffer = append(buffer, point.name...)
+	buffer = append(buffer, '{')
+	buffer = append(buffer, renderLabels(point.labels)...)
+	buffer = append(buffer, "} "...)
+	if point.precision == 0 {
+		buffer = strconv.AppendInt(buffer, int64(point.value), 10)
+	} else {
+		buffer = strconv.AppendFloat(buffer, point.value, 'f', point.precision, 64)
+	}
+	buffer = append(buffer, ' ')
+	buffer = strconv.AppendInt(buffer, point.timestamp, 10)
+	return append(buffer, '\n')
+}
+
+// Render a section in prometheus format, its title as a comment
+func renderResultSection(section ResultSection) string {
+	var buffer []byte
+	if section.title != "" {
+		buffer = append(buffer, "# "+section.title+"\n"...)
+	}
+	for _, point := range section.points {
+		buffer = appendResultPoint(buffer, point)
+	}
+	return string(buffer)
+}
+
+// Every series of the result: the sampled ones, then those of the sections in the order their points are written
+func (result RunResult) series() []Series {
+	series := append([]Series(nil), result.metrics.series...)
+	seriesIndex := make(map[string]int)
+	for _, section := range append(append([]ResultSection(nil), result.before...), result.after...) {
+		for _, point := range section.points {
+			key := point.name + "\xff" + strings.Join(flattenLabels(point.labels), "\xff")
+			index, ok := seriesIndex[key]
+			if !ok {
+				index = len(series)
+				seriesIndex[key] = index
+				series = append(series, Series{name: point.name, labels: point.labels, rendered: renderLabels(point.labels), integer: point.precision == 0})
+			}
+			series[index].add(point.value, point.timestamp)
+		}
+	}
+	return series
+}
+
+// Labels as sorted key/value pairs
+func flattenLabels(labels map[string]string) []string {
+	pairs := make([]string, 0, len(labels)*2)
+	for _, key := range sortedLabelNames(labels) {
+		pairs = append(pairs, key, labels[key])
+	}
+	return pairs
+}
+
+// Labels of a series as renderLabels writes them
+func seriesLabels(metricsLabels map[string]string) map[string]string {
+	labels := map[string]string{
+		"instance": instance,
+		"job":      jobName,
+		"role":     role,
+		"hostname": hostname,
+	}
+	for key, value := range metricsLabels {
+		labels[key] = redactLabelValue(key, value)
+	}
+	for key, value := range extraLabels {
+		labels[key] = value
+	}
+	return labels
+}
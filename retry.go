@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	commandRetries int           = 0                // attempts after a failed one, the summary only covers the last attempt (--retries)
+	retryBackoff   time.Duration = 10 * time.Second // delay before running a failed command again (--retry-backoff)
+	commandAttempt int           = 0                // attempt of the command running, from 1
+
+	// Closed when the command must not be run again: interrupted or stopped by the sync leader
+	retriesCancelled  = make(chan struct{})
+	cancelRetriesOnce sync.Once
+)
+
+func parseRetries(value string) int {
+	retries, err := strconv.Atoi(value)
+	if err != nil || retries < 0 {
+		fatalWith(ExitConfig, "Cannot parse retries, expected a positive number", "value", value)
+	}
+	return retries
+}
+
+func cancelRetries() {
+	cancelRetriesOnce.Do(func() { close(retriesCancelled) })
+}
+
+// Whether a failed attempt is run again, a command stopped by a stop condition or an aborted session is not
+func shouldRetry(exitCode int) bool {
+	if exitCode == 0 || commandAttempt > commandRetries || stoppedByCondition.Load() || session.abortReason() != "" {
+		return false
+	}
+	select {
+	case <-retriesCancelled:
+		return false
+	default:
+		return true
+	}
+}
+
+// Wait before the next attempt, false if the retries were cancelled meanwhile
+func waitRetryBackoff() bool {
+	timer := time.NewTimer(retryBackoff)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-retriesCancelled:
+		return false
+	}
+}
+
+// Exit code and duration of an attempt, labeled with its number
+func recordCommandAttempt(exitCode int, startedAt int64, finishedAt int64) {
+	if commandRetries == 0 {
+		return
+	}
+	labels := map[string]string{"attempt": strconv.Itoa(commandAttempt)}
+	addStaticMetric("command_attempt_exit_code", labels, float64(exitCode), finishedAt)
+	addStaticMetric("command_attempt_duration_seconds", labels, float64(finishedAt-startedAt)/1000, finishedAt)
+}
+
+// Annotate an attempt which failed and is run again
+func annotateFailedAttempt(exitCode int, finishedAt int64) {
+	store.AddAnnotation(GrafanaAnnotation{
+		Time:    finishedAt,
+		TimeEnd: finishedAt,
+		Text:    "Attempt " + strconv.Itoa(commandAttempt) + " failed with status " + strconv.Itoa(exitCode) + ", retrying after " + retryBackoff.String(),
+		Tags: []string{
+			"statexec",
+			"retry",
+			"attempt=" + strconv.Itoa(commandAttempt),
+			"instance=" + instance,
+			"job=" + jobName,
+			"role=" + role,
+			"hostname=" + hostname,
+			"run_id=" + runId,
+		},
+	})
+}
+
+// A started command cannot run again, a fresh one replaces it in place as the stop handlers hold the pointer
+func resetCommand(cmd *exec.Cmd) {
+	fresh := exec.Command(cmd.Path)
+	fresh.Args = cmd.Args
+	fresh.Env = cmd.Env
+	fresh.Dir = cmd.Dir
+	fresh.Stdin = cmd.Stdin
+	fresh.Stdout = cmd.Stdout
+	fresh.Stderr = cmd.Stderr
+	fresh.SysProcAttr = cmd.SysProcAttr
+	*cmd = *fresh
+}
+
+// Attempts of the command for the summary, none without retries
+func commandAttempts() int {
+	if commandRetries == 0 {
+		return 0
+	}
+	return commandAttempt
+}
+
+func formatRetryBackoff() string {
+	if commandRetries == 0 {
+		return ""
+	}
+	return retryBackoff.String()
+}
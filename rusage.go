@@ -0,0 +1,48 @@
+package main
+
+// Resource usage of the command as reported by the kernel when it exited (wait4), exact where samples are periodic
+type CommandUsage struct {
+	MaxRssBytes            int64   `json:"max_rss_bytes"`
+	UserCpuSeconds         float64 `json:"user_cpu_seconds"`
+	SystemCpuSeconds       float64 `json:"system_cpu_seconds"`
+	BlockInputOperations   int64   `json:"block_input_operations"`
+	BlockOutputOperations  int64   `json:"block_output_operations"`
+	VoluntaryCtxSwitches   int64   `json:"voluntary_context_switches"`
+	InvoluntaryCtxSwitches int64   `json:"involuntary_context_switches"`
+	MajorPageFaults        int64   `json:"major_page_faults"`
+}
+
+var commandUsage *CommandUsage // nil until the command exited, or if the platform does not report it
+
+// Resources statexec itself consumed while collecting, to check the observer effect was negligible
+type OverheadUsage struct {
+	UserCpuSeconds   float64 `json:"user_cpu_seconds"`
+	SystemCpuSeconds float64 `json:"system_cpu_seconds"`
+	CpuCores         float64 `json:"cpu_cores"`                   // mean CPU cores used over the collection
+	CommandCpuRatio  float64 `json:"command_cpu_ratio,omitempty"` // CPU time of statexec over the CPU time of the command
+	MaxRssBytes      int64   `json:"max_rss_bytes"`
+}
+
+var overheadUsage *OverheadUsage // nil until the collection ended, or if the platform does not report it
+
+// Measure the overhead of statexec once the collection is over
+func measureOverhead(collectSeconds float64) {
+	self := selfResourceUsage()
+	if self == nil || fakeCollectorsSeed >= 0 {
+		return
+	}
+	overhead := &OverheadUsage{
+		UserCpuSeconds:   self.UserCpuSeconds,
+		SystemCpuSeconds: self.SystemCpuSeconds,
+		MaxRssBytes:      self.MaxRssBytes,
+	}
+	cpuSeconds := self.UserCpuSeconds + self.SystemCpuSeconds
+	if collectSeconds > 0 {
+		overhead.CpuCores = cpuSeconds / collectSeconds
+	}
+	if commandUsage != nil && commandUsage.UserCpuSeconds+commandUsage.SystemCpuSeconds > 0 {
+		overhead.CommandCpuRatio = cpuSeconds / (commandUsage.UserCpuSeconds + commandUsage.SystemCpuSeconds)
+	}
+	overheadUsage = overhead
+	logger.Info("Statexec overhead", "cpu_seconds", cpuSeconds, "cpu_cores", overhead.CpuCores, "command_cpu_ratio", overhead.CommandCpuRatio, "max_rss_bytes", overhead.MaxRssBytes)
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// Resource usage of processes is only reported on unix
+func commandResourceUsage(state *os.ProcessState) *CommandUsage {
+	return nil
+}
+
+func selfResourceUsage() *CommandUsage {
+	return nil
+}
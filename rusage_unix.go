@@ -0,0 +1,46 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// Resource usage of the exited command and of its descendants it waited for
+func commandResourceUsage(state *os.ProcessState) *CommandUsage {
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok || rusage == nil {
+		return nil
+	}
+	return resourceUsage(rusage)
+}
+
+// Resource usage of statexec itself, its threads only
+func selfResourceUsage() *CommandUsage {
+	var rusage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &rusage); err != nil {
+		return nil
+	}
+	return resourceUsage(&rusage)
+}
+
+func resourceUsage(rusage *syscall.Rusage) *CommandUsage {
+	// Maximum resident set size is in kilobytes, except on macOS where it is in bytes
+	maxRss := int64(rusage.Maxrss)
+	if runtime.GOOS != "darwin" {
+		maxRss *= 1024
+	}
+	return &CommandUsage{
+		MaxRssBytes:            maxRss,
+		UserCpuSeconds:         time.Duration(syscall.TimevalToNsec(rusage.Utime)).Seconds(),
+		SystemCpuSeconds:       time.Duration(syscall.TimevalToNsec(rusage.Stime)).Seconds(),
+		BlockInputOperations:   int64(rusage.Inblock),
+		BlockOutputOperations:  int64(rusage.Oublock),
+		VoluntaryCtxSwitches:   int64(rusage.Nvcsw),
+		InvoluntaryCtxSwitches: int64(rusage.Nivcsw),
+		MajorPageFaults:        int64(rusage.Majflt),
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Default file of the deny patterns of a shared machine, one regular expression per line
+const defaultDenyFile = "/etc/statexec/deny"
+
+var (
+	requireConfirm bool             = false           // ask for a confirmation on the terminal before running the command (--require-confirm)
+	assumeYes      bool             = false           // run without confirmation, even a denied command (--yes)
+	denyFile       string           = defaultDenyFile // deny patterns configured for the machine (--deny-file)
+	denyPatterns   []*regexp.Regexp                   // commands refused unless --yes (--deny, and the deny file)
+)
+
+func addDenyPattern(pattern string) {
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		fatalWith(ExitConfig, "Cannot parse deny pattern, must be a regular expression", "pattern", pattern, "error", err)
+	}
+	denyPatterns = append(denyPatterns, compiled)
+}
+
+func denyPatternStrings() []string {
+	var patterns []string
+	for _, pattern := range denyPatterns {
+		patterns = append(patterns, pattern.String())
+	}
+	return patterns
+}
+
+// Load the deny patterns of the machine, a missing default file means none
+func loadDenyFile() {
+	if denyFile == "" {
+		return
+	}
+	file, err := os.Open(denyFile)
+	if err != nil {
+		if os.IsNotExist(err) && denyFile == defaultDenyFile {
+			return
+		}
+		fatalWith(ExitConfig, "Cannot read deny file", "file", denyFile, "error", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addDenyPattern(line)
+	}
+	if err := scanner.Err(); err != nil {
+		fatalWith(ExitConfig, "Cannot read deny file", "file", denyFile, "error", err)
+	}
+}
+
+// First deny pattern matching the command line, its words joined by spaces
+func deniedBy(cmd []string) (string, bool) {
+	commandLine := strings.Join(cmd, " ")
+	for _, pattern := range denyPatterns {
+		if pattern.MatchString(commandLine) {
+			return pattern.String(), true
+		}
+	}
+	return "", false
+}
+
+// Refuse a denied command and ask for a confirmation if required, before anything runs or syncs with peers
+func checkCommandSafety(cmd []string) {
+	if pattern, denied := deniedBy(cmd); denied {
+		if !assumeYes {
+			fatalWith(ExitConfig, "Command matches a deny pattern, pass --yes to run it anyway", "command", strings.Join(cmd, " "), "pattern", pattern)
+		}
+		logger.Warn("Running a command matching a deny pattern", "command", strings.Join(cmd, " "), "pattern", pattern)
+	}
+	if !requireConfirm || assumeYes {
+		return
+	}
+	if !confirm(fmt.Sprintf("Run %q on %s? [y/N] ", strings.Join(cmd, " "), hostname)) {
+		fatalWith(ExitConfig, "Command not confirmed")
+	}
+}
+
+// Ask a question on the terminal, the standard input belongs to the command
+func confirm(question string) bool {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		fatalWith(ExitConfig, "Confirmation required but no terminal, pass --yes to run without it", "error", err)
+	}
+	defer tty.Close()
+
+	fmt.Fprint(tty, question)
+	answer, _ := bufio.NewReader(tty).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// Scheduled start time of the command (--start-at), zero if not scheduled
+var startAt time.Time
+
+// Parse a scheduled start time, either absolute (RFC3339) or relative to now (e.g. +30s)
+func parseStartAt(value string) time.Time {
+	if strings.HasPrefix(value, "+") {
+		delay, err := time.ParseDuration(strings.TrimPrefix(value, "+"))
+		if err != nil || delay < 0 {
+			fatalWith(ExitConfig, "Cannot parse start time, expected a positive duration like +30s", "value", value)
+		}
+		return time.Now().Add(delay)
+	}
+	scheduled, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		fatalWith(ExitConfig, "Cannot parse start time, expected RFC3339 like 2024-06-01T12:00:00Z or a duration like +30s", "value", value, "error", err)
+	}
+	return scheduled
+}
+
+// Wait until the scheduled start time of the command, nodes relying on their own clocks start together
+func waitForScheduledStart() {
+	if startAt.IsZero() {
+		return
+	}
+	wait := time.Until(startAt)
+	if wait < 0 {
+		logger.Warn("Scheduled start time is already passed, starting now", "start_at", startAt.Format(time.RFC3339Nano), "late", -wait)
+		return
+	}
+	logger.Info("Waiting for the scheduled start time", "start_at", startAt.Format(time.RFC3339Nano), "wait", wait)
+	time.Sleep(wait)
+}
+
+// Record how late the command actually started compared to its scheduled start time
+func recordStartSkew(startedAt time.Time, timestamp int64) {
+	if startAt.IsZero() {
+		return
+	}
+	skew := startedAt.Sub(startAt)
+	name, value, _ := metricName("command_start_skew_seconds", skew.Seconds(), false)
+	addStaticMetric(name, nil, value, timestamp)
+}
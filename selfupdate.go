@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GitHub release, only the fields needed to update
+type Release struct {
+	TagName string         `json:"tag_name"`
+	Assets  []ReleaseAsset `json:"assets"`
+}
+
+type ReleaseAsset struct {
+	Name        string `json:"name"`
+	DownloadUrl string `json:"browser_download_url"`
+}
+
+// Checksums asset of a release, in sha256sum format, and its detached ed25519 signature (make checksums)
+const (
+	releaseChecksumsAsset  = "SHA256SUMS"
+	releaseSignatureAsset  = "SHA256SUMS.sig"
+	maxChecksumsAssetBytes = 1024 * 1024
+)
+
+var (
+	releaseUrl  string = "https://api.github.com/repos/blackswifthosting/statexec/releases/latest"
+	checkUpdate bool   = false
+)
+
+// Base64 ed25519 public key the checksums of the releases are signed with, set at build time by the Makefile
+// (RELEASE_PUBLIC_KEY). Builds without it only verify the checksum, which detects a corrupted download, not a tampered
+// release or mirror.
+var releasePublicKey string = ""
+
+var releaseClient = &http.Client{Timeout: 5 * time.Minute}
+
+var selfUpdateFlags = []string{"--check", "--release-url"}
+
+// Name of the release binary for the current platform, as built by the Makefile
+func releaseAssetName() string {
+	return "statexec-" + runtime.GOOS + "-" + runtime.GOARCH
+}
+
+func fetchLatestRelease(url string) (Release, error) {
+	var release Release
+	resp, err := releaseClient.Get(url)
+	if err != nil {
+		return release, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return release, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return release, err
+	}
+	return release, nil
+}
+
+func (release Release) asset(name string) (ReleaseAsset, bool) {
+	for _, asset := range release.Assets {
+		if asset.Name == name {
+			return asset, true
+		}
+	}
+	return ReleaseAsset{}, false
+}
+
+// Parse a X.Y.Z version, with an optional v prefix
+func parseSemver(value string) ([3]int, bool) {
+	var semver [3]int
+	parts := strings.Split(strings.TrimPrefix(value, "v"), ".")
+	if len(parts) != 3 {
+		return semver, false
+	}
+	for i, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil {
+			return semver, false
+		}
+		semver[i] = number
+	}
+	return semver, true
+}
+
+// Whether a release is newer than the running binary. Dev builds, of a branch or without version, take any release;
+// a released binary only moves to a greater X.Y.Z version, never downgraded or replaced by a differently named tag.
+func isNewerVersion(latest string, current string) bool {
+	currentSemver, ok := parseSemver(current)
+	if !ok {
+		return latest != current
+	}
+	latestSemver, ok := parseSemver(latest)
+	if !ok {
+		return false
+	}
+	for i := range latestSemver {
+		if latestSemver[i] != currentSemver[i] {
+			return latestSemver[i] > currentSemver[i]
+		}
+	}
+	return false
+}
+
+// Download a small asset of a release, the checksums or their signature
+func downloadReleaseAsset(release Release, name string) ([]byte, error) {
+	asset, ok := release.asset(name)
+	if !ok {
+		return nil, fmt.Errorf("release %s has no %s asset", release.TagName, name)
+	}
+	resp, err := releaseClient.Get(asset.DownloadUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot download %s: unexpected status %s", name, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxChecksumsAssetBytes))
+}
+
+// Verify the checksums of a release against their signature by the release key
+func verifyChecksumsSignature(release Release, checksums []byte) error {
+	publicKey, err := base64.StdEncoding.DecodeString(releasePublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid release public key built in statexec")
+	}
+	signature, err := downloadReleaseAsset(release, releaseSignatureAsset)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, checksums, signature) {
+		return fmt.Errorf("invalid signature of %s in release %s", releaseChecksumsAsset, release.TagName)
+	}
+	return nil
+}
+
+// Expected SHA-256 of an asset from the checksums asset of the release, once the checksums signature is verified
+func releaseChecksum(release Release, name string) (string, error) {
+	checksums, err := downloadReleaseAsset(release, releaseChecksumsAsset)
+	if err != nil {
+		return "", err
+	}
+	if releasePublicKey == "" {
+		logger.Warn("Built without a release key, only the checksum of the release is verified, not its signature")
+	} else if err := verifyChecksumsSignature(release, checksums); err != nil {
+		return "", err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no checksum for %s in %s", name, releaseChecksumsAsset)
+}
+
+// Download the binary next to the running one, verify its checksum and the signature of the checksums, then replace the
+// running one
+func replaceExecutable(release Release) error {
+	name := releaseAssetName()
+	binaryAsset, ok := release.asset(name)
+	if !ok {
+		return fmt.Errorf("release %s has no binary for this platform (%s)", release.TagName, name)
+	}
+	expectedChecksum, err := releaseChecksum(release, name)
+	if err != nil {
+		return err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	executable, err = filepath.EvalSymlinks(executable)
+	if err != nil {
+		return err
+	}
+
+	// Same directory, so the final rename is atomic
+	newBinary, err := os.CreateTemp(filepath.Dir(executable), ".statexec-update-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(newBinary.Name())
+	defer newBinary.Close()
+
+	resp, err := releaseClient.Get(binaryAsset.DownloadUrl)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cannot download %s: unexpected status %s", name, resp.Status)
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(newBinary, hash), resp.Body); err != nil {
+		return err
+	}
+	if checksum := hex.EncodeToString(hash.Sum(nil)); checksum != expectedChecksum {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", name, expectedChecksum, checksum)
+	}
+
+	if err := newBinary.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(newBinary.Name(), 0755); err != nil {
+		return err
+	}
+	return os.Rename(newBinary.Name(), executable)
+}
+
+func selfUpdateSubcommand(args []string) {
+	checkOnly := false
+	if value := os.Getenv(EnvVarPrefix + "RELEASE_URL"); value != "" {
+		releaseUrl = value
+	}
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--check":
+			checkOnly = true
+		case "--release-url":
+			releaseUrl = flagValue(args, i)
+			i++
+		case "-h", "--help":
+			fmt.Printf("Usage: %s self-update [--check] [--release-url <url>]\n", os.Args[0])
+			fmt.Println("  Replace the statexec binary by a newer release for this platform, after verifying its checksum and, for release builds, the signature of the checksums")
+			fmt.Println("  --check                Only print whether an update is available, exit code 1 if so")
+			fmt.Printf("  --release-url <url>    %sRELEASE_URL   Latest release API url, for mirrors (default: GitHub releases)\n", EnvVarPrefix)
+			os.Exit(0)
+		default:
+			fatalWith(ExitConfig, "Unknown argument", "argument", args[i])
+		}
+	}
+
+	release, err := fetchLatestRelease(releaseUrl)
+	if err != nil {
+		fatal("Cannot fetch latest release", "url", releaseUrl, "error", err)
+	}
+	if !isNewerVersion(release.TagName, version) {
+		fmt.Printf("statexec %s is up to date\n", version)
+		return
+	}
+	if checkOnly {
+		fmt.Printf("statexec %s is available (current: %s)\n", release.TagName, version)
+		os.Exit(ExitError)
+	}
+
+	if err := replaceExecutable(release); err != nil {
+		fatal("Cannot update statexec", "version", release.TagName, "error", err)
+	}
+	fmt.Printf("statexec updated from %s to %s\n", version, release.TagName)
+}
+
+// Log when a newer release is available (--check-update), never failing the run
+func checkForUpdate() {
+	release, err := fetchLatestRelease(releaseUrl)
+	if err != nil {
+		logger.Warn("Cannot check for updates", "url", releaseUrl, "error", err)
+		return
+	}
+	if isNewerVersion(release.TagName, version) {
+		logger.Info("A newer statexec is available, run statexec self-update", "current", version, "latest", release.TagName)
+	}
+}
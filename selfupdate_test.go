@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsNewerVersion(t *testing.T) {
+	cases := []struct {
+		latest  string
+		current string
+		want    bool
+	}{
+		{"1.3.0", "1.2.9", true},
+		{"v1.10.0", "1.9.0", true},
+		{"1.2.0", "1.2.0", false},
+		{"1.1.0", "1.2.0", false},
+		{"nightly", "1.2.0", false},
+		{"1.2.0-rc1", "1.2.0", false},
+		{"1.0.0", "main-abc1234", true},
+		{"nightly", "dev", true},
+		{"dev", "dev", false},
+	}
+	for _, c := range cases {
+		if got := isNewerVersion(c.latest, c.current); got != c.want {
+			t.Errorf("isNewerVersion(%q, %q) = %v, want %v", c.latest, c.current, got, c.want)
+		}
+	}
+}
+
+func TestReleaseChecksumVerifiesSignature(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksums := []byte("0123abcd  statexec-linux-amd64\n")
+	signature := ed25519.Sign(privateKey, checksums)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/" + releaseChecksumsAsset:
+			w.Write(checksums)
+		case "/" + releaseSignatureAsset:
+			w.Write(signature)
+		case "/tampered.sig":
+			w.Write(ed25519.Sign(privateKey, []byte("ffff  statexec-linux-amd64\n")))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	release := func(signatureUrl string) Release {
+		assets := []ReleaseAsset{{Name: releaseChecksumsAsset, DownloadUrl: server.URL + "/" + releaseChecksumsAsset}}
+		if signatureUrl != "" {
+			assets = append(assets, ReleaseAsset{Name: releaseSignatureAsset, DownloadUrl: server.URL + signatureUrl})
+		}
+		return Release{TagName: "1.0.0", Assets: assets}
+	}
+
+	defer func(key string) { releasePublicKey = key }(releasePublicKey)
+	releasePublicKey = base64.StdEncoding.EncodeToString(publicKey)
+
+	sum, err := releaseChecksum(release("/"+releaseSignatureAsset), "statexec-linux-amd64")
+	if err != nil || sum != "0123abcd" {
+		t.Fatalf("signed checksums: got %q, %v", sum, err)
+	}
+	if _, err := releaseChecksum(release("/tampered.sig"), "statexec-linux-amd64"); err == nil {
+		t.Error("checksums accepted with the signature of other checksums")
+	}
+	if _, err := releaseChecksum(release(""), "statexec-linux-amd64"); err == nil {
+		t.Error("checksums accepted without signature while a release key is built in")
+	}
+
+	releasePublicKey = ""
+	if sum, err := releaseChecksum(release(""), "statexec-linux-amd64"); err != nil || sum != "0123abcd" {
+		t.Fatalf("unsigned checksums without release key: got %q, %v", sum, err)
+	}
+}
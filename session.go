@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/blackswifthosting/statexec/collectors"
+)
+
+// Headers of the sync protocol: session id (run id of the leader), id of the follower (its run id), heartbeat interval, abort fan-out and metrics start time of the leader
+const (
+	syncSessionHeader   = "X-Statexec-Session"
+	syncFollowerHeader  = "X-Statexec-Follower"
+	syncHeartbeatHeader = "X-Statexec-Heartbeat"
+	syncAbortHeader     = "X-Statexec-Abort"
+	syncStartTimeHeader = "X-Statexec-Start-Time"
+)
+
+// Abort reason of a follower which lost its leader, there is no one to send the stop to
+const lostLeaderReason = "lost contact with the leader"
+
+// State of a sync session, polled by the followers on GET /session as their heartbeat
+type SessionState struct {
+	Session string `json:"session"`
+	Status  string `json:"status"` // running, aborted
+	Reason  string `json:"reason,omitempty"`
+}
+
+// Followers of the leader, and whether the session was aborted
+type SyncSession struct {
+	mutex     sync.Mutex
+	reason    string
+	followers map[string]time.Time // last contact of each follower, zero if it does not poll
+	indexes   map[string]int       // index of each follower, in the order they joined from 1
+}
+
+var (
+	abortOnFailure   bool          = false
+	syncTimeout      time.Duration = 10 * time.Second // a peer without heartbeat for longer is lost (--sync-timeout)
+	syncHeartbeat    time.Duration = time.Second      // interval of the heartbeats of the followers (--sync-heartbeat)
+	session                        = &SyncSession{followers: make(map[string]time.Time), indexes: make(map[string]int)}
+	sessionLeaderUrl string        = ""    // sync server of a follower polling the session state
+	leaderAborts     bool          = false // whether the sync server of a follower aborts the session on failure
+	leaderTime       bool          = true  // whether a follower offsets its metrics to the metrics start time of its leader (--no-leader-time)
+	syncPeer         string        = ""    // address of the peer of the sync handshake, unredacted
+)
+
+// Role of a sync mode in a distributed topology: the server leads, clients follow
+var syncRoles = map[string]string{
+	"server": "leader",
+	"client": "follower",
+}
+
+// Label the results with the topology negotiated during the sync handshake, so merged results group per session
+func setSyncLabels(peer string, session string) {
+	syncPeer = peer
+	extraLabels["sync_role"] = syncRoles[role]
+	extraLabels["sync_peer"] = redactLabelValue("sync_peer", peer)
+	if session != "" {
+		extraLabels["sync_session"] = session
+	}
+	if syncNode != "" {
+		extraLabels["sync_node"] = syncNode
+	}
+	logger.Info("Sync session established", "sync_role", syncRoles[role], "sync_peer", peer, "sync_session", session)
+}
+
+// Offset the metrics of a follower to the time base of its leader, as if started with -mst, unless set explicitly.
+// The leader fixed its start time while handling the start request, half the round trip before the follower starts.
+func useLeaderTime(header string, roundTrip time.Duration) {
+	if !leaderTime || metricsStartTimeOverride != -1 || header == "" {
+		return
+	}
+	leaderStartTime, err := strconv.ParseInt(header, 10, 64)
+	if err != nil {
+		logger.Warn("Cannot parse the metrics start time of the leader, keeping the local time", "value", header, "error", err)
+		return
+	}
+	metricsStartTimeOverride = leaderStartTime + roundTrip.Milliseconds()/2
+	logger.Info("Offsetting metrics to the leader time", "metrics_start_time", metricsStartTimeOverride, "offset", time.Duration(metricsStartTimeOverride-time.Now().UnixMilli())*time.Millisecond)
+}
+
+// Record the route toward the sync peer when the run starts, to check throughput anomalies against the path it took
+func recordPeerRoute(timestamp int64) {
+	if _, ok := syncSocketPath(syncPeer); ok || syncPeer == "" || syncPeer == "unix" {
+		return
+	}
+	route, err := collectors.CollectPeerRoute(syncPeer)
+	if err != nil {
+		logger.Warn("Cannot resolve the route to the sync peer", "peer", syncPeer, "error", err)
+		return
+	}
+	gateway := route.Gateway
+	if gateway == "" {
+		gateway = "on-link"
+	}
+	addStaticMetric("sync_peer_route_info", map[string]string{
+		"source_address": route.SourceAddress,
+		"interface":      route.Interface,
+		"gateway":        gateway,
+	}, 1, timestamp)
+	addStaticMetric("sync_peer_mtu_bytes", map[string]string{"interface": route.Interface}, float64(route.PathMtu), timestamp)
+
+	text := fmt.Sprintf("Route to sync peer %s: dev %s src %s via %s mtu %d", syncPeer, route.Interface, route.SourceAddress, gateway, route.PathMtu)
+	if route.PathMtu != route.InterfaceMtu {
+		text += fmt.Sprintf(" (interface mtu %d)", route.InterfaceMtu)
+	}
+	logger.Info("Route to sync peer", "peer", syncPeer, "interface", route.Interface, "source_address", route.SourceAddress, "gateway", gateway, "mtu", route.PathMtu, "interface_mtu", route.InterfaceMtu)
+	store.AddAnnotation(GrafanaAnnotation{
+		Time:    timestamp,
+		TimeEnd: timestamp,
+		Text:    text,
+		Tags: []string{
+			"statexec",
+			"route",
+			"instance=" + instance,
+			"job=" + jobName,
+			"role=" + role,
+			"hostname=" + hostname,
+			"run_id=" + runId,
+		},
+	})
+}
+
+// Address of the client of a sync request, without its ephemeral port
+func syncPeerAddress(r *http.Request) string {
+	// Clients of a unix socket have no address
+	if r.RemoteAddr == "" || r.RemoteAddr == "@" {
+		return "unix"
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Id of a follower, older followers without one are identified by their address
+func syncFollowerId(r *http.Request) string {
+	if id := r.Header.Get(syncFollowerHeader); id != "" {
+		return id
+	}
+	return syncPeerAddress(r)
+}
+
+// Add a follower to the session and return its index, only followers with an id poll it, older ones are never considered lost
+func (s *SyncSession) join(follower string, polling bool) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.followers[follower] = time.Time{}
+	if polling {
+		s.followers[follower] = time.Now()
+	}
+	if _, ok := s.indexes[follower]; !ok {
+		s.indexes[follower] = len(s.indexes) + 1
+	}
+	return s.indexes[follower]
+}
+
+// Record a contact of a follower, if it is still part of the session
+func (s *SyncSession) seen(follower string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if lastContact, ok := s.followers[follower]; ok && !lastContact.IsZero() {
+		s.followers[follower] = time.Now()
+	}
+}
+
+// Remove a stopped follower, returning the number of followers still running
+func (s *SyncSession) leave(follower string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.followers, follower)
+	return len(s.followers)
+}
+
+// First follower without contact for longer than the timeout, if any
+func (s *SyncSession) lost(timeout time.Duration) (string, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for follower, lastContact := range s.followers {
+		if !lastContact.IsZero() && time.Since(lastContact) > timeout {
+			return follower, true
+		}
+	}
+	return "", false
+}
+
+// Mark the session failed, returning false if it already was
+func (s *SyncSession) abort(reason string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.reason != "" {
+		return false
+	}
+	s.reason = reason
+	logger.Error("Sync session aborted", "reason", reason)
+	return true
+}
+
+func (s *SyncSession) abortReason() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.reason
+}
+
+func (s *SyncSession) state() SessionState {
+	state := SessionState{Session: runId, Status: "running", Reason: s.abortReason()}
+	if state.Reason != "" {
+		state.Status = "aborted"
+	}
+	return state
+}
+
+// Serve the session state to the followers, polling it is also their heartbeat
+func handleSessionState(w http.ResponseWriter, r *http.Request) {
+	session.seen(syncFollowerId(r))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(session.state())
+}
+
+// Report the followers which stopped polling the session, until done is closed
+func watchFollowers(done chan struct{}, lost func(follower string)) {
+	ticker := time.NewTicker(syncHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		if follower, ok := session.lost(syncTimeout); ok {
+			lost(follower)
+		}
+	}
+}
+
+// Poll the session of the leader while the command runs, terminating it when the session is aborted or the leader is lost
+func watchSyncSession(syncServerUrl string, cmd *exec.Cmd, done chan struct{}) {
+	ticker := time.NewTicker(syncHeartbeat)
+	defer ticker.Stop()
+	client := syncClient(syncHeartbeat)
+	lastContact := time.Now()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		state, err := fetchSessionState(client, syncServerUrl)
+		if err != nil {
+			if time.Since(lastContact) <= syncTimeout {
+				logger.Warn("Cannot reach the sync leader", "error", err)
+				continue
+			}
+			state.Reason = lostLeaderReason
+		} else {
+			lastContact = time.Now()
+		}
+		if state.Reason == "" {
+			continue
+		}
+		session.abort(state.Reason)
+		if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			logger.Warn("Cannot terminate the command", "error", err)
+		}
+		return
+	}
+}
+
+func fetchSessionState(client *http.Client, syncServerUrl string) (SessionState, error) {
+	var state SessionState
+	request, err := http.NewRequest(http.MethodGet, syncServerUrl+"/session", nil)
+	if err != nil {
+		return state, err
+	}
+	request.Header.Set(syncFollowerHeader, runId)
+	resp, err := client.Do(request)
+	if err != nil {
+		return state, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return state, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return state, json.NewDecoder(resp.Body).Decode(&state)
+}
+
+// Exit with an error once results are written when the session was aborted
+func exitIfSessionAborted() {
+	if reason := session.abortReason(); reason != "" {
+		logger.Error("Sync session failed", "reason", reason)
+		os.Exit(ExitSync)
+	}
+}
+
+// Send a sync request to the server, identifying this follower
+func postSync(url string) (*http.Response, error) {
+	request, err := http.NewRequest(http.MethodPost, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set(syncFollowerHeader, runId)
+	resp, err := syncClient(0).Do(request)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+func parseSyncDuration(name string, value string) time.Duration {
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		fatalWith(ExitConfig, "Cannot parse sync duration, expected a positive duration like 10s", name, value)
+	}
+	return duration
+}
+
+// Heartbeat interval advertised by the server, followers of older servers do not send heartbeats
+func parseHeartbeatHeader(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval <= 0 {
+		return 0, false
+	}
+	return interval, true
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var splitOutputDir string = "" // directory of the result files split per collector (--split-output)
+
+// Files of the split output and the metric name prefixes they hold, everything else goes to run.prom
+var splitGroups = []struct {
+	name     string
+	prefixes []string
+}{
+	{"cpu", []string{"cpu_", "softirqs_", "interrupts_"}},
+	{"memory", []string{"memory_", "numa_"}},
+	{"network", []string{"network_", "tcp_", "udp_", "conntrack_", "ethtool_", "sync_peer_"}},
+	{"disk", []string{"disk_", "nfs_"}},
+}
+
+const splitRunGroup = "run"
+
+// File of a metric, named without prefix, summaries go with the metrics they summarize
+func splitGroup(name string) string {
+	name = strings.TrimPrefix(name, "summary_")
+	for _, group := range splitGroups {
+		for _, prefix := range group.prefixes {
+			if strings.HasPrefix(name, prefix) {
+				return group.name
+			}
+		}
+	}
+	return splitRunGroup
+}
+
+// Names of the split result files, in the order they are written
+func splitGroupNames() []string {
+	names := make([]string, 0, len(splitGroups)+1)
+	for _, group := range splitGroups {
+		names = append(names, group.name)
+	}
+	return append(names, splitRunGroup)
+}
+
+func splitResultPath(group string) string {
+	return splitExporter{dir: splitOutputDir}.path(group)
+}
+
+// The result split per collector, each file with the metadata header and the help of its metrics
+type splitExporter struct {
+	dir string
+}
+
+func (exporter splitExporter) Name() string {
+	return "split"
+}
+
+func (exporter splitExporter) path(group string) string {
+	return filepath.Join(exporter.dir, group+".prom")
+}
+
+// Writers of the split result files, the status of the command is written to all of them so each one delimits the run on its own
+type splitWriters map[string]*bufio.Writer
+
+// Errors of the buffered writers are sticky, the first one is returned when the file is committed
+func (writers splitWriters) write(group string, text string) {
+	_, _ = writers[group].WriteString(text)
+}
+
+// Route each line of a rendered section to the file of its metric, other lines go to run.prom, or to all files if shared
+func (writers splitWriters) route(text string, shared bool) {
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if line == "" {
+			continue
+		}
+		name, ok := renderedMetricName(line)
+		switch {
+		case ok && name == "command_status":
+			for group := range writers {
+				writers.write(group, line)
+			}
+		case ok:
+			writers.write(splitGroup(name), line)
+		case shared:
+			for group := range writers {
+				writers.write(group, line)
+			}
+		default:
+			writers.write(splitRunGroup, line)
+		}
+	}
+}
+
+// Name of the metric of a sample, help or type line, without prefix
+func renderedMetricName(line string) (string, bool) {
+	if strings.HasPrefix(line, "# HELP ") || strings.HasPrefix(line, "# TYPE ") {
+		line = line[len("# HELP "):]
+	}
+	if !strings.HasPrefix(line, MetricPrefix) {
+		return "", false
+	}
+	name := strings.TrimPrefix(line, MetricPrefix)
+	if end := strings.IndexAny(name, "{ "); end >= 0 {
+		name = name[:end]
+	}
+	return name, true
+}
+
+func (exporter splitExporter) Export(result RunResult) error {
+	if err := os.MkdirAll(exporter.dir, 0755); err != nil {
+		return fmt.Errorf("cannot create split output directory %s: %w", exporter.dir, err)
+	}
+
+	writers := make(splitWriters)
+	files := make(map[string]*atomicFile)
+	for _, group := range splitGroupNames() {
+		file, err := createAtomicFile(exporter.path(group))
+		if err != nil {
+			return fmt.Errorf("cannot open split metrics file %s: %w", exporter.path(group), err)
+		}
+		defer file.Discard()
+		files[group] = file
+		writers[group] = file.Writer
+	}
+
+	writers.route(resultHeader(), true)
+	writers.write(splitRunGroup, renderAnnotations(result.annotations))
+	for _, section := range result.before {
+		writers.route(renderResultSection(section)+"\n", false)
+	}
+
+	// Series are routed by name, the points of a sample stay together in each file
+	metrics := result.metrics
+	groups := make([]string, len(metrics.series))
+	for i, series := range metrics.series {
+		groups[i] = splitGroup(series.name)
+	}
+	buffers := make(map[string][]byte, len(writers))
+	cursors := make([]int, len(metrics.series))
+	for _, sample := range metrics.samples {
+		for group := range writers {
+			buffers[group] = buffers[group][:0]
+		}
+		for i, series := range metrics.series {
+			if cursors[i] < len(series.timestamps) && series.timestamps[cursors[i]] == sample.timestamp {
+				if series.name == "command_status" {
+					for group := range writers {
+						buffers[group] = series.appendPoint(buffers[group], cursors[i])
+					}
+				} else {
+					buffers[groups[i]] = series.appendPoint(buffers[groups[i]], cursors[i])
+				}
+				cursors[i]++
+			}
+		}
+		for group, buffer := range buffers {
+			_, _ = writers[group].Write(buffer)
+		}
+	}
+
+	for _, section := range result.after {
+		if section.title != "" {
+			writers.write(splitRunGroup, "\n")
+		}
+		writers.route(renderResultSection(section), false)
+	}
+
+	for group, file := range files {
+		if err := file.Commit(); err != nil {
+			return fmt.Errorf("cannot write split metrics file %s: %w", exporter.path(group), err)
+		}
+	}
+	logger.Debug("Split metrics written", "dir", exporter.dir, "files", len(writers))
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Condition on the host load ending the command once it holds long enough (--stop-when), e.g. network_idle for 30s
+type StopCondition struct {
+	Expression string
+	Trigger    Trigger
+	For        time.Duration
+}
+
+var (
+	stopConditions     []StopCondition
+	commandDuration    time.Duration // the command is terminated after it (--duration), disabled if zero
+	stoppedByCondition atomic.Bool
+)
+
+// Shorthands of idle conditions, below the noise of an idle host
+var idleConditions = map[string]string{
+	"cpu_idle":     "cpu<5%",
+	"network_idle": "network<10KB/s",
+	"disk_idle":    "disk<10KB/s",
+}
+
+func parseStopCondition(expression string) StopCondition {
+	condition, duration, ok := strings.Cut(strings.TrimSpace(expression), " for ")
+	if !ok {
+		fatalWith(ExitConfig, "Cannot parse stop condition, expected <condition> for <duration> like network_idle for 30s", "condition", expression)
+	}
+	holdFor, err := time.ParseDuration(strings.TrimSpace(duration))
+	if err != nil || holdFor <= 0 {
+		fatalWith(ExitConfig, "Cannot parse stop condition duration", "condition", expression)
+	}
+	condition = strings.TrimSpace(condition)
+	if idle, ok := idleConditions[condition]; ok {
+		condition = idle
+	}
+	return StopCondition{Expression: expression, Trigger: parseTrigger(condition), For: holdFor}
+}
+
+func stopConditionExpressions() []string {
+	var expressions []string
+	for _, condition := range stopConditions {
+		expressions = append(expressions, condition.Expression)
+	}
+	return expressions
+}
+
+func parseCommandDuration(value string) time.Duration {
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		fatalWith(ExitConfig, "Cannot parse command duration, expected a positive duration like 10m", "value", value)
+	}
+	return duration
+}
+
+func formatCommandDuration() string {
+	if commandDuration == 0 {
+		return ""
+	}
+	return commandDuration.String()
+}
+
+// Terminate the command once its duration elapsed or a stop condition holds for its whole duration, until done is closed
+func watchStopConditions(cmd *exec.Cmd, done chan struct{}) {
+	if len(stopConditions) == 0 && commandDuration == 0 {
+		return
+	}
+	stop := func() {
+		stoppedByCondition.Store(true)
+		if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			logger.Warn("Cannot terminate the command", "error", err)
+		}
+	}
+
+	var deadline <-chan time.Time
+	if commandDuration > 0 {
+		timer := time.NewTimer(commandDuration)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	var poll <-chan time.Time
+	if len(stopConditions) > 0 {
+		ticker := time.NewTicker(triggerInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	holdingSince := make([]time.Time, len(stopConditions))
+	// A poll failing to read the load leaves the command running, the conditions are evaluated again from the next one
+	previous, err := readHostLoad()
+	previousTime := time.Now()
+	if err != nil {
+		logger.Warn("Cannot read the host load of the stop conditions", "error", err)
+		previousTime = time.Time{}
+	}
+	for {
+		select {
+		case <-done:
+			return
+		case <-deadline:
+			logger.Info("Command duration reached, terminating the command", "duration", commandDuration)
+			stop()
+			return
+		case <-poll:
+		}
+		current, err := readHostLoad()
+		currentTime := time.Now()
+		if err != nil {
+			logger.Warn("Cannot read the host load of the stop conditions", "error", err)
+			previousTime = time.Time{}
+			clear(holdingSince)
+			continue
+		}
+		if previousTime.IsZero() {
+			previous, previousTime = current, currentTime
+			continue
+		}
+		for index, condition := range stopConditions {
+			if !condition.Trigger.fired(condition.Trigger.value(previous, current, currentTime.Sub(previousTime))) {
+				holdingSince[index] = time.Time{}
+				continue
+			}
+			if holdingSince[index].IsZero() {
+				holdingSince[index] = previousTime
+			}
+			if currentTime.Sub(holdingSince[index]) >= condition.For {
+				logger.Info("Stop condition reached, terminating the command", "condition", condition.Expression)
+				stop()
+				return
+			}
+		}
+		previous, previousTime = current, currentTime
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/blackswifthosting/statexec/collectors"
+	"github.com/blackswifthosting/statexec/promfile"
+)
+
+// Store of everything collected during a run. It is written concurrently by the
+// collect loop, the command lifecycle, the probes and the sync server handlers,
+// readers get snapshots so rendering never races with collection.
+type Store struct {
+	mutex         sync.RWMutex
+	commandStatus int
+	samples       []SampleInfo
+	series        []*Series
+	seriesIndex   map[string]*Series
+	annotations   []GrafanaAnnotation
+	events        []CommandEvent
+	staticMetrics []StaticMetric
+	probeSamples  []ProbeSample
+	devices       map[string][]string
+}
+
+var store = &Store{seriesIndex: make(map[string]*Series)}
+
+func (s *Store) SetCommandStatus(status int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.commandStatus = status
+}
+
+func (s *Store) CommandStatus() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.commandStatus
+}
+
+// Store a sample column-wise, appending its values to their series
+func (s *Store) AddMetric(metric InstantMetric) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.samples = append(s.samples, SampleInfo{cmdStatus: metric.cmdStatus, timestamp: metric.timestamp})
+	devices := sampleDevices(metric)
+	if s.devices != nil {
+		s.annotations = append(s.annotations, topologyAnnotations(s.devices, devices, metric.timestamp)...)
+	}
+	for kind, previous := range s.devices {
+		if len(devices[kind]) == 0 {
+			devices[kind] = previous
+		}
+	}
+	s.devices = devices
+
+	for _, point := range metric.points() {
+		if isUnavailable(point.Name, point.Labels) {
+			continue
+		}
+		key := seriesKey(point.Name, point.Labels)
+		series, ok := s.seriesIndex[key]
+		if !ok {
+			metricLabels := make(map[string]string, len(point.Labels)/2)
+			for i := 0; i+1 < len(point.Labels); i += 2 {
+				metricLabels[point.Labels[i]] = point.Labels[i+1]
+			}
+			series = &Series{
+				name:     point.Name,
+				labels:   metricLabels,
+				rendered: renderLabels(metricLabels),
+				integer:  point.Integer,
+				counter:  point.Type == collectors.Counter,
+			}
+			s.seriesIndex[key] = series
+			s.series = append(s.series, series)
+		}
+		series.add(point.Value, metric.timestamp)
+
+		if count := len(series.values); series.isCounter() && count > 1 && series.timestamps[count-1] == metric.timestamp && isCounterReset(series.values[count-2], point.Value) {
+			s.annotations = append(s.annotations, counterResetAnnotation(series, series.values[count-2], metric.timestamp))
+		}
+	}
+}
+
+// Annotate a decreasing counter, rate() and the summary count from zero again after it
+func counterResetAnnotation(series *Series, previous float64, timestamp int64) GrafanaAnnotation {
+	seriesName := MetricPrefix + series.name + "{" + promfile.RenderLabels(series.labels) + "}"
+	logger.Warn("Counter reset", "series", seriesName, "previous", previous, "value", series.values[len(series.values)-1])
+	return GrafanaAnnotation{
+		Time:    timestamp,
+		TimeEnd: timestamp,
+		Text:    "Counter reset of " + seriesName,
+		Tags: []string{
+			"statexec",
+			"counter_reset",
+			"instance=" + instance,
+			"job=" + jobName,
+			"role=" + role,
+			"hostname=" + hostname,
+			"run_id=" + runId,
+		},
+	}
+}
+
+func (s *Store) AddAnnotation(annotation GrafanaAnnotation) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.annotations = append(s.annotations, annotation)
+}
+
+func (s *Store) AddEvent(event CommandEvent) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.events = append(s.events, event)
+}
+
+func (s *Store) AddStaticMetric(staticMetric StaticMetric) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.staticMetrics = append(s.staticMetrics, staticMetric)
+}
+
+func (s *Store) AddProbeSample(sample ProbeSample) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.probeSamples = append(s.probeSamples, sample)
+}
+
+// Snapshot of the metrics collected so far. Points are copied, a late sample is inserted in place and would otherwise
+// shift the points under a reader
+func (s *Store) Metrics() MetricsSnapshot {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	snapshot := MetricsSnapshot{
+		samples: append([]SampleInfo(nil), s.samples...),
+		series:  make([]Series, 0, len(s.series)),
+		events:  append([]CommandEvent(nil), s.events...),
+	}
+	for _, series := range s.series {
+		copied := *series
+		copied.timestamps = append([]int64(nil), series.timestamps...)
+		copied.values = append([]float64(nil), series.values...)
+		snapshot.series = append(snapshot.series, copied)
+	}
+	return snapshot
+}
+
+func (s *Store) Annotations() []GrafanaAnnotation {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return append([]GrafanaAnnotation(nil), s.annotations...)
+}
+
+func (s *Store) StaticMetrics() []StaticMetric {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return append([]StaticMetric(nil), s.staticMetrics...)
+}
+
+func (s *Store) ProbeSamples() []ProbeSample {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return append([]ProbeSample(nil), s.probeSamples...)
+}
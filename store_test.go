@@ -0,0 +1,138 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/blackswifthosting/statexec/collectors"
+)
+
+func newTestStore() *Store {
+	return &Store{seriesIndex: make(map[string]*Series)}
+}
+
+// Writers of the run (collect loop, command lifecycle, probes, sync handlers) and readers (summary, stream, exporters)
+// hitting the store at once, run with go test -race
+func TestStoreConcurrentAccess(t *testing.T) {
+	testStore := newTestStore()
+	const writers = 8
+	const samplesPerWriter = 50
+
+	var wg sync.WaitGroup
+	for writer := 0; writer < writers; writer++ {
+		wg.Add(1)
+		go func(writer int) {
+			defer wg.Done()
+			for i := 0; i < samplesPerWriter; i++ {
+				// Interleaved timestamps, samples of the writers land out of order
+				timestamp := int64(i*writers+writers-writer) * 1000
+				testStore.SetCommandStatus(CommandStatusRunning)
+				testStore.AddMetric(testSample(timestamp, i%5 == 0, uint64(timestamp)))
+				testStore.AddAnnotation(GrafanaAnnotation{Time: timestamp, TimeEnd: timestamp, Text: "test"})
+				testStore.AddEvent(CommandEvent{name: "start", timestamp: timestamp})
+				testStore.AddStaticMetric(StaticMetric{name: "test", labels: map[string]string{"writer": "w"}, value: 1, timestamp: timestamp})
+				testStore.AddProbeSample(ProbeSample{probe: collectors.Probe{}, timestamp: timestamp})
+			}
+		}(writer)
+	}
+
+	var readers sync.WaitGroup
+	for reader := 0; reader < 4; reader++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for i := 0; i < samplesPerWriter; i++ {
+				metrics := testStore.Metrics()
+				for _, series := range metrics.series {
+					sum := 0.0
+					for i := range series.timestamps {
+						sum += series.values[i]
+					}
+					_ = sum
+				}
+				_ = testStore.Annotations()
+				_ = testStore.StaticMetrics()
+				_ = testStore.ProbeSamples()
+				_ = testStore.CommandStatus()
+			}
+		}()
+	}
+
+	wg.Wait()
+	readers.Wait()
+
+	metrics := testStore.Metrics()
+	if len(metrics.samples) != writers*samplesPerWriter {
+		t.Errorf("samples = %d, want %d", len(metrics.samples), writers*samplesPerWriter)
+	}
+	if len(metrics.events) != writers*samplesPerWriter {
+		t.Errorf("events = %d, want %d", len(metrics.events), writers*samplesPerWriter)
+	}
+	if got := len(testStore.StaticMetrics()); got != writers*samplesPerWriter {
+		t.Errorf("static metrics = %d, want %d", got, writers*samplesPerWriter)
+	}
+	if got := len(testStore.ProbeSamples()); got != writers*samplesPerWriter {
+		t.Errorf("probe samples = %d, want %d", got, writers*samplesPerWriter)
+	}
+	for _, series := range metrics.series {
+		if !sort.SliceIsSorted(series.timestamps, func(i, j int) bool { return series.timestamps[i] < series.timestamps[j] }) {
+			t.Errorf("points of %s not ordered by timestamp", series.name)
+		}
+		if len(series.timestamps) != len(series.values) {
+			t.Errorf("%s has %d timestamps for %d values", series.name, len(series.timestamps), len(series.values))
+		}
+	}
+	status := metrics.find("command_status")
+	if len(status) != 1 || len(status[0].timestamps) != writers*samplesPerWriter {
+		t.Errorf("command_status series = %v, want one series with a point per sample", status)
+	}
+}
+
+// A snapshot is not changed by the samples stored after it, even when they are inserted before its last point
+func TestStoreSnapshotIsolation(t *testing.T) {
+	testStore := newTestStore()
+	testStore.AddMetric(testSample(1000, false, 1))
+	testStore.AddMetric(testSample(3000, false, 3))
+	testStore.AddMetric(testSample(4000, false, 4))
+	snapshot := testStore.Metrics()
+
+	// Room is left in the slices of the series, the late point is inserted in place
+	testStore.AddMetric(testSample(2000, false, 2))
+
+	used := snapshot.find("memory_used_bytes")
+	if len(used) != 1 {
+		t.Fatalf("memory_used_bytes series = %d, want 1", len(used))
+	}
+	if got := used[0].timestamps; len(got) != 3 || got[0] != 1000 || got[1] != 3000 || got[2] != 4000 {
+		t.Errorf("snapshot timestamps = %v, want [1000 3000 4000]", got)
+	}
+	if got := used[0].values; got[0] != 1 || got[1] != 3 || got[2] != 4 {
+		t.Errorf("snapshot values = %v, want [1 3 4]", got)
+	}
+
+	used = testStore.Metrics().find("memory_used_bytes")
+	if got := used[0].timestamps; len(got) != 4 || got[1] != 2000 {
+		t.Errorf("store timestamps = %v, want [1000 2000 3000 4000]", got)
+	}
+}
+
+// Counters are typed by their collector, a gauge named _total (hugepages) falling is not a counter reset
+func TestStoreDetectsResetsOfCounterSamples(t *testing.T) {
+	testStore := newTestStore()
+	for i, value := range []uint64{1000, 100} {
+		metric := InstantMetric{cmdStatus: CommandStatusRunning, timestamp: int64(i+1) * 1000}
+		metric.storeCollector("memory", collectors.MemorySamples(collectors.MemoryMetrics{HugePagesTotal: value, SwapInBytes: value}))
+		testStore.AddMetric(metric)
+	}
+
+	annotations := testStore.Annotations()
+	if len(annotations) != 1 || annotations[0].Text != "Counter reset of statexec_memory_swap_in_bytes_total{}" {
+		t.Errorf("annotations = %v, want the reset of memory_swap_in_bytes_total only", annotations)
+	}
+	for _, series := range testStore.Metrics().series {
+		if series.isCounter() != (series.name == "memory_swap_in_bytes_total" || series.name == "memory_swap_out_bytes_total") {
+			t.Errorf("series %s counter = %v", series.name, series.isCounter())
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var streamListen string = "" // address serving the samples live over Server-Sent Events (--stream)
+
+// Event of the run, written as a Server-Sent Event on /stream and as a JSON message on the live page WebSocket
+type StreamEvent struct {
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// Sample as streamed, metric names and labels as written in the result file
+type StreamSample struct {
+	Timestamp int64          `json:"timestamp"`
+	Phase     string         `json:"phase"`
+	Metrics   []StreamMetric `json:"metrics"`
+}
+
+type StreamMetric struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+}
+
+// Identity of the run, sent first to each client
+type StreamRun struct {
+	RunId     string            `json:"run_id"`
+	Instance  string            `json:"instance"`
+	Job       string            `json:"job"`
+	Role      string            `json:"role"`
+	Hostname  string            `json:"hostname"`
+	Labels    map[string]string `json:"labels"`
+	Command   []string          `json:"command"`
+	Phase     string            `json:"phase"`
+	StartedAt int64             `json:"started_at,omitempty"` // timestamp of the command start, if started
+}
+
+// Clients of /stream and of the live page, a client too slow to read misses samples instead of delaying the collection
+type streamBroker struct {
+	mutex   sync.Mutex
+	clients map[chan StreamEvent]bool
+	server  *http.Server
+}
+
+var broker = &streamBroker{clients: make(map[chan StreamEvent]bool)}
+
+// Whether someone listens, samples are only converted for them
+func (b *streamBroker) active() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.clients) > 0
+}
+
+func (b *streamBroker) publish(event string, data any) {
+	if !b.active() {
+		return
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		logger.Warn("Cannot marshal stream event", "event", event, "error", err)
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for client := range b.clients {
+		select {
+		case client <- StreamEvent{Event: event, Data: payload}:
+		default:
+			logger.Debug("Stream client too slow, sample dropped", "event", event)
+		}
+	}
+}
+
+func (b *streamBroker) subscribe() chan StreamEvent {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	client := make(chan StreamEvent, 64)
+	b.clients[client] = true
+	return client
+}
+
+func (b *streamBroker) unsubscribe(client chan StreamEvent) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.clients[client] {
+		delete(b.clients, client)
+		close(client)
+	}
+}
+
+// Close every stream once the run is over
+func (b *streamBroker) closeAll() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for client := range b.clients {
+		delete(b.clients, client)
+		close(client)
+	}
+}
+
+func handleStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Custom dashboards are served from elsewhere
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	client := broker.subscribe()
+	defer broker.unsubscribe(client)
+
+	run := streamRunEvent()
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", run.Event, run.Data)
+	flusher.Flush()
+
+	for {
+		select {
+		case event, open := <-client:
+			if !open {
+				return
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Event, event.Data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// First event of each client, with the state of the run when it connects
+func streamRunEvent() StreamEvent {
+	run := StreamRun{
+		RunId:    runId,
+		Instance: instance,
+		Job:      jobName,
+		Role:     role,
+		Hostname: hostname,
+		Labels:   extraLabels,
+		Command:  command,
+		Phase:    phaseOfStatus[store.CommandStatus()],
+	}
+	if startedAt, ok := store.Metrics().event("start"); ok {
+		run.StartedAt = startedAt
+	}
+	payload, _ := json.Marshal(run)
+	return StreamEvent{Event: "run", Data: json.RawMessage(anonymizeText(string(payload)))}
+}
+
+func startStreamServer() {
+	if streamListen == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stream", handleStream)
+	broker.server = &http.Server{Addr: streamListen, Handler: mux}
+
+	listener, err := net.Listen("tcp", streamListen)
+	if err != nil {
+		fatalWith(ExitConfig, "Cannot listen for the stream", "address", streamListen, "error", err)
+	}
+	logger.Info("Streaming samples", "url", "http://"+listener.Addr().String()+"/stream")
+	go func() {
+		if err := broker.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.Warn("Stream server stopped", "error", err)
+		}
+	}()
+}
+
+// Tell the clients the run is over, then stop serving
+func stopStreamServer() {
+	broker.publish("end", map[string]int{"exit_code": commandExitCode})
+	if broker.server == nil {
+		return
+	}
+	broker.closeAll()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = broker.server.Shutdown(ctx)
+}
+
+// Stream a sample once stored
+func streamSample(metric InstantMetric) {
+	if !broker.active() {
+		return
+	}
+	sample := StreamSample{Timestamp: metric.timestamp, Phase: phaseOfStatus[metric.cmdStatus], Metrics: []StreamMetric{}}
+	for _, point := range metric.points() {
+		if isUnavailable(point.Name, point.Labels) {
+			continue
+		}
+		streamMetric := StreamMetric{Name: MetricPrefix + point.Name, Value: point.Value}
+		if len(point.Labels) > 0 {
+			streamMetric.Labels = make(map[string]string, len(point.Labels)/2)
+			for i := 0; i+1 < len(point.Labels); i += 2 {
+				streamMetric.Labels[point.Labels[i]] = redactLabelValue(point.Labels[i], point.Labels[i+1])
+			}
+		}
+		sample.Metrics = append(sample.Metrics, streamMetric)
+	}
+	broker.publish("sample", sample)
+}
+
+// Stream a lifecycle event of the command
+func streamCommandEvent(event CommandEvent) {
+	broker.publish("command", map[string]any{"event": event.name, "timestamp": event.timestamp})
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Summary of metrics while the command was running
+type RunSummary struct {
+	Instance        string             `json:"instance"`
+	Job             string             `json:"job"`
+	Role            string             `json:"role"`
+	Labels          map[string]string  `json:"labels"`
+	ExitCode        int                `json:"exit_code"`
+	Attempts        int                `json:"attempts,omitempty"`
+	WaitSeconds     map[string]float64 `json:"wait_seconds,omitempty"`
+	Timestamp       int64              `json:"timestamp"`
+	DurationSeconds float64            `json:"duration_seconds"`
+	CpuCores        int                `json:"cpu_cores"`
+	CpuMeanSeconds  map[string]float64 `json:"cpu_mean_seconds"`
+	CpuStealPercent float64            `json:"cpu_steal_percent"`
+
+	MemoryUsedBytes    uint64 `json:"memory_used_bytes"`
+	MemoryFreeBytes    uint64 `json:"memory_free_bytes"`
+	MemoryBuffersBytes uint64 `json:"memory_buffers_bytes"`
+	MemoryCachedBytes  uint64 `json:"memory_cached_bytes"`
+	MemoryTotalBytes   uint64 `json:"memory_total_bytes"`
+
+	NetworkMeanSentBytesPerSecond     float64 `json:"network_mean_sent_bytes_per_second"`
+	NetworkMeanReceivedBytesPerSecond float64 `json:"network_mean_received_bytes_per_second"`
+
+	DiskMeanReadBytesPerSecond  float64 `json:"disk_mean_read_bytes_per_second"`
+	DiskMeanWriteBytesPerSecond float64 `json:"disk_mean_write_bytes_per_second"`
+
+	PerfCounters             map[string]float64 `json:"perf_counters,omitempty"`
+	PerfInstructionsPerCycle float64            `json:"perf_instructions_per_cycle,omitempty"`
+
+	CommandUsage *CommandUsage  `json:"command_usage,omitempty"`
+	Overhead     *OverheadUsage `json:"overhead,omitempty"`
+
+	Build BuildInfo `json:"build"`
+
+	collected map[string]bool // collectors with points in the command window, the summary of the others is left out
+}
+
+// Summary of the run from a snapshot of the collected metrics
+func runSummary() RunSummary {
+	metrics := store.Metrics()
+	first, last := commandWindow(metrics)
+	return computeSummary(metrics, first, last)
+}
+
+// Find the indexes of the samples bracketing the command: the last one before it started and the first one after it finished
+func commandWindow(metrics MetricsSnapshot) (int, int) {
+	first, last := -1, -1
+	start, started := metrics.event("start")
+	for index, sample := range metrics.samples {
+		if started && sample.timestamp <= start || first == -1 && sample.cmdStatus != CommandStatusPending {
+			first = index
+		}
+		if sample.cmdStatus == CommandStatusDone && last == -1 {
+			last = index
+		}
+	}
+	return first, last
+}
+
+// Duration of the command from its lifecycle events, the samples bracketing it are up to a second apart from them
+func commandDurationSeconds(metrics MetricsSnapshot, windowSeconds float64) float64 {
+	start, started := metrics.event("start")
+	end, ended := metrics.event("end")
+	if !started || !ended {
+		return windowSeconds
+	}
+	return float64(end-start) / 1000.0
+}
+
+func computeSummary(metrics MetricsSnapshot, firstMetricIndex int, lastMetricIndex int) RunSummary {
+	firstTimestamp := metrics.samples[firstMetricIndex].timestamp
+	lastTimestamp := metrics.samples[lastMetricIndex].timestamp
+	totalDuration := lastTimestamp - firstTimestamp
+	totalDurationSeconds := float64(totalDuration) / 1000.0
+
+	summary := RunSummary{
+		Instance:        instance,
+		Job:             jobName,
+		Role:            role,
+		Labels:          extraLabels,
+		ExitCode:        commandExitCode,
+		Attempts:        commandAttempts(),
+		WaitSeconds:     commandWaitSeconds(),
+		Timestamp:       lastTimestamp,
+		DurationSeconds: commandDurationSeconds(metrics, totalDurationSeconds),
+		CpuMeanSeconds:  make(map[string]float64),
+		collected:       make(map[string]bool),
+	}
+
+	// CPU usage
+	cpuSeries := metrics.find("cpu_seconds_total")
+	for _, mode := range labelValuesAt(cpuSeries, "mode", lastTimestamp) {
+		modeSeries := metrics.find("cpu_seconds_total", "mode", mode)
+		summary.CpuMeanSeconds[mode] = increaseBetween(modeSeries, firstTimestamp, lastTimestamp) / totalDurationSeconds
+	}
+	summary.CpuCores = len(labelValuesAt(cpuSeries, "cpu", firstTimestamp))
+	summary.collected["cpu"] = len(cpuSeries) > 0
+
+	// CPU steal, in percent of the CPU time as analyze computes it: the hypervisor giving the CPUs to noisy neighbors
+	cpuTotalSeconds := 0.0
+	for _, cpuMeanTime := range summary.CpuMeanSeconds {
+		cpuTotalSeconds += cpuMeanTime
+	}
+	if cpuTotalSeconds > 0 {
+		summary.CpuStealPercent = summary.CpuMeanSeconds["steal"] / cpuTotalSeconds * 100
+	}
+
+	// Memory usage, averaged over the samples the memory collector stored its metrics in
+	memoryTotalSeries := metrics.find("memory_total_bytes")
+	memoryUsedSeries := metrics.find("memory_used_bytes")
+	memoryFreeSeries := metrics.find("memory_free_bytes")
+	memoryBuffersSeries := metrics.find("memory_buffers_bytes")
+	memoryCachedSeries := metrics.find("memory_cached_bytes")
+	var memorySumUsed float64 = 0
+	var memorySumFree float64 = 0
+	var memorySumBuffers float64 = 0
+	var memorySumCached float64 = 0
+	var numberOfMemorySamples = 0
+	for i := firstMetricIndex; i <= lastMetricIndex; i++ {
+		timestamp := metrics.samples[i].timestamp
+		if !hasPointAt(memoryUsedSeries, timestamp) {
+			continue
+		}
+		memorySumUsed += sumAt(memoryUsedSeries, timestamp)
+		memorySumFree += sumAt(memoryFreeSeries, timestamp)
+		memorySumBuffers += sumAt(memoryBuffersSeries, timestamp)
+		memorySumCached += sumAt(memoryCachedSeries, timestamp)
+		summary.MemoryTotalBytes = uint64(sumAt(memoryTotalSeries, timestamp))
+		numberOfMemorySamples++
+	}
+	if numberOfMemorySamples > 0 {
+		summary.MemoryUsedBytes = uint64(memorySumUsed / float64(numberOfMemorySamples))
+		summary.MemoryFreeBytes = uint64(memorySumFree / float64(numberOfMemorySamples))
+		summary.MemoryBuffersBytes = uint64(memorySumBuffers / float64(numberOfMemorySamples))
+		summary.MemoryCachedBytes = uint64(memorySumCached / float64(numberOfMemorySamples))
+	}
+	summary.collected["memory"] = numberOfMemorySamples > 0
+
+	// Network counters
+	networkSentSeries := metrics.find("network_sent_bytes_total")
+	networkRecvSeries := metrics.find("network_received_bytes_total")
+	summary.NetworkMeanSentBytesPerSecond = increaseBetween(networkSentSeries, firstTimestamp, lastTimestamp) / totalDurationSeconds
+	summary.NetworkMeanReceivedBytesPerSecond = increaseBetween(networkRecvSeries, firstTimestamp, lastTimestamp) / totalDurationSeconds
+	summary.collected["network"] = len(networkSentSeries) > 0
+
+	// Disk monitoring
+	diskReadSeries := metrics.find("disk_read_bytes_total")
+	diskWriteSeries := metrics.find("disk_write_bytes_total")
+	summary.DiskMeanReadBytesPerSecond = increaseBetween(diskReadSeries, firstTimestamp, lastTimestamp) / totalDurationSeconds
+	summary.DiskMeanWriteBytesPerSecond = increaseBetween(diskWriteSeries, firstTimestamp, lastTimestamp) / totalDurationSeconds
+	summary.collected["disk"] = len(diskReadSeries) > 0
+
+	// Perf counters, counted by perf stat over the whole command
+	summary.PerfCounters = perfCounters
+	if perfCounters["cycles"] > 0 {
+		summary.PerfInstructionsPerCycle = perfCounters["instructions"] / perfCounters["cycles"]
+	}
+
+	// Resource usage of the command, reported by the kernel when it exited
+	summary.CommandUsage = commandUsage
+	summary.Overhead = overheadUsage
+	summary.Build = buildInfo
+
+	return summary
+}
+
+// CPU steal in percent above which a run is flagged, by analyze and at the end of the run
+const defaultStealThreshold = 5.0
+
+// Point out CPU steal during the command, the numbers of the run then depend on the neighbors on the hypervisor
+func warnCpuSteal() {
+	summary := runSummary()
+	if summary.CpuStealPercent > defaultStealThreshold {
+		logger.Warn("CPU steal during the command, results may be affected by noisy neighbors", "steal_percent", summary.CpuStealPercent, "threshold", defaultStealThreshold)
+	}
+}
+
+// Summary of the run as points, at the time of the last sample of the command
+func summarySection(summary RunSummary) ResultSection {
+	timestamp := summary.Timestamp
+	section := ResultSection{title: "Summary of metrics while command was running"}
+
+	if summary.collected["cpu"] {
+		for _, mode := range sortedKeys(summary.CpuMeanSeconds) {
+			section.add("summary_cpu_mean_seconds", summary.CpuMeanSeconds[mode], 6, timestamp, "mode", mode)
+		}
+		section.add("summary_cpu_cores", float64(summary.CpuCores), 0, timestamp)
+		section.add("summary_cpu_steal_percent", summary.CpuStealPercent, 6, timestamp)
+	}
+
+	if summary.collected["memory"] {
+		section.add("summary_memory_used_bytes", float64(summary.MemoryUsedBytes), 0, timestamp)
+		section.add("summary_memory_free_bytes", float64(summary.MemoryFreeBytes), 0, timestamp)
+		section.add("summary_memory_buffers_bytes", float64(summary.MemoryBuffersBytes), 0, timestamp)
+		section.add("summary_memory_cached_bytes", float64(summary.MemoryCachedBytes), 0, timestamp)
+		section.add("summary_memory_total_bytes", float64(summary.MemoryTotalBytes), 0, timestamp)
+	}
+
+	if summary.collected["network"] {
+		section.add("summary_network_mean_sent_bytes_per_second", summary.NetworkMeanSentBytesPerSecond, 6, timestamp)
+		section.add("summary_network_mean_received_bytes_per_second", summary.NetworkMeanReceivedBytesPerSecond, 6, timestamp)
+	}
+
+	if summary.collected["disk"] {
+		section.add("summary_disk_mean_read_bytes_per_second", summary.DiskMeanReadBytesPerSecond, 6, timestamp)
+		section.add("summary_disk_mean_write_bytes_per_second", summary.DiskMeanWriteBytesPerSecond, 6, timestamp)
+	}
+
+	for _, event := range sortedKeys(summary.PerfCounters) {
+		section.add("summary_perf_counter", summary.PerfCounters[event], 6, timestamp, "event", event)
+	}
+	if summary.PerfInstructionsPerCycle > 0 {
+		section.add("summary_perf_instructions_per_cycle", summary.PerfInstructionsPerCycle, 6, timestamp)
+	}
+
+	if summary.Attempts > 0 {
+		section.add("summary_command_attempts", float64(summary.Attempts), 0, timestamp)
+	}
+
+	for _, step := range waitSteps {
+		if seconds, ok := summary.WaitSeconds[step]; ok {
+			section.add("summary_wait_seconds", seconds, 6, timestamp, "wait", step)
+		}
+	}
+
+	if usage := summary.CommandUsage; usage != nil {
+		section.add("summary_command_max_rss_bytes", float64(usage.MaxRssBytes), 0, timestamp)
+		section.add("summary_command_cpu_seconds", usage.UserCpuSeconds, 6, timestamp, "mode", "user")
+		section.add("summary_command_cpu_seconds", usage.SystemCpuSeconds, 6, timestamp, "mode", "system")
+		section.add("summary_command_block_operations", float64(usage.BlockInputOperations), 0, timestamp, "direction", "read")
+		section.add("summary_command_block_operations", float64(usage.BlockOutputOperations), 0, timestamp, "direction", "write")
+		section.add("summary_command_context_switches", float64(usage.VoluntaryCtxSwitches), 0, timestamp, "kind", "voluntary")
+		section.add("summary_command_context_switches", float64(usage.InvoluntaryCtxSwitches), 0, timestamp, "kind", "involuntary")
+		section.add("summary_command_major_page_faults", float64(usage.MajorPageFaults), 0, timestamp)
+	}
+
+	if overhead := summary.Overhead; overhead != nil {
+		section.add("overhead_cpu_seconds", overhead.UserCpuSeconds, 6, timestamp, "mode", "user")
+		section.add("overhead_cpu_seconds", overhead.SystemCpuSeconds, 6, timestamp, "mode", "system")
+		section.add("overhead_cpu_cores", overhead.CpuCores, 6, timestamp)
+		if overhead.CommandCpuRatio > 0 {
+			section.add("overhead_command_cpu_ratio", overhead.CommandCpuRatio, 6, timestamp)
+		}
+		section.add("overhead_max_rss_bytes", float64(overhead.MaxRssBytes), 0, timestamp)
+	}
+
+	return section
+}
+
+// Render the summary in prometheus format, as written in the result file
+func renderSummary(summary RunSummary) string {
+	return renderResultSection(summarySection(summary))
+}
+
+// Write the summary as JSON to a file, to stdout ("-") or to a file descriptor ("fd:3")
+func writeSummaryJson(target string) {
+	summaryJson, err := json.MarshalIndent(runSummary(), "", "  ")
+	if err != nil {
+		fatalWith(ExitOutput, "Cannot marshal summary", "error", err)
+	}
+	summaryJson = append(summaryJson, '\n')
+
+	switch {
+	case target == "-":
+		_, err = os.Stdout.Write(summaryJson)
+	case strings.HasPrefix(target, "fd:"):
+		fd, parseErr := strconv.Atoi(strings.TrimPrefix(target, "fd:"))
+		if parseErr != nil {
+			fatalWith(ExitConfig, "Cannot parse summary file descriptor", "target", target, "error", parseErr)
+		}
+		file := os.NewFile(uintptr(fd), target)
+		_, err = file.Write(summaryJson)
+	default:
+		err = writeFileAtomic(target, summaryJson)
+	}
+	if err != nil {
+		fatalWith(ExitOutput, "Cannot write summary", "target", target, "error", err)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Scheme of sync addresses on a unix socket, e.g. unix:///run/statexec.sock
+const unixSocketScheme = "unix://"
+
+var (
+	syncBind   string = "" // address the sync server listens on (--sync-bind), all addresses of both IPv4 and IPv6 if empty
+	syncListen string = "" // listen address of the sync server overriding bind and port (--sync-listen), host:port or unix://<path>
+)
+
+// Split a sync server address: a hostname, an IPv4 or IPv6 literal, bracketed or not, with an optional port
+func splitSyncServer(value string) (host string, port string) {
+	if strings.HasPrefix(value, unixSocketScheme) {
+		return value, ""
+	}
+	if host, port, err := net.SplitHostPort(value); err == nil {
+		return host, port
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(value, "["), "]"), ""
+}
+
+// Set the sync server of a client (-c), its port overrides the sync port
+func setSyncServer(value string) {
+	host, port := splitSyncServer(value)
+	serverIp = host
+	if port != "" {
+		syncPort = port
+	}
+}
+
+// Unix socket of a sync server, if it listens on one
+func syncSocketPath(host string) (string, bool) {
+	return strings.CutPrefix(host, unixSocketScheme)
+}
+
+// Url of the sync server, hostnames resolving to both IPv4 and IPv6 are dialed with happy eyeballs by net/http
+func syncUrl(host string, port string) string {
+	if _, ok := syncSocketPath(host); ok {
+		return "http://localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// Address of the sync server of a client, as displayed
+func syncServerAddress() string {
+	if _, ok := syncSocketPath(serverIp); ok {
+		return serverIp
+	}
+	return net.JoinHostPort(serverIp, syncPort)
+}
+
+// HTTP client of the sync protocol, dialing the unix socket of the server if it listens on one
+func syncClient(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if path, ok := syncSocketPath(serverIp); ok {
+		transport.DialContext = func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", path)
+		}
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// Resolve the bind address of the sync server: an IP, a hostname, or an interface name standing for its first address
+func resolveSyncBind(value string) string {
+	if value == "" || net.ParseIP(strings.Trim(value, "[]")) != nil {
+		return strings.Trim(value, "[]")
+	}
+	netInterface, err := net.InterfaceByName(value)
+	if err != nil {
+		return value
+	}
+	addresses, err := netInterface.Addrs()
+	if err != nil {
+		fatalWith(ExitConfig, "Cannot list addresses of the sync bind interface", "interface", value, "error", err)
+	}
+	for _, address := range addresses {
+		// Link-local addresses would need a zone to be reached
+		if ipNet, ok := address.(*net.IPNet); ok && !ipNet.IP.IsLinkLocalUnicast() {
+			return ipNet.IP.String()
+		}
+	}
+	fatalWith(ExitConfig, "Sync bind interface has no usable address", "interface", value)
+	return ""
+}
+
+// Listen address of the sync server
+func syncListenAddress() string {
+	if syncListen != "" {
+		return syncListen
+	}
+	return net.JoinHostPort(resolveSyncBind(syncBind), syncPort)
+}
+
+// Listen for sync clients, a socket file left by a crashed server is replaced, not one of a running server
+func listenSync() (net.Listener, error) {
+	address := syncListenAddress()
+	path, ok := syncSocketPath(address)
+	if !ok {
+		return net.Listen("tcp", address)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("a sync server already listens on %s", path)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return net.Listen("unix", path)
+}
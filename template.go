@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+)
+
+// Header of the start response: address the leader was reached at, and index of the follower in the session
+const (
+	syncLeaderAddressHeader = "X-Statexec-Leader-Address"
+	syncNodeIndexHeader     = "X-Statexec-Node-Index"
+)
+
+var (
+	leaderAddress string = "" // address of the leader as seen by the sync peers, {leader_ip}
+	syncNodeIndex int    = 0  // index of the node in the sync session, 0 for the leader and standalone runs, {node_index}
+)
+
+// Placeholders of the command and labels, expanded when the command starts. Only known names are replaced,
+// braces of the command itself, e.g. awk '{print $1}', are left as is.
+var commandPlaceholderPattern = regexp.MustCompile(`\{(hostname|role|job|run_id|session|peer_ip|leader_ip|node_index|env:[A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Value of a placeholder, once the sync handshake is done
+func commandPlaceholderValue(name string) string {
+	switch name {
+	case "hostname":
+		return hostname
+	case "role":
+		return role
+	case "job":
+		return jobName
+	case "run_id":
+		return runId
+	case "session":
+		return extraLabels["sync_session"]
+	case "peer_ip":
+		return syncPeer
+	case "leader_ip":
+		return leaderAddress
+	case "node_index":
+		return strconv.Itoa(syncNodeIndex)
+	}
+	return os.Getenv(name[len("env:"):])
+}
+
+func expandCommandPlaceholders(value string) string {
+	return commandPlaceholderPattern.ReplaceAllStringFunc(value, func(placeholder string) string {
+		return commandPlaceholderValue(placeholder[1 : len(placeholder)-1])
+	})
+}
+
+// Expand the placeholders of the command and of the labels, e.g. iperf3 -c {leader_ip}
+func expandCommandTemplate(cmd *exec.Cmd) {
+	for key, value := range extraLabels {
+		extraLabels[key] = expandCommandPlaceholders(value)
+	}
+
+	expanded := false
+	for i, arg := range cmd.Args {
+		cmd.Args[i] = expandCommandPlaceholders(arg)
+		expanded = expanded || cmd.Args[i] != arg
+	}
+	if !expanded {
+		return
+	}
+	// The program itself may be a placeholder, it is looked up again
+	if path, err := exec.LookPath(cmd.Args[0]); err == nil {
+		cmd.Path, cmd.Err = path, nil
+	}
+	logger.Info("Command expanded", "command", cmd.Args)
+}
+
+// Address a sync request was received at, the address of the leader for this follower, the local host for a unix socket
+func syncLocalAddress(r *http.Request) string {
+	address, ok := r.Context().Value(http.LocalAddrContextKey).(*net.TCPAddr)
+	if !ok {
+		return "127.0.0.1"
+	}
+	return address.IP.String()
+}
+
+// Address of the leader for a follower: as sent by the leader, else as given to --connect for older leaders
+func followerLeaderAddress(header string) string {
+	if header != "" {
+		return header
+	}
+	if _, ok := syncSocketPath(serverIp); ok {
+		return "127.0.0.1"
+	}
+	return serverIp
+}
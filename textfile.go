@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/blackswifthosting/statexec/promfile"
+)
+
+var textfileDir string = "" // node_exporter textfile collector directory snapshot before and after the run (--textfile-dir)
+
+// Samples of the textfiles before the run, by series
+var textfileBeforeRun map[string]promfile.Sample
+
+// Samples of the *.prom files of the textfile directory, a file being rewritten or invalid is left out as node_exporter does
+func readTextfiles() []promfile.Sample {
+	paths, err := filepath.Glob(filepath.Join(textfileDir, "*.prom"))
+	if err != nil || len(paths) == 0 {
+		logger.Warn("No textfile to snapshot", "dir", textfileDir)
+		return nil
+	}
+	sort.Strings(paths)
+
+	var samples []promfile.Sample
+	for _, path := range paths {
+		file, err := promfile.ParseFile(path)
+		if err != nil {
+			logger.Warn("Cannot parse textfile, left out", "file", path, "error", err)
+			continue
+		}
+		samples = append(samples, file.Samples...)
+	}
+	return samples
+}
+
+// Labels of a textfile sample, the names used by statexec are prefixed as the extra labels are
+func textfileLabels(sample promfile.Sample) map[string]string {
+	labels := make(map[string]string, len(sample.Labels)+1)
+	for key, value := range sample.Labels {
+		if isReservedLabel(key) {
+			key = reservedLabelPrefix + key
+		}
+		labels[key] = value
+	}
+	return labels
+}
+
+// Snapshot the textfile metrics, and after the run their change for the series found both times
+func snapshotTextfiles(phase string, timestamp int64) {
+	if textfileDir == "" {
+		return
+	}
+	samples := readTextfiles()
+	if phase == "before" {
+		textfileBeforeRun = make(map[string]promfile.Sample, len(samples))
+	}
+	for _, sample := range samples {
+		labels := textfileLabels(sample)
+		labels["phase"] = phase
+		addStaticMetric("textfile_"+sample.Name, labels, sample.Value, timestamp)
+
+		key := sample.SeriesKey()
+		if phase == "before" {
+			textfileBeforeRun[key] = sample
+			continue
+		}
+		if before, ok := textfileBeforeRun[key]; ok {
+			addStaticMetric("textfile_"+sample.Name+"_delta", textfileLabels(sample), sample.Value-before.Value, timestamp)
+		}
+	}
+}
+
+func checkTextfileDir(dir string) error {
+	_, err := os.ReadDir(dir)
+	return err
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/blackswifthosting/statexec/collectors"
+)
+
+// Devices of a sample, per kind, to detect interfaces and disks appearing or disappearing mid-run
+func sampleDevices(metric InstantMetric) map[string][]string {
+	devices := make(map[string][]string)
+	if samples, ok := metric.collectedBy("network"); ok {
+		devices["network interface"] = sampleLabelValues(samples, "interface")
+	}
+	if samples, ok := metric.collectedBy("disk"); ok {
+		devices["disk"] = sampleLabelValues(samples, "disk")
+	}
+	return devices
+}
+
+// Distinct values of a label among samples, in the order they appear
+func sampleLabelValues(samples []collectors.Sample, label string) []string {
+	var values []string
+	seen := make(map[string]bool)
+	for _, sample := range samples {
+		for i := 0; i+1 < len(sample.Labels); i += 2 {
+			if sample.Labels[i] == label && !seen[sample.Labels[i+1]] {
+				seen[sample.Labels[i+1]] = true
+				values = append(values, sample.Labels[i+1])
+			}
+		}
+	}
+	return values
+}
+
+// Annotate the devices added and removed since the previous sample, their series start or stop there
+func topologyAnnotations(previous map[string][]string, current map[string][]string, timestamp int64) []GrafanaAnnotation {
+	var annotations []GrafanaAnnotation
+	for _, kind := range []string{"network interface", "disk"} {
+		// A kind without devices is a failed or disabled collection, not a topology change
+		if len(previous[kind]) == 0 || len(current[kind]) == 0 {
+			continue
+		}
+		added := missingDevices(current[kind], previous[kind])
+		removed := missingDevices(previous[kind], current[kind])
+		if len(added) == 0 && len(removed) == 0 {
+			continue
+		}
+
+		var changes []string
+		if len(added) > 0 {
+			changes = append(changes, "added "+strings.Join(added, ", "))
+		}
+		if len(removed) > 0 {
+			changes = append(changes, "removed "+strings.Join(removed, ", "))
+		}
+		text := "Topology change, " + kind + " " + strings.Join(changes, ", ")
+		logger.Info("Topology change", "kind", kind, "added", strings.Join(added, ","), "removed", strings.Join(removed, ","))
+		annotations = append(annotations, GrafanaAnnotation{
+			Time:    timestamp,
+			TimeEnd: timestamp,
+			Text:    text,
+			Tags: []string{
+				"statexec",
+				"topology_change",
+				"instance=" + instance,
+				"job=" + jobName,
+				"role=" + role,
+				"hostname=" + hostname,
+				"run_id=" + runId,
+			},
+		})
+	}
+	return annotations
+}
+
+// Devices of a list missing from another one, sorted
+func missingDevices(devices []string, others []string) []string {
+	known := make(map[string]bool, len(others))
+	for _, device := range others {
+		known[device] = true
+	}
+	var missing []string
+	for _, device := range devices {
+		if !known[device] {
+			missing = append(missing, device)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blackswifthosting/statexec/collectors"
+)
+
+// Condition on the host load starting the command (--trigger), e.g. cpu>20% or network>10MB/s
+type Trigger struct {
+	Expression string
+	Resource   string
+	Operator   string
+	Threshold  float64
+}
+
+// Cumulated counters of the host: CPU seconds of all cores, network (without loopback) and disk bytes
+type HostLoad struct {
+	cpuBusy, cpuTotal float64
+	networkBytes      float64
+	diskBytes         float64
+}
+
+var (
+	triggers        []Trigger
+	triggerInterval time.Duration = time.Second
+)
+
+var triggerPattern = regexp.MustCompile(`^(cpu|network|disk)\s*(>=|<=|>|<)\s*([0-9.]+)\s*([A-Za-z%/]*)$`)
+
+var byteUnits = map[string]float64{
+	"":   1,
+	"b":  1,
+	"kb": 1e3, "mb": 1e6, "gb": 1e9,
+	"kib": 1 << 10, "mib": 1 << 20, "gib": 1 << 30,
+}
+
+func parseTrigger(expression string) Trigger {
+	match := triggerPattern.FindStringSubmatch(strings.TrimSpace(expression))
+	if match == nil {
+		fatalWith(ExitConfig, "Cannot parse trigger, expected cpu>20% or network>10MB/s or disk>50MB/s", "trigger", expression)
+	}
+	threshold, err := strconv.ParseFloat(match[3], 64)
+	if err != nil {
+		fatalWith(ExitConfig, "Cannot parse trigger threshold", "trigger", expression, "error", err)
+	}
+
+	unit := strings.ToLower(match[4])
+	if match[1] == "cpu" {
+		if unit != "" && unit != "%" {
+			fatalWith(ExitConfig, "CPU trigger threshold is a percentage", "trigger", expression)
+		}
+	} else {
+		multiplier, ok := byteUnits[strings.TrimSuffix(unit, "/s")]
+		if !ok {
+			fatalWith(ExitConfig, "Unknown unit in trigger, expected B, KB, MB, GB, KiB, MiB or GiB per second", "trigger", expression)
+		}
+		threshold *= multiplier
+	}
+	return Trigger{Expression: expression, Resource: match[1], Operator: match[2], Threshold: threshold}
+}
+
+// Read the counters of the host, the load is the difference between two polls
+func readHostLoad() (HostLoad, error) {
+	var load HostLoad
+	cpuMetrics, err := collectors.CollectCpuMetrics()
+	if err != nil {
+		return load, err
+	}
+	networkMetrics, err := collectors.CollectNetworkMetrics()
+	if err != nil {
+		return load, err
+	}
+	diskMetrics, err := collectors.CollectDiskMetrics()
+	if err != nil {
+		return load, err
+	}
+	for _, cpu := range cpuMetrics {
+		for mode, seconds := range cpu.CpuTimePerMode {
+			// Guest time is already counted in user time
+			if mode == "guest" || mode == "guestNice" {
+				continue
+			}
+			load.cpuTotal += seconds
+			if mode != "idle" && mode != "iowait" {
+				load.cpuBusy += seconds
+			}
+		}
+	}
+	for _, network := range networkMetrics {
+		if network.Interface == "lo" {
+			continue
+		}
+		load.networkBytes += float64(network.SentTotalBytes + network.RecvTotalBytes)
+	}
+	for _, disk := range diskMetrics {
+		load.diskBytes += float64(disk.ReadBytesTotal + disk.WriteBytesTotal)
+	}
+	return load, nil
+}
+
+// Value of the resource of a trigger between two polls
+func (t Trigger) value(previous HostLoad, current HostLoad, elapsed time.Duration) float64 {
+	switch t.Resource {
+	case "cpu":
+		total := current.cpuTotal - previous.cpuTotal
+		if total <= 0 {
+			return 0
+		}
+		return (current.cpuBusy - previous.cpuBusy) / total * 100
+	case "network":
+		return (current.networkBytes - previous.networkBytes) / elapsed.Seconds()
+	default:
+		return (current.diskBytes - previous.diskBytes) / elapsed.Seconds()
+	}
+}
+
+func (t Trigger) fired(value float64) bool {
+	switch t.Operator {
+	case ">":
+		return value > t.Threshold
+	case ">=":
+		return value >= t.Threshold
+	case "<":
+		return value < t.Threshold
+	default:
+		return value <= t.Threshold
+	}
+}
+
+func triggerExpressions() []string {
+	var expressions []string
+	for _, trigger := range triggers {
+		expressions = append(expressions, trigger.Expression)
+	}
+	return expressions
+}
+
+// Wait until one of the triggers fires, metrics are collected meanwhile with the command pending
+func waitForTrigger() {
+	if len(triggers) == 0 {
+		return
+	}
+	logger.Info("Waiting for a trigger to fire", "triggers", strings.Join(triggerExpressions(), " or "))
+
+	// The command is not started yet, a trigger which cannot be evaluated would never fire
+	armedAt := time.Now()
+	previous, err := readHostLoad()
+	if err != nil {
+		fatalWith(ExitCollector, "Cannot read the host load of the triggers", "error", err)
+	}
+	previousTime := time.Now()
+	for {
+		time.Sleep(triggerInterval)
+		current, err := readHostLoad()
+		if err != nil {
+			fatalWith(ExitCollector, "Cannot read the host load of the triggers", "error", err)
+		}
+		currentTime := time.Now()
+		for _, trigger := range triggers {
+			value := trigger.value(previous, current, currentTime.Sub(previousTime))
+			if trigger.fired(value) {
+				logger.Info("Trigger fired", "trigger", trigger.Expression, "value", value, "armed_for", time.Since(armedAt).Round(time.Millisecond))
+				return
+			}
+		}
+		previous, previousTime = current, currentTime
+	}
+}
@@ -0,0 +1,581 @@
+// Package tsdb writes Prometheus TSDB blocks (index format v2, XOR chunks) that Prometheus, Thanos and promtool read as is,
+// the block format is implemented here to avoid heavy dependencies.
+package tsdb
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"math/big"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	indexMagic         = 0xBAAAD700
+	indexFormatV2      = 2
+	chunksMagic        = 0x85BD40DD
+	chunksFormatV1     = 1
+	tombstonesMagic    = 0x0130BA30
+	tombstonesFormatV1 = 1
+	encodingXor        = 1
+	samplesPerChunk    = 120       // as cut by Prometheus
+	maxSegmentSize     = 512 << 20 // size of a chunks file, as cut by Prometheus
+	tmpSuffix          = ".tmp-for-creation"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+type Sample struct {
+	Value     float64
+	Timestamp int64 // in milliseconds
+}
+
+type Series struct {
+	Labels  map[string]string // Including __name__
+	Samples []Sample
+}
+
+// Content of the meta.json file of a block
+type BlockMeta struct {
+	Ulid       string          `json:"ulid"`
+	MinTime    int64           `json:"minTime"`
+	MaxTime    int64           `json:"maxTime"` // exclusive
+	Stats      BlockStats      `json:"stats"`
+	Compaction BlockCompaction `json:"compaction"`
+	Version    int             `json:"version"`
+	Thanos     *ThanosMeta     `json:"thanos,omitempty"`
+}
+
+type BlockStats struct {
+	NumSamples uint64 `json:"numSamples"`
+	NumSeries  uint64 `json:"numSeries"`
+	NumChunks  uint64 `json:"numChunks"`
+}
+
+type BlockCompaction struct {
+	Level   int      `json:"level"`
+	Sources []string `json:"sources"`
+}
+
+// Thanos section of the meta, required by Thanos to read a block from an object store, its external labels identify where the block comes from
+type ThanosMeta struct {
+	Labels     map[string]string `json:"labels"`
+	Downsample ThanosDownsample  `json:"downsample"`
+	Source     string            `json:"source"`
+}
+
+type ThanosDownsample struct {
+	Resolution int64 `json:"resolution"` // 0 for raw data
+}
+
+type label struct {
+	name, value string
+}
+
+type chunkMeta struct {
+	minTime, maxTime int64
+	ref              uint64 // index of the chunks file in the upper 4 bytes, offset in the lower ones
+}
+
+type blockSeries struct {
+	labels  []label
+	samples []Sample
+	chunks  []chunkMeta
+}
+
+// Write the series as a new block of a data directory, created under a temporary name then renamed so readers never see it partially written.
+// The Thanos section of the meta is only written if set.
+func WriteBlock(dataDir string, series []Series, thanos *ThanosMeta) (BlockMeta, error) {
+	ulid, err := NewUlid(time.Now())
+	if err != nil {
+		return BlockMeta{}, err
+	}
+	meta := BlockMeta{
+		Ulid:       ulid,
+		MinTime:    math.MaxInt64,
+		MaxTime:    math.MinInt64,
+		Compaction: BlockCompaction{Level: 1, Sources: []string{ulid}},
+		Version:    1,
+		Thanos:     thanos,
+	}
+
+	blockSeriesList := normalizeSeries(series)
+	if len(blockSeriesList) == 0 {
+		return meta, fmt.Errorf("no samples to write")
+	}
+	for _, oneSeries := range blockSeriesList {
+		meta.MinTime = min(meta.MinTime, oneSeries.samples[0].Timestamp)
+		meta.MaxTime = max(meta.MaxTime, oneSeries.samples[len(oneSeries.samples)-1].Timestamp+1)
+		meta.Stats.NumSamples += uint64(len(oneSeries.samples))
+	}
+	meta.Stats.NumSeries = uint64(len(blockSeriesList))
+
+	tmpDir := filepath.Join(dataDir, ulid+tmpSuffix)
+	if err := os.MkdirAll(filepath.Join(tmpDir, "chunks"), 0755); err != nil {
+		return meta, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	numChunks, err := writeChunks(filepath.Join(tmpDir, "chunks"), blockSeriesList)
+	if err != nil {
+		return meta, err
+	}
+	meta.Stats.NumChunks = numChunks
+	if err := os.WriteFile(filepath.Join(tmpDir, "index"), encodeIndex(blockSeriesList), 0644); err != nil {
+		return meta, err
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "tombstones"), encodeTombstones(), 0644); err != nil {
+		return meta, err
+	}
+	metaJson, err := json.MarshalIndent(meta, "", "\t")
+	if err != nil {
+		return meta, err
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "meta.json"), metaJson, 0644); err != nil {
+		return meta, err
+	}
+	return meta, os.Rename(tmpDir, filepath.Join(dataDir, ulid))
+}
+
+// Metas of the blocks of a data directory, other directories are ignored like Prometheus does
+func ReadBlockMetas(dataDir string) ([]BlockMeta, error) {
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	var metas []BlockMeta
+	for _, entry := range entries {
+		if !entry.IsDir() || len(entry.Name()) != 26 {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dataDir, entry.Name(), "meta.json"))
+		if err != nil {
+			continue
+		}
+		var meta BlockMeta
+		if err := json.Unmarshal(content, &meta); err != nil {
+			return nil, fmt.Errorf("cannot parse meta of block %s: %w", entry.Name(), err)
+		}
+		metas = append(metas, meta)
+	}
+	return metas, nil
+}
+
+// Sort labels, samples and series as the index requires, dropping empty series and duplicate timestamps (the last one wins)
+func normalizeSeries(series []Series) []*blockSeries {
+	var result []*blockSeries
+	for _, oneSeries := range series {
+		if len(oneSeries.Samples) == 0 {
+			continue
+		}
+		labels := make([]label, 0, len(oneSeries.Labels))
+		for name, value := range oneSeries.Labels {
+			if value != "" {
+				labels = append(labels, label{name, value})
+			}
+		}
+		sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+
+		samples := append([]Sample(nil), oneSeries.Samples...)
+		sort.SliceStable(samples, func(i, j int) bool { return samples[i].Timestamp < samples[j].Timestamp })
+		deduplicated := samples[:0]
+		for _, sample := range samples {
+			if len(deduplicated) > 0 && deduplicated[len(deduplicated)-1].Timestamp == sample.Timestamp {
+				deduplicated[len(deduplicated)-1] = sample
+				continue
+			}
+			deduplicated = append(deduplicated, sample)
+		}
+		result = append(result, &blockSeries{labels: labels, samples: deduplicated})
+	}
+	sort.Slice(result, func(i, j int) bool { return compareLabels(result[i].labels, result[j].labels) < 0 })
+	return result
+}
+
+func compareLabels(a []label, b []label) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i].name != b[i].name {
+			if a[i].name < b[i].name {
+				return -1
+			}
+			return 1
+		}
+		if a[i].value != b[i].value {
+			if a[i].value < b[i].value {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
+
+//===============================================================================
+// Chunks
+//===============================================================================
+
+// Write the XOR chunks of every series into numbered chunks files, recording their references in the series
+func writeChunks(dir string, series []*blockSeries) (uint64, error) {
+	var numChunks uint64
+	segment := 0
+	buffer := chunksFileHeader()
+	flush := func() error {
+		segment++
+		return os.WriteFile(filepath.Join(dir, fmt.Sprintf("%06d", segment)), buffer, 0644)
+	}
+
+	for _, oneSeries := range series {
+		for start := 0; start < len(oneSeries.samples); start += samplesPerChunk {
+			samples := oneSeries.samples[start:min(start+samplesPerChunk, len(oneSeries.samples))]
+			data := encodeXorChunk(samples)
+
+			encoded := binary.AppendUvarint(nil, uint64(len(data)))
+			encoded = append(encoded, encodingXor)
+			encoded = append(encoded, data...)
+			encoded = binary.BigEndian.AppendUint32(encoded, crc32.Checksum(encoded[len(encoded)-len(data)-1:], castagnoli))
+
+			if len(buffer)+len(encoded) > maxSegmentSize {
+				if err := flush(); err != nil {
+					return numChunks, err
+				}
+				buffer = chunksFileHeader()
+			}
+			oneSeries.chunks = append(oneSeries.chunks, chunkMeta{
+				minTime: samples[0].Timestamp,
+				maxTime: samples[len(samples)-1].Timestamp,
+				ref:     uint64(segment)<<32 | uint64(len(buffer)),
+			})
+			buffer = append(buffer, encoded...)
+			numChunks++
+		}
+	}
+	return numChunks, flush()
+}
+
+func chunksFileHeader() []byte {
+	header := binary.BigEndian.AppendUint32(nil, chunksMagic)
+	return append(header, chunksFormatV1, 0, 0, 0)
+}
+
+// Bit stream of the XOR encoding, written most significant bit first
+type bitStream struct {
+	stream []byte
+	count  uint8 // bits still free in the last byte
+}
+
+func (b *bitStream) writeBit(bit bool) {
+	if b.count == 0 {
+		b.stream = append(b.stream, 0)
+		b.count = 8
+	}
+	if bit {
+		b.stream[len(b.stream)-1] |= 1 << (b.count - 1)
+	}
+	b.count--
+}
+
+func (b *bitStream) writeByte(value byte) {
+	if b.count == 0 {
+		b.stream = append(b.stream, 0)
+		b.count = 8
+	}
+	b.stream[len(b.stream)-1] |= value >> (8 - b.count)
+	b.stream = append(b.stream, value<<b.count)
+}
+
+func (b *bitStream) writeBits(value uint64, count int) {
+	value <<= 64 - uint(count)
+	for ; count >= 8; count -= 8 {
+		b.writeByte(byte(value >> 56))
+		value <<= 8
+	}
+	for ; count > 0; count-- {
+		b.writeBit(value>>63 == 1)
+		value <<= 1
+	}
+}
+
+// Gorilla encoding of Prometheus: delta of delta timestamps and XOR of consecutive values, after a 2 bytes samples count
+func encodeXorChunk(samples []Sample) []byte {
+	b := &bitStream{stream: binary.BigEndian.AppendUint16(nil, uint16(len(samples)))}
+	var previous Sample
+	var timeDelta uint64
+	leading, trailing := uint8(0xff), uint8(0)
+	varint := make([]byte, binary.MaxVarintLen64)
+
+	for i, sample := range samples {
+		switch i {
+		case 0:
+			for _, value := range varint[:binary.PutVarint(varint, sample.Timestamp)] {
+				b.writeByte(value)
+			}
+			b.writeBits(math.Float64bits(sample.Value), 64)
+		case 1:
+			timeDelta = uint64(sample.Timestamp - previous.Timestamp)
+			for _, value := range varint[:binary.PutUvarint(varint, timeDelta)] {
+				b.writeByte(value)
+			}
+			writeXorValue(b, sample.Value, previous.Value, &leading, &trailing)
+		default:
+			delta := uint64(sample.Timestamp - previous.Timestamp)
+			deltaOfDelta := int64(delta - timeDelta)
+			switch {
+			case deltaOfDelta == 0:
+				b.writeBit(false)
+			case bitRange(deltaOfDelta, 14):
+				b.writeBits(0b10, 2)
+				b.writeBits(uint64(deltaOfDelta), 14)
+			case bitRange(deltaOfDelta, 17):
+				b.writeBits(0b110, 3)
+				b.writeBits(uint64(deltaOfDelta), 17)
+			case bitRange(deltaOfDelta, 20):
+				b.writeBits(0b1110, 4)
+				b.writeBits(uint64(deltaOfDelta), 20)
+			default:
+				b.writeBits(0b1111, 4)
+				b.writeBits(uint64(deltaOfDelta), 64)
+			}
+			timeDelta = delta
+			writeXorValue(b, sample.Value, previous.Value, &leading, &trailing)
+		}
+		previous = sample
+	}
+	return b.stream
+}
+
+// Whether a value fits in a number of bits, with the asymmetric range of Prometheus
+func bitRange(value int64, count uint8) bool {
+	return -((1<<(count-1))-1) <= value && value <= 1<<(count-1)
+}
+
+func writeXorValue(b *bitStream, value float64, previous float64, leading *uint8, trailing *uint8) {
+	delta := math.Float64bits(value) ^ math.Float64bits(previous)
+	if delta == 0 {
+		b.writeBit(false)
+		return
+	}
+	b.writeBit(true)
+
+	newLeading := uint8(bits.LeadingZeros64(delta))
+	newTrailing := uint8(bits.TrailingZeros64(delta))
+	// The count of leading zeros is written on 5 bits
+	if newLeading >= 32 {
+		newLeading = 31
+	}
+	if *leading != 0xff && newLeading >= *leading && newTrailing >= *trailing {
+		b.writeBit(false)
+		b.writeBits(delta>>*trailing, 64-int(*leading)-int(*trailing))
+		return
+	}
+	*leading, *trailing = newLeading, newTrailing
+	b.writeBit(true)
+	b.writeBits(uint64(newLeading), 5)
+	significant := 64 - newLeading - newTrailing
+	// 64 significant bits are written as 0, it cannot happen otherwise
+	b.writeBits(uint64(significant), 6)
+	b.writeBits(delta>>newTrailing, int(significant))
+}
+
+//===============================================================================
+// Index
+//===============================================================================
+
+// Index of a block: symbols, series with their chunks, label indices, postings and their offset tables, then the table of contents
+func encodeIndex(series []*blockSeries) []byte {
+	index := binary.BigEndian.AppendUint32(nil, indexMagic)
+	index = append(index, indexFormatV2)
+
+	// Symbols are referenced by their position in the sorted table
+	symbolSet := make(map[string]bool)
+	values := make(map[string]map[string]bool)
+	for _, oneSeries := range series {
+		for _, l := range oneSeries.labels {
+			symbolSet[l.name] = true
+			symbolSet[l.value] = true
+			if values[l.name] == nil {
+				values[l.name] = make(map[string]bool)
+			}
+			values[l.name][l.value] = true
+		}
+	}
+	symbols := sortedKeys(symbolSet)
+	symbolRefs := make(map[string]uint32, len(symbols))
+	symbolsOffset := uint64(len(index))
+	content := binary.BigEndian.AppendUint32(nil, uint32(len(symbols)))
+	for i, symbol := range symbols {
+		symbolRefs[symbol] = uint32(i)
+		content = appendString(content, symbol)
+	}
+	index = appendSection(index, content)
+
+	// Series are 16 bytes aligned, their id is their offset divided by 16
+	index = pad(index, 16)
+	seriesOffset := uint64(len(index))
+	seriesIds := make([]uint32, len(series))
+	for i, oneSeries := range series {
+		index = pad(index, 16)
+		seriesIds[i] = uint32(len(index) / 16)
+		content := binary.AppendUvarint(nil, uint64(len(oneSeries.labels)))
+		for _, l := range oneSeries.labels {
+			content = binary.AppendUvarint(content, uint64(symbolRefs[l.name]))
+			content = binary.AppendUvarint(content, uint64(symbolRefs[l.value]))
+		}
+		content = binary.AppendUvarint(content, uint64(len(oneSeries.chunks)))
+		for j, chunk := range oneSeries.chunks {
+			if j == 0 {
+				content = binary.AppendVarint(content, chunk.minTime)
+				content = binary.AppendUvarint(content, uint64(chunk.maxTime-chunk.minTime))
+				content = binary.AppendUvarint(content, chunk.ref)
+				continue
+			}
+			previous := oneSeries.chunks[j-1]
+			content = binary.AppendUvarint(content, uint64(chunk.minTime-previous.maxTime))
+			content = binary.AppendUvarint(content, uint64(chunk.maxTime-chunk.minTime))
+			content = binary.AppendVarint(content, int64(chunk.ref-previous.ref))
+		}
+		index = binary.AppendUvarint(index, uint64(len(content)))
+		index = append(index, content...)
+		index = binary.BigEndian.AppendUint32(index, crc32.Checksum(content, castagnoli))
+	}
+
+	// Label indices, only read by the readers of the index format v1
+	names := sortedKeys(values)
+	index = pad(index, 4)
+	labelIndicesOffset := uint64(len(index))
+	labelIndexOffsets := make([]uint64, len(names))
+	for i, name := range names {
+		index = pad(index, 4)
+		labelIndexOffsets[i] = uint64(len(index))
+		nameValues := sortedKeys(values[name])
+		content := binary.BigEndian.AppendUint32(nil, 1)
+		content = binary.BigEndian.AppendUint32(content, uint32(len(nameValues)))
+		for _, value := range nameValues {
+			content = binary.BigEndian.AppendUint32(content, symbolRefs[value])
+		}
+		index = appendSection(index, content)
+	}
+	labelIndicesTableOffset := uint64(len(index))
+	content = binary.BigEndian.AppendUint32(nil, uint32(len(names)))
+	for i, name := range names {
+		content = binary.AppendUvarint(content, 1)
+		content = appendString(content, name)
+		content = binary.AppendUvarint(content, labelIndexOffsets[i])
+	}
+	index = appendSection(index, content)
+
+	// Postings: the ids of the series of each label pair, all series first under the empty pair
+	postings := []label{{"", ""}}
+	for _, name := range names {
+		for _, value := range sortedKeys(values[name]) {
+			postings = append(postings, label{name, value})
+		}
+	}
+	index = pad(index, 4)
+	postingsOffset := uint64(len(index))
+	postingsOffsets := make([]uint64, len(postings))
+	for i, posting := range postings {
+		index = pad(index, 4)
+		postingsOffsets[i] = uint64(len(index))
+		var ids []uint32
+		for j, oneSeries := range series {
+			if posting.name == "" || hasLabel(oneSeries.labels, posting) {
+				ids = append(ids, seriesIds[j])
+			}
+		}
+		content := binary.BigEndian.AppendUint32(nil, uint32(len(ids)))
+		for _, id := range ids {
+			content = binary.BigEndian.AppendUint32(content, id)
+		}
+		index = appendSection(index, content)
+	}
+	postingsTableOffset := uint64(len(index))
+	content = binary.BigEndian.AppendUint32(nil, uint32(len(postings)))
+	for i, posting := range postings {
+		content = binary.AppendUvarint(content, 2)
+		content = appendString(content, posting.name)
+		content = appendString(content, posting.value)
+		content = binary.AppendUvarint(content, postingsOffsets[i])
+	}
+	index = appendSection(index, content)
+
+	toc := make([]byte, 0, 6*8)
+	for _, offset := range []uint64{symbolsOffset, seriesOffset, labelIndicesOffset, labelIndicesTableOffset, postingsOffset, postingsTableOffset} {
+		toc = binary.BigEndian.AppendUint64(toc, offset)
+	}
+	index = append(index, toc...)
+	return binary.BigEndian.AppendUint32(index, crc32.Checksum(toc, castagnoli))
+}
+
+// Section of the index: its length on 4 bytes, its content and the CRC32 of the content
+func appendSection(index []byte, content []byte) []byte {
+	index = binary.BigEndian.AppendUint32(index, uint32(len(content)))
+	index = append(index, content...)
+	return binary.BigEndian.AppendUint32(index, crc32.Checksum(content, castagnoli))
+}
+
+func appendString(buffer []byte, value string) []byte {
+	buffer = binary.AppendUvarint(buffer, uint64(len(value)))
+	return append(buffer, value...)
+}
+
+func pad(buffer []byte, alignment int) []byte {
+	for len(buffer)%alignment != 0 {
+		buffer = append(buffer, 0)
+	}
+	return buffer
+}
+
+func hasLabel(labels []label, wanted label) bool {
+	for _, l := range labels {
+		if l == wanted {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Tombstones of a new block: none, the CRC32 of nothing follows the header
+func encodeTombstones() []byte {
+	tombstones := binary.BigEndian.AppendUint32(nil, tombstonesMagic)
+	tombstones = append(tombstones, tombstonesFormatV1)
+	return binary.BigEndian.AppendUint32(tombstones, crc32.Checksum(nil, castagnoli))
+}
+
+//===============================================================================
+// ULID
+//===============================================================================
+
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// Lexicographically sortable id of a block: 48 bits of milliseconds then 80 random bits, in Crockford base32
+func NewUlid(now time.Time) (string, error) {
+	id := make([]byte, 16)
+	binary.BigEndian.PutUint64(id[:8], uint64(now.UnixMilli())<<16)
+	if _, err := rand.Read(id[6:]); err != nil {
+		return "", err
+	}
+	value := new(big.Int).SetBytes(id)
+	mask := big.NewInt(31)
+	encoded := make([]byte, 26)
+	for i := len(encoded) - 1; i >= 0; i-- {
+		encoded[i] = crockfordAlphabet[new(big.Int).And(value, mask).Int64()]
+		value.Rsh(value, 5)
+	}
+	return string(encoded), nil
+}
@@ -0,0 +1,226 @@
+package tsdb
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Reader of the bit stream of a chunk, most significant bit first
+type bitReader struct {
+	stream []byte
+	offset int // in bits
+}
+
+func (r *bitReader) readBits(count int) uint64 {
+	var value uint64
+	for ; count > 0; count-- {
+		bit := r.stream[r.offset/8] >> (7 - r.offset%8) & 1
+		value = value<<1 | uint64(bit)
+		r.offset++
+	}
+	return value
+}
+
+func (r *bitReader) ReadByte() (byte, error) {
+	return byte(r.readBits(8)), nil
+}
+
+// Decode a XOR chunk as Prometheus reads it
+func decodeXorChunk(t *testing.T, data []byte) []Sample {
+	t.Helper()
+	count := int(binary.BigEndian.Uint16(data))
+	r := &bitReader{stream: data[2:]}
+	var samples []Sample
+	var timeDelta int64
+	var leading, trailing uint8
+	readValue := func(previous float64) float64 {
+		if r.readBits(1) == 0 {
+			return previous
+		}
+		if r.readBits(1) == 1 {
+			leading = uint8(r.readBits(5))
+			significant := uint8(r.readBits(6))
+			if significant == 0 {
+				significant = 64
+			}
+			trailing = 64 - leading - significant
+		}
+		delta := r.readBits(64-int(leading)-int(trailing)) << trailing
+		return math.Float64frombits(math.Float64bits(previous) ^ delta)
+	}
+
+	for i := 0; i < count; i++ {
+		var sample Sample
+		switch i {
+		case 0:
+			timestamp, err := binary.ReadVarint(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sample = Sample{Timestamp: timestamp, Value: math.Float64frombits(r.readBits(64))}
+		case 1:
+			delta, err := binary.ReadUvarint(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			timeDelta = int64(delta)
+			previous := samples[i-1]
+			sample = Sample{Timestamp: previous.Timestamp + timeDelta, Value: readValue(previous.Value)}
+		default:
+			size := 0
+			for _, candidate := range []int{14, 17, 20, 64} {
+				if r.readBits(1) == 0 {
+					break
+				}
+				size = candidate
+			}
+			var deltaOfDelta int64
+			if size == 64 {
+				deltaOfDelta = int64(r.readBits(64))
+			} else if size > 0 {
+				bits := int64(r.readBits(size))
+				if bits > 1<<(size-1) {
+					bits -= 1 << size
+				}
+				deltaOfDelta = bits
+			}
+			timeDelta += deltaOfDelta
+			previous := samples[i-1]
+			sample = Sample{Timestamp: previous.Timestamp + timeDelta, Value: readValue(previous.Value)}
+		}
+		samples = append(samples, sample)
+	}
+	return samples
+}
+
+func TestXorChunkRoundTrip(t *testing.T) {
+	samples := []Sample{
+		{Timestamp: 1700000000000, Value: 1},
+		{Timestamp: 1700000001000, Value: 1},
+		{Timestamp: 1700000002000, Value: 2.5},
+		{Timestamp: 1700000003001, Value: 2.5000001},
+		{Timestamp: 1700000010000, Value: -1e9},
+		{Timestamp: 1700000200000, Value: 0},
+		{Timestamp: 1700000200001, Value: math.MaxFloat64},
+		{Timestamp: 1700900000000, Value: math.SmallestNonzeroFloat64},
+		{Timestamp: 1700900000500, Value: 42},
+	}
+	decoded := decodeXorChunk(t, encodeXorChunk(samples))
+	if len(decoded) != len(samples) {
+		t.Fatalf("decoded %d samples, want %d", len(decoded), len(samples))
+	}
+	for i := range samples {
+		if decoded[i] != samples[i] {
+			t.Errorf("sample %d = %v, want %v", i, decoded[i], samples[i])
+		}
+	}
+}
+
+func TestWriteBlock(t *testing.T) {
+	dir := t.TempDir()
+	start := int64(1700000000000)
+	var long []Sample
+	for i := 0; i < 2*samplesPerChunk+10; i++ {
+		long = append(long, Sample{Timestamp: start + int64(i)*1000, Value: float64(i)})
+	}
+	series := []Series{
+		{Labels: map[string]string{"__name__": "statexec_memory_used_bytes", "instance": "node-1"}, Samples: long},
+		// Out of order with a duplicate timestamp, the last value wins
+		{Labels: map[string]string{"__name__": "statexec_command_status", "instance": "node-1", "empty": ""}, Samples: []Sample{
+			{Timestamp: start + 2000, Value: 1}, {Timestamp: start, Value: 0}, {Timestamp: start + 2000, Value: 2},
+		}},
+		{Labels: map[string]string{"__name__": "statexec_empty"}},
+	}
+
+	meta, err := WriteBlock(dir, series, &ThanosMeta{Labels: map[string]string{}, Source: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.MinTime != start || meta.MaxTime != long[len(long)-1].Timestamp+1 {
+		t.Errorf("time range = %d-%d, want %d-%d", meta.MinTime, meta.MaxTime, start, long[len(long)-1].Timestamp+1)
+	}
+	if meta.Stats.NumSeries != 2 || meta.Stats.NumSamples != uint64(len(long))+2 || meta.Stats.NumChunks != 4 {
+		t.Errorf("stats = %+v, want 2 series, %d samples, 4 chunks", meta.Stats, len(long)+2)
+	}
+
+	blockDir := filepath.Join(dir, meta.Ulid)
+	for _, name := range []string{"index", "tombstones", "meta.json", filepath.Join("chunks", "000001")} {
+		if _, err := os.Stat(filepath.Join(blockDir, name)); err != nil {
+			t.Errorf("block file missing: %v", err)
+		}
+	}
+	index, err := os.ReadFile(filepath.Join(blockDir, "index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if binary.BigEndian.Uint32(index) != indexMagic || index[4] != indexFormatV2 {
+		t.Errorf("index header = %x, want magic %x and format %d", index[:5], indexMagic, indexFormatV2)
+	}
+	chunks, err := os.ReadFile(filepath.Join(blockDir, "chunks", "000001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if binary.BigEndian.Uint32(chunks) != chunksMagic || chunks[4] != chunksFormatV1 {
+		t.Errorf("chunks header = %x, want magic %x and format %d", chunks[:5], chunksMagic, chunksFormatV1)
+	}
+
+	// Series are sorted by labels, command_status first, the duplicate resolved to its last value
+	normalized := normalizeSeries(series)
+	if len(normalized) != 2 || normalized[0].labels[0].value != "statexec_command_status" {
+		t.Fatalf("normalized series = %v", normalized)
+	}
+	if got := normalized[0].samples; len(got) != 2 || got[1].Value != 2 {
+		t.Errorf("command_status samples = %v, want the duplicate replaced", got)
+	}
+	for _, l := range normalized[0].labels {
+		if l.name == "empty" {
+			t.Error("label with an empty value written")
+		}
+	}
+
+	metas, err := ReadBlockMetas(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metas) != 1 || metas[0].Ulid != meta.Ulid || metas[0].Thanos == nil || metas[0].Thanos.Source != "test" {
+		t.Errorf("read metas = %+v, want the written block", metas)
+	}
+	// No temporary directory left behind
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("data directory has %d entries, want the block only", len(entries))
+	}
+}
+
+func TestWriteBlockWithoutSamples(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := WriteBlock(dir, []Series{{Labels: map[string]string{"__name__": "up"}}}, nil); err == nil {
+		t.Error("block written without samples")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("data directory has %d entries, want none", len(entries))
+	}
+}
+
+func TestNewUlidSortsByTime(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var ids []string
+	for i := 0; i < 10; i++ {
+		id, err := NewUlid(now.Add(time.Duration(i) * time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(id) != 26 {
+			t.Fatalf("ulid %s has %d characters, want 26", id, len(id))
+		}
+		ids = append(ids, id)
+	}
+	if !sort.StringsAreSorted(ids) {
+		t.Errorf("ulids not sorted by time: %v", ids)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/blackswifthosting/statexec/promfile"
+)
+
+var validateFlags = []string{"--format"}
+
+// Issues kept per file, the others are only counted
+const maxValidationIssues = 100
+
+var (
+	metricNamePattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNamePattern  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+type ValidationIssue struct {
+	Line     int    `json:"line"`
+	Severity string `json:"severity"` // error, warning
+	Message  string `json:"message"`
+}
+
+type ValidationReport struct {
+	File        string            `json:"file"`
+	Schema      int               `json:"schema"`
+	Lines       int               `json:"lines"`
+	Comments    int               `json:"comments"`
+	Samples     int               `json:"samples"`
+	Series      int               `json:"series"`
+	Metrics     int               `json:"metrics"`
+	Annotations int               `json:"annotations"`
+	FirstSample int64             `json:"first_sample,omitempty"`
+	LastSample  int64             `json:"last_sample,omitempty"`
+	Errors      int               `json:"errors"`
+	Warnings    int               `json:"warnings"`
+	Issues      []ValidationIssue `json:"issues"`
+}
+
+func (report *ValidationReport) add(line int, severity string, format string, args ...any) {
+	if severity == "error" {
+		report.Errors++
+	} else {
+		report.Warnings++
+	}
+	if len(report.Issues) < maxValidationIssues {
+		report.Issues = append(report.Issues, ValidationIssue{Line: line, Severity: severity, Message: fmt.Sprintf(format, args...)})
+	}
+}
+
+// Check every line of a result file, reporting all malformed ones instead of stopping at the first
+func validateResultContent(path string, content []byte) ValidationReport {
+	report := ValidationReport{File: path, Issues: []ValidationIssue{}}
+	metrics := make(map[string]bool)
+	lastTimestamps := make(map[string]int64)
+	seen := make(map[string]bool)
+	schemaFound := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		report.Lines++
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, promfile.AnnotationPrefix):
+			report.Annotations++
+			var annotation promfile.Annotation
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, promfile.AnnotationPrefix)), &annotation); err != nil {
+				report.add(report.Lines, "error", "invalid annotation: %v", err)
+			} else if annotation.Time <= 0 {
+				report.add(report.Lines, "error", "annotation %q has no time", annotation.Text)
+			} else if annotation.TimeEnd != 0 && annotation.TimeEnd < annotation.Time {
+				report.add(report.Lines, "warning", "annotation %q ends before it starts", annotation.Text)
+			}
+		case strings.HasPrefix(line, "#"):
+			report.Comments++
+			if value, ok := strings.CutPrefix(line, promfile.SchemaCommentPrefix); ok {
+				schemaFound = true
+				version, err := strconv.Atoi(strings.TrimSpace(value))
+				switch {
+				case err != nil || version < 1:
+					report.add(report.Lines, "error", "invalid schema version %q", value)
+				case version > promfile.SchemaVersion:
+					report.Schema = version
+					report.add(report.Lines, "error", "schema version %d is newer than the supported one (%d)", version, promfile.SchemaVersion)
+				default:
+					report.Schema = version
+				}
+			}
+		default:
+			validateSample(&report, line, metrics, lastTimestamps, seen)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		report.add(report.Lines, "error", "cannot read file: %v", err)
+	}
+	if !schemaFound {
+		report.add(0, "warning", "no schema version, written before versioning (schema 0)")
+	}
+	report.Metrics = len(metrics)
+	report.Series = len(lastTimestamps)
+	return report
+}
+
+func validateSample(report *ValidationReport, line string, metrics map[string]bool, lastTimestamps map[string]int64, seen map[string]bool) {
+	sample, err := promfile.ParseSample(line)
+	if err != nil {
+		report.add(report.Lines, "error", "malformed sample: %v", err)
+		return
+	}
+	report.Samples++
+	if !metricNamePattern.MatchString(sample.Name) {
+		report.add(report.Lines, "error", "invalid metric name %q", sample.Name)
+	}
+	for name := range sample.Labels {
+		if !labelNamePattern.MatchString(name) || strings.HasPrefix(name, "__") {
+			report.add(report.Lines, "error", "invalid label name %q of %s", name, sample.Name)
+		}
+	}
+	if sample.Timestamp == 0 {
+		report.add(report.Lines, "warning", "sample of %s has no timestamp", sample.Name)
+	}
+
+	metrics[sample.Name] = true
+	key := sample.SeriesKey()
+	sampleKey := key + "@" + strconv.FormatInt(sample.Timestamp, 10)
+	if seen[sampleKey] {
+		report.add(report.Lines, "error", "duplicate sample of %s at %d", key, sample.Timestamp)
+	}
+	seen[sampleKey] = true
+	if last, ok := lastTimestamps[key]; ok && sample.Timestamp < last {
+		report.add(report.Lines, "warning", "sample of %s goes back in time (%d after %d)", key, sample.Timestamp, last)
+	}
+	lastTimestamps[key] = max(lastTimestamps[key], sample.Timestamp)
+
+	if report.FirstSample == 0 || sample.Timestamp < report.FirstSample {
+		report.FirstSample = sample.Timestamp
+	}
+	report.LastSample = max(report.LastSample, sample.Timestamp)
+}
+
+func validateSubcommand(args []string) {
+	format := "text"
+	files := []string{}
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--format":
+			format = flagValue(args, i)
+			if format != "text" && format != "json" {
+				fatalWith(ExitConfig, "Format must be text or json", "format", format)
+			}
+			i++
+		case "-h", "--help":
+			fmt.Printf("Usage: %s validate [--format <text|json>] <file.prom> [...]\n", os.Args[0])
+			fmt.Println("  Check the schema version, series, labels and annotations of result files, exit 1 if one is invalid")
+			fmt.Println("  --format <text|json>   Output format (default: text)")
+			os.Exit(0)
+		default:
+			files = append(files, args[i])
+		}
+	}
+	if len(files) == 0 {
+		fatalWith(ExitConfig, "Validate needs at least one result file")
+	}
+
+	reports := []ValidationReport{}
+	failed := false
+	for _, path := range files {
+		content, err := readResultContent(path)
+		if err != nil {
+			fatal("Cannot read result file", "file", path, "error", err)
+		}
+		report := validateResultContent(path, content)
+		failed = failed || report.Errors > 0
+		reports = append(reports, report)
+	}
+
+	if format == "json" {
+		printJson(reports)
+	} else {
+		for _, report := range reports {
+			printValidationReport(report)
+		}
+	}
+	if failed {
+		os.Exit(ExitError)
+	}
+}
+
+func printValidationReport(report ValidationReport) {
+	status := "valid"
+	if report.Errors > 0 {
+		status = "INVALID"
+	}
+	fmt.Printf("File:        %s (%s)\n", report.File, status)
+	fmt.Printf("Schema:      %d (supported: %d)\n", report.Schema, promfile.SchemaVersion)
+	fmt.Printf("Samples:     %d in %d series of %d metrics\n", report.Samples, report.Series, report.Metrics)
+	fmt.Printf("Annotations: %d\n", report.Annotations)
+	if report.Samples > 0 {
+		fmt.Printf("Time range:  %s -> %s\n", formatTimestamp(report.FirstSample), formatTimestamp(report.LastSample))
+	}
+	fmt.Printf("Issues:      %d error(s), %d warning(s)\n", report.Errors, report.Warnings)
+	for _, issue := range report.Issues {
+		if issue.Line == 0 {
+			fmt.Printf("  %-7s %s\n", strings.ToUpper(issue.Severity), issue.Message)
+			continue
+		}
+		fmt.Printf("  %-7s line %d: %s\n", strings.ToUpper(issue.Severity), issue.Line, issue.Message)
+	}
+	if hidden := report.Errors + report.Warnings - len(report.Issues); hidden > 0 {
+		fmt.Printf("  ... and %d more\n", hidden)
+	}
+	fmt.Println()
+}
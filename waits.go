@@ -0,0 +1,90 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Steps statexec waits at rather than running the command, in the order of the run
+var waitSteps = []string{"sync_start", "delay_before", "schedule", "trigger", "delay_after"}
+
+// Text of the annotations of the waits
+var waitDescriptions = map[string]string{
+	"sync_start":   "Wait for the sync start",
+	"delay_before": "Delay before the command",
+	"schedule":     "Wait for the scheduled start",
+	"trigger":      "Wait for a trigger to fire",
+	"delay_after":  "Delay after the command",
+}
+
+type commandWait struct {
+	step      string
+	startedAt time.Time
+	duration  time.Duration
+}
+
+var (
+	waitsMutex    sync.Mutex
+	commandWaits  []commandWait
+	waitTimeBase  time.Time // real time of metricsStartTime, waits recorded before it are annotated once it is known
+	annotatedWait int       // waits already annotated
+)
+
+// Record a wait which started at startedAt and ends now
+func recordWait(step string, startedAt time.Time) {
+	waitsMutex.Lock()
+	defer waitsMutex.Unlock()
+	commandWaits = append(commandWaits, commandWait{step: step, startedAt: startedAt, duration: time.Since(startedAt)})
+	annotateWaits()
+}
+
+// Time base of the metrics, the sync start wait happens before it
+func setWaitTimeBase(realStartTime time.Time) {
+	waitsMutex.Lock()
+	defer waitsMutex.Unlock()
+	waitTimeBase = realStartTime
+	annotateWaits()
+}
+
+// Annotate the waits not annotated yet as regions, the sync start ending where the metrics start
+func annotateWaits() {
+	if waitTimeBase.IsZero() {
+		return
+	}
+	for ; annotatedWait < len(commandWaits); annotatedWait++ {
+		wait := commandWaits[annotatedWait]
+		waitStart := metricsStartTime + wait.startedAt.Sub(waitTimeBase).Milliseconds()
+		if wait.step == "sync_start" {
+			waitStart = metricsStartTime - wait.duration.Milliseconds()
+		}
+		store.AddAnnotation(GrafanaAnnotation{
+			Time:    waitStart,
+			TimeEnd: waitStart + wait.duration.Milliseconds(),
+			Text:    waitDescriptions[wait.step] + ": " + wait.duration.Round(time.Millisecond).String(),
+			Tags: []string{
+				"statexec",
+				"wait",
+				"wait=" + wait.step,
+				"instance=" + instance,
+				"job=" + jobName,
+				"role=" + role,
+				"hostname=" + hostname,
+				"run_id=" + runId,
+			},
+		})
+	}
+}
+
+// Seconds waited by step for the summary, none if the run did not wait
+func commandWaitSeconds() map[string]float64 {
+	waitsMutex.Lock()
+	defer waitsMutex.Unlock()
+	if len(commandWaits) == 0 {
+		return nil
+	}
+	seconds := make(map[string]float64)
+	for _, wait := range commandWaits {
+		seconds[wait.step] += wait.duration.Seconds()
+	}
+	return seconds
+}
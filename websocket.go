@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Minimal server side of RFC 6455, enough to push text messages to a browser
+const (
+	websocketGuid        = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	websocketOpText      = 0x1
+	websocketOpClose     = 0x8
+	websocketMaxReadSize = 64 * 1024
+)
+
+// Complete the opening handshake and take over the connection
+func upgradeWebsocket(w http.ResponseWriter, r *http.Request) (net.Conn, *bufio.ReadWriter, error) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || !strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade") {
+		return nil, nil, errors.New("not a websocket handshake")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, nil, errors.New("missing Sec-WebSocket-Key")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection cannot be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hash := sha1.Sum([]byte(key + websocketGuid))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	rw.WriteString("Upgrade: websocket\r\n")
+	rw.WriteString("Connection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(hash[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, rw, nil
+}
+
+// Write an unmasked frame, servers never mask
+func writeWebsocketFrame(w *bufio.Writer, opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	switch length := len(payload); {
+	case length < 126:
+		header = append(header, byte(length))
+	case length <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// Read a frame sent by the browser, always masked, and return its opcode and unmasked payload
+func readWebsocketFrame(r *bufio.Reader) (byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		extended := make([]byte, 2)
+		if _, err := io.ReadFull(r, extended); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		if _, err := io.ReadFull(r, extended); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended)
+	}
+	if length > websocketMaxReadSize {
+		return 0, nil, errors.New("websocket frame too large")
+	}
+	mask := make([]byte, 4)
+	if masked {
+		if _, err := io.ReadFull(r, mask); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A significant line of a YAML document
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+// Parse the subset of YAML used by configuration files (maps, lists, flow lists and scalars)
+// into the same generic values as encoding/json. JSON documents are accepted as well.
+func parseYaml(data []byte) (interface{}, error) {
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "{") {
+		var value interface{}
+		err := json.Unmarshal(data, &value)
+		return value, err
+	}
+
+	var lines []yamlLine
+	for number, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(stripYamlComment(line), " \t\r")
+		if strings.TrimSpace(line) == "" || line == "---" {
+			continue
+		}
+		if strings.HasPrefix(strings.TrimLeft(line, " "), "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", number+1)
+		}
+		text := strings.TrimLeft(line, " ")
+		lines = append(lines, yamlLine{number: number + 1, indent: len(line) - len(text), text: text})
+	}
+	if len(lines) == 0 {
+		return map[string]interface{}{}, nil
+	}
+
+	value, next, err := parseYamlBlock(lines, 0, lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if next < len(lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", lines[next].number)
+	}
+	return value, nil
+}
+
+// Remove a trailing comment, outside of quotes
+func stripYamlComment(line string) string {
+	var quote rune
+	for i, char := range line {
+		switch {
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case char == '"' || char == '\'':
+			quote = char
+		case char == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func isYamlListItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// Parse a block of lines at the same indentation, returning the index of the first line after it
+func parseYamlBlock(lines []yamlLine, start int, indent int) (interface{}, int, error) {
+	if isYamlListItem(lines[start].text) {
+		return parseYamlList(lines, start, indent)
+	}
+	return parseYamlMap(lines, start, indent)
+}
+
+func parseYamlList(lines []yamlLine, start int, indent int) (interface{}, int, error) {
+	list := []interface{}{}
+	i := start
+	for i < len(lines) && lines[i].indent == indent && isYamlListItem(lines[i].text) {
+		item := strings.TrimSpace(strings.TrimPrefix(lines[i].text, "-"))
+		switch {
+		case item == "":
+			// Nested block on the next lines
+			if i+1 < len(lines) && lines[i+1].indent > indent {
+				value, next, err := parseYamlBlock(lines, i+1, lines[i+1].indent)
+				if err != nil {
+					return nil, 0, err
+				}
+				list = append(list, value)
+				i = next
+			} else {
+				list = append(list, nil)
+				i++
+			}
+		case yamlKey(item) != "":
+			// Map starting on the item line, e.g. "- name: value"
+			lines[i] = yamlLine{number: lines[i].number, indent: indent + 2, text: item}
+			value, next, err := parseYamlMap(lines, i, indent+2)
+			if err != nil {
+				return nil, 0, err
+			}
+			list = append(list, value)
+			i = next
+		default:
+			value, err := parseYamlScalar(item)
+			if err != nil {
+				return nil, 0, fmt.Errorf("line %d: %w", lines[i].number, err)
+			}
+			list = append(list, value)
+			i++
+		}
+	}
+	return list, i, nil
+}
+
+func parseYamlMap(lines []yamlLine, start int, indent int) (interface{}, int, error) {
+	values := map[string]interface{}{}
+	i := start
+	for i < len(lines) && lines[i].indent == indent {
+		key := yamlKey(lines[i].text)
+		if key == "" {
+			return nil, 0, fmt.Errorf("line %d: expected a key: value pair", lines[i].number)
+		}
+		rest := strings.TrimSpace(strings.TrimPrefix(lines[i].text, lines[i].text[:len(key)]))
+		rest = strings.TrimSpace(strings.TrimPrefix(rest, ":"))
+		key = unquoteYamlKey(key)
+
+		switch {
+		case rest != "":
+			value, err := parseYamlScalar(rest)
+			if err != nil {
+				return nil, 0, fmt.Errorf("line %d: %w", lines[i].number, err)
+			}
+			values[key] = value
+			i++
+		case i+1 < len(lines) && (lines[i+1].indent > indent || (lines[i+1].indent == indent && isYamlListItem(lines[i+1].text))):
+			value, next, err := parseYamlBlock(lines, i+1, lines[i+1].indent)
+			if err != nil {
+				return nil, 0, err
+			}
+			values[key] = value
+			i = next
+		default:
+			values[key] = nil
+			i++
+		}
+	}
+	return values, i, nil
+}
+
+// Key of a "key: value" or "key:" line, empty if the line is not a pair
+func yamlKey(text string) string {
+	if strings.HasPrefix(text, "\"") || strings.HasPrefix(text, "'") {
+		end := strings.IndexByte(text[1:], text[0])
+		if end == -1 || !strings.HasPrefix(text[end+2:], ":") {
+			return ""
+		}
+		return text[:end+2]
+	}
+	if strings.HasPrefix(text, "[") || strings.HasPrefix(text, "{") {
+		return ""
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			return text[:i]
+		}
+	}
+	return ""
+}
+
+func unquoteYamlKey(key string) string {
+	if value, err := parseYamlScalar(key); err == nil {
+		if text, ok := value.(string); ok {
+			return text
+		}
+	}
+	return key
+}
+
+// Parse a scalar or a flow list like [a, "b c"]
+func parseYamlScalar(text string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(text, "["):
+		if !strings.HasSuffix(text, "]") {
+			return nil, fmt.Errorf("unterminated list %s", text)
+		}
+		list := []interface{}{}
+		for _, item := range splitYamlFlow(text[1 : len(text)-1]) {
+			value, err := parseYamlScalar(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, nil
+	case text == "{}":
+		return map[string]interface{}{}, nil
+	case strings.HasPrefix(text, "\""):
+		return strconv.Unquote(text)
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return nil, fmt.Errorf("unterminated string %s", text)
+		}
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	case text == "~" || text == "null":
+		return nil, nil
+	case text == "true" || text == "false":
+		return text == "true", nil
+	}
+	if number, err := strconv.ParseFloat(text, 64); err == nil {
+		return number, nil
+	}
+	return text, nil
+}
+
+// Split the items of a flow list on commas outside of quotes
+func splitYamlFlow(text string) []string {
+	var items []string
+	var quote rune
+	current := ""
+	for _, char := range text {
+		switch {
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case char == '"' || char == '\'':
+			quote = char
+		case char == ',':
+			items = append(items, strings.TrimSpace(current))
+			current = ""
+			continue
+		}
+		current += string(char)
+	}
+	if strings.TrimSpace(current) != "" {
+		items = append(items, strings.TrimSpace(current))
+	}
+	return items
+}